@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	BANBUCKET = "banlist" // 封禁列表的桶名, key 是被封禁的地址, value 是序列化之后的 BanEntry
+)
+
+// BanEntry describes a banned peer
+//
+// 一条封禁记录
+type BanEntry struct {
+	Addr        string // 被封禁的地址, host:port 或者 host
+	BannedAt    int64  // 封禁开始的时间, UNIX秒
+	BannedUntil int64  // 封禁结束的时间, UNIX秒
+	Reason      string // 封禁原因
+}
+
+// Expired reports whether the ban is over
+func (e *BanEntry) Expired() bool {
+	return time.Now().Unix() >= e.BannedUntil
+}
+
+func (e *BanEntry) String() string {
+	return fmt.Sprintf("%s banned until %s (%s)", e.Addr, time.Unix(e.BannedUntil, 0).Format(time.RFC3339), e.Reason)
+}
+
+// Serialize returns a serialized BanEntry
+func (e BanEntry) Serialize() []byte {
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(e)
+	if err != nil {
+		panic(err)
+	}
+	return encoded.Bytes()
+}
+
+// DeserializeBanEntry returns a deserialized BanEntry
+func DeserializeBanEntry(d []byte) (*BanEntry, error) {
+	var entry BanEntry
+	err := gob.NewDecoder(bytes.NewReader(d)).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// BanPeer bans addr for duration
+//
+// 把一个地址加入封禁列表, 封禁列表存储在区块链数据库中, 重启节点之后依然有效
+func (bc *Blockchain) BanPeer(addr string, duration time.Duration, reason string) error {
+	now := time.Now()
+	entry := BanEntry{
+		Addr:        addr,
+		BannedAt:    now.Unix(),
+		BannedUntil: now.Add(duration).Unix(),
+		Reason:      reason,
+	}
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BANBUCKET))
+		if err != nil {
+			return fmt.Errorf("create bucket %s failed, %w", BANBUCKET, err)
+		}
+		return b.Put([]byte(addr), entry.Serialize())
+	})
+	if err != nil {
+		return fmt.Errorf("ban peer %s failed, %w", addr, err)
+	}
+
+	return nil
+}
+
+// UnbanPeer removes addr from the ban list
+//
+// 把一个地址从封禁列表中移除
+func (bc *Blockchain) UnbanPeer(addr string) error {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BANBUCKET))
+		if b == nil || b.Get([]byte(addr)) == nil {
+			return fmt.Errorf("%s is not banned", addr)
+		}
+		return b.Delete([]byte(addr))
+	})
+	if err != nil {
+		return fmt.Errorf("unban peer %s failed, %w", addr, err)
+	}
+
+	return nil
+}
+
+// ListBanned returns all active bans sorted by address, dropping expired ones
+//
+// 返回所有还在生效的封禁记录，同时把已经过期的记录从数据库中删除
+func (bc *Blockchain) ListBanned() ([]BanEntry, error) {
+	var entries []BanEntry
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BANBUCKET))
+		if b == nil {
+			return nil
+		}
+
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			entry, err := DeserializeBanEntry(v)
+			if err != nil {
+				return fmt.Errorf("decode ban entry %s failed, %w", k, err)
+			}
+			if entry.Expired() {
+				expired = append(expired, k)
+				return nil
+			}
+			entries = append(entries, *entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// 在ForEach中不能修改bucket, 所以先记录下来再删除
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list banned peers failed, %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Addr < entries[j].Addr })
+	return entries, nil
+}
+
+// IsBanned reports whether addr is covered by an active ban
+//
+// 判断一个地址是否被封禁
+func (bc *Blockchain) IsBanned(addr string) bool {
+	banned := false
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BANBUCKET))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if !banMatches(string(k), addr) {
+				return nil
+			}
+			entry, err := DeserializeBanEntry(v)
+			if err != nil {
+				return err
+			}
+			if !entry.Expired() {
+				banned = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		fmt.Printf("read ban list failed: %v\n", err)
+		return false
+	}
+
+	return banned
+}
@@ -2,11 +2,24 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"math/big"
 )
 
+const (
+	CHECKSUMLENGTH = 4 // Base58Check 校验和的长度
+)
+
 var alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
 
+var (
+	ErrInvalidCharacter = errors.New("invalid base58 character")
+	ErrInvalidLength    = errors.New("invalid length")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrUnknownVersion   = errors.New("unknown version byte")
+)
+
 // Base58Encode encodes a byte array to a modified base58 string
 //
 // Base58Encode 用于将一个字节切片（[]byte）编码为 Base58 格式。
@@ -47,7 +60,7 @@ func Base58Encode(input []byte) []byte {
 
 // Base58Decode decodes a modified base58 string to bytes
 //
-// Base58Decode 用于将一个 Base58 编码的字符串解码为字节切片（[]byte）
+// Base58Decode 用于将一个 Base58 编码的字符串解码为字节切片（[]byte）, 遇到字母表之外的字符返回 ErrInvalidCharacter
 func Base58Decode(input []byte) ([]byte, error) {
 	result := big.NewInt(0)
 	zeroBytes := 0
@@ -64,6 +77,9 @@ func Base58Decode(input []byte) ([]byte, error) {
 
 	for b := range payload {
 		charIndex := bytes.IndexByte(alphabet, payload[b])
+		if charIndex < 0 {
+			return nil, fmt.Errorf("%w %q at position %d", ErrInvalidCharacter, payload[b], zeroBytes+b)
+		}
 		result.Mul(result, big.NewInt(58))
 		result.Add(result, big.NewInt(int64(charIndex)))
 	}
@@ -74,3 +90,31 @@ func Base58Decode(input []byte) ([]byte, error) {
 
 	return decoded, nil
 }
+
+// Base58CheckEncode encodes version || payload || checksum in base58
+//
+// Base58Check 编码: 版本号 || 数据 || 校验和(两次sha256的前4字节), 地址和WIF私钥都使用这种编码
+func Base58CheckEncode(version byte, payload []byte) string {
+	versioned := append([]byte{version}, payload...)
+	return string(Base58Encode(append(versioned, GenerateChecksum(versioned)...)))
+}
+
+// Base58CheckDecode decodes a Base58Check string and returns its version byte and payload
+//
+// 解码 Base58Check 字符串并且校验校验和, 返回版本号和数据; 数据的长度和版本号由调用者检查
+func Base58CheckDecode(input string) (byte, []byte, error) {
+	decoded, err := Base58Decode([]byte(input))
+	if err != nil {
+		return 0, nil, err
+	}
+	// 至少需要1字节版本号和4字节校验和
+	if len(decoded) < 1+CHECKSUMLENGTH {
+		return 0, nil, fmt.Errorf("%w: %d bytes is too short for base58check", ErrInvalidLength, len(decoded))
+	}
+
+	versioned, checksum := decoded[:len(decoded)-CHECKSUMLENGTH], decoded[len(decoded)-CHECKSUMLENGTH:]
+	if !bytes.Equal(GenerateChecksum(versioned), checksum) {
+		return 0, nil, ErrChecksumMismatch
+	}
+	return versioned[0], versioned[1:], nil
+}
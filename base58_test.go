@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// base58_encode_decode.json of Bitcoin Core
+//
+// 比特币核心的 base58 测试向量
+var base58Vectors = []struct {
+	hex     string
+	encoded string
+}{
+	{"", ""},
+	{"61", "2g"},
+	{"626262", "a3gV"},
+	{"636363", "aPEr"},
+	{"73696d706c792061206c6f6e6720737472696e67", "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+	{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	{"516b6fcd0f", "ABnLTmg"},
+	{"bf4f89001e670274dd", "3SEo3LWLoPntC"},
+	{"572e4794", "3EFU7m"},
+	{"ecac89cad93923c02321", "EJDM8drfXA6uyA"},
+	{"10c8511e", "Rt5zm"},
+	{"00000000000000000000", "1111111111"},
+}
+
+func TestBase58(t *testing.T) {
+	for _, test := range base58Vectors {
+		data, _ := hex.DecodeString(test.hex)
+		if encoded := string(Base58Encode(data)); encoded != test.encoded {
+			t.Errorf("Base58Encode(%s) = %s, want %s", test.hex, encoded, test.encoded)
+		}
+		decoded, err := Base58Decode([]byte(test.encoded))
+		if err != nil {
+			t.Errorf("Base58Decode(%s): %v", test.encoded, err)
+			continue
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("Base58Decode(%s) = %x, want %s", test.encoded, decoded, test.hex)
+		}
+	}
+
+	// 0 O I l 不在字母表中
+	for _, invalid := range []string{"0", "1O1", "3SEo3LWLoPntI", "l"} {
+		if _, err := Base58Decode([]byte(invalid)); !errors.Is(err, ErrInvalidCharacter) {
+			t.Errorf("Base58Decode(%s) error %v, want %v", invalid, err, ErrInvalidCharacter)
+		}
+	}
+}
+
+func TestBase58Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		version byte
+		payload string
+		wantErr error
+	}{
+		{
+			name:    "genesis address",
+			encoded: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+			version: 0x00,
+			payload: "62e907b15cbf27d5425399ebf6f0fb50ebb88f18",
+		},
+		{
+			name:    "wif private key",
+			encoded: "5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ",
+			version: 0x80,
+			payload: "0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d",
+		},
+		{name: "last character changed", encoded: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb", wantErr: ErrChecksumMismatch},
+		{name: "invalid character", encoded: "1A1zP1eP5QGefi2DMPTfTL5SLmv7Div0Na", wantErr: ErrInvalidCharacter},
+		{name: "too short", encoded: "1111", wantErr: ErrInvalidLength},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, payload, err := Base58CheckDecode(test.encoded)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Base58CheckDecode error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if version != test.version || hex.EncodeToString(payload) != test.payload {
+				t.Errorf("Base58CheckDecode = %#x %x, want %#x %s", version, payload, test.version, test.payload)
+			}
+			if encoded := Base58CheckEncode(version, payload); encoded != test.encoded {
+				t.Errorf("Base58CheckEncode = %s, want %s", encoded, test.encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	BECH32CHARSET      = "qpzry9x8gf2tvdw0s3jn54khce6mua7l" // 每个字符代表5位, 去掉了容易混淆的 1 b i o
+	BECH32SEPARATOR    = '1'                                // 前缀和数据之间的分隔符, 前缀中也可以出现, 以最后一个为准
+	BECH32CHECKSUMSIZE = 6                                  // 校验和是6个字符(30位)
+	BECH32MAXLENGTH    = 90
+
+	BECH32ADDRESSVERSION = 0 // 地址数据的第一个5位组, 预留给以后的地址类型
+)
+
+var (
+	ErrMixedCase         = errors.New("mixed upper and lower case")
+	ErrMissingSeparator  = errors.New("missing separator '1'")
+	ErrInvalidBech32Char = errors.New("invalid bech32 character")
+	ErrInvalidPadding    = errors.New("invalid padding")
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Polymod computes the BCH checksum of the 5-bit values
+//
+// BCH 码的校验和, 任意不超过4个字符的错误都能被检测出来
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HRPExpand spreads the human-readable part over 5-bit values so it is covered by the checksum
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, make([]byte, BECH32CHECKSUMSIZE)...)) ^ 1
+
+	checksum := make([]byte, BECH32CHECKSUMSIZE)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+func bech32VerifyChecksum(hrp string, data []byte) bool {
+	return bech32Polymod(append(bech32HRPExpand(hrp), data...)) == 1
+}
+
+// Bech32Encode encodes 5-bit values under a human-readable prefix
+//
+// Bech32 编码: 前缀 + '1' + 数据 + 校验和, 只使用小写字母和数字
+func Bech32Encode(hrp string, data []byte) string {
+	combined := append(append([]byte{}, data...), bech32Checksum(hrp, data)...)
+
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte(BECH32SEPARATOR)
+	for _, v := range combined {
+		builder.WriteByte(BECH32CHARSET[v])
+	}
+	return builder.String()
+}
+
+// Bech32Decode decodes a Bech32 string into its human-readable prefix and 5-bit values
+//
+// 解码并校验 Bech32 字符串; 校验和不匹配时尝试找出写错的那一个字符, 在错误信息中给出位置
+func Bech32Decode(input string) (string, []byte, error) {
+	if len(input) > BECH32MAXLENGTH {
+		return "", nil, fmt.Errorf("%w: %d characters, at most %d", ErrInvalidLength, len(input), BECH32MAXLENGTH)
+	}
+	// 先检查字符范围, 非ASCII字符不能被当作大小写混合
+	for i := 0; i < len(input); i++ {
+		if input[i] < 33 || input[i] > 126 {
+			return "", nil, fmt.Errorf("%w %q at position %d", ErrInvalidBech32Char, input[i], i)
+		}
+	}
+	lower, upper := strings.ToLower(input), strings.ToUpper(input)
+	if input != lower && input != upper {
+		return "", nil, ErrMixedCase
+	}
+	input = lower
+
+	separator := strings.LastIndexByte(input, BECH32SEPARATOR)
+	if separator < 1 {
+		return "", nil, ErrMissingSeparator
+	}
+	if len(input)-separator-1 < BECH32CHECKSUMSIZE {
+		return "", nil, fmt.Errorf("%w: data part is shorter than the checksum", ErrInvalidLength)
+	}
+
+	hrp := input[:separator]
+	data := make([]byte, 0, len(input)-separator-1)
+	for i := separator + 1; i < len(input); i++ {
+		v := strings.IndexByte(BECH32CHARSET, input[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("%w %q at position %d", ErrInvalidBech32Char, input[i], i)
+		}
+		data = append(data, byte(v))
+	}
+
+	if !bech32VerifyChecksum(hrp, data) {
+		if position, ok := bech32LocateTypo(hrp, data); ok {
+			return "", nil, fmt.Errorf("%w, check the character at position %d", ErrChecksumMismatch, separator+1+position)
+		}
+		return "", nil, ErrChecksumMismatch
+	}
+	return hrp, data[:len(data)-BECH32CHECKSUMSIZE], nil
+}
+
+// bech32LocateTypo looks for the one character whose replacement makes the checksum valid
+//
+// 逐个位置尝试替换成其他31个字符, 只有一个位置可以修正时才认为找到了写错的字符;
+// 只用于提示用户, 不会自动修正地址
+func bech32LocateTypo(hrp string, data []byte) (int, bool) {
+	position, found := -1, 0
+	candidate := append([]byte{}, data...)
+	for i := range candidate {
+		original := candidate[i]
+		for v := byte(0); v < 32; v++ {
+			if v == original {
+				continue
+			}
+			candidate[i] = v
+			if bech32VerifyChecksum(hrp, candidate) {
+				position = i
+				found++
+				break
+			}
+		}
+		candidate[i] = original
+	}
+	return position, found == 1
+}
+
+// convertBits regroups a byte slice from fromBits-bit values into toBits-bit values
+//
+// 8位和5位之间的转换; 编码时补0, 解码时多出来的位必须是0并且不能超过一组
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<toBits - 1
+
+	var result []byte
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("value %d does not fit in %d bits", v, fromBits)
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, ErrInvalidPadding
+	}
+	return result, nil
+}
+
+// EncodeBech32Address encodes a public key hash as a Bech32 address of the current network
+func EncodeBech32Address(pubkeyHash []byte) string {
+	data, _ := convertBits(pubkeyHash, 8, 5, true) // 8位的输入一定可以转换
+	return Bech32Encode(Params.Bech32HRP, append([]byte{BECH32ADDRESSVERSION}, data...))
+}
+
+// isBech32Address reports whether an address starts with the human-readable part of a known network
+func isBech32Address(address string) bool {
+	lower := strings.ToLower(address)
+	separator := strings.LastIndexByte(lower, BECH32SEPARATOR)
+	if separator < 1 {
+		return false
+	}
+	for _, params := range Networks {
+		if lower[:separator] == params.Bech32HRP {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBech32Address returns the public key hash of a Bech32 address of the current network
+func decodeBech32Address(address string) ([]byte, error) {
+	hrp, data, err := Bech32Decode(address)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != Params.Bech32HRP {
+		return nil, fmt.Errorf("%w (prefix %s)", ErrWrongNetwork, hrp)
+	}
+	if len(data) == 0 || data[0] != BECH32ADDRESSVERSION {
+		return nil, fmt.Errorf("%w: unsupported bech32 address version", ErrUnknownVersion)
+	}
+
+	pubkeyHash, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(pubkeyHash) != PUBKEYHASHLENGTH {
+		return nil, fmt.Errorf("%w: public key hash is %d bytes, want %d", ErrInvalidLength, len(pubkeyHash), PUBKEYHASHLENGTH)
+	}
+	return pubkeyHash, nil
+}
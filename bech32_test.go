@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBech32(t *testing.T) {
+	// BIP173 中校验和正确的字符串
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11" + strings.Repeat("q", 82) + "c8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	}
+	for _, test := range valid {
+		hrp, data, err := Bech32Decode(test)
+		if err != nil {
+			t.Errorf("Bech32Decode(%s): %v", test, err)
+			continue
+		}
+		if encoded := Bech32Encode(hrp, data); encoded != strings.ToLower(test) {
+			t.Errorf("Bech32Encode(%s, %v) = %s, want %s", hrp, data, encoded, strings.ToLower(test))
+		}
+	}
+
+	// BIP173 中无效的字符串
+	invalid := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"hrp character out of range", "\x201nwldj5", ErrInvalidBech32Char},
+		{"hrp character out of range", "\x7f1axkwrx", ErrInvalidBech32Char},
+		{"overall max length exceeded", "an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", ErrInvalidLength},
+		{"no separator", "pzry9x0s0muk", ErrMissingSeparator},
+		{"empty hrp", "1pzry9x0s0muk", ErrMissingSeparator},
+		{"invalid data character", "x1b4n0q5v", ErrInvalidBech32Char},
+		{"too short checksum", "li1dgmt3", ErrInvalidLength},
+		{"invalid character in checksum", "de1lg7wt\xff", ErrInvalidBech32Char},
+		{"checksum calculated with uppercase hrp", "A1G7SGD8", ErrChecksumMismatch},
+		{"empty hrp", "10a06t8", ErrMissingSeparator},
+		{"empty hrp", "1qzzfhee", ErrMissingSeparator},
+		{"mixed case", "A12uEL5L", ErrMixedCase},
+	}
+	for _, test := range invalid {
+		if _, _, err := Bech32Decode(test.encoded); !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: Bech32Decode(%q) error %v, want %v", test.name, test.encoded, err, test.wantErr)
+		}
+	}
+}
+
+func TestBech32Address(t *testing.T) {
+	// BIP173 的 P2WPKH 地址, 数据是版本0和20字节的公钥哈希, 与这里的地址格式相同
+	pubkeyHash, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	tests := []struct {
+		hrp     string
+		address string
+	}{
+		{"bc", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4"},
+		{"tb", "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+	}
+	for _, test := range tests {
+		hrp, data, err := Bech32Decode(test.address)
+		if err != nil {
+			t.Errorf("Bech32Decode(%s): %v", test.address, err)
+			continue
+		}
+		if hrp != test.hrp || data[0] != BECH32ADDRESSVERSION {
+			t.Errorf("Bech32Decode(%s) = %s version %d, want %s version 0", test.address, hrp, data[0], test.hrp)
+		}
+		decoded, err := convertBits(data[1:], 5, 8, false)
+		if err != nil || !bytes.Equal(decoded, pubkeyHash) {
+			t.Errorf("public key hash of %s = %x %v, want %x", test.address, decoded, err, pubkeyHash)
+		}
+	}
+
+	address := EncodeBech32Address(pubkeyHash)
+	decoded, err := decodeBech32Address(address)
+	if err != nil || !bytes.Equal(decoded, pubkeyHash) {
+		t.Errorf("decodeBech32Address(%s) = %x %v, want %x", address, decoded, err, pubkeyHash)
+	}
+
+	// 写错一个字符时错误信息给出位置
+	typo := []byte(address)
+	position := len(typo) - 10
+	typo[position] = BECH32CHARSET[(strings.IndexByte(BECH32CHARSET, typo[position])+1)%32]
+	_, err = decodeBech32Address(string(typo))
+	if !errors.Is(err, ErrChecksumMismatch) || !strings.Contains(err.Error(), "position") {
+		t.Errorf("decodeBech32Address(%s) error %v, want %v with the position", typo, err, ErrChecksumMismatch)
+	}
+}
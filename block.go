@@ -24,6 +24,7 @@ type Block struct {
 // CreateMerkleRoot creates a merkle root from the transactions
 //
 // The merkle root is the hash of the root node of the merkle tree
+// 叶子节点是完整交易(包括签名)的哈希, 所以替换区块中的任何交易都会改变默克尔根; 不修改区块的交易列表
 func (b *Block) CreateMerkleRoot() []byte {
 
 	// if there are no transactions, merkle root is nil
@@ -31,12 +32,6 @@ func (b *Block) CreateMerkleRoot() []byte {
 		return nil
 	}
 
-	// number of transactions is odd, repeat the last transaction
-	if len(b.Transactions)%2 != 0 {
-		// repeat the last transaction
-		b.Transactions = append(b.Transactions, b.Transactions[len(b.Transactions)-1])
-	}
-
 	var txHashes [][]byte
 
 	for _, tx := range b.Transactions {
@@ -44,11 +39,16 @@ func (b *Block) CreateMerkleRoot() []byte {
 	}
 
 	for len(txHashes) > 1 {
+		// number of hashes is odd, repeat the last hash; 每一层都要补齐
+		if len(txHashes)%2 != 0 {
+			txHashes = append(txHashes, txHashes[len(txHashes)-1])
+		}
+
 		newTxHashes := [][]byte{}
 
 		for i := 0; i < len(txHashes); i += 2 {
 			// concatenate the two hashes and calculate the hash
-			hash := sha256.Sum256(append(txHashes[i], txHashes[i+1]...))
+			hash := sha256.Sum256(bytes.Join([][]byte{txHashes[i], txHashes[i+1]}, []byte{}))
 			newTxHashes = append(newTxHashes, hash[:])
 		}
 		txHashes = newTxHashes
@@ -72,12 +72,23 @@ func (b Block) Serialize() []byte {
 //
 // 根据序列化的数据，进行反序列化，返回一个区块的指针
 func Deserialize(d []byte) *Block {
+	block, err := DeserializeBlock(d)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+// DeserializeBlock is like Deserialize but returns an error instead of panicking
+//
+// 用于解析来自其他节点的区块数据, 数据可能是恶意构造的，所以不能panic
+func DeserializeBlock(d []byte) (*Block, error) {
 	var block Block
 	err := gob.NewDecoder(bytes.NewReader(d)).Decode(&block)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &block
+	return &block, nil
 }
 
 func (b *Block) String() string {
@@ -96,28 +107,32 @@ func (b *Block) String() string {
 // GenesisBlock creates and returns genesis block
 //
 // 创世纪区块是区块链中的第一个区块，它是在区块链系统启动时创建的，而不是像其他区块一样通过工作量证明算法创建的。
-func GenesisBlock() *Block {
+func GenesisBlock() (*Block, error) {
 	// coinbase transaction
-	// XXX: 这里的地址是我自己的地址，你可以换成你自己的地址
-	coinbaseTx := CoinBaseTx("1FBae9FyJTofCbWYK2hMHnxtf78qreFTSD")
+	// 创世区块的收款地址和时间由网络参数决定, 同一个网络的所有节点创建的创世区块相同
+	coinbaseTx, err := CoinBaseTx(Params.GenesisAddress)
+	if err != nil {
+		return nil, fmt.Errorf("genesis block failed, %w", err)
+	}
 	block := &Block{
-		1,                 // Version= 1
-		[]byte{},          // PrevBlockHash= {}
-		nil,               // MerkleRoot= nil
-		nil,               // Hash= nil
-		time.Now().Unix(), // Time= 0
-		0,                 // Bits= 0
-		0,                 // Nonce= 0
+		1,                  // Version= 1
+		[]byte{},           // PrevBlockHash= {}
+		nil,                // MerkleRoot= nil
+		nil,                // Hash= nil
+		Params.GenesisTime, // Time= 网络参数中固定的时间
+		0,                  // Bits= 0
+		0,                  // Nonce= 0
 		[]*Transaction{coinbaseTx},
 		0, // Height= 0
 	} // Transaction list
+	block.MerkleRoot = block.CreateMerkleRoot() // 默克尔根是区块头的一部分, 必须在工作量证明之前计算
 
 	pow := NewPOW(block)
 	// calculate the nonce and hash
 	nonce, hash := pow.Run()
 	// hash of genesis block cannot be 0
 	block.Nonce, block.Hash = nonce, hash[:]
-	return block
+	return block, nil
 }
 
 // NewBlock creates and returns Block
@@ -135,6 +150,7 @@ func NewBlock(prevBlockHash []byte, transactions []*Transaction, latestHeight in
 		transactions,
 		latestHeight, // Height= latestHeight
 	} // Transaction list
+	block.MerkleRoot = block.CreateMerkleRoot() // 默克尔根是区块头的一部分, 必须在工作量证明之前计算
 
 	pow := NewPOW(block)
 	// calculate the nonce and hash
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"reflect"
 	"testing"
@@ -18,3 +20,54 @@ func TestSerialize(t *testing.T) {
 		t.Errorf("TestSerialize failed, expected %v, got %v", block, rs)
 	}
 }
+
+func TestCreateMerkleRoot(t *testing.T) {
+	txs := []*Transaction{}
+	for i := 1; i <= 5; i++ {
+		txs = append(txs, &Transaction{ID: []byte{byte(i)}, Out: []TXoutput{{Value: i, PublickeyHash: []byte{byte(i)}}}})
+	}
+	pair := func(a, b []byte) []byte {
+		hash := sha256.Sum256(append(append([]byte{}, a...), b...))
+		return hash[:]
+	}
+	h := func(i int) []byte { return txs[i].Hash() }
+
+	tests := []struct {
+		name string
+		txs  []*Transaction
+		want []byte
+	}{
+		{"empty", nil, nil},
+		{"one", txs[:1], h(0)},
+		{"two", txs[:2], pair(h(0), h(1))},
+		{"three", txs[:3], pair(pair(h(0), h(1)), pair(h(2), h(2)))},
+		// 第二层只有三个节点, 也要复制最后一个补齐
+		{"five", txs[:5], pair(pair(pair(h(0), h(1)), pair(h(2), h(3))), pair(pair(h(4), h(4)), pair(h(4), h(4))))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := &Block{Transactions: tt.txs}
+			if got := block.CreateMerkleRoot(); !bytes.Equal(got, tt.want) {
+				t.Errorf("CreateMerkleRoot() = %x, want %x", got, tt.want)
+			}
+			if len(block.Transactions) != len(tt.txs) {
+				t.Errorf("CreateMerkleRoot changed the transactions, got %d want %d", len(block.Transactions), len(tt.txs))
+			}
+		})
+	}
+}
+
+func TestCheckBlockMerkleRoot(t *testing.T) {
+	tx := &Transaction{ID: []byte{1}, Out: []TXoutput{{Value: 10, PublickeyHash: []byte{1}}}}
+	block := NewBlock([]byte{}, []*Transaction{tx}, 0)
+	if err := checkBlock(block); err != nil {
+		t.Fatalf("checkBlock() = %v, want nil", err)
+	}
+
+	// 替换交易之后默克尔根对不上, 工作量证明仍然有效
+	block.Transactions = []*Transaction{{ID: []byte{2}, Out: []TXoutput{{Value: 1000, PublickeyHash: []byte{2}}}}}
+	err := checkBlock(block)
+	if err == nil || err.Score != MISBEHAVIOR_BADBLOCK {
+		t.Fatalf("checkBlock() = %v, want a bad block misbehavior", err)
+	}
+}
@@ -6,18 +6,20 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
 
 const (
-	DBFILE      = "blockchain.db" // 数据库文件名
-	BLOCKBUCKET = "blocks"        // 区块桶名
+	BLOCKBUCKET = "blocks" // 区块桶名
 )
 
 type Blockchain struct {
-	topHash []byte   // 最新区块的哈希值
-	db      *bolt.DB // 数据库
+	topHash []byte       // 最新区块的哈希值
+	topMu   sync.RWMutex // 连接区块的时候 RPC, REST 和浏览器同时读取最新区块
+	db      *bolt.DB     // 数据库
 }
 
 type BlockchainIterator struct {
@@ -31,15 +33,26 @@ type BlockchainIterator struct {
 //
 // 返回最新区块的哈希值
 func (bc *Blockchain) GetTopHash() []byte {
+	bc.topMu.RLock()
+	defer bc.topMu.RUnlock()
+
 	return bc.topHash
 }
 
+// setTopHash records the hash of the new latest block
+func (bc *Blockchain) setTopHash(hash []byte) {
+	bc.topMu.Lock()
+	defer bc.topMu.Unlock()
+
+	bc.topHash = hash
+}
+
 // CreateBlockchain creates a new blockchain DB
 //
-// 创建一个新的区块链并且添加一个创世区块
-func CreateBlockchain() *Blockchain {
+// 打开dbFile中的区块链, 如果区块链不存在则创建一个新的区块链并且添加一个创世区块
+func CreateBlockchain(dbFile string) *Blockchain {
 	// 0600 文件拥有者具有读写权限，其他人无任何权限
-	boltDB, err := bolt.Open(DBFILE, 0600, nil)
+	boltDB, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -52,7 +65,10 @@ func CreateBlockchain() *Blockchain {
 		// if bucket is nil, blockchain doesnt exist, we then create a new blockchain
 		if bucket == nil {
 			// create a genesisblock
-			genesisBlock := GenesisBlock()
+			genesisBlock, err := GenesisBlock()
+			if err != nil {
+				return err
+			}
 
 			// 创建一个新的bucket
 			bucket, err = tx.CreateBucket([]byte(BLOCKBUCKET))
@@ -75,7 +91,8 @@ func CreateBlockchain() *Blockchain {
 		} else {
 			// genesis block already exists,
 			// get the latest block hash
-			tophash = bucket.Get([]byte("latest"))
+			// bolt 返回的切片只在事务中有效, 数据库扩容重新映射之后就失效了, 必须复制
+			tophash = append([]byte{}, bucket.Get([]byte("latest"))...)
 		}
 		return nil
 	})
@@ -100,6 +117,7 @@ func (bc *Blockchain) AddBlock(txs []*Transaction) (bool, *Block) {
 	var latestHeight int64
 
 	// 验证交易序列中的所有交易都是有效的
+	validationStart := time.Now()
 	for _, tx := range txs {
 		if !bc.VerifyTransaction(tx) {
 			fmt.Printf("This Transaction is invalid: %v\n", tx)
@@ -108,11 +126,12 @@ func (bc *Blockchain) AddBlock(txs []*Transaction) (bool, *Block) {
 			fmt.Printf("This Transaction is valid\n: %v\n", tx)
 		}
 	}
+	Metrics.BlockValidation["local"].ObserveSince(validationStart)
 
 	// get the latest block hash
 	err := bc.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(BLOCKBUCKET))
-		tophash = bucket.Get([]byte("latest")) // 获取最新区块的哈希值
+		tophash = append([]byte{}, bucket.Get([]byte("latest"))...) // 获取最新区块的哈希值, 复制之后在事务外使用
 
 		blockdata := bucket.Get(tophash)
 		block := Deserialize(blockdata)
@@ -142,7 +161,7 @@ func (bc *Blockchain) AddBlock(txs []*Transaction) (bool, *Block) {
 		}
 
 		// update the latest block hash
-		bc.topHash = newBlock.Hash
+		bc.setTopHash(newBlock.Hash)
 
 		return nil
 	})
@@ -151,6 +170,7 @@ func (bc *Blockchain) AddBlock(txs []*Transaction) (bool, *Block) {
 		panic(err)
 	}
 
+	Events.publishTipChange(nil, []*Block{newBlock})
 	return true, newBlock
 }
 
@@ -160,7 +180,7 @@ func (bc *Blockchain) AddBlock(txs []*Transaction) (bool, *Block) {
 //
 // 创建一个区块链迭代器
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{bc.topHash, bc.db}
+	return &BlockchainIterator{bc.GetTopHash(), bc.db}
 }
 
 // Next returns the next block of the blockchain according to the current hash
@@ -299,6 +319,73 @@ func (bc *Blockchain) FindUTXO(pubkeyHash []byte) []*TXoutput {
 	return UTXOs
 }
 
+// FindUsedPubkeyHashes returns every public key hash that appears in the chain
+//
+// 遍历整个区块链, 记录所有出现在交易输出和交易输入中的公钥哈希, 用于恢复钱包时判断地址是否使用过
+func (bc *Blockchain) FindUsedPubkeyHashes() map[string]bool {
+	used := make(map[string]bool)
+
+	iterator := bc.Iterator()
+	for {
+		block := iterator.Next()
+
+		for _, tx := range block.Transactions {
+			for _, output := range tx.Out {
+				used[string(output.PublickeyHash)] = true
+			}
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, input := range tx.In {
+				used[string(PublickeyHash(input.Pubkey))] = true
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return used
+}
+
+// FindSpendableCoins returns every unspent output of a public key hash
+//
+// 从最新的区块向前遍历, 先看到花费再看到被花费的输出, 所以没有被记录为已花费的输出就是未花费输出
+func (bc *Blockchain) FindSpendableCoins(pubkeyHash []byte) []Coin {
+	coins := []Coin{}
+	spent := make(map[string]bool) // 已经被花费的输出, key 是 Outpoint.String()
+
+	iterator := bc.Iterator()
+	for {
+		block := iterator.Next()
+
+		for _, tx := range block.Transactions {
+			for outIdx, output := range tx.Out {
+				outpoint := Outpoint{TxID: tx.ID, Index: outIdx}
+				if !spent[outpoint.String()] && output.CanBeUnlockedWith(pubkeyHash) {
+					coins = append(coins, Coin{Outpoint: outpoint, Value: output.Value})
+				}
+			}
+
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, input := range tx.In {
+				if input.CanUnlockOutputWith(pubkeyHash) {
+					spent[Outpoint{TxID: input.TXid, Index: input.Voutindex}.String()] = true
+				}
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return coins
+}
+
 // FindSpendableOutputs finds all unspent transaction outputs according to the address and the amount
 //
 // 根据给定的地址和金额，找到这个地址在当前区块链中所没有花费的输出，
@@ -380,24 +467,49 @@ func (tx *Transaction) sign(privatekey ecdsa.PrivateKey, mapping map[string]*Tra
 		}
 	}
 
-	// 对当前的tx进行复制, 其中Inputs的Signature和Pubkey设置为nil
-	txcopy := tx.trimmedCopy()
-
-	// 对txcopy的所有输入进行签名
-	for inIdx, input := range txcopy.In {
+	prevPubkeyHashes := make([][]byte, len(tx.In))
+	for inIdx, input := range tx.In {
 		preTx := mapping[string(input.TXid)]
-		txcopy.In[inIdx].Signature = nil
-		txcopy.In[inIdx].Pubkey = preTx.Out[input.Voutindex].PublickeyHash // 设置Pubkey为上一笔交易的输出的公钥哈希
+		prevPubkeyHashes[inIdx] = preTx.Out[input.Voutindex].PublickeyHash // 上一笔交易的输出的公钥哈希
+	}
 
-		txcopy.ID = txcopy.Hash()                                    // 重新计算交易的哈希值
-		r, s, err := ecdsa.Sign(rand.Reader, &privatekey, txcopy.ID) // 对交易的哈希值进行签名
-		if err != nil {
-			panic(err)
-		}
+	// 对tx的所有输入进行签名
+	for inIdx, hash := range tx.signatureHashes(prevPubkeyHashes) {
+		tx.In[inIdx].Signature = signHash(privatekey, hash)
+	}
+}
+
+// signatureHashes returns the hash signed by each input, given the public key hashes of the spent outputs
+//
+// 对交易进行复制, 其中Inputs的Signature和Pubkey设置为nil; 计算第i个输入的哈希时,
+// 只把第i个输入的Pubkey设置为引用的输出的公钥哈希, 和 Verify 的验证过程一致
+func (tx *Transaction) signatureHashes(prevPubkeyHashes [][]byte) [][]byte {
+	txcopy := tx.trimmedCopy()
+
+	hashes := make([][]byte, len(txcopy.In))
+	for inIdx := range txcopy.In {
+		txcopy.In[inIdx].Pubkey = prevPubkeyHashes[inIdx]
+		txcopy.ID = txcopy.Hash() // 重新计算交易的哈希值
+		hashes[inIdx] = txcopy.ID
+		txcopy.In[inIdx].Pubkey = nil // 将交易输入的公钥置空 用于下一次循环
+	}
+	return hashes
+}
 
-		signature := append(r.Bytes(), s.Bytes()...)
-		tx.In[inIdx].Signature = signature
+// signHash signs a hash, the signature is r || s with both halves padded to the same length
+//
+// Verify 从中间把签名分成 r 和 s, 所以两部分必须一样长
+func signHash(privatekey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privatekey, hash) // 对交易的哈希值进行签名
+	if err != nil {
+		panic(err)
 	}
+
+	size := (privatekey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature
 }
 
 // trimmedCopy returns a copy of the transaction with all inputs' signature and pubkey set to nil
@@ -443,6 +555,46 @@ func (bc *Blockchain) FindTxByID(txID []byte) (*Transaction, error) {
 	return &Transaction{}, errors.New("Transaction is not found")
 }
 
+// FindTxBlock returns a transaction of the main chain together with the block that contains it
+//
+// 和 FindTxByID 一样从最新的区块开始查找, 同时返回交易所在的区块
+func (bc *Blockchain) FindTxBlock(txID []byte) (*Transaction, *Block, error) {
+	bcIterator := bc.Iterator()
+
+	for {
+		block := bcIterator.Next()
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				return tx, block, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, nil, fmt.Errorf("transaction %x is not found", txID)
+}
+
+// GetBlockByHeight returns the main chain block at a height
+func (bc *Blockchain) GetBlockByHeight(height int64) (*Block, error) {
+	bcIterator := bc.Iterator()
+
+	for {
+		block := bcIterator.Next()
+		if block.Height == height {
+			return block, nil
+		}
+		// 从最新的区块往回找, 高度已经比目标小说明不存在
+		if block.Height < height || len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("block at height %d is not found", height)
+}
+
 // VerifyTransaction verifies the transaction using the public key
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	prevTxs := make(map[string]*Transaction) // 记录tx的所有输入所在的交易
@@ -455,8 +607,9 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	for _, input := range tx.In {
 		prevTx, err := bc.FindTxByID(input.TXid) // 找到tx的输入所在的交易
 		if err != nil {
+			// 找不到引用的交易，说明这笔交易是无效的
 			fmt.Printf("Transaction is not found: %v\n", err)
-			panic(err)
+			return false
 		}
 
 		prevTxs[string(input.TXid)] = prevTx // 把交易放入map中
@@ -467,10 +620,11 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 
 // FindAllUTXO finds all unspent transaction outputs
 //
-// 找到所有未花费的输出; map [交易ID] 所有未花费的输出
-func (bc *Blockchain) FindAllUTXO() map[string]TXoutputSlice {
+// 找到所有未花费的输出; map [交易ID] 所有未花费的输出和它们在交易中的索引
+func (bc *Blockchain) FindAllUTXO() map[string]UnspentOutputs {
 	// nil值slice可以使用append函数
-	UTXO := make(map[string]TXoutputSlice) // map [交易ID] 所有未花费的输出
+	UTXO := make(map[string]UnspentOutputs) // map [交易ID] 所有未花费的输出
+	seen := make(map[string]bool)           // 已经遍历过的交易ID
 
 	spentTxs := make(map[string][]int) // 记录一笔交易中所有被使用的输出
 
@@ -483,6 +637,12 @@ func (bc *Blockchain) FindAllUTXO() map[string]TXoutputSlice {
 		for _, tx := range block.Transactions {
 			txID := string(tx.ID) // 获取交易ID
 
+			// 交易ID相同的旧交易的输出已经被新交易覆盖了, 和 UpdateUTXO 保持一致
+			if seen[txID] {
+				continue
+			}
+			seen[txID] = true
+
 			// 如果一个交易中的某个输出被使用了，跳过这个交易遍历下一个输出
 		Outputs:
 			// 遍历交易中的所有输出, 判断是否被使用
@@ -504,7 +664,7 @@ func (bc *Blockchain) FindAllUTXO() map[string]TXoutputSlice {
 
 				// update the UTXO[txID] slice
 				outputslice := UTXO[txID]
-				outputslice = append(outputslice, output)
+				outputslice = append(outputslice, UnspentOutput{Index: outputIdx, Output: output})
 				UTXO[txID] = outputslice
 			}
 
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	NETWORKMAINNET = "mainnet"
+	NETWORKTESTNET = "testnet"
+	NETWORKREGTEST = "regtest"
+
+	DEFAULTNETWORK = NETWORKMAINNET
+
+	MAGICLENGTH = 4 // 每条P2P消息前面的网络标识的长度
+)
+
+var (
+	ErrWrongNetwork = errors.New("belongs to another network")
+)
+
+// ChainParams are the settings that differ between networks
+//
+// 不同网络的参数: 地址和私钥的版本号, 默认端口, 创世区块, 消息的网络标识和数据目录;
+// 不同网络的节点不能互相连接, 地址也不能混用
+type ChainParams struct {
+	Name           string
+	Magic          [MAGICLENGTH]byte // 网络标识, 其他网络的消息会被丢弃; 以0xbb开头并且都大于0x7f, 和比特币的网络以及文本协议都不会相同
+	AddressVersion byte              // 地址的版本号
+	WIFVersion     byte              // WIF私钥的版本号
+	Bech32HRP      string            // Bech32 地址的前缀
+	DefaultPort    int               // 默认的节点ID和端口
+	DataSubdir     string            // 数据目录下的子目录, 主网直接使用数据目录
+	GenesisAddress string            // 创世区块的coinbase收款地址
+	GenesisTime    int64             // 创世区块的时间, 固定的时间保证所有节点的创世区块相同
+	TargetBits     int               // 挖矿难度, 区块hash的前 TargetBits 位必须是0
+}
+
+// Networks are the networks a node can join
+var Networks = map[string]*ChainParams{
+	NETWORKMAINNET: {
+		Name:           NETWORKMAINNET,
+		Magic:          [MAGICLENGTH]byte{0xbb, 0xc1, 0xa9, 0xe2},
+		AddressVersion: MAINNET_VERSION,
+		WIFVersion:     MAINNET_WIF_VERSION,
+		Bech32HRP:      "bb",
+		DefaultPort:    3000,
+		GenesisAddress: "1FBae9FyJTofCbWYK2hMHnxtf78qreFTSD",
+		GenesisTime:    1704067200,
+		TargetBits:     16,
+	},
+	NETWORKTESTNET: {
+		Name:           NETWORKTESTNET,
+		Magic:          [MAGICLENGTH]byte{0xbb, 0xd7, 0x95, 0xc4},
+		AddressVersion: TESTNET_VERSION,
+		WIFVersion:     TESTNET_WIF_VERSION,
+		Bech32HRP:      "tbb",
+		DefaultPort:    13000,
+		DataSubdir:     NETWORKTESTNET,
+		GenesisAddress: "muhXwCLx7VEuyhzA2bfj7iBDX6jYjjLgxY", // 和主网创世地址是同一个私钥
+		GenesisTime:    1704153600,
+		TargetBits:     16,
+	},
+	// 本地测试网络, 和测试网使用相同的地址格式, 挖矿难度很低
+	NETWORKREGTEST: {
+		Name:           NETWORKREGTEST,
+		Magic:          [MAGICLENGTH]byte{0xbb, 0xe3, 0x8f, 0xb6},
+		AddressVersion: TESTNET_VERSION,
+		WIFVersion:     TESTNET_WIF_VERSION,
+		Bech32HRP:      "bbrt", // 和测试网的 Base58 地址相同, 但是 Bech32 地址可以区分
+		DefaultPort:    23000,
+		DataSubdir:     NETWORKREGTEST,
+		GenesisAddress: "muhXwCLx7VEuyhzA2bfj7iBDX6jYjjLgxY",
+		GenesisTime:    1704240000,
+		TargetBits:     4,
+	},
+}
+
+// Params are the parameters of the network this node runs on
+var Params = Networks[DEFAULTNETWORK]
+
+// SelectNetwork switches the chain parameters to a network
+func SelectNetwork(name string) error {
+	params, ok := Networks[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown network %q, must be mainnet, testnet or regtest", name)
+	}
+	Params = params
+	return nil
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // CLI responsible for processing command line arguments
 
 type CLI struct {
 	Blockchain *Blockchain
+	RPC        *RPCClient // 节点正在运行时不为空, 支持的命令通过RPC交给节点执行
 }
 
 // validateArgs validates the arguments of the command line, must be greater than 1
@@ -24,83 +31,526 @@ func (cli *CLI) validateArgs() {
 	}
 }
 
+// parseNodeConfig parses the node flags in front of the command and returns the remaining arguments
+//
+// 解析命令前面的节点配置参数, 优先级: 命令行参数 > 环境变量NODE_ID > 配置文件 > 默认值
+// 例如: main -nodeid 3001 -datadir ./node3001 startnode -minner xxx
+func (cli *CLI) parseNodeConfig() []string {
+	global := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := global.String("config", "", "JSON config file of the node")
+	network := global.String("network", "", "Network to join: mainnet, testnet or regtest (default mainnet)")
+	nodeID := global.String("nodeid", "", "Node ID, can also be set with the NODE_ID env var")
+	dataDir := global.String("datadir", "", "Directory of the blockchain database and the wallet")
+	walletFile := global.String("wallet", "", "Path of the wallet file")
+	listenAddr := global.String("listen", "", "Address to listen on, default localhost:<nodeid>")
+	advertiseAddr := global.String("advertise", "", "Address announced to other nodes, default the listen address")
+	seedPeers := global.String("seeds", "", "Comma separated seed node addresses")
+	rpcListen := global.String("rpclisten", "", "Address of the JSON-RPC server, default localhost:<nodeid+1000>")
+	rpcUser := global.String("rpcuser", "", "JSON-RPC user name")
+	rpcPassword := global.String("rpcpassword", os.Getenv("RPC_PASSWORD"), "JSON-RPC password, the cookie file is used when empty (default $RPC_PASSWORD)")
+	restListen := global.String("restlisten", "", "Address of the read-only REST API, disabled when empty")
+	explorerListen := global.String("explorerlisten", "", "Address of the block explorer web UI, disabled when empty")
+	metricsListen := global.String("metricslisten", "", "Address of the Prometheus /metrics endpoint, disabled when empty")
+
+	err := global.Parse(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
+	if len(*configFile) > 0 {
+		if err := Config.LoadConfigFile(*configFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if len(*network) > 0 {
+		Config.Network = *network
+	}
+	if env := os.Getenv("NODE_ID"); len(env) > 0 {
+		Config.NodeID = env
+	}
+	if len(*nodeID) > 0 {
+		Config.NodeID = *nodeID
+	}
+	if len(*dataDir) > 0 {
+		Config.DataDir = *dataDir
+	}
+	if len(*walletFile) > 0 {
+		Config.WalletFile = *walletFile
+	}
+	if len(*listenAddr) > 0 {
+		Config.ListenAddr = *listenAddr
+	}
+	if len(*advertiseAddr) > 0 {
+		Config.AdvertiseAddr = *advertiseAddr
+	}
+	if len(*seedPeers) > 0 {
+		Config.SeedPeers = splitPeers(*seedPeers)
+	}
+	if len(*rpcListen) > 0 {
+		Config.RPCListen = *rpcListen
+	}
+	if len(*rpcUser) > 0 {
+		Config.RPCUser = *rpcUser
+	}
+	if len(*rpcPassword) > 0 {
+		Config.RPCPassword = *rpcPassword
+	}
+	if len(*restListen) > 0 {
+		Config.RESTListen = *restListen
+	}
+	if len(*explorerListen) > 0 {
+		Config.ExplorerListen = *explorerListen
+	}
+	if len(*metricsListen) > 0 {
+		Config.MetricsListen = *metricsListen
+	}
+
+	if err := Config.Finalize(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	KnownNodes = append([]string{}, Config.SeedPeers...)
+
+	return global.Args()
+}
+
 func (cli *CLI) Run() {
 	cli.validateArgs()
 
+	// --------------------- 0. 解析节点配置，打开当前节点的区块链数据库 ---------------------
+	args := cli.parseNodeConfig()
+	if len(args) < 1 {
+		fmt.Println("missing command!")
+		os.Exit(1)
+	}
+	// 节点运行时区块链数据库被节点锁定, 这时命令行只是RPC客户端
+	cli.RPC = ConnectRPC()
+	if cli.RPC == nil && args[0] == "rpc" {
+		fmt.Printf("no node is running on %q, start one with startnode\n", Config.RPCListen)
+		os.Exit(1)
+	}
+	if cli.RPC == nil {
+		cli.Blockchain = CreateBlockchain(Config.DBFile())
+	} else if !RemoteCommands[args[0]] {
+		fmt.Printf("a node is running on %s, stop it before %s or use `rpc <method> [params...]`\n", Config.RPCListen, args[0])
+		os.Exit(1)
+	}
+
 	// --------------------- 1. create a flagset addblock for addblock command ---------------------
 	startNode := flag.NewFlagSet("startnode", flag.ExitOnError)
 	startNodeMinner := startNode.String("minner", "", "Start a node with miner address")
+	startNodeBanScore := startNode.Int("banscore", DEFAULTBANSCORE, "Misbehavior score at which a peer is banned")
+	startNodeBanTime := startNode.Int64("bantime", int64(DEFAULTBANTIME/time.Second), "Number of seconds a misbehaving peer stays banned")
+	startNodeEncrypt := startNode.Bool("encrypt", false, "Use TLS when connecting to other nodes")
+	startNodeRequireEncryption := startNode.Bool("requireencryption", false, "Reject plaintext connections and always connect with TLS")
+	startNodeAllowPeers := startNode.String("allowpeers", "", "File with the key fingerprints of the only peers allowed to connect")
 
 	addBlock := flag.NewFlagSet("addblock", flag.ExitOnError)
 	printBlock := flag.NewFlagSet("printblock", flag.ExitOnError)
+	printBlockJSON := printBlock.Bool("json", false, "Print the blocks with their transactions as JSON")
+	getTx := flag.NewFlagSet("gettx", flag.ExitOnError)
+	getTxID := getTx.String("id", "", "Transaction ID in hex")
+	getTxJSON := getTx.Bool("json", false, "Print the transaction as JSON")
 	getBalance := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	// 在 "getbalance" 这个 FlagSet 对象中定义了一个新的字符串参数 "address"。
 	// 可以通过 -address 参数来提供一个地址
 	// default value is "", usage is "The address to get balance for"
-	addr := getBalance.String("address", "", "The address to get balance for")
+	addr := getBalance.String("address", "", "The address to get balance for, every wallet address when empty")
+	getBalanceWatchOnly := getBalance.Bool("includewatchonly", false, "Add the watch-only balance to the wallet total")
 
 	sendtx := flag.NewFlagSet("sendtx", flag.ExitOnError)
 	sendtxFrom := sendtx.String("from", "", "Source wallet address")
 	sendtxTo := sendtx.String("to", "", "Destination wallet address")
 	sendtxAmount := sendtx.Int("amount", 0, "Amount to send")
+	sendtxMine := sendtx.Bool("mine", true, "Mine the transaction locally instead of sending it to a node")
+	sendtxPassphrase := sendtx.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "Passphrase of an encrypted wallet (default $WALLET_PASSPHRASE)")
+	sendtxChange := sendtx.String("changeaddress", Config.ChangeAddress, "Send the change to this address instead of a fresh one")
+	sendtxDust := sendtx.Int("dust", DEFAULTDUSTTHRESHOLD, "Change up to this amount is added to the fee instead of creating an output")
+	sendtxSelection := sendtx.String("selection", DEFAULTSELECTION, "Coin selection: largest, smallest, bnb or random")
+	sendtxCoins := sendtx.String("coins", "", "Comma separated txid:index outpoints to spend instead of selecting coins")
+
+	// 一笔交易支付多个收款人
+	sendMany := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	sendManyFrom := sendMany.String("from", "", "Source wallet address")
+	sendManyTo := sendMany.String("to", "", "Comma separated address:amount recipients")
+	sendManyFile := sendMany.String("file", "", "CSV file of address,amount recipients")
+	sendManyFee := sendMany.Int("fee", 0, "Transaction fee")
+	sendManyFeeSplit := sendMany.String("feesplit", DEFAULTFEESPLIT, "Who pays the fee: sender, equal or proportional")
+	sendManyMine := sendMany.Bool("mine", true, "Mine the transaction locally instead of sending it to a node")
+	sendManyPassphrase := sendMany.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "Passphrase of an encrypted wallet (default $WALLET_PASSPHRASE)")
+	sendManyChange := sendMany.String("changeaddress", Config.ChangeAddress, "Send the change to this address instead of a fresh one")
+	sendManyDust := sendMany.Int("dust", DEFAULTDUSTTHRESHOLD, "Change up to this amount is added to the fee instead of creating an output")
+	sendManySelection := sendMany.String("selection", DEFAULTSELECTION, "Coin selection: largest, smallest, bnb or random")
+	sendManyCoins := sendMany.String("coins", "", "Comma separated txid:index outpoints to spend instead of selecting coins")
 
 	// 创建钱包
 	createWallet := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	createWalletPassphrase := createWallet.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "Passphrase of an encrypted wallet (default $WALLET_PASSPHRASE)")
+	createWalletFormat := createWallet.String("format", DEFAULTADDRESSFORMAT, "Address format: base58 or bech32")
 	listAddress := flag.NewFlagSet("listaddress", flag.ExitOnError)
 
+	// HD钱包
+	passphraseUsage := "Passphrase of an encrypted wallet (default $WALLET_PASSPHRASE)"
+	createHDWallet := flag.NewFlagSet("createhdwallet", flag.ExitOnError)
+	createHDWalletWords := createHDWallet.Int("words", MNEMONICWORDS, "Number of mnemonic words: 12, 15, 18, 21 or 24")
+	createHDWalletPassphrase := createHDWallet.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	restoreHDWallet := flag.NewFlagSet("restorehdwallet", flag.ExitOnError)
+	restoreHDWalletMnemonic := restoreHDWallet.String("mnemonic", "", "Mnemonic of the wallet to restore")
+	restoreHDWalletGapLimit := restoreHDWallet.Int("gaplimit", HDGAPLIMIT, "Stop scanning after this many unused addresses in a row")
+	restoreHDWalletPassphrase := restoreHDWallet.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	getNewAddress := flag.NewFlagSet("getnewaddress", flag.ExitOnError)
+	getNewAddressChange := getNewAddress.Bool("change", false, "Derive a change address instead of a receive address")
+	getNewAddressPassphrase := getNewAddress.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	getNewAddressFormat := getNewAddress.String("format", DEFAULTADDRESSFORMAT, "Address format: base58 or bech32")
+	dumpMnemonic := flag.NewFlagSet("dumpmnemonic", flag.ExitOnError)
+	dumpMnemonicPassphrase := dumpMnemonic.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+
+	// 导入导出私钥
+	dumpPrivKey := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	dumpPrivKeyAddress := dumpPrivKey.String("address", "", "Address whose private key is printed")
+	dumpPrivKeyPassphrase := dumpPrivKey.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	importPrivKey := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	importPrivKeyKey := importPrivKey.String("key", "", "Private key in WIF")
+	importPrivKeyRescan := importPrivKey.Bool("rescan", true, "Rescan the chain for outputs of the imported key")
+	importPrivKeyPassphrase := importPrivKey.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	dumpWallet := flag.NewFlagSet("dumpwallet", flag.ExitOnError)
+	dumpWalletFile := dumpWallet.String("file", "", "File the private keys are written to")
+	dumpWalletPassphrase := dumpWallet.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	importWallet := flag.NewFlagSet("importwallet", flag.ExitOnError)
+	importWalletFile := importWallet.String("file", "", "File written by dumpwallet")
+	importWalletRescan := importWallet.Bool("rescan", true, "Rescan the chain for outputs of the imported keys")
+	importWalletPassphrase := importWallet.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+
+	// 只读地址
+	importAddress := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	importAddressAddress := importAddress.String("address", "", "Address to watch")
+	importAddressPubkey := importAddress.String("pubkey", "", "Hex public key to watch, lets unsigned transactions carry it")
+	importAddressRescan := importAddress.Bool("rescan", true, "Rescan the chain for outputs of the address")
+	createUnsignedTx := flag.NewFlagSet("createunsignedtx", flag.ExitOnError)
+	createUnsignedTxFrom := createUnsignedTx.String("from", "", "Watch-only source address")
+	createUnsignedTxTo := createUnsignedTx.String("to", "", "Destination wallet address")
+	createUnsignedTxAmount := createUnsignedTx.Int("amount", 0, "Amount to send")
+	createUnsignedTxChange := createUnsignedTx.String("changeaddress", Config.ChangeAddress, "Send the change to this address instead of back to the source")
+	createUnsignedTxDust := createUnsignedTx.Int("dust", DEFAULTDUSTTHRESHOLD, "Change up to this amount is added to the fee instead of creating an output")
+	createUnsignedTxSelection := createUnsignedTx.String("selection", DEFAULTSELECTION, "Coin selection: largest, smallest, bnb or random")
+	createUnsignedTxCoins := createUnsignedTx.String("coins", "", "Comma separated txid:index outpoints to spend instead of selecting coins")
+
+	// 原始交易: 创建, 离线签名, 解码, 广播分开执行
+	createRawTx := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	createRawTxIn := createRawTx.String("in", "", "Comma separated txid:index outpoints to spend")
+	createRawTxTo := createRawTx.String("to", "", "Comma separated address:amount recipients, the inputs left over are the fee")
+	signRawTx := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	signRawTxHex := signRawTx.String("hex", "", "Hex transaction to sign")
+	signRawTxFrom := signRawTx.String("from", "", "Address whose key spends inputs that are not in the local chain")
+	signRawTxPassphrase := signRawTx.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "Passphrase of an encrypted wallet (default $WALLET_PASSPHRASE)")
+	decodeRawTx := flag.NewFlagSet("decoderawtx", flag.ExitOnError)
+	decodeRawTxHex := decodeRawTx.String("hex", "", "Hex transaction to decode")
+	sendRawTx := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
+	sendRawTxHex := sendRawTx.String("hex", "", "Hex signed transaction to broadcast")
+
+	// 部分签名交易文件: 多个签名方离线签名
+	createPSBT := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	createPSBTIn := createPSBT.String("in", "", "Comma separated txid:index outpoints to spend")
+	createPSBTTo := createPSBT.String("to", "", "Comma separated address:amount recipients, the inputs left over are the fee")
+	createPSBTHex := createPSBT.String("hex", "", "Hex raw transaction to wrap instead of -in and -to")
+	createPSBTOut := createPSBT.String("out", "", "File to write the PSBT to, stdout when empty")
+	signPSBT := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	signPSBTFile := signPSBT.String("file", "", "PSBT file to sign")
+	signPSBTOut := signPSBT.String("out", "", "File to write the signed PSBT to, stdout when empty")
+	signPSBTPassphrase := signPSBT.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "Passphrase of an encrypted wallet (default $WALLET_PASSPHRASE)")
+	combinePSBT := flag.NewFlagSet("combinepsbt", flag.ExitOnError)
+	combinePSBTFiles := combinePSBT.String("files", "", "Comma separated PSBT files of the same transaction")
+	combinePSBTOut := combinePSBT.String("out", "", "File to write the combined PSBT to, stdout when empty")
+	finalizePSBT := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	finalizePSBTFile := finalizePSBT.String("file", "", "Fully signed PSBT file")
+	inspectPSBT := flag.NewFlagSet("inspectpsbt", flag.ExitOnError)
+	inspectPSBTFile := inspectPSBT.String("file", "", "PSBT file to describe")
+
+	// 交易记录和标签
+	listTransactions := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	listTransactionsCount := listTransactions.Int("count", 10, "Number of most recent transactions to list, 0 for all")
+	listTransactionsSkip := listTransactions.Int("skip", 0, "Number of most recent transactions to skip")
+	listTransactionsWatchOnly := listTransactions.Bool("includewatchonly", false, "Include transactions of watch-only addresses")
+	setLabel := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	setLabelTarget := setLabel.String("target", "", "Transaction id or address to label")
+	setLabelLabel := setLabel.String("label", "", "Label, empty to remove it")
+
+	// 列出未花费输出, 配合 -coins 手动选择要花费的输出
+	listUnspent := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	listUnspentAddress := listUnspent.String("address", "", "Only list the outputs of this address")
+
+	// 使用备份恢复损坏的钱包文件
+	recoverWallet := flag.NewFlagSet("recoverwallet", flag.ExitOnError)
+
+	// 钱包加密
+	encryptWallet := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	encryptWalletPassphrase := encryptWallet.String("passphrase", "", "New wallet passphrase")
+	changePassphrase := flag.NewFlagSet("changepassphrase", flag.ExitOnError)
+	changePassphraseOld := changePassphrase.String("old", "", "Current wallet passphrase")
+	changePassphraseNew := changePassphrase.String("new", "", "New wallet passphrase")
+
+	// 解锁或者锁定运行中节点的钱包, 只有节点运行时才有意义
+	walletPassphrase := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	walletPassphrasePassphrase := walletPassphrase.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), passphraseUsage)
+	walletPassphraseTimeout := walletPassphrase.Int64("timeout", int64(WALLETUNLOCKTIMEOUT/time.Second), "Number of seconds the wallet stays unlocked")
+	walletLock := flag.NewFlagSet("walletlock", flag.ExitOnError)
+
 	// 获取最新区块高度
 	getLatestHeight := flag.NewFlagSet("getlatestheight", flag.ExitOnError)
 
+	// 封禁列表
+	listBanned := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	setBan := flag.NewFlagSet("setban", flag.ExitOnError)
+	setBanAddr := setBan.String("addr", "", "Peer address (host:port) or host to ban")
+	setBanCommand := setBan.String("command", "add", "add or remove the ban")
+	setBanTime := setBan.Int64("bantime", int64(DEFAULTBANTIME/time.Second), "Number of seconds the ban lasts")
+
+	// 调用运行中节点的任意RPC方法: rpc <method> [params...]
+	rpcCmd := flag.NewFlagSet("rpc", flag.ExitOnError)
+
 	// -------------------------- 2. 解析命令行参数 --------------------------
-	// os.Args[0]是程序的路径, os.Args[1]是第一个参数
-	switch os.Args[1] {
+	// args[0]是命令, 后面是命令的参数
+	switch args[0] {
 
 	case "startnode":
-		err := startNode.Parse(os.Args[2:])
+		err := startNode.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 
 	case "getlatestheight":
-		err := getLatestHeight.Parse(os.Args[2:])
+		err := getLatestHeight.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "rpc":
+		err := rpcCmd.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "listbanned":
+		err := listBanned.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "setban":
+		err := setBan.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 	// 打印wallets.dat中的所有地址
 	case "listaddress":
-		err := listAddress.Parse(os.Args[2:])
+		err := listAddress.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "createhdwallet":
+		err := createHDWallet.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "restorehdwallet":
+		err := restoreHDWallet.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "getnewaddress":
+		err := getNewAddress.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "dumpmnemonic":
+		err := dumpMnemonic.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "dumpprivkey":
+		err := dumpPrivKey.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "importprivkey":
+		err := importPrivKey.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "dumpwallet":
+		err := dumpWallet.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "importwallet":
+		err := importWallet.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "importaddress":
+		err := importAddress.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "createunsignedtx":
+		err := createUnsignedTx.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "createrawtx":
+		err := createRawTx.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "signrawtx":
+		err := signRawTx.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "decoderawtx":
+		err := decodeRawTx.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "sendrawtx":
+		err := sendRawTx.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "createpsbt":
+		err := createPSBT.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "signpsbt":
+		err := signPSBT.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "combinepsbt":
+		err := combinePSBT.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "finalizepsbt":
+		err := finalizePSBT.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "inspectpsbt":
+		err := inspectPSBT.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "listtransactions":
+		err := listTransactions.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "setlabel":
+		err := setLabel.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "listunspent":
+		err := listUnspent.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "recoverwallet":
+		err := recoverWallet.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "encryptwallet":
+		err := encryptWallet.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "changepassphrase":
+		err := changePassphrase.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "walletpassphrase":
+		err := walletPassphrase.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "walletlock":
+		err := walletLock.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 
 	// 创建钱包并且保存到wallets.dat中
+	case "sendmany":
+		err := sendMany.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
 	case "createwallet":
-		err := createWallet.Parse(os.Args[2:])
+		err := createWallet.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 
 	case "addblock":
 		// 解析从第二个参数开始的所有命令行参数, 把命令行参数转换成程序可以使用的数据和配置
-		err := addBlock.Parse(os.Args[2:])
+		err := addBlock.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 
 	case "printblock":
-		err := printBlock.Parse(os.Args[2:])
+		err := printBlock.Parse(args[1:])
+		if err != nil {
+			panic(err)
+		}
+
+	case "gettx":
+		err := getTx.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 
 	case "getbalance":
-		err := getBalance.Parse(os.Args[2:])
+		err := getBalance.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
 
 	case "sendtx":
-		err := sendtx.Parse(os.Args[2:])
+		err := sendtx.Parse(args[1:])
 		if err != nil {
 			panic(err)
 		}
@@ -112,42 +562,93 @@ func (cli *CLI) Run() {
 
 	// ------------------------ 3. 根据解析后的命令行参数执行对应的功能 ------------------------
 	if startNode.Parsed() {
-		nodeID := "3000" // 需要监听的节点ID
-		if nodeID == "" {
-			fmt.Println("NODE_ID env. var is not set! You need to set it to a positive integer value")
+		nodeID := Config.NodeID // 需要监听的节点ID
+		if *startNodeBanScore <= 0 || *startNodeBanTime <= 0 {
+			fmt.Println("banscore and bantime must be positive")
+			os.Exit(1)
+		}
+		BanScore = *startNodeBanScore
+		BanTime = time.Duration(*startNodeBanTime) * time.Second
+
+		// 加密传输和节点白名单
+		EncryptTransport = *startNodeEncrypt
+		RequireEncryption = *startNodeRequireEncryption
+		if len(*startNodeAllowPeers) > 0 {
+			if err := LoadAllowedPeerKeys(*startNodeAllowPeers); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := InitTransport(Config.NodeKeyFile()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if len(*startNodeMinner) > 0 && !validAddress("miner", *startNodeMinner) {
 			os.Exit(1)
 		}
+
 		cli.startnode(nodeID, *startNodeMinner)
 	}
 
+	if listBanned.Parsed() {
+		cli.ListBanned()
+	}
+
+	if setBan.Parsed() {
+		if len(*setBanAddr) == 0 {
+			fmt.Println("invalid peer address")
+			os.Exit(1)
+		}
+		if *setBanTime <= 0 {
+			fmt.Println("invalid bantime")
+			os.Exit(1)
+		}
+		cli.SetBan(*setBanAddr, *setBanCommand, time.Duration(*setBanTime)*time.Second)
+	}
+
 	if getLatestHeight.Parsed() {
 		cli.GetLatestHeight()
 	}
 
+	if rpcCmd.Parsed() {
+		if rpcCmd.NArg() < 1 {
+			fmt.Println("missing rpc method, run `rpc help` for the list")
+			os.Exit(1)
+		}
+		cli.CallRPC(rpcCmd.Arg(0), rpcCmd.Args()[1:])
+	}
+
 	if addBlock.Parsed() {
 		cli.addBlock()
 	}
 
 	if printBlock.Parsed() {
-		cli.printBlock()
+		cli.printBlock(*printBlockJSON)
+	}
+
+	if getTx.Parsed() {
+		txID, err := hex.DecodeString(*getTxID)
+		if err != nil || len(txID) == 0 {
+			fmt.Println("invalid transaction id")
+			os.Exit(1)
+		}
+		cli.GetTx(txID, *getTxJSON)
 	}
 
 	if getBalance.Parsed() {
 		// check if the address is valid
-		if len(os.Args[2]) == 0 {
-			fmt.Println("invalid address")
+		if len(*addr) == 0 {
+			cli.GetWalletBalance(*getBalanceWatchOnly)
+		} else if !validAddress("", *addr) {
 			os.Exit(1)
+		} else {
+			cli.GetBalance(*addr)
 		}
-		cli.GetBalance(*addr)
 	}
 
 	if sendtx.Parsed() {
-		if len(*sendtxFrom) == 0 {
-			fmt.Println("invalid sender address")
-			os.Exit(1)
-		}
-		if len(*sendtxTo) == 0 {
-			fmt.Println("invalid receiver address")
+		if !validAddress("sender", *sendtxFrom) || !validAddress("receiver", *sendtxTo) {
 			os.Exit(1)
 		}
 		if *sendtxAmount <= 0 {
@@ -155,93 +656,1327 @@ func (cli *CLI) Run() {
 			os.Exit(1)
 		}
 
-		cli.SendTx(*sendtxFrom, *sendtxTo, *sendtxAmount)
-	}
+		opts, ok := parseTxOptions(*sendtxChange, *sendtxDust, *sendtxSelection, *sendtxCoins)
+		if !ok {
+			os.Exit(1)
+		}
 
-	if createWallet.Parsed() {
-		cli.CreateWallet()
+		cli.SendTx(*sendtxFrom, *sendtxTo, *sendtxAmount, *sendtxMine, opts, *sendtxPassphrase)
 	}
 
-	if listAddress.Parsed() {
-		cli.ListAddress()
-	}
+	if sendMany.Parsed() {
+		if !validAddress("sender", *sendManyFrom) {
+			os.Exit(1)
+		}
+		if (len(*sendManyTo) == 0) == (len(*sendManyFile) == 0) {
+			fmt.Println("give the recipients with either -to or -file")
+			os.Exit(1)
+		}
+		if *sendManyFee < 0 {
+			fmt.Println("invalid fee")
+			os.Exit(1)
+		}
+		if _, ok := FeeSplits[*sendManyFeeSplit]; !ok {
+			fmt.Printf("invalid fee split %q, must be sender, equal or proportional\n", *sendManyFeeSplit)
+			os.Exit(1)
+		}
 
-}
+		var recipients []Recipient
+		var err error
+		if len(*sendManyFile) > 0 {
+			recipients, err = ReadRecipientsCSV(*sendManyFile)
+		} else {
+			recipients, err = ParseRecipients(*sendManyTo)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
-// addBlock add a new block to the blockchain using CLI
-//
-// 使用CLI添加一个新的区块到区块链
-func (cli *CLI) addBlock() {
+		opts, ok := parseTxOptions(*sendManyChange, *sendManyDust, *sendManySelection, *sendManyCoins)
+		if !ok {
+			os.Exit(1)
+		}
+		opts.Fee = *sendManyFee
+		opts.FeeSplit = *sendManyFeeSplit
 
-	// new a slice of random transactions
-	txs := []*Transaction{
-		CoinBaseTx("1FBae9FyJTofCbWYK2hMHnxtf78qreFTSD"),
+		cli.SendMany(*sendManyFrom, recipients, *sendManyMine, opts, *sendManyPassphrase)
 	}
-	cli.Blockchain.AddBlock(txs)
-}
 
-func (cli *CLI) printBlock() {
-	cli.Blockchain.IterateBlockchain()
-}
+	if createWallet.Parsed() {
+		if err := CheckAddressFormat(*createWalletFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cli.CreateWallet(*createWalletFormat, *createWalletPassphrase)
+	}
 
-// GetBalance get the balance of the address
-func (cli *CLI) GetBalance(addr string) {
-	balance := 0
-	// utxos := cli.Blockchain.FindUTXO(AddressToPubkeyHash(addr))
+	if createHDWallet.Parsed() {
+		cli.CreateHDWallet(*createHDWalletWords, *createHDWalletPassphrase)
+	}
 
-	utxoset := UTXOSet{cli.Blockchain}
-	utxoset.StoreUTXO()
-	utxos := utxoset.FindUTXOByPubkeyHash(AddressToPubkeyHash(addr))
+	if restoreHDWallet.Parsed() {
+		if len(*restoreHDWalletMnemonic) == 0 {
+			fmt.Println("invalid mnemonic")
+			os.Exit(1)
+		}
+		cli.RestoreHDWallet(*restoreHDWalletMnemonic, *restoreHDWalletGapLimit, *restoreHDWalletPassphrase)
+	}
 
-	for _, utxo := range utxos {
-		balance += utxo.Value
+	if getNewAddress.Parsed() {
+		if err := CheckAddressFormat(*getNewAddressFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cli.GetNewAddress(*getNewAddressChange, *getNewAddressFormat, *getNewAddressPassphrase)
 	}
 
-	fmt.Printf("Balance of %s: %d\n", addr, balance)
-}
+	if dumpMnemonic.Parsed() {
+		cli.DumpMnemonic(*dumpMnemonicPassphrase)
+	}
 
-func (cli *CLI) SendTx(from, to string, amount int) {
-	tx := CreateTransaction(from, to, amount, cli.Blockchain)
+	if dumpPrivKey.Parsed() {
+		if len(*dumpPrivKeyAddress) == 0 {
+			fmt.Println("invalid address")
+			os.Exit(1)
+		}
+		cli.DumpPrivKey(*dumpPrivKeyAddress, *dumpPrivKeyPassphrase)
+	}
+
+	if importPrivKey.Parsed() {
+		if len(*importPrivKeyKey) == 0 {
+			fmt.Println("invalid private key")
+			os.Exit(1)
+		}
+		cli.ImportPrivKey(*importPrivKeyKey, *importPrivKeyRescan, *importPrivKeyPassphrase)
+	}
+
+	if dumpWallet.Parsed() {
+		if len(*dumpWalletFile) == 0 {
+			fmt.Println("invalid file")
+			os.Exit(1)
+		}
+		cli.DumpWallet(*dumpWalletFile, *dumpWalletPassphrase)
+	}
+
+	if importWallet.Parsed() {
+		if len(*importWalletFile) == 0 {
+			fmt.Println("invalid file")
+			os.Exit(1)
+		}
+		cli.ImportWallet(*importWalletFile, *importWalletRescan, *importWalletPassphrase)
+	}
+
+	if importAddress.Parsed() {
+		if len(*importAddressAddress) == 0 && len(*importAddressPubkey) == 0 {
+			fmt.Println("invalid address")
+			os.Exit(1)
+		}
+		cli.ImportAddress(*importAddressAddress, *importAddressPubkey, *importAddressRescan)
+	}
+
+	if createUnsignedTx.Parsed() {
+		if !validAddress("sender", *createUnsignedTxFrom) || !validAddress("receiver", *createUnsignedTxTo) {
+			os.Exit(1)
+		}
+		if *createUnsignedTxAmount <= 0 {
+			fmt.Println("invalid amount")
+			os.Exit(1)
+		}
+		opts, ok := parseTxOptions(*createUnsignedTxChange, *createUnsignedTxDust, *createUnsignedTxSelection, *createUnsignedTxCoins)
+		if !ok {
+			os.Exit(1)
+		}
+		cli.CreateUnsignedTx(*createUnsignedTxFrom, *createUnsignedTxTo, *createUnsignedTxAmount, opts)
+	}
+
+	if createRawTx.Parsed() {
+		tx, err := parseRawTx(*createRawTxIn, *createRawTxTo)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cli.CreateRawTx(tx)
+	}
+
+	if signRawTx.Parsed() {
+		if len(*signRawTxHex) == 0 {
+			fmt.Println("invalid transaction hex")
+			os.Exit(1)
+		}
+		if len(*signRawTxFrom) > 0 && !validAddress("from", *signRawTxFrom) {
+			os.Exit(1)
+		}
+		cli.SignRawTx(*signRawTxHex, *signRawTxFrom, *signRawTxPassphrase)
+	}
+
+	if decodeRawTx.Parsed() {
+		if len(*decodeRawTxHex) == 0 {
+			fmt.Println("invalid transaction hex")
+			os.Exit(1)
+		}
+		cli.DecodeRawTx(*decodeRawTxHex)
+	}
+
+	if sendRawTx.Parsed() {
+		if len(*sendRawTxHex) == 0 {
+			fmt.Println("invalid transaction hex")
+			os.Exit(1)
+		}
+		cli.SendRawTx(*sendRawTxHex)
+	}
+
+	if createPSBT.Parsed() {
+		var tx *Transaction
+		var err error
+		if len(*createPSBTHex) > 0 {
+			tx, err = DecodeRawTransaction(*createPSBTHex)
+		} else {
+			tx, err = parseRawTx(*createPSBTIn, *createPSBTTo)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cli.CreatePSBT(tx, *createPSBTOut)
+	}
+
+	if signPSBT.Parsed() {
+		if len(*signPSBTFile) == 0 {
+			fmt.Println("invalid psbt file")
+			os.Exit(1)
+		}
+		cli.SignPSBT(*signPSBTFile, *signPSBTOut, *signPSBTPassphrase)
+	}
+
+	if combinePSBT.Parsed() {
+		files := splitPeers(*combinePSBTFiles)
+		if len(files) < 2 {
+			fmt.Println("combine needs at least two psbt files")
+			os.Exit(1)
+		}
+		cli.CombinePSBT(files, *combinePSBTOut)
+	}
+
+	if finalizePSBT.Parsed() {
+		if len(*finalizePSBTFile) == 0 {
+			fmt.Println("invalid psbt file")
+			os.Exit(1)
+		}
+		cli.FinalizePSBT(*finalizePSBTFile)
+	}
+
+	if inspectPSBT.Parsed() {
+		if len(*inspectPSBTFile) == 0 {
+			fmt.Println("invalid psbt file")
+			os.Exit(1)
+		}
+		cli.InspectPSBT(*inspectPSBTFile)
+	}
+
+	if listTransactions.Parsed() {
+		if *listTransactionsCount < 0 || *listTransactionsSkip < 0 {
+			fmt.Println("count and skip must not be negative")
+			os.Exit(1)
+		}
+		cli.ListTransactions(*listTransactionsCount, *listTransactionsSkip, *listTransactionsWatchOnly)
+	}
+
+	if setLabel.Parsed() {
+		if len(*setLabelTarget) == 0 {
+			fmt.Println("invalid target")
+			os.Exit(1)
+		}
+		cli.SetLabel(*setLabelTarget, *setLabelLabel)
+	}
+
+	if listUnspent.Parsed() {
+		if len(*listUnspentAddress) > 0 && !validAddress("", *listUnspentAddress) {
+			os.Exit(1)
+		}
+		cli.ListUnspent(*listUnspentAddress)
+	}
+
+	if recoverWallet.Parsed() {
+		cli.RecoverWallet()
+	}
+
+	if encryptWallet.Parsed() {
+		if len(*encryptWalletPassphrase) == 0 {
+			fmt.Println("invalid passphrase")
+			os.Exit(1)
+		}
+		cli.EncryptWallet(*encryptWalletPassphrase)
+	}
+
+	if changePassphrase.Parsed() {
+		if len(*changePassphraseNew) == 0 {
+			fmt.Println("invalid new passphrase")
+			os.Exit(1)
+		}
+		cli.ChangePassphrase(*changePassphraseOld, *changePassphraseNew)
+	}
+
+	if walletPassphrase.Parsed() {
+		if *walletPassphraseTimeout <= 0 {
+			fmt.Println("invalid timeout")
+			os.Exit(1)
+		}
+		cli.WalletPassphrase(*walletPassphrasePassphrase, time.Duration(*walletPassphraseTimeout)*time.Second)
+	}
+
+	if walletLock.Parsed() {
+		cli.WalletLock()
+	}
+
+	if listAddress.Parsed() {
+		cli.ListAddress()
+	}
+
+}
+
+// addBlock add a new block to the blockchain using CLI
+//
+// 使用CLI添加一个新的区块到区块链
+func (cli *CLI) addBlock() {
+
+	coinbase, err := CoinBaseTx(Params.GenesisAddress)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// new a slice of random transactions
+	txs := []*Transaction{coinbase}
+	cli.Blockchain.AddBlock(txs)
+}
+
+// printBlock prints every block from the tip back to the genesis block
+//
+// -json 时输出一个JSON数组, 包含区块中的所有交易, 格式见 BlockJSON
+func (cli *CLI) printBlock(asJSON bool) {
+	if !asJSON {
+		cli.Blockchain.IterateBlockchain()
+		return
+	}
+
+	tipHeight, err := cli.Blockchain.GetLatestHeight()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	blocks := []BlockJSON{}
+	iterator := cli.Blockchain.Iterator()
+	for {
+		block := iterator.Next()
+		blocks = append(blocks, NewBlockJSON(block, tipHeight))
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	printJSON(blocks)
+}
+
+// GetTx prints a transaction of the main chain
+func (cli *CLI) GetTx(txID []byte, asJSON bool) {
+	var view TransactionJSON
+	if cli.RPC != nil {
+		// 节点的交易池中的交易也可以查到
+		cli.RPC.mustCall("gettransaction", &view, hex.EncodeToString(txID))
+	} else {
+		tx, block, err := cli.Blockchain.FindTxBlock(txID)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		tipHeight, err := cli.Blockchain.GetLatestHeight()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		view = NewTransactionJSON(tx, block, tipHeight)
+	}
+
+	if asJSON {
+		view.Hex = ""
+		printJSON(view)
+		return
+	}
+
+	tx, err := view.Transaction()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Transaction %s\n", view.TxID)
+	if view.Height != nil {
+		fmt.Printf("Block: %s (height %d, %d confirmations)\n", view.BlockHash, *view.Height, view.Confirmations)
+	} else {
+		fmt.Println("Block: unconfirmed")
+	}
+	fmt.Println(tx.String())
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+// GetBalance get the balance of the address
+func (cli *CLI) GetBalance(addr string) {
+	if cli.RPC != nil {
+		cli.remoteGetBalance(addr)
+		return
+	}
+
+	utxoset := UTXOSet{cli.Blockchain}
+	utxoset.StoreUTXO()
+
+	suffix := ""
+	if CreateWallets().IsWatchOnly(addr) {
+		suffix = " (watch-only)"
+	}
+	balance, err := addressBalance(&utxoset, addr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Balance of %s: %d%s\n", addr, balance, suffix)
+}
+
+// GetWalletBalance prints the balance of every address in the wallet
+//
+// 打印钱包中每个地址的余额, 只读地址的余额单独统计, includeWatchOnly 为true时计入总额
+func (cli *CLI) GetWalletBalance(includeWatchOnly bool) {
+	if cli.RPC != nil {
+		cli.remoteGetWalletBalance(includeWatchOnly)
+		return
+	}
+
+	utxoset := UTXOSet{cli.Blockchain}
+	utxoset.StoreUTXO()
+
+	wallets := CreateWallets()
+	addresses := wallets.getAllAddress()
+	sort.Strings(addresses)
+
+	spendable := 0
+	for _, address := range addresses {
+		balance, err := addressBalance(&utxoset, address)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		spendable += balance
+		fmt.Printf("Balance of %s: %d\n", address, balance)
+	}
+
+	watched := 0
+	for _, address := range wallets.getWatchOnlyAddresses() {
+		balance, err := addressBalance(&utxoset, address)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		watched += balance
+		fmt.Printf("Balance of %s: %d (watch-only)\n", address, balance)
+	}
+
+	fmt.Printf("spendable balance: %d\n", spendable)
+	fmt.Printf("watch-only balance: %d\n", watched)
+	if includeWatchOnly {
+		fmt.Printf("total balance: %d\n", spendable+watched)
+	}
+}
+
+// addressBalance sums the unspent outputs of an address
+func addressBalance(utxoset *UTXOSet, addr string) (int, error) {
+	pubkeyHash, err := DecodeAddress(addr)
+	if err != nil {
+		return 0, err
+	}
+	balance := 0
+	for _, utxo := range utxoset.FindUTXOByPubkeyHash(pubkeyHash) {
+		balance += utxo.Value
+	}
+	return balance, nil
+}
+
+func (cli *CLI) SendTx(from, to string, amount int, mineNow bool, opts TxOptions, passphrase string) {
+	cli.SendMany(from, []Recipient{{Address: to, Amount: amount}}, mineNow, opts, passphrase)
+}
+
+// SendMany pays every recipient with one transaction
+//
+// 一笔交易支付所有收款人, 只需要一次签名和一个区块
+func (cli *CLI) SendMany(from string, recipients []Recipient, mineNow bool, opts TxOptions, passphrase string) {
+	if cli.RPC != nil {
+		cli.remoteSendMany(from, recipients, opts, passphrase)
+		return
+	}
+
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	// 加密的钱包在签名之前临时解锁, 签名之后立即锁定
+	unlockWallet(wallets, passphrase)
+	tx, changeIndex, err := CreateManyTransaction(from, recipients, opts, wallets, cli.Blockchain)
+	wallets.WalletLock()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// 保存新生成的找零地址, 否则找零就丢失了; 交易先记录为未确认
+	wallets.RecordTransaction(tx, cli.Blockchain)
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+	printTxOutputs(tx, changeIndex)
+
+	// 不在本地挖矿, 把交易发送给种子节点, 由矿工节点打包
+	if !mineNow {
+		if !sendTx(KnownNodes[0], tx) {
+			fmt.Println("send transaction failed")
+			os.Exit(1)
+		}
+		fmt.Printf("transaction %x sent to %s\n", tx.ID, KnownNodes[0])
+		return
+	}
 
 	_, newblock := cli.Blockchain.AddBlock([]*Transaction{tx})
 
-	utsoxet := UTXOSet{cli.Blockchain}
+	utsoxet := UTXOSet{cli.Blockchain}
+
+	if err := utsoxet.UpdateUTXO(newblock); err != nil {
+		fmt.Printf("%v, rebuild utxo set\n", err)
+		if err := utsoxet.StoreUTXO(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// 新区块已经确认了这笔交易
+	wallets.SyncJournal(cli.Blockchain)
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Println("Success!")
+}
+
+// validAddress checks an address given on the command line, printing why it is rejected
+//
+// 地址无效时打印具体原因: 字符非法, 长度错误, 校验和不匹配或者属于其他网络
+func validAddress(role, address string) bool {
+	if _, err := DecodeAddress(address); err != nil {
+		if len(role) > 0 {
+			fmt.Printf("%s: %v\n", role, err)
+		} else {
+			fmt.Println(err)
+		}
+		return false
+	}
+	return true
+}
+
+// parseTxOptions checks the change, selection and coin control flags, printing the problem
+func parseTxOptions(changeAddr string, dust int, selection, coins string) (TxOptions, bool) {
+	opts := TxOptions{Change: ChangePolicy{Address: changeAddr, DustThreshold: dust}, Selection: selection}
+
+	if len(changeAddr) > 0 && !validAddress("change", changeAddr) {
+		return opts, false
+	}
+	if dust < 0 {
+		fmt.Println("invalid dust threshold")
+		return opts, false
+	}
+	if _, ok := CoinSelections[selection]; !ok {
+		fmt.Printf("invalid coin selection %q, must be largest, smallest, bnb or random\n", selection)
+		return opts, false
+	}
+	for _, coin := range splitPeers(coins) {
+		outpoint, err := ParseOutpoint(coin)
+		if err != nil {
+			fmt.Println(err)
+			return opts, false
+		}
+		opts.Coins = append(opts.Coins, outpoint)
+	}
+
+	return opts, true
+}
+
+// ListTransactions syncs the wallet journal with the chain and prints the most recent transactions
+//
+// 先同步交易记录, 再按照从旧到新的顺序打印最近的 count 笔交易, 未确认的交易在最后
+func (cli *CLI) ListTransactions(count, skip int, includeWatchOnly bool) {
+	if cli.RPC != nil {
+		cli.remoteListTransactions(count, skip, includeWatchOnly)
+		return
+	}
+
+	wallets := CreateWallets()
+	if !wallets.ReadWalletsFromFile() {
+		os.Exit(1)
+	}
+
+	connected, disconnected := wallets.SyncJournal(cli.Blockchain)
+	if connected > 0 || disconnected > 0 {
+		if !wallets.SaveWalletsToFile() {
+			os.Exit(1)
+		}
+	}
+	if disconnected > 0 {
+		fmt.Printf("%d transactions left the main chain\n", disconnected)
+	}
+
+	tipHeight, err := cli.Blockchain.GetLatestHeight()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, entry := range RecentTransactions(wallets.Transactions(), count, skip, includeWatchOnly) {
+		confirmations := entry.Confirmations(tipHeight)
+		fmt.Printf("%s %-8s %+d fee=%d confirmations=%d txid=%x",
+			time.Unix(entry.Time, 0).UTC().Format(time.RFC3339), entry.Direction, entry.Amount, entry.Fee, confirmations, entry.TxID)
+		if len(entry.Counterparties) > 0 {
+			fmt.Printf(" counterparty=%s", strings.Join(entry.Counterparties, ","))
+		}
+		if len(entry.ChangeOutputs) > 0 {
+			fmt.Printf(" change=%s", formatIndexes(entry.ChangeOutputs))
+		}
+		if label := wallets.LabelOf(entry); len(label) > 0 {
+			fmt.Printf(" label=%q", label)
+		}
+		if entry.WatchOnly {
+			fmt.Printf(" (watch-only)")
+		}
+		fmt.Println()
+	}
+}
+
+// SetLabel labels a wallet transaction or an address
+func (cli *CLI) SetLabel(target, label string) {
+	if cli.RPC != nil {
+		cli.remoteSetLabel(target, label)
+		return
+	}
+
+	wallets := CreateWallets()
+	if !wallets.ReadWalletsFromFile() {
+		os.Exit(1)
+	}
+
+	// 刚刚确认的交易也可以设置标签
+	wallets.SyncJournal(cli.Blockchain)
+	if err := wallets.SetLabel(target, label); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	if len(label) == 0 {
+		fmt.Printf("label of %s removed\n", target)
+		return
+	}
+	fmt.Printf("%s labelled %q\n", target, label)
+}
+
+// ListUnspent prints the unspent outputs of the wallet, or of one address
+func (cli *CLI) ListUnspent(address string) {
+	if cli.RPC != nil {
+		cli.remoteListUnspent(address)
+		return
+	}
+
+	addresses := []string{address}
+	if len(address) == 0 {
+		wallets := CreateWallets()
+		addresses = append(wallets.getAllAddress(), wallets.getWatchOnlyAddresses()...)
+		sort.Strings(addresses)
+	}
+
+	for _, addr := range addresses {
+		pubkeyHash, err := DecodeAddress(addr)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, coin := range cli.Blockchain.FindSpendableCoins(pubkeyHash) {
+			fmt.Printf("%s %d %s\n", coin.Outpoint, coin.Value, addr)
+		}
+	}
+}
+
+// formatIndexes joins output indexes with commas
+func formatIndexes(indexes []int) string {
+	parts := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		parts = append(parts, strconv.Itoa(index))
+	}
+	return strings.Join(parts, ",")
+}
+
+// printTxOutputs prints the outputs of a new transaction, marking the change output
+func printTxOutputs(tx *Transaction, changeIndex int) {
+	for i, output := range tx.Out {
+		marker := ""
+		if i == changeIndex {
+			marker = " (change)"
+		}
+		fmt.Printf("output %d: %d -> %x%s\n", i, output.Value, output.PublickeyHash, marker)
+	}
+	if changeIndex < 0 {
+		fmt.Println("no change output")
+	}
+}
+
+func (cli *CLI) CreateWallet(format, passphrase string) {
+	if cli.RPC != nil {
+		fmt.Println("Your new address: ", cli.remoteNewAddress(false, format, passphrase))
+		return
+	}
+
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile() // 读取已经存在的钱包
+
+	// 加密的钱包需要解锁之后才能加密新的私钥
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	address, err := wallets.NewAddress(false, format) // 添加一个新的地址, 有HD种子的时候按照路径派生
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// 再次保存到文件中
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Println("Your new address: ", address)
+}
+
+// GetNewAddress derives the next receive or change address of the wallet
+func (cli *CLI) GetNewAddress(change bool, format, passphrase string) {
+	if cli.RPC != nil {
+		fmt.Printf("new address: %s\n", cli.remoteNewAddress(change, format, passphrase))
+		return
+	}
+
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	address, err := wallets.NewAddress(change, format)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Printf("new address: %s\n", address)
+}
 
-	utsoxet.UpdateUTXO(newblock)
+// CreateHDWallet adds a new HD seed to the wallet and prints its mnemonic
+//
+// 生成助记词作为钱包的HD种子, 之后的新地址都从这个种子派生, 备份助记词就可以恢复所有地址
+func (cli *CLI) CreateHDWallet(words int, passphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
 
-	// 硬编码形式验证UpdateUTXO是否正确
-	cli.GetBalance("1FBae9FyJTofCbWYK2hMHnxtf78qreFTSD")
-	cli.GetBalance("13bkBrCPM8tiCaXNufbWcQnRBjTboxK9jM")
-	cli.GetBalance("1D5S4w2ApAwhBgnYrRhtQx4X2J9uwSDCaD")
-	cli.GetBalance("1MFHDXugCGge6wxAhoS5Pee2AoVnpyfC7L")
-	cli.GetBalance("14AcsbEULnBTSU44HV8wswH12bmuJ78b9G")
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
 
-	fmt.Println("Success!")
+	mnemonic, err := wallets.NewHDSeed(words)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	address, err := wallets.NewAddress(false, DEFAULTADDRESSFORMAT)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Printf("mnemonic: %s\n", mnemonic)
+	fmt.Println("write the mnemonic down and keep it safe, it restores every address of this wallet")
+	fmt.Printf("first address: %s\n", address)
 }
 
-func (cli *CLI) CreateWallet() {
+// RestoreHDWallet restores the HD seed from a mnemonic and scans the chain for used addresses
+func (cli *CLI) RestoreHDWallet(mnemonic string, gapLimit int, passphrase string) {
 	wallets := CreateWallets()
-	wallets.ReadWalletsFromFile()             // 读取已经存在的钱包
-	address := wallets.CreateWalletRandomly() // 添加一个新的钱包
-	wallets.SaveWalletsToFile()               // 再次保存到文件中
+	wallets.ReadWalletsFromFile()
 
-	fmt.Println("Your new address: ", address)
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	found, err := wallets.RestoreHDWallet(mnemonic, cli.Blockchain, gapLimit)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Printf("wallet restored, %d used addresses found\n", found)
+}
+
+// DumpMnemonic prints the mnemonic of the HD seed
+func (cli *CLI) DumpMnemonic(passphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	mnemonic, err := wallets.Mnemonic()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("mnemonic: %s\n", mnemonic)
+}
+
+// DumpPrivKey prints the private key of an address in the WIF of the current network
+func (cli *CLI) DumpPrivKey(address, passphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	key, err := wallets.PrivateKeyOf(address)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(EncodeWIF(&key, Params.WIFVersion))
+}
+
+// ImportPrivKey adds a WIF private key to the wallet
+func (cli *CLI) ImportPrivKey(wif string, rescan bool, passphrase string) {
+	key, version, err := DecodeWIF(wif)
+	if err == nil {
+		err = checkWIFNetwork(version)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	address, isNew, err := wallets.ImportPrivateKey(key)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !isNew {
+		fmt.Printf("address %s is already in the wallet\n", address)
+		return
+	}
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Printf("imported address: %s\n", address)
+	if rescan {
+		cli.rescan([]string{address})
+	}
+}
+
+// DumpWallet writes every private key of the wallet to a file
+func (cli *CLI) DumpWallet(file, passphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	if err := wallets.DumpWallet(file, Params.WIFVersion); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("wallet dumped to %s\n", file)
+}
+
+// ImportWallet imports the private keys of a file written by dumpwallet
+func (cli *CLI) ImportWallet(file string, rescan bool, passphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	unlockWallet(wallets, passphrase)
+	defer wallets.WalletLock()
+
+	imported, err := wallets.ImportWallet(file)
+	// 出错之前已经导入的私钥也要保存
+	if len(imported) > 0 && !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("imported %d new addresses\n", len(imported))
+	if rescan && len(imported) > 0 {
+		cli.rescan(imported)
+	}
+}
+
+// rescan rebuilds the UTXO set and reports the outputs found for the addresses
+//
+// 重新扫描区块链, 重建UTXO集合, 然后打印导入地址的未花费输出
+func (cli *CLI) rescan(addresses []string) {
+	utxoset := UTXOSet{cli.Blockchain}
+	if err := utxoset.StoreUTXO(); err != nil {
+		fmt.Printf("rescan failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, address := range addresses {
+		pubkeyHash, err := DecodeAddress(address)
+		if err != nil {
+			fmt.Printf("rescan %s failed: %v\n", address, err)
+			continue
+		}
+		utxos := utxoset.FindUTXOByPubkeyHash(pubkeyHash)
+		balance := 0
+		for _, utxo := range utxos {
+			balance += utxo.Value
+		}
+		fmt.Printf("rescan: %s has %d unspent outputs, balance %d\n", address, len(utxos), balance)
+	}
+}
+
+// unlockWallet unlocks an encrypted wallet for a single command, exiting on a wrong passphrase
+//
+// 加密的钱包在需要私钥的命令中临时解锁, 命令结束之前调用 WalletLock 重新锁定
+func unlockWallet(wallets *Wallets, passphrase string) {
+	if !wallets.IsEncrypted() {
+		return
+	}
+	if err := wallets.WalletPassphrase(passphrase, WALLETUNLOCKTIMEOUT); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 func (cli *CLI) ListAddress() {
+	if cli.RPC != nil {
+		cli.remoteListAddress()
+		return
+	}
+
 	wallets := CreateWallets()
 	addresses := wallets.getAllAddress()
 
 	for _, address := range addresses {
+		if wallets.IsChangeAddress(address) {
+			fmt.Printf("address: %s (change)\n", address)
+			continue
+		}
 		fmt.Printf("address: %s\n", address)
 	}
+	for _, address := range wallets.getWatchOnlyAddresses() {
+		fmt.Printf("address: %s (watch-only)\n", address)
+	}
+}
+
+// ImportAddress adds a watch-only address or public key to the wallet
+func (cli *CLI) ImportAddress(address, pubkeyHex string, rescan bool) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	var isNew bool
+	var err error
+	if len(pubkeyHex) > 0 {
+		var publickey []byte
+		publickey, err = hex.DecodeString(pubkeyHex)
+		if err == nil {
+			address, isNew, err = wallets.ImportWatchOnlyPubkey(publickey)
+		}
+	} else {
+		isNew, err = wallets.ImportWatchOnlyAddress(address)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !isNew {
+		fmt.Printf("address %s is already watched\n", address)
+		return
+	}
+	if !wallets.SaveWalletsToFile() {
+		os.Exit(1)
+	}
+
+	fmt.Printf("watching address: %s\n", address)
+	if rescan {
+		cli.rescan([]string{address})
+	}
+}
+
+// CreateUnsignedTx prints an unsigned transaction spending from a watch-only address
+//
+// 打印十六进制编码的未签名交易, 拿到持有私钥的机器上签名之后再广播
+func (cli *CLI) CreateUnsignedTx(from, to string, amount int, opts TxOptions) {
+	wallets := CreateWallets()
+	tx, changeIndex, err := CreateUnsignedTransaction(from, to, amount, opts, wallets, cli.Blockchain)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	printTxOutputs(tx, changeIndex)
+	fmt.Printf("unsigned transaction %x:\n", tx.ID)
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+}
+
+// CreateRawTx prints an unsigned transaction spending exactly the given outpoints
+//
+// 不需要钱包和区块链, 可以在任何机器上创建, 然后用 signrawtx 签名, sendrawtx 广播
+func (cli *CLI) CreateRawTx(tx *Transaction) {
+	printTxOutputs(tx, -1)
+	fmt.Printf("unsigned transaction %x:\n", tx.ID)
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+}
+
+// parseRawTx builds an unsigned transaction from the -in outpoints and the -to recipients
+func parseRawTx(in, to string) (*Transaction, error) {
+	outpoints := []Outpoint{}
+	for _, coin := range splitPeers(in) {
+		outpoint, err := ParseOutpoint(coin)
+		if err != nil {
+			return nil, err
+		}
+		outpoints = append(outpoints, outpoint)
+	}
+	recipients, err := ParseRecipients(to)
+	if err != nil {
+		return nil, err
+	}
+	return NewRawTransaction(outpoints, recipients)
+}
+
+// SignRawTx signs the inputs of a raw transaction with the local wallet, or the wallet of the running node
+//
+// 只签名钱包中有私钥的输入; 还有其他人的输入没有签名时, 把输出的交易交给他们继续签名
+func (cli *CLI) SignRawTx(rawHex, from, passphrase string) {
+	var signed SignedRawTx
+	if cli.RPC != nil {
+		cli.RPC.walletCall(passphrase, "signrawtransaction", &signed, rawHex, from)
+	} else {
+		tx, err := DecodeRawTransaction(rawHex)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		wallets := CreateWallets()
+		if !wallets.ReadWalletsFromFile() {
+			os.Exit(1)
+		}
+		unlockWallet(wallets, passphrase)
+		complete, err := SignRawTransaction(tx, from, wallets, cli.Blockchain)
+		wallets.WalletLock()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		signed = SignedRawTx{Hex: hex.EncodeToString(tx.Serialize()), TxID: hex.EncodeToString(tx.ID), Complete: complete}
+	}
+
+	if signed.Complete {
+		fmt.Printf("signed transaction %s:\n", signed.TxID)
+	} else {
+		fmt.Printf("partially signed transaction %s, other inputs still need signatures:\n", signed.TxID)
+	}
+	fmt.Println(signed.Hex)
+}
+
+// DecodeRawTx prints a raw transaction as JSON
+func (cli *CLI) DecodeRawTx(rawHex string) {
+	tx, err := DecodeRawTransaction(rawHex)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	printJSON(NewTransactionJSON(tx, nil, -1))
+}
+
+// SendRawTx broadcasts a signed raw transaction
+//
+// 节点运行时交给节点验证并放入交易池, 否则在本地验证之后发送给种子节点
+func (cli *CLI) SendRawTx(rawHex string) {
+	if cli.RPC != nil {
+		var txid string
+		cli.RPC.mustCall("sendrawtransaction", &txid, rawHex)
+		fmt.Printf("transaction %s sent to %s\n", txid, Config.RPCListen)
+		return
+	}
+
+	tx, err := DecodeRawTransaction(rawHex)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := CheckRawTransaction(tx, cli.Blockchain); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !sendTx(KnownNodes[0], tx) {
+		fmt.Println("send transaction failed")
+		os.Exit(1)
+	}
+	fmt.Printf("transaction %x sent to %s\n", tx.ID, KnownNodes[0])
+}
+
+// CreatePSBT wraps an unsigned transaction together with the outputs it spends
+//
+// 引用的输出从本地区块链或者正在运行的节点查找, 之后的签名, 合并和检查都不需要区块链
+func (cli *CLI) CreatePSBT(tx *Transaction, out string) {
+	fetch := func(txID []byte) (*Transaction, error) {
+		prevTx, err := cli.Blockchain.FindTxByID(txID)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %x is not found in the chain", txID)
+		}
+		return prevTx, nil
+	}
+	if cli.RPC != nil {
+		fetch = cli.RPC.confirmedTransaction
+	}
+
+	p, err := NewPSBT(tx, fetch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	writePSBT(p, out)
+}
+
+// SignPSBT adds the public keys and signatures of the local wallet
+//
+// 只读取本地的钱包文件, 不需要区块链, 所以可以在离线的机器上签名
+func (cli *CLI) SignPSBT(file, out, passphrase string) {
+	p, err := ReadPSBTFile(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	wallets := CreateWallets()
+	if !wallets.ReadWalletsFromFile() {
+		os.Exit(1)
+	}
+	unlockWallet(wallets, passphrase)
+	signed, err := p.Sign(wallets)
+	wallets.WalletLock()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(out) > 0 {
+		fmt.Printf("signed %d inputs\n", signed)
+	}
+	writePSBT(p, out)
+}
+
+// CombinePSBT merges the public keys and signatures of several copies of a PSBT
+func (cli *CLI) CombinePSBT(files []string, out string) {
+	psbts := []*PSBT{}
+	for _, file := range files {
+		p, err := ReadPSBTFile(file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		psbts = append(psbts, p)
+	}
+
+	combined, err := CombinePSBT(psbts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	writePSBT(combined, out)
+}
+
+// FinalizePSBT prints the fully signed transaction, ready for sendrawtx
+func (cli *CLI) FinalizePSBT(file string) {
+	p, err := ReadPSBTFile(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tx, err := p.Finalize()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("signed transaction %x, fee %d:\n", tx.ID, p.Fee())
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+}
+
+// InspectPSBT describes a PSBT and what it still needs
+func (cli *CLI) InspectPSBT(file string) {
+	p, err := ReadPSBTFile(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(p.String())
+}
+
+// writePSBT saves a PSBT to out and describes it, or prints it as JSON when out is empty
+func writePSBT(p *PSBT, out string) {
+	if len(out) == 0 {
+		printJSON(p)
+		return
+	}
+	if err := WritePSBTFile(out, p); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(p.String())
+	fmt.Printf("psbt written to %s\n", out)
+}
+
+// RecoverWallet restores the wallet file from its backup
+func (cli *CLI) RecoverWallet() {
+	if err := RecoverWalletFromBackup(Config.WalletFile); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("wallet restored from %s\n", Config.WalletFile+WALLETBACKUPSUFFIX)
+}
+
+// EncryptWallet encrypts the private keys of the wallet file with a passphrase
+func (cli *CLI) EncryptWallet(passphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	if err := wallets.EncryptWallet(passphrase); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("wallet encrypted, keep the passphrase safe: it can not be recovered")
+}
+
+// ChangePassphrase re-encrypts the wallet file under a new passphrase
+func (cli *CLI) ChangePassphrase(oldPassphrase, newPassphrase string) {
+	wallets := CreateWallets()
+	wallets.ReadWalletsFromFile()
+
+	if err := wallets.ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Success!")
+}
+
+// WalletPassphrase unlocks the wallet of the running node for timeout
+//
+// 命令行进程执行完一个命令就退出了, 解锁只对运行中的节点有意义; 本地命令用 -passphrase 临时解锁
+func (cli *CLI) WalletPassphrase(passphrase string, timeout time.Duration) {
+	if cli.RPC == nil {
+		fmt.Println("walletpassphrase needs a running node, local commands take -passphrase instead")
+		os.Exit(1)
+	}
+	cli.remoteWalletPassphrase(passphrase, timeout)
+}
+
+// WalletLock locks the wallet of the running node before its unlock timeout
+func (cli *CLI) WalletLock() {
+	if cli.RPC == nil {
+		fmt.Println("walletlock needs a running node, the wallet file is never left unlocked")
+		os.Exit(1)
+	}
+	cli.remoteWalletLock()
 }
 
 func (cli *CLI) GetLatestHeight() {
+	if cli.RPC != nil {
+		cli.remoteGetLatestHeight()
+		return
+	}
+
 	height, _ := cli.Blockchain.GetLatestHeight()
 	fmt.Printf("latest height: %d\n", height)
 }
 
+// ListBanned prints all active bans
+func (cli *CLI) ListBanned() {
+	if cli.RPC != nil {
+		cli.remoteListBanned()
+		return
+	}
+
+	entries, err := cli.Blockchain.ListBanned()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("no banned peers")
+		return
+	}
+	for _, entry := range entries {
+		fmt.Println(entry.String())
+	}
+}
+
+// SetBan adds or removes a ban manually
+//
+// 手动封禁或者解封一个节点, command 只能是 add 或者 remove
+func (cli *CLI) SetBan(addr, command string, duration time.Duration) {
+	if cli.RPC != nil {
+		cli.remoteSetBan(addr, command, duration)
+		return
+	}
+
+	var err error
+
+	switch command {
+	case "add":
+		err = cli.Blockchain.BanPeer(addr, duration, "manually banned")
+	case "remove":
+		err = cli.Blockchain.UnbanPeer(addr)
+	default:
+		err = fmt.Errorf("invalid setban command %q, must be add or remove", command)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Success!")
+}
+
 // startnode start a node
 func (cli CLI) startnode(nodeid, minnerAddr string) {
 	fmt.Printf("start node: %s\n", nodeid)
@@ -255,6 +1990,24 @@ func (cli CLI) startnode(nodeid, minnerAddr string) {
 		}
 	}
 
+	// RPC服务和P2P服务共用区块链数据库, 必须在同一个进程中启动
+	if err := StartRPCServer(cli.Blockchain); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := StartRESTServer(cli.Blockchain); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := StartExplorer(cli.Blockchain); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := StartMetricsServer(cli.Blockchain); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	// 如果节点有效，则启动服务器
 	ok := StartServer(nodeid, minnerAddr, cli.Blockchain)
 	if !ok {
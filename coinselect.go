@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SELECTIONLARGEST  = "largest"  // 优先使用金额大的输出, 交易的输入最少
+	SELECTIONSMALLEST = "smallest" // 优先使用金额小的输出, 顺便合并零钱
+	SELECTIONBNB      = "bnb"      // 分支定界, 寻找不需要找零的组合, 找不到时退回到 largest
+	SELECTIONRANDOM   = "random"   // 随机选择, 不泄露钱包中输出的金额分布
+
+	DEFAULTSELECTION = SELECTIONBNB
+
+	BNBMAXTRIES = 100000 // 分支定界最多搜索的节点数
+)
+
+var (
+	ErrInsufficientFunds = errors.New("not enough funds")
+)
+
+// Outpoint identifies an output of a transaction
+type Outpoint struct {
+	TxID  []byte
+	Index int
+}
+
+// String formats the outpoint as txid:index
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Index)
+}
+
+// ParseOutpoint parses txid:index
+func ParseOutpoint(s string) (Outpoint, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return Outpoint{}, fmt.Errorf("invalid outpoint %q, want txid:index", s)
+	}
+	txID, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return Outpoint{}, fmt.Errorf("invalid outpoint txid %q, %w", parts[0], err)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || index < 0 {
+		return Outpoint{}, fmt.Errorf("invalid outpoint index %q", parts[1])
+	}
+	return Outpoint{TxID: txID, Index: index}, nil
+}
+
+// Coin is an unspent output that can be spent by the wallet
+//
+// 可以花费的输出: 输出的位置和金额
+type Coin struct {
+	Outpoint
+	Value int
+}
+
+// CoinSelection picks coins worth at least amount; dust is the change that may be given up as fee
+//
+// 选币策略: 从coins中选出总额不少于amount的输出
+type CoinSelection func(coins []Coin, amount, dust int) []Coin
+
+// CoinSelections are the strategies available to sendtx -selection
+var CoinSelections = map[string]CoinSelection{
+	SELECTIONLARGEST:  selectLargestFirst,
+	SELECTIONSMALLEST: selectSmallestFirst,
+	SELECTIONBNB:      selectBranchAndBound,
+	SELECTIONRANDOM:   selectRandom,
+}
+
+// SelectCoins runs a strategy, or spends exactly the chosen outpoints when coin control is used
+//
+// 选币: 指定了输出的时候(coin control)只花费这些输出, 否则使用选币策略
+func SelectCoins(coins []Coin, amount, dust int, strategy string, chosen []Outpoint) ([]Coin, int, error) {
+	var selected []Coin
+
+	if len(chosen) > 0 {
+		seen := make(map[string]bool)
+		for _, outpoint := range chosen {
+			if seen[outpoint.String()] {
+				return nil, 0, fmt.Errorf("outpoint %s is chosen twice", outpoint)
+			}
+			seen[outpoint.String()] = true
+
+			coin, ok := findCoin(coins, outpoint)
+			if !ok {
+				return nil, 0, fmt.Errorf("outpoint %s is not an unspent output of the sender", outpoint)
+			}
+			selected = append(selected, coin)
+		}
+	} else {
+		selection, ok := CoinSelections[strategy]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown coin selection %q", strategy)
+		}
+		selected = selection(coins, amount, dust)
+	}
+
+	sum := sumCoins(selected)
+	if sum < amount {
+		return nil, 0, fmt.Errorf("%w, selected %d, want %d", ErrInsufficientFunds, sum, amount)
+	}
+	return selected, sum, nil
+}
+
+// findCoin looks up the coin of an outpoint
+func findCoin(coins []Coin, outpoint Outpoint) (Coin, bool) {
+	for _, coin := range coins {
+		if bytes.Equal(coin.TxID, outpoint.TxID) && coin.Index == outpoint.Index {
+			return coin, true
+		}
+	}
+	return Coin{}, false
+}
+
+func sumCoins(coins []Coin) int {
+	sum := 0
+	for _, coin := range coins {
+		sum += coin.Value
+	}
+	return sum
+}
+
+// sortedCoins returns a copy of coins ordered by value, ties broken by outpoint so the result is stable
+func sortedCoins(coins []Coin, descending bool) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return (sorted[i].Value > sorted[j].Value) == descending
+		}
+		if c := bytes.Compare(sorted[i].TxID, sorted[j].TxID); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Index < sorted[j].Index
+	})
+	return sorted
+}
+
+// accumulate takes coins in order until amount is reached
+func accumulate(coins []Coin, amount int) []Coin {
+	selected := []Coin{}
+	sum := 0
+	for _, coin := range coins {
+		if sum >= amount {
+			break
+		}
+		selected = append(selected, coin)
+		sum += coin.Value
+	}
+	return selected
+}
+
+// selectLargestFirst spends the biggest outputs first
+func selectLargestFirst(coins []Coin, amount, _ int) []Coin {
+	return accumulate(sortedCoins(coins, true), amount)
+}
+
+// selectSmallestFirst spends the smallest outputs first, consolidating small change
+func selectSmallestFirst(coins []Coin, amount, _ int) []Coin {
+	return accumulate(sortedCoins(coins, false), amount)
+}
+
+// selectRandom spends outputs in a random order
+//
+// 随机打乱顺序再累加, 交易的输入不会暴露钱包中最大或者最小的输出
+func selectRandom(coins []Coin, amount, _ int) []Coin {
+	shuffled := append([]Coin{}, coins...)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return selectLargestFirst(coins, amount, 0)
+		}
+		j := int(n.Int64())
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return accumulate(shuffled, amount)
+}
+
+// selectBranchAndBound searches for inputs worth between amount and amount+dust so no change output is needed
+//
+// 分支定界: 深度优先搜索每个输出选或不选, 总额超过 amount+dust 或者剩下的输出加起来也不够时剪枝;
+// 多余的部分不超过dust, 直接作为手续费; 找不到这样的组合时退回到 largest
+func selectBranchAndBound(coins []Coin, amount, dust int) []Coin {
+	sorted := sortedCoins(coins, true)
+
+	// remaining[i] = sorted[i:] 的总额, 用于剪枝
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+
+	var best []int
+	bestWaste := -1
+	current := []int{}
+	tries := 0
+
+	var search func(index, sum int)
+	search = func(index, sum int) {
+		tries++
+		if tries > BNBMAXTRIES {
+			return
+		}
+		if sum > amount+dust {
+			return
+		}
+		if sum >= amount {
+			// 多余的金额越少越好, 一样多的时候输入越少越好
+			waste := sum - amount
+			if bestWaste < 0 || waste < bestWaste || (waste == bestWaste && len(current) < len(best)) {
+				best = append([]int{}, current...)
+				bestWaste = waste
+			}
+			return
+		}
+		if index >= len(sorted) || sum+remaining[index] < amount {
+			return
+		}
+
+		current = append(current, index)
+		search(index+1, sum+sorted[index].Value)
+		current = current[:len(current)-1]
+		search(index+1, sum)
+	}
+	search(0, 0)
+
+	if best == nil {
+		return selectLargestFirst(coins, amount, dust)
+	}
+
+	selected := make([]Coin, 0, len(best))
+	for _, index := range best {
+		selected = append(selected, sorted[index])
+	}
+	return selected
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSelectCoins(t *testing.T) {
+	coin := func(id string, value int) Coin { return Coin{Outpoint{[]byte(id), 0}, value} }
+	a, b, c, d := coin("a", 50), coin("b", 30), coin("c", 20), coin("d", 5)
+	coins := []Coin{d, a, c, b}
+
+	tests := []struct {
+		name     string
+		amount   int
+		dust     int
+		strategy string
+		chosen   []Outpoint
+		want     []Coin
+		wantErr  error
+	}{
+		{name: "largest", amount: 60, strategy: SELECTIONLARGEST, want: []Coin{a, b}},
+		{name: "smallest", amount: 30, strategy: SELECTIONSMALLEST, want: []Coin{d, c, b}},
+		{name: "bnb exact match", amount: 55, strategy: SELECTIONBNB, want: []Coin{a, d}},
+		{name: "bnb fewer inputs", amount: 50, strategy: SELECTIONBNB, want: []Coin{a}},
+		{name: "bnb within dust", amount: 48, dust: 3, strategy: SELECTIONBNB, want: []Coin{a}},
+		// 没有不需要找零的组合, 退回到 largest
+		{name: "bnb fallback", amount: 96, strategy: SELECTIONBNB, want: []Coin{a, b, c}},
+		{name: "insufficient", amount: 106, strategy: SELECTIONLARGEST, wantErr: ErrInsufficientFunds},
+		{name: "coin control", amount: 10, chosen: []Outpoint{c.Outpoint, d.Outpoint}, want: []Coin{c, d}},
+		{name: "coin control insufficient", amount: 30, chosen: []Outpoint{c.Outpoint}, wantErr: ErrInsufficientFunds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, sum, err := SelectCoins(coins, test.amount, test.dust, test.strategy, test.chosen)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("SelectCoins error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(selected, test.want) {
+				t.Errorf("SelectCoins = %v, want %v", selected, test.want)
+			}
+			if sum != sumCoins(test.want) {
+				t.Errorf("SelectCoins sum %d, want %d", sum, sumCoins(test.want))
+			}
+		})
+	}
+
+	// 随机选择的结果不固定, 但是总额一定足够
+	for i := 0; i < 20; i++ {
+		if _, sum, err := SelectCoins(coins, 70, 0, SELECTIONRANDOM, nil); err != nil || sum < 70 {
+			t.Fatalf("random selection = %d %v, want at least 70", sum, err)
+		}
+	}
+
+	invalid := []struct {
+		name     string
+		strategy string
+		chosen   []Outpoint
+	}{
+		{name: "unknown strategy", strategy: "fifo"},
+		{name: "unknown outpoint", chosen: []Outpoint{{[]byte("e"), 0}}},
+		{name: "chosen twice", chosen: []Outpoint{a.Outpoint, a.Outpoint}},
+	}
+	for _, test := range invalid {
+		if _, _, err := SelectCoins(coins, 10, 0, test.strategy, test.chosen); err == nil {
+			t.Errorf("%s: SelectCoins succeeded, want an error", test.name)
+		}
+	}
+}
+
+func TestParseOutpoint(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Outpoint
+		wantErr bool
+	}{
+		{input: "0a0b:1", want: Outpoint{[]byte{0x0a, 0x0b}, 1}},
+		{input: " 0a0b:0 ", want: Outpoint{[]byte{0x0a, 0x0b}, 0}},
+		{input: "0a0b", wantErr: true},
+		{input: "xyz:1", wantErr: true},
+		{input: "0a0b:-1", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseOutpoint(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseOutpoint(%q) error %v, want error %v", test.input, err, test.wantErr)
+			continue
+		}
+		if err == nil && (got.String() != test.want.String()) {
+			t.Errorf("ParseOutpoint(%q) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	SHORTIDLENGTH = 6 // 短交易ID的字节数, 和BIP152一样使用6个字节
+
+	MAXPENDINGCOMPACT     = 32               // 同时等待缺少交易的紧凑区块最多数量
+	PENDINGCOMPACTTIMEOUT = 30 * time.Second // 等待缺少交易的时间, 超时之后丢弃重建中的区块
+)
+
+// BlockHeader is a block without its transactions
+//
+// 区块头, 紧凑区块中只携带区块头和短交易ID
+type BlockHeader struct {
+	Version       int
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Hash          []byte
+	Time          int64
+	Bits          int64
+	Nonce         int64
+	Height        int64
+}
+
+// PrefilledTx is a transaction sent in full inside a compact block
+//
+// 接收方的交易池中一定没有的交易（比如coinbase交易）直接放在紧凑区块中发送
+type PrefilledTx struct {
+	Index int    // 交易在区块中的位置
+	Tx    []byte // 序列化之后的交易
+}
+
+// CompactBlock announces a new block using short transaction IDs
+//
+// 紧凑区块: 区块头 + 每笔交易的短ID, 接收方根据自己的交易池重建区块
+type CompactBlock struct {
+	AddrFrom  string
+	Header    BlockHeader
+	Salt      uint64        // 计算短ID用的随机数, 每个区块都不一样, 防止有人故意构造短ID冲突
+	ShortIDs  [][]byte      // 没有预先填充的交易的短ID, 按照区块中的顺序排列
+	Prefilled []PrefilledTx // 预先填充的交易, 按照Index从小到大排列
+}
+
+// GetBlockTxn asks the announcer for the transactions we could not find in our mempool
+//
+// 向发送紧凑区块的节点请求交易池中缺少的交易
+type GetBlockTxn struct {
+	AddrFrom  string
+	BlockHash []byte
+	Indexes   []int // 缺少的交易在区块中的位置
+}
+
+// BlockTxn carries the transactions requested with GetBlockTxn
+type BlockTxn struct {
+	AddrFrom  string
+	BlockHash []byte
+	Txs       [][]byte // 序列化之后的交易, 顺序和请求中的Indexes一致
+}
+
+// partialBlock is a compact block waiting for its missing transactions
+//
+// 正在重建的区块, 等待对方发送缺少的交易
+type partialBlock struct {
+	header  BlockHeader
+	peer    string         // 发送紧凑区块的连接对端地址, 只接受它发送的缺少交易
+	from    string         // 对方声明的地址, 向它请求缺少的交易
+	txs     []*Transaction // 重建中的交易列表, 缺少的交易为nil
+	missing []int          // 缺少的交易在区块中的位置
+	added   time.Time      // 开始等待的时间, 池满的时候丢弃最早的区块
+	timer   *time.Timer    // 超时之后从 pendingCompact 中删除
+}
+
+var (
+	// 正在等待缺少交易的区块; map[区块hash]*partialBlock
+	pendingCompact   = make(map[string]*partialBlock)
+	pendingCompactMu sync.Mutex
+)
+
+// Header returns the header of a block
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Version, b.PrevBlockHash, b.MerkleRoot, b.Hash, b.Time, b.Bits, b.Nonce, b.Height}
+}
+
+// BlockFromHeader creates a block from a header and its transactions
+func BlockFromHeader(h BlockHeader, txs []*Transaction) *Block {
+	return &Block{h.Version, h.PrevBlockHash, h.MerkleRoot, h.Hash, h.Time, h.Bits, h.Nonce, txs, h.Height}
+}
+
+// shortTxID computes the short ID of a transaction for a given block and salt
+//
+// 短ID = SHA256(区块hash || salt || 交易ID) 的前6个字节
+func shortTxID(blockHash []byte, salt uint64, txID []byte) []byte {
+	data := bytes.Join([][]byte{blockHash, Uint64ToBytesBigEndian(salt), txID}, []byte{})
+	hash := sha256.Sum256(data)
+	return hash[:SHORTIDLENGTH]
+}
+
+// NewCompactBlock builds the compact representation of a block
+//
+// 构建紧凑区块, coinbase交易总是预先填充, 其余交易使用短ID
+func NewCompactBlock(block *Block) (*CompactBlock, error) {
+	var saltBytes [8]byte
+	_, err := rand.Read(saltBytes[:])
+	if err != nil {
+		return nil, fmt.Errorf("generate compact block salt failed, %w", err)
+	}
+
+	cmpct := &CompactBlock{
+		AddrFrom: CurrentNode,
+		Header:   block.Header(),
+		Salt:     binary.BigEndian.Uint64(saltBytes[:]),
+	}
+
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			cmpct.Prefilled = append(cmpct.Prefilled, PrefilledTx{Index: i, Tx: tx.Serialize()})
+			continue
+		}
+		cmpct.ShortIDs = append(cmpct.ShortIDs, shortTxID(block.Hash, cmpct.Salt, tx.ID))
+	}
+
+	return cmpct, nil
+}
+
+// announceBlock sends a compact block to every known node except skip
+//
+// 把新区块以紧凑区块的形式广播给所有已知节点
+func announceBlock(block *Block, skip string) {
+	cmpct, err := NewCompactBlock(block)
+	if err != nil {
+		fmt.Printf("build compact block failed: %v\n", err)
+		return
+	}
+	request := append(commandToBytes("cmpctblock"), EncodeEverything(cmpct)...)
+
+	for _, node := range knownNodes() {
+		if node == CurrentNode || node == skip {
+			continue
+		}
+		sendData(node, request)
+	}
+}
+
+// handleCmpctBlock rebuilds a block from a compact announcement and our mempool
+//
+// 收到紧凑区块之后, 根据短ID从交易池中找到对应的交易, 缺少的交易向对方请求
+func handleCmpctBlock(request []byte, peer string, bc *Blockchain) error {
+	var payload CompactBlock
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
+	}
+
+	header := payload.Header
+
+	// 1. 已经有这个区块了, 不需要处理
+	if _, err := bc.GetBlock(header.Hash); err == nil {
+		return nil
+	}
+	Peers.MarkAnnounced(payload.AddrFrom, [][]byte{header.Hash})
+
+	// 2. 先检查区块头的工作量证明, 无效的区块不需要重建
+	if !NewPOW(BlockFromHeader(header, nil)).Validate() {
+		return misbehavior(peer, MISBEHAVIOR_BADPOW, fmt.Sprintf("bad proof of work in compact block %x", header.Hash), nil)
+	}
+
+	// 3. 把预先填充的交易放到对应的位置
+	total := len(payload.ShortIDs) + len(payload.Prefilled)
+	txs := make([]*Transaction, total)
+	for _, prefilled := range payload.Prefilled {
+		if prefilled.Index < 0 || prefilled.Index >= total || txs[prefilled.Index] != nil {
+			return misbehavior(peer, MISBEHAVIOR_MALFORMED, "invalid prefilled transaction index", nil)
+		}
+		tx, err := DeserializeTransaction(prefilled.Tx)
+		if err != nil {
+			return misbehavior(peer, MISBEHAVIOR_MALFORMED, "malformed prefilled transaction", err)
+		}
+		txs[prefilled.Index] = tx
+	}
+
+	// 4. 计算交易池中所有交易的短ID, 短ID冲突的交易不能使用, 需要向对方请求
+	mempoolByShortID := make(map[string]*Transaction)
+	collisions := make(map[string]bool)
+	for _, tx := range TxPool.Transactions() {
+		id := string(shortTxID(header.Hash, payload.Salt, tx.ID))
+		if _, ok := mempoolByShortID[id]; ok {
+			collisions[id] = true
+		}
+		mempoolByShortID[id] = tx
+	}
+
+	// 5. 按顺序填充剩下的位置
+	missing := []int{}
+	next := 0
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+		if next >= len(payload.ShortIDs) {
+			return misbehavior(peer, MISBEHAVIOR_MALFORMED, "compact block short id count mismatch", nil)
+		}
+		id := string(payload.ShortIDs[next])
+		next++
+
+		if tx, ok := mempoolByShortID[id]; ok && !collisions[id] {
+			txs[i] = tx
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	// 6. 交易都找到了，直接添加区块; 否则向对方请求缺少的交易
+	if len(missing) == 0 {
+		fmt.Printf("reconstructed compact block %x from mempool\n", header.Hash)
+		return connectCompactBlock(BlockFromHeader(header, txs), peer, payload.AddrFrom, bc)
+	}
+
+	addPendingCompact(&partialBlock{header: header, peer: peer, from: payload.AddrFrom, txs: txs, missing: missing})
+
+	fmt.Printf("compact block %x is missing %d transactions, request them from %s\n", header.Hash, len(missing), payload.AddrFrom)
+	getBlockTxn := GetBlockTxn{AddrFrom: CurrentNode, BlockHash: header.Hash, Indexes: missing}
+	sendData(payload.AddrFrom, append(commandToBytes("getblocktxn"), EncodeEverything(getBlockTxn)...))
+	return nil
+}
+
+// handleGetBlockTxn sends back the requested transactions of a block
+//
+// 对方重建紧凑区块的时候缺少一些交易, 把这些交易发送给对方
+func handleGetBlockTxn(request []byte, peer string, bc *Blockchain) error {
+	var payload GetBlockTxn
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
+	}
+
+	block, err := bc.GetBlock(payload.BlockHash)
+	if err != nil {
+		return fmt.Errorf("get block %x failed, %w", payload.BlockHash, err)
+	}
+
+	response := BlockTxn{AddrFrom: CurrentNode, BlockHash: block.Hash}
+	for _, index := range payload.Indexes {
+		if index < 0 || index >= len(block.Transactions) {
+			return misbehavior(peer, MISBEHAVIOR_MALFORMED, "getblocktxn index out of range", nil)
+		}
+		response.Txs = append(response.Txs, block.Transactions[index].Serialize())
+	}
+
+	sendData(payload.AddrFrom, append(commandToBytes("blocktxn"), EncodeEverything(response)...))
+	return nil
+}
+
+// handleBlockTxn completes a pending compact block
+//
+// 收到缺少的交易之后, 完成区块的重建
+func handleBlockTxn(request []byte, peer string, bc *Blockchain) error {
+	var payload BlockTxn
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
+	}
+
+	partial, ok := takePendingCompact(payload.BlockHash, peer)
+	if !ok {
+		return misbehavior(peer, MISBEHAVIOR_UNSOLICITED, fmt.Sprintf("unsolicited blocktxn for %x", payload.BlockHash), nil)
+	}
+
+	if len(payload.Txs) != len(partial.missing) {
+		// 对方返回的交易数量不对, 退回到请求完整区块
+		fmt.Printf("blocktxn for %x has %d transactions, want %d; request full block\n", payload.BlockHash, len(payload.Txs), len(partial.missing))
+		getBlockData(partial.from, "block", payload.BlockHash)
+		return nil
+	}
+
+	for i, index := range partial.missing {
+		tx, err := DeserializeTransaction(payload.Txs[i])
+		if err != nil {
+			return misbehavior(peer, MISBEHAVIOR_MALFORMED, "malformed blocktxn transaction", err)
+		}
+		partial.txs[index] = tx
+	}
+
+	return connectCompactBlock(BlockFromHeader(partial.header, partial.txs), peer, partial.from, bc)
+}
+
+// connectCompactBlock validates and stores a reconstructed block, then relays it
+//
+// 校验并且添加重建之后的区块, 然后继续把区块广播给其他节点
+func connectCompactBlock(block *Block, peer, from string, bc *Blockchain) error {
+	// 短ID冲突的时候可能从交易池中取到了错误的交易, 默克尔根对不上时向对方请求完整的区块, 不算违规
+	if !bytes.Equal(block.CreateMerkleRoot(), block.MerkleRoot) {
+		fmt.Printf("compact block %x does not match its merkle root, request full block\n", block.Hash)
+		getBlockData(from, "block", block.Hash)
+		return nil
+	}
+
+	if err := processBlock(block, peer, from, bc); err != nil {
+		return err
+	}
+	// 孤儿区块等父区块到达之后再连接, 现在还不能广播
+	if _, err := bc.GetBlock(block.Hash); err != nil {
+		return nil
+	}
+	fmt.Printf("Received a new compact block and add it to blockchain! %x\n", block.Hash)
+
+	announceBlock(block, from)
+	return nil
+}
+
+// addPendingCompact holds a compact block until the announcer sends its missing transactions
+//
+// 每个节点同时只能有一个区块在等待, 新的紧凑区块替换同一个节点之前的区块; 池满的时候丢弃等待最久的区块
+func addPendingCompact(partial *partialBlock) {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	hash := string(partial.header.Hash)
+	oldest := ""
+	for key, pending := range pendingCompact {
+		if key == hash || pending.peer == partial.peer {
+			pending.timer.Stop()
+			delete(pendingCompact, key)
+			continue
+		}
+		if len(oldest) == 0 || pending.added.Before(pendingCompact[oldest].added) {
+			oldest = key
+		}
+	}
+	if len(pendingCompact) >= MAXPENDINGCOMPACT {
+		pendingCompact[oldest].timer.Stop()
+		delete(pendingCompact, oldest)
+	}
+
+	partial.added = time.Now()
+	partial.timer = time.AfterFunc(PENDINGCOMPACTTIMEOUT, func() { expirePendingCompact(partial) })
+	pendingCompact[hash] = partial
+}
+
+// expirePendingCompact drops a compact block whose missing transactions did not arrive in time
+func expirePendingCompact(partial *partialBlock) {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	hash := string(partial.header.Hash)
+	// 超时之前可能已经被替换, 只删除同一个等待
+	if pendingCompact[hash] == partial {
+		delete(pendingCompact, hash)
+		fmt.Printf("missing transactions of compact block %x did not arrive from %s in time\n", partial.header.Hash, partial.peer)
+	}
+}
+
+// takePendingCompact removes and returns the compact block a peer was asked to complete
+func takePendingCompact(hash []byte, peer string) (*partialBlock, bool) {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	partial, ok := pendingCompact[string(hash)]
+	if !ok || partial.peer != peer {
+		return nil, false
+	}
+	partial.timer.Stop()
+	delete(pendingCompact, string(hash))
+	return partial, true
+}
+
+// prunePendingCompact drops the compact blocks the tip has moved past
+//
+// 新区块连接之后, 同一高度和更低高度的区块不再需要重建
+func prunePendingCompact(height int64) {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	for key, pending := range pendingCompact {
+		if pending.header.Height <= height {
+			pending.timer.Stop()
+			delete(pendingCompact, key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// resetPendingCompact empties the pending compact blocks after a test
+func resetPendingCompact(t *testing.T) {
+	t.Cleanup(func() { prunePendingCompact(1 << 62) })
+}
+
+func newTestPartialBlock(hash, peer string, height int64) *partialBlock {
+	return &partialBlock{header: BlockHeader{Hash: []byte(hash), Height: height}, peer: peer, from: peer}
+}
+
+func pendingCompactCount() int {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	return len(pendingCompact)
+}
+
+func TestPendingCompactPerPeer(t *testing.T) {
+	resetPendingCompact(t)
+
+	first := newTestPartialBlock("first", "10.0.0.1:3000", 5)
+	addPendingCompact(first)
+	addPendingCompact(newTestPartialBlock("other", "10.0.0.2:3000", 5))
+	// 同一个节点的新紧凑区块替换之前的区块
+	addPendingCompact(newTestPartialBlock("second", "10.0.0.1:3000", 6))
+
+	if _, ok := takePendingCompact([]byte("first"), "10.0.0.1:3000"); ok {
+		t.Error("the replaced compact block is still pending")
+	}
+	if _, ok := takePendingCompact([]byte("second"), "10.0.0.2:3000"); ok {
+		t.Error("took a compact block announced by another peer")
+	}
+	if partial, ok := takePendingCompact([]byte("second"), "10.0.0.1:3000"); !ok || partial.header.Height != 6 {
+		t.Errorf("takePendingCompact = %v %v, want the second block", partial, ok)
+	}
+	if _, ok := takePendingCompact([]byte("second"), "10.0.0.1:3000"); ok {
+		t.Error("took the same compact block twice")
+	}
+
+	// 超时之后删除; 已经被替换的区块超时不影响新的区块
+	expirePendingCompact(first)
+	if pendingCompactCount() != 1 {
+		t.Errorf("%d compact blocks pending, want 1", pendingCompactCount())
+	}
+	other, _ := takePendingCompact([]byte("other"), "10.0.0.2:3000")
+	addPendingCompact(other)
+	expirePendingCompact(other)
+	if pendingCompactCount() != 0 {
+		t.Errorf("%d compact blocks pending after expiry, want 0", pendingCompactCount())
+	}
+}
+
+func TestPendingCompactLimit(t *testing.T) {
+	resetPendingCompact(t)
+
+	for i := 0; i < MAXPENDINGCOMPACT+5; i++ {
+		addPendingCompact(newTestPartialBlock(fmt.Sprint("block", i), fmt.Sprint("10.0.0.1:", i), int64(i)))
+	}
+	if pendingCompactCount() != MAXPENDINGCOMPACT {
+		t.Errorf("%d compact blocks pending, want %d", pendingCompactCount(), MAXPENDINGCOMPACT)
+	}
+	// 池满时丢弃等待最久的区块
+	if _, ok := takePendingCompact([]byte("block0"), "10.0.0.1:0"); ok {
+		t.Error("the oldest compact block was kept")
+	}
+
+	// 最新区块移动之后, 不高于它的区块不再等待
+	prunePendingCompact(MAXPENDINGCOMPACT)
+	if pendingCompactCount() != 4 {
+		t.Errorf("%d compact blocks pending after pruning, want 4", pendingCompactCount())
+	}
+}
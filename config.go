@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NodeConfig holds the per-node settings
+//
+// 每个节点独立的配置, 同一台机器上可以运行多个节点
+type NodeConfig struct {
+	Network        string   `json:"network"`       // mainnet, testnet 或者 regtest
+	NodeID         string   `json:"nodeid"`        // 节点ID, 默认也是监听的端口号
+	ListenAddr     string   `json:"listen"`        // 监听的地址, 默认 localhost:<nodeid>
+	AdvertiseAddr  string   `json:"advertise"`     // 告诉其他节点的地址, 默认和监听地址一样
+	SeedPeers      []string `json:"seeds"`         // 种子节点列表
+	DataDir        string   `json:"datadir"`       // 数据目录, 区块链数据库和钱包都保存在这里
+	WalletFile     string   `json:"wallet"`        // 钱包文件路径, 默认 <datadir>/wallets_<nodeid>.dat
+	ChangeAddress  string   `json:"changeaddress"` // 固定的找零地址, 为空时每笔交易使用新的找零地址
+	RPCListen      string   `json:"rpclisten"`     // JSON-RPC 监听的地址, 默认 localhost:<nodeid+1000>
+	RPCUser        string   `json:"rpcuser"`       // JSON-RPC 的用户名和密码, 没有设置密码时使用cookie文件认证
+	RPCPassword    string   `json:"rpcpassword"`
+	RESTListen     string   `json:"restlisten"`     // 只读REST接口监听的地址, 为空时不启动
+	ExplorerListen string   `json:"explorerlisten"` // 区块浏览器监听的地址, 为空时不启动
+	MetricsListen  string   `json:"metricslisten"`  // Prometheus 指标监听的地址, 为空时不启动
+}
+
+// Config is the configuration of the running node
+var Config = DefaultConfig()
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() *NodeConfig {
+	return &NodeConfig{
+		Network: DEFAULTNETWORK,
+		DataDir: ".",
+	}
+}
+
+// LoadConfigFile reads a JSON config file on top of the current settings
+//
+// 读取JSON格式的配置文件, 配置文件中没有出现的字段保持原来的值
+func (c *NodeConfig) LoadConfigFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read config file failed, %w", err)
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return fmt.Errorf("parse config file %s failed, %w", file, err)
+	}
+
+	return nil
+}
+
+// Finalize selects the network, fills the derived defaults and creates the data directory
+//
+// 选择网络参数, 补全依赖网络和节点ID的默认值, 并且创建数据目录; 测试网络的数据保存在数据目录的子目录中
+func (c *NodeConfig) Finalize() error {
+	if len(c.Network) == 0 {
+		c.Network = DEFAULTNETWORK
+	}
+	if err := SelectNetwork(c.Network); err != nil {
+		return err
+	}
+	c.Network = Params.Name
+
+	if len(c.NodeID) == 0 {
+		c.NodeID = strconv.Itoa(Params.DefaultPort)
+	}
+	if len(c.SeedPeers) == 0 {
+		c.SeedPeers = []string{fmt.Sprintf("localhost:%d", Params.DefaultPort)}
+	}
+	if len(c.ListenAddr) == 0 {
+		c.ListenAddr = fmt.Sprintf("localhost:%s", c.NodeID)
+	}
+	if len(c.AdvertiseAddr) == 0 {
+		c.AdvertiseAddr = c.ListenAddr
+	}
+	// 节点ID不是端口号的时候必须手动设置RPC地址
+	if port, err := strconv.Atoi(c.NodeID); len(c.RPCListen) == 0 && err == nil {
+		c.RPCListen = fmt.Sprintf("localhost:%d", port+RPCPORTOFFSET)
+	}
+	if len(c.DataDir) == 0 {
+		c.DataDir = "."
+	}
+	c.DataDir = filepath.Join(c.DataDir, Params.DataSubdir)
+	if len(c.WalletFile) == 0 {
+		c.WalletFile = filepath.Join(c.DataDir, fmt.Sprintf("wallets_%s.dat", c.NodeID))
+	}
+
+	// 0700 只有文件拥有者可以访问数据目录
+	err := os.MkdirAll(c.DataDir, 0700)
+	if err != nil {
+		return fmt.Errorf("create data directory failed, %w", err)
+	}
+
+	return nil
+}
+
+// DBFile returns the path of the blockchain database of this node
+func (c *NodeConfig) DBFile() string {
+	return filepath.Join(c.DataDir, fmt.Sprintf("blockchain_%s.db", c.NodeID))
+}
+
+// NodeKeyFile returns the path of the transport key of this node
+func (c *NodeConfig) NodeKeyFile() string {
+	return filepath.Join(c.DataDir, fmt.Sprintf("nodekey_%s.pem", c.NodeID))
+}
+
+// RPCCookieFile returns the path of the file holding the RPC credentials of a running node
+func (c *NodeConfig) RPCCookieFile() string {
+	return filepath.Join(c.DataDir, fmt.Sprintf(".cookie_%s", c.NodeID))
+}
+
+// splitPeers parses a comma separated list of peer addresses
+func splitPeers(list string) []string {
+	peers := []string{}
+	for _, peer := range strings.Split(list, ",") {
+		peer = strings.TrimSpace(peer)
+		if len(peer) > 0 {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useTestNetwork restores the selected network after the test
+func useTestNetwork(t *testing.T) {
+	saved := Params
+	t.Cleanup(func() { Params = saved })
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "node.json")
+	if err := os.WriteFile(file, []byte(`{"network":"testnet","seeds":["10.0.0.1:13000"],"rpcuser":"user"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置文件中没有的字段保持原来的值
+	config := DefaultConfig()
+	config.NodeID = "4000"
+	if err := config.LoadConfigFile(file); err != nil {
+		t.Fatal(err)
+	}
+	want := &NodeConfig{Network: NETWORKTESTNET, NodeID: "4000", SeedPeers: []string{"10.0.0.1:13000"}, DataDir: ".", RPCUser: "user"}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("LoadConfigFile = %+v, want %+v", config, want)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"network":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{invalid, filepath.Join(dir, "missing.json")} {
+		if err := DefaultConfig().LoadConfigFile(name); err == nil {
+			t.Errorf("LoadConfigFile(%s) succeeded", filepath.Base(name))
+		}
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	useTestNetwork(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		config  NodeConfig
+		want    NodeConfig
+		wantErr bool
+	}{
+		{
+			name:   "mainnet defaults",
+			config: NodeConfig{DataDir: dir},
+			want: NodeConfig{
+				Network:       NETWORKMAINNET,
+				NodeID:        "3000",
+				ListenAddr:    "localhost:3000",
+				AdvertiseAddr: "localhost:3000",
+				SeedPeers:     []string{"localhost:3000"},
+				DataDir:       dir,
+				WalletFile:    filepath.Join(dir, "wallets_3000.dat"),
+				RPCListen:     "localhost:4000",
+			},
+		},
+		{
+			// 测试网络的数据保存在子目录中
+			name:   "testnet",
+			config: NodeConfig{Network: NETWORKTESTNET, NodeID: "13001", DataDir: dir},
+			want: NodeConfig{
+				Network:       NETWORKTESTNET,
+				NodeID:        "13001",
+				ListenAddr:    "localhost:13001",
+				AdvertiseAddr: "localhost:13001",
+				SeedPeers:     []string{"localhost:13000"},
+				DataDir:       filepath.Join(dir, NETWORKTESTNET),
+				WalletFile:    filepath.Join(dir, NETWORKTESTNET, "wallets_13001.dat"),
+				RPCListen:     "localhost:14001",
+			},
+		},
+		{
+			// 节点ID不是端口号, 没有默认的RPC地址; 设置过的值保持不变
+			name: "explicit",
+			config: NodeConfig{
+				Network:       NETWORKREGTEST,
+				NodeID:        "alice",
+				ListenAddr:    "0.0.0.0:23005",
+				AdvertiseAddr: "10.0.0.5:23005",
+				SeedPeers:     []string{"10.0.0.1:23000"},
+				DataDir:       dir,
+				WalletFile:    filepath.Join(dir, "alice.dat"),
+			},
+			want: NodeConfig{
+				Network:       NETWORKREGTEST,
+				NodeID:        "alice",
+				ListenAddr:    "0.0.0.0:23005",
+				AdvertiseAddr: "10.0.0.5:23005",
+				SeedPeers:     []string{"10.0.0.1:23000"},
+				DataDir:       filepath.Join(dir, NETWORKREGTEST),
+				WalletFile:    filepath.Join(dir, "alice.dat"),
+			},
+		},
+		{name: "unknown network", config: NodeConfig{Network: "moonnet", DataDir: dir}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			err := config.Finalize()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Finalize error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("Finalize = %+v, want %+v", config, tt.want)
+			}
+			if Params.Name != tt.want.Network {
+				t.Errorf("selected network %s, want %s", Params.Name, tt.want.Network)
+			}
+			if info, err := os.Stat(config.DataDir); err != nil || !info.IsDir() {
+				t.Errorf("data directory %s not created, %v", config.DataDir, err)
+			}
+		})
+	}
+}
+
+func TestSplitPeers(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", []string{}},
+		{"localhost:3000", []string{"localhost:3000"}},
+		{" localhost:3000, ,10.0.0.1:3000,", []string{"localhost:3000", "10.0.0.1:3000"}},
+	}
+	for _, tt := range tests {
+		if got := splitPeers(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitPeers(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	EVENTTIP               = "tip"               // 主链有了新的最新区块
+	EVENTBLOCKCONNECTED    = "blockconnected"    // 区块加入主链
+	EVENTBLOCKDISCONNECTED = "blockdisconnected" // 分叉切换时区块离开主链
+	EVENTMEMPOOL           = "mempool"           // 交易进入交易池
+
+	EVENTBUFFERSIZE = 256 // 每个订阅者最多积压的事件数
+)
+
+// Events is the event bus of the node
+var Events = NewEventBus()
+
+// Event is something that happened to the chain or the mempool
+//
+// 区块事件带有 Block, 交易池事件带有 Tx
+type Event struct {
+	Type  string
+	Block *Block
+	Tx    *Transaction
+}
+
+// EventBus fans events out to every subscriber without ever blocking the publisher
+//
+// 事件总线: 区块链和交易池发布事件, 订阅者各自从自己的缓冲通道读取;
+// 发布者在持有锁或者处理网络消息的时候发布事件, 所以不能被慢的订阅者阻塞
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]bool
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	Events <-chan Event // 订阅者跟不上的时候通道会被关闭, 需要重新订阅并且重新同步状态
+
+	ch  chan Event
+	bus *EventBus
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]bool)}
+}
+
+// Subscribe starts receiving events
+func (b *EventBus) Subscribe() *Subscription {
+	ch := make(chan Event, EVENTBUFFERSIZE)
+	sub := &Subscription{Events: ch, ch: ch, bus: b}
+
+	b.mu.Lock()
+	b.subscribers[sub] = true
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe stops receiving events and closes the channel, it may be called more than once
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if s.bus.subscribers[s] {
+		delete(s.bus.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// Publish sends an event to every subscriber
+//
+// 缓冲区已经满的订阅者会丢失事件, 与其悄悄丢掉一个付款通知, 不如直接关闭它的通道
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// publishTipChange publishes the blocks that left and joined the main chain, then the new tip
+//
+// disconnected 从旧的最新区块往回排列, connected 从分叉点往新的最新区块排列
+func (b *EventBus) publishTipChange(disconnected, connected []*Block) {
+	for _, block := range disconnected {
+		b.Publish(Event{Type: EVENTBLOCKDISCONNECTED, Block: block})
+	}
+	for _, block := range connected {
+		b.Publish(Event{Type: EVENTBLOCKCONNECTED, Block: block})
+	}
+	if len(connected) > 0 {
+		b.Publish(Event{Type: EVENTTIP, Block: connected[len(connected)-1]})
+	}
+}
+
+// tipChange walks back from the old and the new tip to their common ancestor
+//
+// 返回离开主链的区块和加入主链的区块; 缺少父区块时(同步还没有完成)停止查找
+func tipChange(bucket *bolt.Bucket, oldTip, newTip *Block) ([]*Block, []*Block) {
+	disconnected, connected := []*Block{}, []*Block{}
+	for !bytes.Equal(oldTip.Hash, newTip.Hash) {
+		if newTip.Height > oldTip.Height {
+			connected = append(connected, newTip)
+			data := bucket.Get(newTip.PrevBlockHash)
+			if data == nil {
+				break
+			}
+			newTip = Deserialize(data)
+		} else {
+			disconnected = append(disconnected, oldTip)
+			data := bucket.Get(oldTip.PrevBlockHash)
+			if data == nil {
+				break
+			}
+			oldTip = Deserialize(data)
+		}
+	}
+
+	for i, j := 0, len(connected)-1; i < j; i, j = i+1, j-1 {
+		connected[i], connected[j] = connected[j], connected[i]
+	}
+	return disconnected, connected
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EXPLORERPAGESIZE      = 20  // 首页每页显示的区块数
+	EXPLORERHISTORYBLOCKS = 100 // 地址页面每页查找交易的区块数
+	EXPLORERCONTENTTYPE   = "text/html; charset=utf-8"
+	EXPLORERCSP           = "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'" // 页面不加载任何外部资源
+)
+
+// Explorer serves a read-only HTML view of the chain, the mempool and the peers
+//
+// 区块浏览器: 服务端用 html/template 渲染, 模板和样式都编译在程序中, 不依赖任何外部资源;
+// 和REST接口一样不需要认证, 所以只显示公开的数据
+//
+//	GET /                    最新的区块, ?from=<height> 翻页
+//	GET /block/{hash}
+//	GET /block/height/{n}
+//	GET /tx/{txid}
+//	GET /address/{address}   余额和交易记录, ?from=<height> 翻页
+//	GET /mempool
+//	GET /peers
+//	GET /search?q=<height, hash, txid or address>
+type Explorer struct {
+	bc *Blockchain
+}
+
+// explorerLayout is shared by every page
+type explorerLayout struct {
+	Title   string
+	Network string
+	Height  int64
+}
+
+type explorerHome struct {
+	explorerLayout
+	TipHash string
+	Mempool int
+	Peers   int
+	Blocks  []explorerBlockRow
+	Newer   int64 // 上一页的起始高度, -1 表示已经是第一页
+	Older   int64 // 下一页的起始高度, -1 表示已经到创世区块
+}
+
+type explorerBlockRow struct {
+	Height  int64
+	Hash    string
+	Time    int64
+	TxCount int
+}
+
+type explorerBlock struct {
+	explorerLayout
+	Block     BlockJSON
+	NextBlock string // 主链上的下一个区块, 最新区块为空
+}
+
+type explorerTx struct {
+	explorerLayout
+	Tx        TransactionJSON
+	InMempool bool
+	Inputs    []explorerInput
+	TotalOut  int
+	Fee       int
+	FeeKnown  bool // 所有输入引用的输出都找到了才知道手续费
+}
+
+// explorerInput is an input together with the output it spends
+type explorerInput struct {
+	InputJSON
+	Value int
+	Known bool
+}
+
+type explorerAddress struct {
+	explorerLayout
+	Address     string
+	Balance     int
+	UTXOs       int
+	Unconfirmed []explorerHistoryRow
+	History     []explorerHistoryRow
+	From, To    int64 // 这一页查找的区块高度范围, 从 To 到 From
+	Newer       int64 // 上一页的起始高度, -1 表示没有
+	Older       int64 // 下一页的起始高度, -1 表示没有
+}
+
+type explorerHistoryRow struct {
+	JournalEntry
+	TxIDHex   string
+	BlockHex  string
+	Confirmed bool
+}
+
+type explorerMempool struct {
+	explorerLayout
+	Transactions []explorerMempoolRow
+}
+
+type explorerMempoolRow struct {
+	TxID     string
+	Inputs   int
+	Outputs  int
+	TotalOut int
+}
+
+type explorerPeers struct {
+	explorerLayout
+	Peers  []Peer
+	Banned []BanEntry
+}
+
+type explorerError struct {
+	explorerLayout
+	Status  int
+	Message string
+}
+
+// StartExplorer listens on the configured explorer address and serves pages in the background
+func StartExplorer(bc *Blockchain) error {
+	if len(Config.ExplorerListen) == 0 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", Config.ExplorerListen)
+	if err != nil {
+		return fmt.Errorf("explorer listen %s failed, %w", Config.ExplorerListen, err)
+	}
+
+	httpServer := &http.Server{Handler: &Explorer{bc: bc}, ReadTimeout: RESTREADTIMEOUT}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil {
+			fmt.Printf("explorer stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("explorer listening on http://%s/\n", Config.ExplorerListen)
+	return nil
+}
+
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		e.error(w, r, http.StatusMethodNotAllowed, "the explorer is read-only")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/":
+		e.home(w, r)
+	case len(parts) == 3 && parts[0] == "block" && parts[1] == "height":
+		e.blockAtHeight(w, r, parts[2])
+	case len(parts) == 2 && parts[0] == "block":
+		e.block(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "tx":
+		e.transaction(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "address":
+		e.address(w, r, parts[1])
+	case len(parts) == 1 && parts[0] == "mempool":
+		e.mempool(w, r)
+	case len(parts) == 1 && parts[0] == "peers":
+		e.peers(w, r)
+	case len(parts) == 1 && parts[0] == "search":
+		e.search(w, r)
+	default:
+		e.error(w, r, http.StatusNotFound, fmt.Sprintf("page %s is not found", r.URL.Path))
+	}
+}
+
+// layout fills in the header shared by every page
+func (e *Explorer) layout(title string) explorerLayout {
+	height, err := e.bc.GetLatestHeight()
+	if err != nil {
+		height = -1
+	}
+	return explorerLayout{Title: title, Network: Params.Name, Height: height}
+}
+
+// home lists the latest blocks, or the blocks below ?from=<height>
+func (e *Explorer) home(w http.ResponseWriter, r *http.Request) {
+	page := explorerHome{explorerLayout: e.layout("Latest blocks"), TipHash: hex.EncodeToString(e.bc.GetTopHash()), Mempool: TxPool.Count(), Peers: len(Peers.Snapshot())}
+
+	from, err := fromHeight(r, page.Height)
+	if err != nil {
+		e.error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	iterator := e.bc.Iterator()
+	for {
+		block := iterator.Next()
+		if block.Height <= from {
+			page.Blocks = append(page.Blocks, explorerBlockRow{Height: block.Height, Hash: hex.EncodeToString(block.Hash), Time: block.Time, TxCount: len(block.Transactions)})
+		}
+		if len(page.Blocks) == EXPLORERPAGESIZE || len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	page.Newer, page.Older = pageLinks(from, page.Height, EXPLORERPAGESIZE)
+	e.render(w, r, "home", page)
+}
+
+// fromHeight reads the ?from=<height> query of a paged view, the tip when it is missing or above the tip
+func fromHeight(r *http.Request, tip int64) (int64, error) {
+	value := r.URL.Query().Get("from")
+	if len(value) == 0 {
+		return tip, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid height %q", value)
+	}
+	if n < tip {
+		return n, nil
+	}
+	return tip, nil
+}
+
+// pageLinks returns the start heights of the newer and the older page, -1 when there is none
+func pageLinks(from, tip, size int64) (int64, int64) {
+	newer, older := int64(-1), int64(-1)
+	if from < tip {
+		newer = from + size
+		if newer > tip {
+			newer = tip
+		}
+	}
+	if from-size >= 0 {
+		older = from - size
+	}
+	return newer, older
+}
+
+func (e *Explorer) block(w http.ResponseWriter, r *http.Request, hash string) {
+	blockHash, err := hex.DecodeString(hash)
+	if err != nil || len(blockHash) == 0 {
+		e.error(w, r, http.StatusBadRequest, fmt.Sprintf("invalid block hash %q", hash))
+		return
+	}
+	block, err := e.bc.GetBlock(blockHash)
+	if err != nil {
+		e.error(w, r, http.StatusNotFound, fmt.Sprintf("block %s is not found", hash))
+		return
+	}
+	e.renderBlock(w, r, &block)
+}
+
+func (e *Explorer) blockAtHeight(w http.ResponseWriter, r *http.Request, height string) {
+	n, err := strconv.ParseInt(height, 10, 64)
+	if err != nil || n < 0 {
+		e.error(w, r, http.StatusBadRequest, fmt.Sprintf("invalid height %q", height))
+		return
+	}
+	block, err := e.bc.GetBlockByHeight(n)
+	if err != nil {
+		e.error(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	e.renderBlock(w, r, block)
+}
+
+func (e *Explorer) renderBlock(w http.ResponseWriter, r *http.Request, block *Block) {
+	page := explorerBlock{explorerLayout: e.layout(fmt.Sprintf("Block %d", block.Height))}
+	page.Block = NewBlockJSON(block, page.Height)
+
+	// 分叉上的区块没有下一个区块; 主链上的下一个区块的父区块是当前区块
+	if next, err := e.bc.GetBlockByHeight(block.Height + 1); err == nil && bytes.Equal(next.PrevBlockHash, block.Hash) {
+		page.NextBlock = hex.EncodeToString(next.Hash)
+	}
+	e.render(w, r, "block", page)
+}
+
+// transaction shows a transaction of the mempool or of the main chain, each input links to the output it spends
+func (e *Explorer) transaction(w http.ResponseWriter, r *http.Request, txid string) {
+	txID, err := hex.DecodeString(txid)
+	if err != nil || len(txID) == 0 {
+		e.error(w, r, http.StatusBadRequest, fmt.Sprintf("invalid txid %q", txid))
+		return
+	}
+
+	page := explorerTx{explorerLayout: e.layout("Transaction")}
+	tx, inMempool := TxPool.Get(txID)
+	var block *Block
+	if !inMempool {
+		tx, block, err = e.bc.FindTxBlock(txID)
+		if err != nil {
+			e.error(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+	}
+	page.Tx = NewTransactionJSON(tx, block, page.Height)
+	page.InMempool = inMempool
+
+	totalIn := 0
+	page.FeeKnown = !tx.IsCoinbase()
+	for i, input := range page.Tx.Vin {
+		view := explorerInput{InputJSON: input}
+		if !tx.IsCoinbase() {
+			prevTx, err := e.bc.FindTxByID(tx.In[i].TXid)
+			if err == nil && input.Vout >= 0 && input.Vout < len(prevTx.Out) {
+				view.Value, view.Known = prevTx.Out[input.Vout].Value, true
+			}
+		}
+		totalIn += view.Value
+		page.FeeKnown = page.FeeKnown && view.Known
+		page.Inputs = append(page.Inputs, view)
+	}
+	for _, output := range tx.Out {
+		page.TotalOut += output.Value
+	}
+	page.Fee = totalIn - page.TotalOut
+	e.render(w, r, "tx", page)
+}
+
+// address shows the balance of an address and the transactions that paid it or spent from it, ?from=<height> pages the history
+//
+// 余额从UTXO集合中读取; 交易记录每页只查找 EXPLORERHISTORYBLOCKS 个区块, 不用每次都从创世区块开始遍历.
+// 和钱包的交易记录使用相同的方法(describeTransaction)计算每笔交易对地址余额的影响
+func (e *Explorer) address(w http.ResponseWriter, r *http.Request, address string) {
+	pubkeyHash, err := DecodeAddress(address)
+	if err != nil {
+		e.error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page := explorerAddress{explorerLayout: e.layout("Address"), Address: address}
+	utxoSet := UTXOSet{e.bc}
+	coins, err := utxoSet.FindCoins(pubkeyHash)
+	if err != nil {
+		e.error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, coin := range coins {
+		page.Balance += coin.Value
+		page.UTXOs++
+	}
+
+	from, err := fromHeight(r, page.Height)
+	if err != nil {
+		e.error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// describeTransaction 需要从旧到新处理交易, 才能知道花费的输出的金额; 这一页之前的输出在区块链中查找
+	blocks := []*Block{}
+	iterator := e.bc.Iterator()
+	for {
+		block := iterator.Next()
+		if block.Height <= from {
+			blocks = append(blocks, block)
+		}
+		if len(blocks) == EXPLORERHISTORYBLOCKS || len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	page.From, page.To = from, from
+	if len(blocks) > 0 {
+		page.To = blocks[len(blocks)-1].Height
+	}
+	page.Newer, page.Older = pageLinks(from, page.Height, EXPLORERHISTORYBLOCKS)
+
+	owned := map[string]bool{string(pubkeyHash): false}
+	prevouts := make(map[string]int)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		seen := make(map[string]bool) // 区块中可能有重复的交易(默克尔树补齐)
+		for _, tx := range blocks[i].Transactions {
+			if seen[string(tx.ID)] {
+				continue
+			}
+			seen[string(tx.ID)] = true
+			if entry := describeTransaction(tx, owned, prevouts, e.bc); entry != nil {
+				entry.BlockHash, entry.Height, entry.Time = blocks[i].Hash, blocks[i].Height, blocks[i].Time
+				page.History = append(page.History, newExplorerHistoryRow(entry))
+			}
+		}
+	}
+	// 未确认的交易只在第一页显示
+	if from == page.Height {
+		for _, tx := range TxPool.Transactions() {
+			if entry := describeTransaction(tx, owned, prevouts, e.bc); entry != nil {
+				entry.Height = UNCONFIRMEDHEIGHT
+				page.Unconfirmed = append(page.Unconfirmed, newExplorerHistoryRow(entry))
+			}
+		}
+	}
+
+	// 最新的交易在前面
+	for i, j := 0, len(page.History)-1; i < j; i, j = i+1, j-1 {
+		page.History[i], page.History[j] = page.History[j], page.History[i]
+	}
+	e.render(w, r, "address", page)
+}
+
+func newExplorerHistoryRow(entry *JournalEntry) explorerHistoryRow {
+	return explorerHistoryRow{
+		JournalEntry: *entry,
+		TxIDHex:      hex.EncodeToString(entry.TxID),
+		BlockHex:     hex.EncodeToString(entry.BlockHash),
+		Confirmed:    entry.Height != UNCONFIRMEDHEIGHT,
+	}
+}
+
+func (e *Explorer) mempool(w http.ResponseWriter, r *http.Request) {
+	page := explorerMempool{explorerLayout: e.layout("Mempool")}
+	for _, tx := range TxPool.Transactions() {
+		row := explorerMempoolRow{TxID: hex.EncodeToString(tx.ID), Inputs: len(tx.In), Outputs: len(tx.Out)}
+		for _, output := range tx.Out {
+			row.TotalOut += output.Value
+		}
+		page.Transactions = append(page.Transactions, row)
+	}
+	e.render(w, r, "mempool", page)
+}
+
+func (e *Explorer) peers(w http.ResponseWriter, r *http.Request) {
+	page := explorerPeers{explorerLayout: e.layout("Peers"), Peers: Peers.Snapshot()}
+	banned, err := e.bc.ListBanned()
+	if err != nil {
+		e.error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page.Banned = banned
+	e.render(w, r, "peers", page)
+}
+
+// search redirects to the page of a height, a block hash, a txid or an address
+func (e *Explorer) search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	target := ""
+	if height, err := strconv.ParseInt(query, 10, 64); err == nil && height >= 0 {
+		target = "/block/height/" + query
+	} else if _, err := DecodeAddress(query); err == nil {
+		target = "/address/" + query
+	} else if hash, err := hex.DecodeString(query); err == nil && len(hash) > 0 {
+		target = "/tx/" + query
+		if _, err := e.bc.GetBlock(hash); err == nil {
+			target = "/block/" + query
+		}
+	}
+
+	if len(target) == 0 {
+		e.error(w, r, http.StatusNotFound, fmt.Sprintf("nothing matches %q", query))
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (e *Explorer) error(w http.ResponseWriter, r *http.Request, status int, message string) {
+	page := explorerError{explorerLayout: e.layout("Error"), Status: status, Message: message}
+	e.renderStatus(w, r, status, "error", page)
+}
+
+func (e *Explorer) render(w http.ResponseWriter, r *http.Request, name string, page interface{}) {
+	e.renderStatus(w, r, http.StatusOK, name, page)
+}
+
+// renderStatus executes a template into a buffer first, so a template error still yields a clean 500
+func (e *Explorer) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, page interface{}) {
+	var body bytes.Buffer
+	if err := explorerTemplates.ExecuteTemplate(&body, name, page); err != nil {
+		status = http.StatusInternalServerError
+		body.Reset()
+		body.WriteString(template.HTMLEscapeString(err.Error()))
+	}
+
+	w.Header().Set("Content-Type", EXPLORERCONTENTTYPE)
+	w.Header().Set("Content-Security-Policy", EXPLORERCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", RESTCACHENONE)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body.Bytes())
+}
+
+// explorerFuncs are the helpers available to the templates
+var explorerFuncs = template.FuncMap{
+	"time": func(unix int64) string {
+		return time.Unix(unix, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+	},
+	"short": func(hash string) string {
+		if len(hash) <= 16 {
+			return hash
+		}
+		return hash[:8] + "…" + hash[len(hash)-8:]
+	},
+	"total": func(outputs []OutputJSON) int {
+		total := 0
+		for _, output := range outputs {
+			total += output.Value
+		}
+		return total
+	},
+	"rtt": func(d time.Duration) string {
+		return d.Round(time.Microsecond).String()
+	},
+}
+
+var explorerTemplates = template.Must(template.New("explorer").Funcs(explorerFuncs).Parse(EXPLORERTEMPLATES))
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFromHeight(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 50, false},
+		{"?from=10", 10, false},
+		{"?from=80", 50, false},
+		{"?from=-1", 0, true},
+		{"?from=abc", 0, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+		got, err := fromHeight(r, 50)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("fromHeight(%q) = %d, %v, want %d, error %v", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPageLinks(t *testing.T) {
+	tests := []struct {
+		from, tip, size int64
+		newer, older    int64
+	}{
+		{from: 250, tip: 250, size: 100, newer: -1, older: 150},
+		{from: 150, tip: 250, size: 100, newer: 250, older: 50},
+		{from: 50, tip: 250, size: 100, newer: 150, older: -1},
+		{from: 200, tip: 250, size: 100, newer: 250, older: 100},
+	}
+	for _, tt := range tests {
+		newer, older := pageLinks(tt.from, tt.tip, tt.size)
+		if newer != tt.newer || older != tt.older {
+			t.Errorf("pageLinks(%d, %d, %d) = %d, %d, want %d, %d", tt.from, tt.tip, tt.size, newer, older, tt.newer, tt.older)
+		}
+	}
+}
+
+func TestExplorerAddress(t *testing.T) {
+	bc := newTestBlockchain(t)
+	explorer := &Explorer{bc: bc}
+
+	w := httptest.NewRecorder()
+	explorer.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/address/"+Params.GenesisAddress, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"<dt>Balance</dt><dd>100</dd>", "blocks 0 to 0", "generate"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("address page does not contain %q", want)
+		}
+	}
+}
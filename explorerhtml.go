@@ -0,0 +1,166 @@
+package main
+
+// EXPLORERTEMPLATES are the pages of the block explorer
+//
+// 所有页面共用 header 和 footer, 样式直接写在页面中, 不引用任何外部资源
+const EXPLORERTEMPLATES = `
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - {{.Network}} explorer</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #222; background: #f6f7f9; }
+header { background: #1f2937; color: #fff; padding: 0.6em 1.2em; display: flex; flex-wrap: wrap; align-items: center; gap: 1.2em; }
+header a { color: #e5e7eb; text-decoration: none; }
+header a:hover { color: #fff; }
+header .brand { font-weight: bold; color: #fff; }
+header form { margin-left: auto; }
+header input { width: 22em; padding: 0.3em; border: 0; border-radius: 3px; }
+main { max-width: 72em; margin: 1.2em auto; padding: 0 1.2em; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 1.6em; }
+table { width: 100%; border-collapse: collapse; background: #fff; margin-bottom: 1em; }
+th, td { text-align: left; padding: 0.4em 0.6em; border-bottom: 1px solid #e5e7eb; vertical-align: top; }
+th { background: #f3f4f6; font-weight: 600; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.3em 1.2em; background: #fff; padding: 0.8em; }
+dt { font-weight: 600; }
+dd { margin: 0; }
+.mono { font-family: ui-monospace, Menlo, Consolas, monospace; word-break: break-all; }
+.num { text-align: right; }
+.muted { color: #6b7280; }
+.in { color: #047857; }
+.out { color: #b91c1c; }
+.pager { display: flex; justify-content: space-between; }
+a { color: #1d4ed8; }
+:target { background: #fef3c7; }
+</style>
+</head>
+<body>
+<header>
+<a class="brand" href="/">{{.Network}} explorer</a>
+<a href="/">Blocks</a>
+<a href="/mempool">Mempool</a>
+<a href="/peers">Peers</a>
+<span class="muted">height {{.Height}}</span>
+<form action="/search" method="get"><input name="q" placeholder="height, block hash, txid or address"></form>
+</header>
+<main>
+{{end}}
+
+{{define "footer"}}</main>
+</body>
+</html>
+{{end}}
+
+{{define "home"}}{{template "header" .}}
+<h1>Latest blocks</h1>
+<dl>
+<dt>Network</dt><dd>{{.Network}}</dd>
+<dt>Height</dt><dd>{{.Height}}</dd>
+<dt>Best block</dt><dd class="mono"><a href="/block/{{.TipHash}}">{{.TipHash}}</a></dd>
+<dt>Mempool</dt><dd><a href="/mempool">{{.Mempool}} transactions</a></dd>
+<dt>Peers</dt><dd><a href="/peers">{{.Peers}} connected</a></dd>
+</dl>
+<table>
+<tr><th>Height</th><th>Hash</th><th>Time</th><th class="num">Transactions</th></tr>
+{{range .Blocks}}<tr><td><a href="/block/height/{{.Height}}">{{.Height}}</a></td><td class="mono"><a href="/block/{{.Hash}}">{{.Hash}}</a></td><td>{{time .Time}}</td><td class="num">{{.TxCount}}</td></tr>
+{{end}}</table>
+<div class="pager">
+<span>{{if ge .Newer 0}}<a href="/?from={{.Newer}}">&larr; newer</a>{{end}}</span>
+<span>{{if ge .Older 0}}<a href="/?from={{.Older}}">older &rarr;</a>{{end}}</span>
+</div>
+{{template "footer" .}}{{end}}
+
+{{define "block"}}{{template "header" .}}
+<h1>Block {{.Block.Height}}</h1>
+<dl>
+<dt>Hash</dt><dd class="mono">{{.Block.Hash}}</dd>
+<dt>Previous block</dt><dd class="mono">{{if .Block.PrevBlockHash}}<a href="/block/{{.Block.PrevBlockHash}}">{{.Block.PrevBlockHash}}</a>{{else}}<span class="muted">genesis</span>{{end}}</dd>
+<dt>Next block</dt><dd class="mono">{{if .NextBlock}}<a href="/block/{{.NextBlock}}">{{.NextBlock}}</a>{{else}}<span class="muted">none</span>{{end}}</dd>
+<dt>Confirmations</dt><dd>{{.Block.Confirmations}}</dd>
+<dt>Time</dt><dd>{{time .Block.Time}}</dd>
+<dt>Merkle root</dt><dd class="mono">{{.Block.MerkleRoot}}</dd>
+<dt>Version</dt><dd>{{.Block.Version}}</dd>
+<dt>Bits</dt><dd>{{.Block.Bits}}</dd>
+<dt>Nonce</dt><dd>{{.Block.Nonce}}</dd>
+</dl>
+<h2>Transactions ({{len .Block.Tx}})</h2>
+<table>
+<tr><th>Txid</th><th class="num">Inputs</th><th>Outputs</th><th class="num">Total out</th></tr>
+{{range .Block.Tx}}<tr><td class="mono"><a href="/tx/{{.TxID}}">{{.TxID}}</a>{{if .Coinbase}} <span class="muted">coinbase</span>{{end}}</td><td class="num">{{len .Vin}}</td><td>{{range .Vout}}<div><a class="mono" href="/address/{{.Address}}">{{.Address}}</a> {{.Value}}</div>{{end}}</td><td class="num">{{total .Vout}}</td></tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "tx"}}{{template "header" .}}
+<h1>Transaction</h1>
+<dl>
+<dt>Txid</dt><dd class="mono">{{.Tx.TxID}}</dd>
+<dt>Status</dt><dd>{{if .InMempool}}unconfirmed, in the <a href="/mempool">mempool</a>{{else}}{{.Tx.Confirmations}} confirmations{{end}}</dd>
+{{if .Tx.BlockHash}}<dt>Block</dt><dd class="mono"><a href="/block/{{.Tx.BlockHash}}">{{.Tx.BlockHash}}</a>{{with .Tx.Height}} (height {{.}}){{end}}</dd>{{end}}
+<dt>Total out</dt><dd>{{.TotalOut}}</dd>
+<dt>Fee</dt><dd>{{if .FeeKnown}}{{.Fee}}{{else}}<span class="muted">unknown</span>{{end}}</dd>
+</dl>
+<h2>Inputs ({{len .Inputs}})</h2>
+<table>
+<tr><th>#</th><th>Spends</th><th>Address</th><th class="num">Value</th></tr>
+{{if .Tx.Coinbase}}<tr><td>0</td><td class="muted">coinbase, newly mined coins</td><td></td><td></td></tr>
+{{else}}{{range $i, $in := .Inputs}}<tr><td>{{$i}}</td><td class="mono"><a href="/tx/{{$in.TxID}}#out-{{$in.Vout}}">{{short $in.TxID}}:{{$in.Vout}}</a></td><td class="mono">{{if $in.Address}}<a href="/address/{{$in.Address}}">{{$in.Address}}</a>{{else}}<span class="muted">not signed</span>{{end}}</td><td class="num">{{if $in.Known}}{{$in.Value}}{{else}}<span class="muted">unknown</span>{{end}}</td></tr>
+{{end}}{{end}}</table>
+<h2>Outputs ({{len .Tx.Vout}})</h2>
+<table>
+<tr><th>#</th><th>Address</th><th class="num">Value</th></tr>
+{{range .Tx.Vout}}<tr id="out-{{.N}}"><td>{{.N}}</td><td class="mono"><a href="/address/{{.Address}}">{{.Address}}</a></td><td class="num">{{.Value}}</td></tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "history"}}<table>
+<tr><th>Time</th><th>Txid</th><th>Block</th><th>Direction</th><th class="num">Amount</th><th>Counterparties</th></tr>
+{{range .}}<tr><td>{{if .Confirmed}}{{time .Time}}{{else}}<span class="muted">pending</span>{{end}}</td><td class="mono"><a href="/tx/{{.TxIDHex}}">{{short .TxIDHex}}</a></td><td>{{if .Confirmed}}<a href="/block/{{.BlockHex}}">{{.Height}}</a>{{else}}<span class="muted">unconfirmed</span>{{end}}</td><td class="{{if eq .Direction "send"}}out{{else}}in{{end}}">{{.Direction}}</td><td class="num">{{.Amount}}</td><td class="mono">{{range .Counterparties}}<div><a href="/address/{{.}}">{{.}}</a></div>{{end}}</td></tr>
+{{end}}</table>{{end}}
+
+{{define "address"}}{{template "header" .}}
+<h1>Address</h1>
+<dl>
+<dt>Address</dt><dd class="mono">{{.Address}}</dd>
+<dt>Balance</dt><dd>{{.Balance}}</dd>
+<dt>Unspent outputs</dt><dd>{{.UTXOs}}</dd>
+</dl>
+{{if .Unconfirmed}}<h2>Unconfirmed ({{len .Unconfirmed}})</h2>
+{{template "history" .Unconfirmed}}{{end}}
+<h2>History <span class="muted">(blocks {{.To}} to {{.From}})</span></h2>
+{{if .History}}{{template "history" .History}}{{else}}<p class="muted">No transactions in these blocks.</p>{{end}}
+<div class="pager">
+<span>{{if ge .Newer 0}}<a href="/address/{{.Address}}?from={{.Newer}}">&larr; newer</a>{{end}}</span>
+<span>{{if ge .Older 0}}<a href="/address/{{.Address}}?from={{.Older}}">older &rarr;</a>{{end}}</span>
+</div>
+{{template "footer" .}}{{end}}
+
+{{define "mempool"}}{{template "header" .}}
+<h1>Mempool ({{len .Transactions}})</h1>
+{{if .Transactions}}<table>
+<tr><th>Txid</th><th class="num">Inputs</th><th class="num">Outputs</th><th class="num">Total out</th></tr>
+{{range .Transactions}}<tr><td class="mono"><a href="/tx/{{.TxID}}">{{.TxID}}</a></td><td class="num">{{.Inputs}}</td><td class="num">{{.Outputs}}</td><td class="num">{{.TotalOut}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">The mempool is empty.</p>{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "peers"}}{{template "header" .}}
+<h1>Peers ({{len .Peers}})</h1>
+{{if .Peers}}<table>
+<tr><th>Address</th><th>Last seen</th><th class="num">Pings</th><th class="num">Last RTT</th><th class="num">Min RTT</th><th class="num">Avg RTT</th><th class="num">Misbehavior</th></tr>
+{{range .Peers}}<tr><td class="mono">{{.Addr}}</td><td>{{if .LastSeen.IsZero}}<span class="muted">never</span>{{else}}{{time .LastSeen.Unix}}{{end}}</td><td class="num">{{.PingCount}}</td><td class="num">{{rtt .LastRTT}}</td><td class="num">{{rtt .MinRTT}}</td><td class="num">{{rtt .AvgRTT}}</td><td class="num">{{.Misbehavior}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No peers.</p>{{end}}
+{{if .Banned}}<h2>Banned ({{len .Banned}})</h2>
+<table>
+<tr><th>Address</th><th>Banned at</th><th>Until</th><th>Reason</th></tr>
+{{range .Banned}}<tr><td class="mono">{{.Addr}}</td><td>{{time .BannedAt}}</td><td>{{time .BannedUntil}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "error"}}{{template "header" .}}
+<h1>{{.Status}}</h1>
+<p>{{.Message}}</p>
+<p><a href="/">Back to the latest blocks</a></p>
+{{template "footer" .}}{{end}}
+`
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	MNEMONICWORDS = 12 // 默认的助记词数量, 对应128位熵
+
+	HDHARDENED uint32 = 0x80000000 // 强化派生的索引从 2^31 开始
+	HDPURPOSE  uint32 = 44         // BIP44
+	HDCOINTYPE uint32 = 0          // 币种
+	HDACCOUNT  uint32 = 0          // 账户
+
+	HDRECEIVE uint32 = 0 // 收款地址所在的分支
+	HDCHANGE  uint32 = 1 // 找零地址所在的分支
+
+	HDGAPLIMIT = 20 // 恢复钱包的时候, 连续这么多个地址没有使用过就停止扫描
+)
+
+var (
+	//go:embed bip39_english.txt
+	bip39English string
+
+	// BIP39 英文单词表, 每个单词对应11位
+	bip39Words = strings.Fields(bip39English)
+	bip39Index = func() map[string]int {
+		index := make(map[string]int, len(bip39Words))
+		for i, word := range bip39Words {
+			index[word] = i
+		}
+		return index
+	}()
+
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+	ErrHDSeedExists    = errors.New("wallet already has an HD seed")
+	ErrNoHDSeed        = errors.New("wallet has no HD seed, create or restore one first")
+)
+
+// NewMnemonic generates a random BIP39 mnemonic with the given number of words
+//
+// 生成随机的助记词: 熵 || SHA256(熵)的前 熵长度/32 位, 每11位对应一个单词
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, got %d", words)
+	}
+
+	entropy := make([]byte, words*4/3)
+	if _, err := io.ReadFull(rand.Reader, entropy); err != nil {
+		return "", fmt.Errorf("generate mnemonic entropy failed, %w", err)
+	}
+
+	return mnemonicFromEntropy(entropy), nil
+}
+
+// mnemonicFromEntropy encodes entropy and its checksum as words
+func mnemonicFromEntropy(entropy []byte) string {
+	checksum := sha256.Sum256(entropy)
+	checksumBits := uint(len(entropy) * 8 / 32)
+
+	// 把熵和校验和拼成一个大整数, 再每11位取一个单词
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(checksum[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + int(checksumBits)) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = bip39Words[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// ValidateMnemonic checks the words and the checksum of a mnemonic
+//
+// 校验助记词: 每个单词必须在单词表中, 并且校验和正确
+func ValidateMnemonic(mnemonic string) error {
+	words := strings.Fields(normalizeMnemonic(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return fmt.Errorf("%w: unexpected word count %d", ErrInvalidMnemonic, len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			return fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) * 11 / 33)
+	checksum := new(big.Int).And(data, big.NewInt(int64(1)<<checksumBits-1)).Int64()
+	data.Rsh(data, checksumBits)
+
+	entropy := make([]byte, len(words)*4/3)
+	data.FillBytes(entropy)
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidMnemonic)
+	}
+
+	return nil
+}
+
+// normalizeMnemonic joins the words with single spaces
+//
+// 英文单词表只包含ASCII字符, 不需要做 Unicode NFKD 规范化
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// MnemonicToSeed derives the 64-byte BIP39 seed
+//
+// 助记词通过 PBKDF2-HMAC-SHA512 迭代2048次得到种子, 盐是 "mnemonic" + 可选的口令
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+	salt := "mnemonic" + passphrase
+	return pbkdf2.Key([]byte(normalizeMnemonic(mnemonic)), []byte(salt), 2048, 64, sha512.New)
+}
+
+// ExtendedKey is a BIP32 private key with its chain code
+//
+// 扩展私钥: 私钥 + 链码, 可以派生出子私钥
+type ExtendedKey struct {
+	Key       []byte // 32字节私钥
+	ChainCode []byte // 32字节链码
+	Depth     uint8
+	Index     uint32
+}
+
+// NewMasterKey derives the master key from a seed
+//
+// 根据种子生成主私钥: HMAC-SHA512(key="Bitcoin seed", seed), 左边32字节是私钥, 右边32字节是链码
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	if !validPrivateScalar(sum[:32]) {
+		return nil, errors.New("seed produces an invalid master key")
+	}
+	return &ExtendedKey{Key: sum[:32], ChainCode: sum[32:]}, nil
+}
+
+// Child derives the child key at index, indexes from HDHARDENED on are hardened
+//
+// 派生子私钥: 强化派生使用 0x00 || 父私钥, 普通派生使用父公钥的压缩格式
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	var data []byte
+	if index >= HDHARDENED {
+		data = append([]byte{0x00}, k.Key...)
+	} else {
+		data = compressedPublicKey(k.Key)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	// 子私钥 = (IL + 父私钥) mod n, IL 超出范围或者结果为0的时候这个索引无效
+	n := secp256k1.S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+	childKey := il.Add(il, new(big.Int).SetBytes(k.Key))
+	childKey.Mod(childKey, n)
+	if childKey.Sign() == 0 {
+		return nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+
+	key := make([]byte, 32)
+	childKey.FillBytes(key)
+	return &ExtendedKey{Key: key, ChainCode: sum[32:], Depth: k.Depth + 1, Index: index}, nil
+}
+
+// DerivePath derives a key along a path such as m/44'/0'/0'/0/1
+func (k *ExtendedKey) DerivePath(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("derivation path %q must start with m", path)
+	}
+
+	key := k
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		part = strings.TrimRight(part, "'h")
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HDHARDENED {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		if hardened {
+			index += uint64(HDHARDENED)
+		}
+
+		key, err = key.Child(uint32(index))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// ECDSA returns the key as an ecdsa private key on secp256k1
+func (k *ExtendedKey) ECDSA() (ecdsa.PrivateKey, error) {
+	return privateKeyFromBytes(k.Key)
+}
+
+// HDKeyPath returns the BIP44 path of a receive or change address
+//
+// BIP44 路径: m / purpose' / coin_type' / account' / change / address_index
+func HDKeyPath(branch, index uint32) string {
+	return fmt.Sprintf("m/%d'/%d'/%d'/%d/%d", HDPURPOSE, HDCOINTYPE, HDACCOUNT, branch, index)
+}
+
+// validPrivateScalar checks 0 < k < n
+func validPrivateScalar(key []byte) bool {
+	k := new(big.Int).SetBytes(key)
+	return k.Sign() > 0 && k.Cmp(secp256k1.S256().Params().N) < 0
+}
+
+// compressedPublicKey returns the 33-byte compressed public key of a private key
+func compressedPublicKey(key []byte) []byte {
+	x, y := secp256k1.S256().ScalarBaseMult(key)
+
+	compressed := make([]byte, 33)
+	compressed[0] = 0x02 + byte(y.Bit(0))
+	x.FillBytes(compressed[1:])
+	return compressed
+}
+
+// walletFromPrivateKey builds a wallet entry from a private key
+//
+// 公钥格式和 GenerateKeyPair 一致: X || Y
+func walletFromPrivateKey(private ecdsa.PrivateKey) *Wallet {
+	publickey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(publickey[:32])
+	private.PublicKey.Y.FillBytes(publickey[32:])
+	return &Wallet{PrivateKey: private, PublicKey: publickey}
+}
+
+// hdChain is the HD seed of a wallet and the next unused index of each branch
+//
+// 钱包的HD种子, 加密钱包只保存种子和助记词的密文, 解锁之后才有明文
+type hdChain struct {
+	Seed              []byte // 明文种子, 加密钱包锁定时为nil
+	Mnemonic          string // 明文助记词, 加密钱包锁定时为空
+	EncryptedSeed     []byte
+	EncryptedMnemonic []byte
+	NextReceive       uint32 // 下一个收款地址的索引
+	NextChange        uint32 // 下一个找零地址的索引
+}
+
+// seal encrypts the seed and the mnemonic with the wallet key
+func (hd *hdChain) seal(key []byte) error {
+	var err error
+	hd.EncryptedSeed, err = sealWithKey(key, hd.Seed)
+	if err != nil {
+		return err
+	}
+	hd.EncryptedMnemonic, err = sealWithKey(key, []byte(hd.Mnemonic))
+	return err
+}
+
+// open decrypts the seed and the mnemonic with the wallet key
+func (hd *hdChain) open(key []byte) error {
+	seed, err := openWithKey(key, hd.EncryptedSeed)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := openWithKey(key, hd.EncryptedMnemonic)
+	if err != nil {
+		return err
+	}
+	hd.Seed, hd.Mnemonic = seed, string(mnemonic)
+	return nil
+}
+
+// wipe forgets the plaintext seed
+func (hd *hdChain) wipe() {
+	for i := range hd.Seed {
+		hd.Seed[i] = 0
+	}
+	hd.Seed = nil
+	hd.Mnemonic = ""
+}
+
+// HasHDSeed reports whether the wallet derives its keys from a seed
+func (ws *Wallets) HasHDSeed() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.hd != nil
+}
+
+// NewHDSeed creates a new mnemonic and uses it as the seed of the wallet
+//
+// 生成新的助记词作为钱包的HD种子, 返回助记词, 用户需要抄写下来作为备份
+func (ws *Wallets) NewHDSeed(words int) (string, error) {
+	mnemonic, err := NewMnemonic(words)
+	if err != nil {
+		return "", err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err := ws.setHDSeedLocked(mnemonic); err != nil {
+		return "", err
+	}
+	return mnemonic, nil
+}
+
+// setHDSeedLocked installs the seed of a mnemonic; caller must hold ws.mu
+func (ws *Wallets) setHDSeedLocked(mnemonic string) error {
+	if ws.hd != nil {
+		return ErrHDSeedExists
+	}
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return err
+	}
+	if ws.crypter != nil && ws.unlockKey == nil {
+		return ErrWalletLocked
+	}
+
+	hd := &hdChain{Seed: MnemonicToSeed(mnemonic, ""), Mnemonic: normalizeMnemonic(mnemonic)}
+	if ws.crypter != nil {
+		if err := hd.seal(ws.unlockKey); err != nil {
+			return fmt.Errorf("encrypt hd seed failed, %w", err)
+		}
+	}
+	ws.hd = hd
+	return nil
+}
+
+// Mnemonic returns the mnemonic of the HD seed
+func (ws *Wallets) Mnemonic() (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.hd == nil {
+		return "", ErrNoHDSeed
+	}
+	if ws.crypter != nil && ws.unlockKey == nil {
+		return "", ErrWalletLocked
+	}
+	return ws.hd.Mnemonic, nil
+}
+
+// NewAddress returns a fresh receive or change address in the given format, base58 when empty
+//
+// 获取一个新的地址: 有HD种子的钱包按照BIP44路径派生下一个地址, 否则随机生成一个私钥
+func (ws *Wallets) NewAddress(change bool, format string) (string, error) {
+	// 先检查格式, 避免HD钱包跳过一个派生索引
+	if err := CheckAddressFormat(format); err != nil {
+		return "", err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.hd == nil {
+		wallet := CreateWallet()
+		wallet.change = change
+		return ws.addKeyLocked(wallet, format)
+	}
+
+	branch, next := HDRECEIVE, &ws.hd.NextReceive
+	if change {
+		branch, next = HDCHANGE, &ws.hd.NextChange
+	}
+
+	for {
+		wallet, err := ws.deriveHDKeyLocked(branch, *next)
+		*next++
+		if err != nil {
+			// 极小概率出现无效的子私钥, 按照BIP32跳过这个索引
+			if errors.Is(err, ErrWalletLocked) {
+				*next--
+				return "", err
+			}
+			continue
+		}
+		wallet.change = change
+		return ws.addKeyLocked(wallet, format)
+	}
+}
+
+// deriveHDKeyLocked derives the key at branch/index; caller must hold ws.mu
+func (ws *Wallets) deriveHDKeyLocked(branch, index uint32) (*Wallet, error) {
+	if ws.hd.Seed == nil {
+		return nil, ErrWalletLocked
+	}
+
+	master, err := NewMasterKey(ws.hd.Seed)
+	if err != nil {
+		return nil, err
+	}
+	path := HDKeyPath(branch, index)
+	child, err := master.DerivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	private, err := child.ECDSA()
+	if err != nil {
+		return nil, err
+	}
+
+	wallet := walletFromPrivateKey(private)
+	wallet.path = path
+	return wallet, nil
+}
+
+// addKeyLocked stores a key, encrypting it for an encrypted wallet; caller must hold ws.mu
+func (ws *Wallets) addKeyLocked(wallet *Wallet, format string) (string, error) {
+	address, err := EncodeAddress(PublickeyHash(wallet.PublicKey), format)
+	if err != nil {
+		return "", err
+	}
+
+	if ws.crypter != nil {
+		if ws.unlockKey == nil {
+			return "", ErrWalletLocked
+		}
+		encryptedKey, err := sealWithKey(ws.unlockKey, privateKeyBytes(&wallet.PrivateKey))
+		if err != nil {
+			return "", fmt.Errorf("encrypt new key failed, %w", err)
+		}
+		wallet.encryptedKey = encryptedKey
+	}
+
+	ws.Wallets[address] = wallet
+	return address, nil
+}
+
+// RestoreHDWallet installs the seed of a mnemonic and scans the chain for the addresses already used
+//
+// 根据助记词恢复钱包: 依次派生每个分支的地址, 直到连续 gapLimit 个地址在链上都没有出现过
+func (ws *Wallets) RestoreHDWallet(mnemonic string, bc *Blockchain, gapLimit int) (int, error) {
+	if gapLimit <= 0 {
+		return 0, fmt.Errorf("invalid gap limit %d", gapLimit)
+	}
+	used := bc.FindUsedPubkeyHashes()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err := ws.setHDSeedLocked(mnemonic); err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for _, branch := range []uint32{HDRECEIVE, HDCHANGE} {
+		derived := make(map[uint32]*Wallet)
+		next, gap := uint32(0), 0
+		for index := uint32(0); gap < gapLimit; index++ {
+			wallet, err := ws.deriveHDKeyLocked(branch, index)
+			if errors.Is(err, ErrWalletLocked) {
+				return found, err
+			}
+			// BIP32 中极少数的索引派生不出有效的密钥, 跳过这个索引, 同样算作一个没有使用的地址
+			if err != nil {
+				gap++
+				continue
+			}
+			wallet.change = branch == HDCHANGE
+			derived[index] = wallet
+			if used[string(PublickeyHash(wallet.PublicKey))] {
+				next, gap = index+1, 0
+				found++
+			} else {
+				gap++
+			}
+		}
+
+		// 保存最后一个使用过的地址之前的所有地址, 中间没有使用的地址也属于这个钱包
+		for index, wallet := range derived {
+			if index >= next {
+				continue
+			}
+			if _, err := ws.addKeyLocked(wallet, DEFAULTADDRESSFORMAT); err != nil {
+				return found, err
+			}
+		}
+		if branch == HDRECEIVE {
+			ws.hd.NextReceive = next
+		} else {
+			ws.hd.NextChange = next
+		}
+	}
+
+	// 恢复的地址在之前的区块中有交易, 交易记录需要重新扫描
+	if found > 0 {
+		ws.journal.SyncedHash = nil
+	}
+	return found, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMnemonic(t *testing.T) {
+	// BIP39 的测试向量(trezor/python-mnemonic), 口令都是 "TREZOR"
+	tests := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{
+			entropy:  "00000000000000000000000000000000",
+			mnemonic: "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			seed:     "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			entropy:  "7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			mnemonic: "legal winner thank year wave sausage worth useful legal winner thank yellow",
+			seed:     "2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			entropy:  "80808080808080808080808080808080",
+			mnemonic: "letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			seed:     "d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+		},
+		{
+			entropy:  "ffffffffffffffffffffffffffffffff",
+			mnemonic: "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			seed:     "ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+		},
+		{
+			entropy:  "0000000000000000000000000000000000000000000000000000000000000000",
+			mnemonic: strings.Repeat("abandon ", 23) + "art",
+			seed:     "bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+		},
+	}
+	for _, test := range tests {
+		entropy, _ := hex.DecodeString(test.entropy)
+		if mnemonic := mnemonicFromEntropy(entropy); mnemonic != test.mnemonic {
+			t.Errorf("mnemonicFromEntropy(%s) = %s, want %s", test.entropy, mnemonic, test.mnemonic)
+		}
+		if err := ValidateMnemonic(test.mnemonic); err != nil {
+			t.Errorf("ValidateMnemonic(%s): %v", test.mnemonic, err)
+		}
+		if seed := hex.EncodeToString(MnemonicToSeed(test.mnemonic, "TREZOR")); seed != test.seed {
+			t.Errorf("MnemonicToSeed(%s) = %s, want %s", test.mnemonic, seed, test.seed)
+		}
+	}
+
+	// 大小写和多余的空格不影响种子
+	messy := "  Legal winner THANK year wave sausage worth useful legal winner thank   yellow "
+	if seed := hex.EncodeToString(MnemonicToSeed(messy, "TREZOR")); seed != tests[1].seed {
+		t.Errorf("MnemonicToSeed(%q) = %s, want %s", messy, seed, tests[1].seed)
+	}
+
+	invalid := []string{
+		strings.Repeat("abandon ", 12),           // 校验和错误
+		strings.Repeat("abandon ", 10) + "about", // 11个单词
+		strings.Repeat("abandon ", 11) + "bitcoin",
+		strings.Repeat("abandon ", 11) + "abut", // 不在单词表中
+	}
+	for _, mnemonic := range invalid {
+		if err := ValidateMnemonic(mnemonic); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("ValidateMnemonic(%q) error %v, want %v", mnemonic, err, ErrInvalidMnemonic)
+		}
+	}
+}
+
+func TestExtendedKey(t *testing.T) {
+	// BIP32 的测试向量1
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path      string
+		chainCode string
+		key       string
+	}{
+		{"m", "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508", "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+		{"m/0'", "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141", "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{"m/0'/1", "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19", "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+		{"m/0h/1/2h", "04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f", "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"},
+		{"m/0'/1/2'/2", "cfb71883f01676f587d023cc53a35bc7f88f724b1f8c2892ac1275ac822a3edd", "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4"},
+		{"m/0'/1/2'/2/1000000000", "c783e67b921d2beb8f6b389cc646d7263b4145701dadd2161548a8b078e65e9e", "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8"},
+	}
+	for _, test := range tests {
+		key, err := master.DerivePath(test.path)
+		if err != nil {
+			t.Errorf("DerivePath(%s): %v", test.path, err)
+			continue
+		}
+		if hex.EncodeToString(key.ChainCode) != test.chainCode || hex.EncodeToString(key.Key) != test.key {
+			t.Errorf("DerivePath(%s) = %x %x, want %s %s", test.path, key.ChainCode, key.Key, test.chainCode, test.key)
+		}
+	}
+
+	for _, path := range []string{"", "0'/1", "m/x", "m/2147483648"} {
+		if _, err := master.DerivePath(path); err == nil {
+			t.Errorf("DerivePath(%q) succeeded, want an error", path)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTxIDMismatch       = errors.New("txid does not match the transaction")
+	ErrBlockHashMismatch  = errors.New("block hash does not match the header")
+	ErrMerkleRootMismatch = errors.New("merkle root does not match the transactions")
+)
+
+// BlockJSON is the canonical JSON form of a block
+//
+// 区块的标准JSON格式: 哈希使用十六进制, 交易展开成 TransactionJSON;
+// confirmations 依赖当前的最新区块, 不属于区块本身, 为0时省略
+type BlockJSON struct {
+	Hash          string            `json:"hash"`
+	Height        int64             `json:"height"`
+	Confirmations int64             `json:"confirmations,omitempty"`
+	Version       int               `json:"version"`
+	PrevBlockHash string            `json:"previousblockhash"`
+	MerkleRoot    string            `json:"merkleroot"`
+	Time          int64             `json:"time"`
+	Bits          int64             `json:"bits"`
+	Nonce         int64             `json:"nonce"`
+	Tx            []TransactionJSON `json:"tx"`
+}
+
+// TransactionJSON is the canonical JSON form of a transaction
+//
+// 交易所在的区块也不属于交易本身, 未确认的交易没有 blockhash, height 和 confirmations
+type TransactionJSON struct {
+	TxID          string       `json:"txid"`
+	Coinbase      bool         `json:"coinbase"`
+	Vin           []InputJSON  `json:"vin"`
+	Vout          []OutputJSON `json:"vout"`
+	BlockHash     string       `json:"blockhash,omitempty"`
+	Height        *int64       `json:"height,omitempty"` // 创世区块的高度是0, 所以用指针区分
+	Confirmations int64        `json:"confirmations,omitempty"`
+	Hex           string       `json:"hex,omitempty"` // gettransaction 附带的序列化数据
+}
+
+// InputJSON is the canonical JSON form of a transaction input
+type InputJSON struct {
+	TxID      string `json:"txid"`
+	Vout      int    `json:"vout"`
+	PubKey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+	Address   string `json:"address,omitempty"` // 根据公钥计算出来的付款地址, coinbase 没有
+}
+
+// OutputJSON is the canonical JSON form of a transaction output
+type OutputJSON struct {
+	N          int    `json:"n"`
+	Value      int    `json:"value"`
+	PubkeyHash string `json:"pubkeyhash"`
+	Address    string `json:"address"`
+}
+
+// NewBlockJSON describes a block; confirmations are left out when tipHeight is below the block
+func NewBlockJSON(block *Block, tipHeight int64) BlockJSON {
+	result := BlockJSON{
+		Hash:          hex.EncodeToString(block.Hash),
+		Height:        block.Height,
+		Version:       block.Version,
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Time:          block.Time,
+		Bits:          block.Bits,
+		Nonce:         block.Nonce,
+		Tx:            []TransactionJSON{},
+	}
+	if tipHeight >= block.Height {
+		result.Confirmations = tipHeight - block.Height + 1
+	}
+	for _, tx := range block.Transactions {
+		result.Tx = append(result.Tx, NewTransactionJSON(tx, block, tipHeight))
+	}
+	return result
+}
+
+// NewTransactionJSON describes a transaction; block is nil for a transaction outside the chain
+func NewTransactionJSON(tx *Transaction, block *Block, tipHeight int64) TransactionJSON {
+	result := TransactionJSON{
+		TxID:     hex.EncodeToString(tx.ID),
+		Coinbase: tx.IsCoinbase(),
+		Vin:      []InputJSON{},
+		Vout:     []OutputJSON{},
+	}
+	if block != nil {
+		height := block.Height
+		result.BlockHash = hex.EncodeToString(block.Hash)
+		result.Height = &height
+		if tipHeight >= block.Height {
+			result.Confirmations = tipHeight - block.Height + 1
+		}
+	}
+	for _, input := range tx.In {
+		result.Vin = append(result.Vin, newInputJSON(input))
+	}
+	for i, output := range tx.Out {
+		result.Vout = append(result.Vout, newOutputJSON(i, output))
+	}
+	return result
+}
+
+func newInputJSON(input TXinput) InputJSON {
+	view := InputJSON{
+		TxID:      hex.EncodeToString(input.TXid),
+		Vout:      input.Voutindex,
+		PubKey:    hex.EncodeToString(input.Pubkey),
+		Signature: hex.EncodeToString(input.Signature),
+	}
+	if len(input.Pubkey) > 0 {
+		view.Address = PubkeyHashToAddress(PublickeyHash(input.Pubkey), Params.AddressVersion)
+	}
+	return view
+}
+
+func newOutputJSON(n int, output TXoutput) OutputJSON {
+	return OutputJSON{
+		N:          n,
+		Value:      output.Value,
+		PubkeyHash: hex.EncodeToString(output.PublickeyHash),
+		Address:    PubkeyHashToAddress(output.PublickeyHash, Params.AddressVersion),
+	}
+}
+
+// Transaction decodes the transaction and checks that the txid matches its content
+//
+// 地址, 区块和确认数都是推导出来的字段, 解码时忽略
+func (j TransactionJSON) Transaction() (*Transaction, error) {
+	id, err := decodeHexField("txid", j.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Transaction{ID: id, In: []TXinput{}, Out: []TXoutput{}}
+	for i, view := range j.Vin {
+		input, err := view.input()
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		tx.In = append(tx.In, input)
+	}
+	for i, view := range j.Vout {
+		if view.N != i {
+			return nil, fmt.Errorf("output %d: out of order, n is %d", i, view.N)
+		}
+		output, err := view.output()
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		tx.Out = append(tx.Out, output)
+	}
+
+	if !bytes.Equal(tx.ID, txIDOf(tx)) {
+		return nil, fmt.Errorf("%w %s", ErrTxIDMismatch, j.TxID)
+	}
+	return tx, nil
+}
+
+func (j InputJSON) input() (TXinput, error) {
+	txid, err := decodeHexField("txid", j.TxID)
+	if err != nil {
+		return TXinput{}, err
+	}
+	pubkey, err := decodeHexField("pubkey", j.PubKey)
+	if err != nil {
+		return TXinput{}, err
+	}
+	signature, err := decodeHexField("signature", j.Signature)
+	if err != nil {
+		return TXinput{}, err
+	}
+	return TXinput{TXid: txid, Voutindex: j.Vout, Signature: signature, Pubkey: pubkey}, nil
+}
+
+func (j OutputJSON) output() (TXoutput, error) {
+	pubkeyHash, err := decodeHexField("pubkeyhash", j.PubkeyHash)
+	if err != nil {
+		return TXoutput{}, err
+	}
+	if len(pubkeyHash) != PUBKEYHASHLENGTH {
+		return TXoutput{}, fmt.Errorf("%w: public key hash is %d bytes, want %d", ErrInvalidLength, len(pubkeyHash), PUBKEYHASHLENGTH)
+	}
+	if j.Value < 0 {
+		return TXoutput{}, fmt.Errorf("negative value %d", j.Value)
+	}
+	return TXoutput{Value: j.Value, PublickeyHash: pubkeyHash}, nil
+}
+
+// Block decodes the block and checks its hash against the header, its merkle root and every txid
+//
+// txid 不包含签名, 签名被替换时只有默克尔根对不上
+func (j BlockJSON) Block() (*Block, error) {
+	hash, err := decodeHexField("hash", j.Hash)
+	if err != nil {
+		return nil, err
+	}
+	prevBlockHash, err := decodeHexField("previousblockhash", j.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	merkleRoot, err := decodeHexField("merkleroot", j.MerkleRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &Block{
+		Version:       j.Version,
+		PrevBlockHash: prevBlockHash,
+		MerkleRoot:    merkleRoot,
+		Hash:          hash,
+		Time:          j.Time,
+		Bits:          j.Bits,
+		Nonce:         j.Nonce,
+		Transactions:  []*Transaction{},
+		Height:        j.Height,
+	}
+	for i, view := range j.Tx {
+		tx, err := view.Transaction()
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	if !bytes.Equal(block.Hash, NewPOW(block).HeaderHash()) {
+		return nil, fmt.Errorf("%w %s", ErrBlockHashMismatch, j.Hash)
+	}
+	if !bytes.Equal(block.CreateMerkleRoot(), block.MerkleRoot) {
+		return nil, fmt.Errorf("%w of block %s", ErrMerkleRootMismatch, j.Hash)
+	}
+	return block, nil
+}
+
+// txIDOf computes the txid, which is the hash of the transaction before it was signed
+//
+// 交易ID在签名之前计算, 所以计算时去掉ID和签名
+func txIDOf(tx *Transaction) []byte {
+	unsigned := Transaction{In: make([]TXinput, len(tx.In)), Out: tx.Out}
+	for i, input := range tx.In {
+		unsigned.In[i] = TXinput{TXid: input.TXid, Voutindex: input.Voutindex, Pubkey: input.Pubkey}
+	}
+	return unsigned.Hash()
+}
+
+func decodeHexField(name, value string) ([]byte, error) {
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q, %w", name, value, err)
+	}
+	return decoded, nil
+}
+
+// ------------------------- encoding/json -------------------------
+
+// MarshalJSON encodes the block in its canonical form, without confirmations
+func (b Block) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewBlockJSON(&b, -1))
+}
+
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var view BlockJSON
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+	block, err := view.Block()
+	if err != nil {
+		return err
+	}
+	*b = *block
+	return nil
+}
+
+// MarshalJSON encodes the transaction in its canonical form, without its block
+func (tx Transaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewTransactionJSON(&tx, nil, -1))
+}
+
+func (tx *Transaction) UnmarshalJSON(data []byte) error {
+	var view TransactionJSON
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+	decoded, err := view.Transaction()
+	if err != nil {
+		return err
+	}
+	*tx = *decoded
+	return nil
+}
+
+func (input TXinput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newInputJSON(input))
+}
+
+func (input *TXinput) UnmarshalJSON(data []byte) error {
+	var view InputJSON
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+	decoded, err := view.input()
+	if err != nil {
+		return err
+	}
+	*input = decoded
+	return nil
+}
+
+// MarshalJSON encodes an output on its own, n is 0 because only the transaction knows its position
+func (output TXoutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newOutputJSON(0, output))
+}
+
+func (output *TXoutput) UnmarshalJSON(data []byte) error {
+	var view OutputJSON
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+	decoded, err := view.output()
+	if err != nil {
+		return err
+	}
+	*output = decoded
+	return nil
+}
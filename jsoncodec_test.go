@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestSignedTx returns a transaction with a public key and a signature, its ID is the hash before signing
+func newTestSignedTx() *Transaction {
+	tx := &Transaction{
+		In: []TXinput{{TXid: bytes.Repeat([]byte{0xab}, 32), Voutindex: 1, Pubkey: bytes.Repeat([]byte{4}, 64)}},
+		Out: []TXoutput{
+			{Value: 70, PublickeyHash: bytes.Repeat([]byte{1}, PUBKEYHASHLENGTH)},
+			{Value: 0, PublickeyHash: bytes.Repeat([]byte{2}, PUBKEYHASHLENGTH)},
+		},
+	}
+	tx.ID = txIDOf(tx)
+	tx.In[0].Signature = bytes.Repeat([]byte{5}, 64)
+	return tx
+}
+
+func TestTransactionJSON(t *testing.T) {
+	tx := newTestSignedTx()
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, tx) {
+		t.Errorf("round trip = %v, want %v", &decoded, tx)
+	}
+	// 编码的结果是确定的, 再次编码得到同样的JSON
+	again, err := json.Marshal(&decoded)
+	if err != nil || !bytes.Equal(again, data) {
+		t.Errorf("second encoding differs:\n%s\n%s", again, data)
+	}
+
+	// 签名不参与交易ID, 修改签名仍然可以解码; wantErr 为nil的失败用例只检查有没有错误
+	tests := []struct {
+		name    string
+		edit    func(view *TransactionJSON)
+		valid   bool
+		wantErr error
+	}{
+		{name: "signature", edit: func(view *TransactionJSON) { view.Vin[0].Signature = "00" }, valid: true},
+		{name: "value", edit: func(view *TransactionJSON) { view.Vout[0].Value = 700 }, wantErr: ErrTxIDMismatch},
+		{name: "txid", edit: func(view *TransactionJSON) { view.TxID = strings.Repeat("00", 32) }, wantErr: ErrTxIDMismatch},
+		{name: "public key hash length", edit: func(view *TransactionJSON) { view.Vout[1].PubkeyHash = "0202" }, wantErr: ErrInvalidLength},
+		{name: "out of order", edit: func(view *TransactionJSON) { view.Vout[0].N, view.Vout[1].N = 1, 0 }},
+		{name: "negative value", edit: func(view *TransactionJSON) { view.Vout[1].Value = -1 }},
+		{name: "invalid hex", edit: func(view *TransactionJSON) { view.Vin[0].PubKey = "xyz" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view := NewTransactionJSON(tx, nil, -1)
+			test.edit(&view)
+			_, err := view.Transaction()
+			if (err == nil) != test.valid {
+				t.Fatalf("Transaction error %v, want valid %v", err, test.valid)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("Transaction error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlockJSON(t *testing.T) {
+	bc := newTestBlockchain(t)
+	genesis, err := bc.GetBlock(bc.GetTopHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Block
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Serialize(), genesis.Serialize()) {
+		t.Errorf("round trip = %+v, want %+v", decoded, genesis)
+	}
+
+	// 确认数和交易所在的区块是推导出来的字段, 不影响解码
+	view := NewBlockJSON(&genesis, 10)
+	if view.Confirmations != 11 || view.Tx[0].Confirmations != 11 || *view.Tx[0].Height != 0 {
+		t.Errorf("confirmations %d, transaction confirmations %d, want 11", view.Confirmations, view.Tx[0].Confirmations)
+	}
+	if _, err := view.Block(); err != nil {
+		t.Errorf("Block with confirmations: %v", err)
+	}
+
+	view.Nonce++
+	if _, err := view.Block(); !errors.Is(err, ErrBlockHashMismatch) {
+		t.Errorf("Block with another nonce error %v, want %v", err, ErrBlockHashMismatch)
+	}
+	view.Nonce--
+
+	// 签名不影响txid, 但是改变了默克尔根
+	view.Tx[0].Vin[0].Signature = "00"
+	if _, err := view.Block(); !errors.Is(err, ErrMerkleRootMismatch) {
+		t.Errorf("Block with another signature error %v, want %v", err, ErrMerkleRootMismatch)
+	}
+}
@@ -6,7 +6,6 @@ func main() {
 	// test()
 
 	cli := CLI{}
-	cli.Blockchain = CreateBlockchain()
 	cli.Run()
 
 }
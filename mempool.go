@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	MINETHRESHOLD = 1 // 矿工节点的交易池中至少有多少笔交易才开始挖矿
+)
+
+var (
+	MinerAddress = ""           // 矿工地址, 为空表示当前节点不挖矿
+	TxPool       = NewMempool() // 当前节点的交易池
+)
+
+// Mempool holds verified transactions waiting to be mined
+//
+// 交易池, 存放已经验证过但是还没有被打包进区块的交易
+type Mempool struct {
+	mu  sync.Mutex
+	txs map[string]*Transaction // map[hex交易ID]*Transaction
+}
+
+// NewMempool creates an empty mempool
+func NewMempool() *Mempool {
+	return &Mempool{txs: make(map[string]*Transaction)}
+}
+
+// Add puts a transaction into the pool, returning false if it was already there
+//
+// 把交易放入交易池, 如果交易已经存在则返回false
+func (mp *Mempool) Add(tx *Transaction) bool {
+	mp.mu.Lock()
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := mp.txs[id]; ok {
+		mp.mu.Unlock()
+		return false
+	}
+	mp.txs[id] = tx
+	mp.mu.Unlock()
+
+	Events.Publish(Event{Type: EVENTMEMPOOL, Tx: tx})
+	return true
+}
+
+// Get returns the transaction with the given ID
+func (mp *Mempool) Get(txID []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	tx, ok := mp.txs[hex.EncodeToString(txID)]
+	return tx, ok
+}
+
+// Has reports whether the pool contains the transaction
+func (mp *Mempool) Has(txID []byte) bool {
+	_, ok := mp.Get(txID)
+	return ok
+}
+
+// Count returns the number of transactions in the pool
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.txs)
+}
+
+// Bytes returns the serialized size of all transactions in the pool
+//
+// 交易池中所有交易序列化之后的总字节数
+func (mp *Mempool) Bytes() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	size := 0
+	for _, tx := range mp.txs {
+		size += len(tx.Serialize())
+	}
+	return size
+}
+
+// Transactions returns every transaction in the pool ordered by ID
+//
+// 按照交易ID排序, 保证每次返回的顺序是一样的
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	ids := make([]string, 0, len(mp.txs))
+	for id := range mp.txs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	txs := make([]*Transaction, 0, len(ids))
+	for _, id := range ids {
+		txs = append(txs, mp.txs[id])
+	}
+	return txs
+}
+
+// Remove drops a transaction from the pool
+func (mp *Mempool) Remove(txID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	delete(mp.txs, hex.EncodeToString(txID))
+}
+
+// Conflict returns the ID of a pool transaction that spends an output also spent by tx, or nil
+//
+// 交易池中的交易之间不能双花
+func (mp *Mempool) Conflict(tx *Transaction) []byte {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, poolTx := range mp.txs {
+		if bytes.Equal(poolTx.ID, tx.ID) {
+			continue
+		}
+		for _, poolInput := range poolTx.In {
+			for _, input := range tx.In {
+				if bytes.Equal(poolInput.TXid, input.TXid) && poolInput.Voutindex == input.Voutindex {
+					return poolTx.ID
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveBlockTxs drops every transaction included in a block
+//
+// 区块被添加到区块链之后，把区块中的交易从交易池中删除
+func (mp *Mempool) RemoveBlockTxs(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		delete(mp.txs, hex.EncodeToString(tx.ID))
+	}
+}
+
+// SelectForBlock picks valid, non-conflicting transactions from the pool
+//
+// 从交易池中选出可以打包进区块的交易: 交易必须有效, 引用的输出必须还在UTXO集合中, 并且不能和已经选出的交易花费同一个输出
+func (mp *Mempool) SelectForBlock(bc *Blockchain) []*Transaction {
+	selected := []*Transaction{}
+	spent := make(map[string]bool) // 已经被选出的交易花费的输出, key 是 交易ID:输出索引
+	utxoSet := UTXOSet{bc}
+
+TxLoop:
+	for _, tx := range mp.Transactions() {
+		if !bc.VerifyTransaction(tx) {
+			fmt.Printf("drop invalid transaction %x from mempool\n", tx.ID)
+			mp.Remove(tx.ID)
+			continue
+		}
+
+		// 交易进入交易池之后, 引用的输出可能已经被其他节点挖出的区块花费了
+		if err := utxoSet.CheckSpends([]*Transaction{tx}); err != nil {
+			fmt.Printf("drop transaction from mempool: %v\n", err)
+			mp.Remove(tx.ID)
+			continue
+		}
+
+		for _, input := range tx.In {
+			if spent[fmt.Sprintf("%x:%d", input.TXid, input.Voutindex)] {
+				continue TxLoop
+			}
+		}
+		for _, input := range tx.In {
+			spent[fmt.Sprintf("%x:%d", input.TXid, input.Voutindex)] = true
+		}
+
+		selected = append(selected, tx)
+	}
+
+	return selected
+}
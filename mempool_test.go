@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMempoolConflict(t *testing.T) {
+	mp := NewMempool()
+	pooled := &Transaction{ID: []byte("pooled"), In: []TXinput{{TXid: []byte("prev"), Voutindex: 0}}}
+	mp.Add(pooled)
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		want []byte
+	}{
+		{"same output", &Transaction{ID: []byte("other"), In: []TXinput{{TXid: []byte("prev"), Voutindex: 0}}}, pooled.ID},
+		{"other index", &Transaction{ID: []byte("other"), In: []TXinput{{TXid: []byte("prev"), Voutindex: 1}}}, nil},
+		{"itself", pooled, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mp.Conflict(tt.tx); !bytes.Equal(got, tt.want) {
+				t.Errorf("Conflict() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
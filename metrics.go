@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	METRICSNAMESPACE   = "blockchain"
+	METRICSCONTENTTYPE = "text/plain; version=0.0.4; charset=utf-8" // Prometheus 文本格式
+	METRICSUNKNOWN     = "unknown"                                  // 未知的P2P命令都记在这个标签下, 防止标签无限增长
+)
+
+// VALIDATIONBUCKETS are the upper bounds in seconds of the block validation histogram
+var VALIDATIONBUCKETS = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Counter is a value that only goes up
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Add increases the counter by n
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// CounterVec is a family of counters told apart by one label
+type CounterVec struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounterVec creates an empty counter family
+func NewCounterVec() *CounterVec {
+	return &CounterVec{values: make(map[string]uint64)}
+}
+
+// Add increases the counter of a label by n
+func (v *CounterVec) Add(label string, n uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.values[label] += n
+}
+
+// Snapshot returns a copy of every counter of the family
+func (v *CounterVec) Snapshot() map[string]uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	values := make(map[string]uint64, len(v.values))
+	for label, value := range v.values {
+		values[label] = value
+	}
+	return values
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits atomic.Uint64 // float64 的二进制表示, 用原子操作读写
+}
+
+// Set replaces the value of the gauge
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+// Add changes the value of the gauge by delta
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // 每个桶只记录落在 (上一个上界, 本上界] 的观测值, 输出时再累加
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i := sort.SearchFloat64s(h.bounds, value); i < len(h.bounds) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// ObserveSince records the seconds elapsed since start
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// NodeMetrics are the counters updated while the node runs
+//
+// 运行过程中累计的指标; 区块高度, 交易池和节点数量这些可以直接读到的值在抓取时计算, 不在这里保存
+type NodeMetrics struct {
+	MessagesReceived *CounterVec // 按命令统计收到的P2P消息
+	MessagesSent     *CounterVec // 按命令统计发送的P2P消息
+	BytesReceived    Counter
+	BytesSent        Counter
+
+	BlockValidation map[string]*Histogram // 区块校验耗时, 按区块来源区分: peer 或者 local
+
+	PowHashes   Counter // 挖矿计算过的哈希数
+	PowHashrate Gauge   // 最近一次挖矿的每秒哈希数
+
+	UTXOTransactions Gauge // UTXO集合中还有未花费输出的交易数
+	UTXOOutputs      Gauge // UTXO集合中未花费的输出数
+}
+
+// Metrics are the metrics of the running node
+var Metrics = NewNodeMetrics()
+
+// NewNodeMetrics creates zeroed metrics
+func NewNodeMetrics() *NodeMetrics {
+	return &NodeMetrics{
+		MessagesReceived: NewCounterVec(),
+		MessagesSent:     NewCounterVec(),
+		BlockValidation: map[string]*Histogram{
+			"peer":  NewHistogram(VALIDATIONBUCKETS),
+			"local": NewHistogram(VALIDATIONBUCKETS),
+		},
+	}
+}
+
+// messageLabel returns the label of a P2P command, commands we do not handle share one label
+func messageLabel(command string) string {
+	switch command {
+	case "version", "getblocks", "inv", "getdata", "block", "ping", "pong", "tx", "cmpctblock", "getblocktxn", "blocktxn":
+		return command
+	}
+	return METRICSUNKNOWN
+}
+
+// WritePrometheus writes every metric in the Prometheus text format
+//
+// 按照 Prometheus 文本格式输出, 每个指标前面是 HELP 和 TYPE 注释
+func (m *NodeMetrics) WritePrometheus(w io.Writer, bc *Blockchain) error {
+	out := &metricsWriter{w: w}
+
+	height, err := bc.GetLatestHeight()
+	if err != nil {
+		return err
+	}
+	out.family("height", "gauge", "Height of the best block")
+	out.sample("height", "", float64(height))
+
+	tip, err := bc.GetBlock(bc.GetTopHash())
+	if err != nil {
+		return err
+	}
+	out.family("tip_age_seconds", "gauge", "Seconds since the timestamp of the best block")
+	out.sample("tip_age_seconds", "", float64(time.Now().Unix()-tip.Time))
+
+	out.family("mempool_transactions", "gauge", "Transactions waiting in the mempool")
+	out.sample("mempool_transactions", "", float64(TxPool.Count()))
+	out.family("mempool_bytes", "gauge", "Serialized size of the transactions in the mempool")
+	out.sample("mempool_bytes", "", float64(TxPool.Bytes()))
+
+	banned, err := bc.ListBanned()
+	if err != nil {
+		return err
+	}
+	out.family("peers", "gauge", "Peers by state")
+	out.sample("peers", `state="tracked"`, float64(len(Peers.Snapshot())))
+	out.sample("peers", `state="known"`, float64(len(knownNodes())))
+	out.sample("peers", `state="banned"`, float64(len(banned)))
+
+	out.family("p2p_messages_received_total", "counter", "P2P messages received by command")
+	out.counterVec("p2p_messages_received_total", m.MessagesReceived)
+	out.family("p2p_messages_sent_total", "counter", "P2P messages sent by command")
+	out.counterVec("p2p_messages_sent_total", m.MessagesSent)
+	out.family("p2p_received_bytes_total", "counter", "Bytes of P2P messages received")
+	out.sample("p2p_received_bytes_total", "", float64(m.BytesReceived.Value()))
+	out.family("p2p_sent_bytes_total", "counter", "Bytes of P2P messages sent")
+	out.sample("p2p_sent_bytes_total", "", float64(m.BytesSent.Value()))
+
+	out.family("block_validation_seconds", "histogram", "Time spent validating a block before it is added to the chain")
+	for _, source := range []string{"local", "peer"} {
+		out.histogram("block_validation_seconds", fmt.Sprintf("source=%q", source), m.BlockValidation[source])
+	}
+
+	out.family("pow_hashes_total", "counter", "Hashes computed while mining")
+	out.sample("pow_hashes_total", "", float64(m.PowHashes.Value()))
+	out.family("pow_hashrate", "gauge", "Hashes per second of the last mined block")
+	out.sample("pow_hashrate", "", m.PowHashrate.Value())
+
+	out.family("utxo_transactions", "gauge", "Transactions with unspent outputs in the UTXO set")
+	out.sample("utxo_transactions", "", m.UTXOTransactions.Value())
+	out.family("utxo_outputs", "gauge", "Unspent outputs in the UTXO set")
+	out.sample("utxo_outputs", "", m.UTXOOutputs.Value())
+
+	var dbSize int64
+	err = bc.db.View(func(tx *bolt.Tx) error {
+		dbSize = tx.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	out.family("db_size_bytes", "gauge", "Size of the bolt database")
+	out.sample("db_size_bytes", "", float64(dbSize))
+
+	return out.err
+}
+
+// metricsWriter writes metric lines and keeps the first write error
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *metricsWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+func (mw *metricsWriter) family(name, kind, help string) {
+	mw.printf("# HELP %s_%s %s\n", METRICSNAMESPACE, name, help)
+	mw.printf("# TYPE %s_%s %s\n", METRICSNAMESPACE, name, kind)
+}
+
+func (mw *metricsWriter) sample(name, labels string, value float64) {
+	if len(labels) > 0 {
+		labels = "{" + labels + "}"
+	}
+	mw.printf("%s_%s%s %s\n", METRICSNAMESPACE, name, labels, formatMetricValue(value))
+}
+
+func (mw *metricsWriter) counterVec(name string, v *CounterVec) {
+	values := v.Snapshot()
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		mw.sample(name, fmt.Sprintf("command=%q", label), float64(values[label]))
+	}
+}
+
+func (mw *metricsWriter) histogram(name, labels string, h *Histogram) {
+	h.mu.Lock()
+	buckets := append([]uint64{}, h.buckets...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	// le 标签加在其他标签后面, 没有其他标签时不能以逗号开头
+	bucketLabels := labels
+	if len(bucketLabels) > 0 {
+		bucketLabels += ","
+	}
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += buckets[i]
+		mw.sample(name+"_bucket", bucketLabels+`le="`+formatMetricValue(bound)+`"`, float64(cumulative))
+	}
+	mw.sample(name+"_bucket", bucketLabels+`le="+Inf"`, float64(count))
+	mw.sample(name+"_sum", labels, sum)
+	mw.sample(name+"_count", labels, float64(count))
+}
+
+// formatMetricValue formats a sample value the way Prometheus parses it
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// StartMetricsServer listens on the configured metrics address and serves /metrics in the background
+//
+// 和REST接口一样不需要认证, 生产环境应该只监听内网地址
+func StartMetricsServer(bc *Blockchain) error {
+	if len(Config.MetricsListen) == 0 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", Config.MetricsListen)
+	if err != nil {
+		return fmt.Errorf("metrics listen %s failed, %w", Config.MetricsListen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 先写到缓冲区, 读取数据库失败时返回500而不是半截的指标
+		var body bytes.Buffer
+		if err := Metrics.WritePrometheus(&body, bc); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", METRICSCONTENTTYPE)
+		w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(body.Bytes())
+	})
+
+	httpServer := &http.Server{Handler: mux, ReadTimeout: RESTREADTIMEOUT}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil {
+			fmt.Printf("metrics server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("metrics listening on http://%s/metrics\n", Config.MetricsListen)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.5, 1, 5})
+	// 等于上界的值落在这个桶里, 超过所有上界的值只计入 +Inf
+	for _, value := range []float64{0.1, 0.5, 0.75, 1, 2, 10} {
+		h.Observe(value)
+	}
+
+	tests := []struct {
+		name   string
+		labels string
+		want   string
+	}{
+		{
+			name:   "labelled",
+			labels: `source="peer"`,
+			want: `blockchain_validation_bucket{source="peer",le="0.5"} 2
+blockchain_validation_bucket{source="peer",le="1"} 4
+blockchain_validation_bucket{source="peer",le="5"} 5
+blockchain_validation_bucket{source="peer",le="+Inf"} 6
+blockchain_validation_sum{source="peer"} 14.35
+blockchain_validation_count{source="peer"} 6
+`,
+		},
+		{
+			name: "no labels",
+			want: `blockchain_validation_bucket{le="0.5"} 2
+blockchain_validation_bucket{le="1"} 4
+blockchain_validation_bucket{le="5"} 5
+blockchain_validation_bucket{le="+Inf"} 6
+blockchain_validation_sum 14.35
+blockchain_validation_count 6
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			out := &metricsWriter{w: &buffer}
+			out.histogram("validation", tt.labels, h)
+			if out.err != nil || buffer.String() != tt.want {
+				t.Errorf("histogram %v\n%s\nwant\n%s", out.err, buffer.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMetricValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{0.005, "0.005"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatMetricValue(tt.value); got != tt.want {
+			t.Errorf("formatMetricValue(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMessageLabel(t *testing.T) {
+	for command, want := range map[string]string{"block": "block", "cmpctblock": "cmpctblock", "addr": METRICSUNKNOWN, "": METRICSUNKNOWN} {
+		if got := messageLabel(command); got != want {
+			t.Errorf("messageLabel(%q) = %s, want %s", command, got, want)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	bc := newTestBlockchain(t)
+	metrics := NewNodeMetrics()
+	metrics.MessagesReceived.Add(messageLabel("block"), 2)
+	metrics.MessagesReceived.Add(messageLabel("addr"), 1)
+	metrics.BytesSent.Add(300)
+	metrics.PowHashrate.Set(1.5)
+	metrics.BlockValidation["peer"].Observe(0.002)
+
+	var buffer bytes.Buffer
+	if err := metrics.WritePrometheus(&buffer, bc); err != nil {
+		t.Fatal(err)
+	}
+	exposition := buffer.String()
+
+	// 每个样本都属于前面用 HELP 和 TYPE 声明过的指标, 值可以被解析
+	types := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(exposition, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if fields := strings.Fields(line); strings.HasPrefix(line, "# TYPE ") && len(fields) == 4 {
+			if _, ok := types[fields[2]]; ok {
+				t.Errorf("metric %s declared twice", fields[2])
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.Contains(value, " ") {
+			t.Errorf("malformed sample %q", line)
+			continue
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			t.Errorf("sample %q has an invalid value, %v", line, err)
+		}
+		if i := strings.Index(name, "{"); i >= 0 {
+			if !strings.HasSuffix(name, "}") || strings.Contains(name, "{,") {
+				t.Errorf("sample %q has malformed labels", line)
+			}
+			name = name[:i]
+		}
+		family := name
+		if _, ok := types[family]; !ok {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				family = strings.TrimSuffix(family, suffix)
+			}
+			if types[family] != "histogram" {
+				t.Errorf("sample %q before its TYPE line", line)
+			}
+		}
+	}
+
+	for _, want := range []string{
+		"blockchain_height 0",
+		`blockchain_p2p_messages_received_total{command="block"} 2`,
+		`blockchain_p2p_messages_received_total{command="unknown"} 1`,
+		"blockchain_p2p_sent_bytes_total 300",
+		"blockchain_pow_hashrate 1.5",
+		`blockchain_block_validation_seconds_bucket{source="peer",le="0.005"} 1`,
+		`blockchain_block_validation_seconds_bucket{source="local",le="+Inf"} 0`,
+		`blockchain_block_validation_seconds_count{source="peer"} 1`,
+	} {
+		if !strings.Contains(exposition, want+"\n") {
+			t.Errorf("exposition is missing %q", want)
+		}
+	}
+	if types["blockchain_block_validation_seconds"] != "histogram" || types["blockchain_p2p_sent_bytes_total"] != "counter" {
+		t.Errorf("metric types %v", types)
+	}
+}
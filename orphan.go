@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+const (
+	MAXORPHANBLOCKS = 100 // 孤儿区块池最多保存的区块数
+)
+
+// Orphans are the blocks received before their parent
+var Orphans = NewOrphanPool()
+
+// orphanBlock is a block waiting for its parent together with the peer that sent it
+type orphanBlock struct {
+	block *Block
+	peer  string // 发送这个区块的连接对端地址, 区块最终校验失败时惩罚这个节点
+	from  string // 对方声明的地址, 向它请求父区块
+}
+
+// OrphanPool holds blocks whose parent is not in the chain yet
+//
+// 孤儿区块池: 父区块还没有收到的区块无法校验交易, 也不能添加到区块链中, 先保存在这里, 父区块连接之后再处理
+type OrphanPool struct {
+	mu     sync.Mutex
+	blocks map[string]orphanBlock // map[区块hash]orphanBlock
+}
+
+// NewOrphanPool creates an empty orphan pool
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{blocks: make(map[string]orphanBlock)}
+}
+
+// Add holds a block until its parent arrives, returning false if it is already held
+//
+// 池满的时候随便丢弃一个孤儿区块, 防止对方用大量孤儿区块耗尽内存; 丢弃的区块之后还可以重新下载
+func (op *OrphanPool) Add(block *Block, peer, from string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if _, ok := op.blocks[string(block.Hash)]; ok {
+		return false
+	}
+	if len(op.blocks) >= MAXORPHANBLOCKS {
+		for hash := range op.blocks {
+			delete(op.blocks, hash)
+			break
+		}
+	}
+	op.blocks[string(block.Hash)] = orphanBlock{block: block, peer: peer, from: from}
+	return true
+}
+
+// Has reports whether a block is held in the pool
+func (op *OrphanPool) Has(hash []byte) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	_, ok := op.blocks[string(hash)]
+	return ok
+}
+
+// TakeChildren removes and returns the orphans whose parent is hash
+func (op *OrphanPool) TakeChildren(hash []byte) []orphanBlock {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	children := []orphanBlock{}
+	for key, orphan := range op.blocks {
+		if bytes.Equal(orphan.block.PrevBlockHash, hash) {
+			children = append(children, orphan)
+			delete(op.blocks, key)
+		}
+	}
+	return children
+}
+
+// Count returns the number of orphans held
+func (op *OrphanPool) Count() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	return len(op.blocks)
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestOrphanPoolTakeChildren(t *testing.T) {
+	pool := NewOrphanPool()
+	child := &Block{Hash: []byte("child"), PrevBlockHash: []byte("parent")}
+	grandchild := &Block{Hash: []byte("grandchild"), PrevBlockHash: []byte("child")}
+
+	if !pool.Add(child, "10.0.0.1", "a") || !pool.Add(grandchild, "10.0.0.2", "b") {
+		t.Fatal("Add() = false for a new orphan")
+	}
+	if pool.Add(child, "10.0.0.3", "c") {
+		t.Error("Add() = true for an orphan already held")
+	}
+
+	children := pool.TakeChildren([]byte("parent"))
+	if len(children) != 1 || string(children[0].block.Hash) != "child" || children[0].peer != "10.0.0.1" || children[0].from != "a" {
+		t.Fatalf("TakeChildren(parent) = %v, want the child from a", children)
+	}
+	if pool.Has([]byte("child")) || !pool.Has([]byte("grandchild")) || pool.Count() != 1 {
+		t.Errorf("after TakeChildren the pool should only hold the grandchild, count %d", pool.Count())
+	}
+}
+
+func TestOrphanPoolLimit(t *testing.T) {
+	pool := NewOrphanPool()
+	for i := 0; i < MAXORPHANBLOCKS+10; i++ {
+		pool.Add(&Block{Hash: []byte{byte(i), byte(i >> 8)}, PrevBlockHash: []byte("parent")}, "10.0.0.1", "a")
+	}
+	if pool.Count() != MAXORPHANBLOCKS {
+		t.Errorf("Count() = %d, want %d", pool.Count(), MAXORPHANBLOCKS)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 每一种协议违规行为对应的惩罚分数，分数累计到 BanScore 之后对方节点会被封禁
+	MISBEHAVIOR_BADPOW      = 100 // 区块的工作量证明无效
+	MISBEHAVIOR_INVALIDTX   = 100 // 区块中包含无效的交易
+	MISBEHAVIOR_BADBLOCK    = 100 // 区块的默克尔根或者高度和区块内容不一致
+	MISBEHAVIOR_OVERSIZED   = 50  // 消息超过了 MAXMESSAGESIZE
+	MISBEHAVIOR_MALFORMED   = 50  // 消息无法被gob解码
+	MISBEHAVIOR_UNSOLICITED = 20  // 发送了我们没有请求过的区块
+
+	DEFAULTBANSCORE = 100            // 默认的封禁阈值
+	DEFAULTBANTIME  = 24 * time.Hour // 默认的封禁时长
+
+	PINGINTERVAL = 30 * time.Second // 每隔多久向其他节点发送一次ping
+	PINGTIMEOUT  = 60 * time.Second // 发出ping之后多久没有收到pong就认为对方节点已经失联
+	IDLETIMEOUT  = 90 * time.Second // 多久没有收到对方的任何消息就认为对方节点已经失联
+)
+
+var (
+	BanScore = DEFAULTBANSCORE // 节点的惩罚分数达到这个值之后就会被封禁
+	BanTime  = DEFAULTBANTIME  // 节点被封禁的时长
+
+	Peers = NewPeerSet() // 当前节点所知道的所有对端节点的状态
+)
+
+// Peer keeps the runtime state of a remote node
+//
+// 记录一个对端节点的运行时状态
+type Peer struct {
+	Addr        string    // 对端节点的地址, 可能是 host:port 也可能只有 host
+	Misbehavior int       // 这个节点所在 host 累计的惩罚分数, 只在 Snapshot 中填写
+	LastSeen    time.Time // 最后一次收到对方消息的时间
+
+	PingNonce uint64        // 还没有收到pong的ping的随机数, 0表示没有等待中的ping
+	PingSent  time.Time     // 发送这个ping的时间
+	PingCount int           // 收到的pong的数量
+	LastRTT   time.Duration // 最近一次的往返时间
+	MinRTT    time.Duration // 最小的往返时间
+	AvgRTT    time.Duration // 往返时间的指数移动平均
+}
+
+// PeerSet is a concurrency safe collection of peers
+//
+// 每一个连接都在单独的goroutine中处理，所以需要加锁保护
+type PeerSet struct {
+	mu        sync.Mutex
+	peers     map[string]*Peer    // map[address]*Peer
+	announced map[string][]string // map[区块hash]向我们通告过这个区块的节点
+	scores    map[string]int      // map[host]惩罚分数; 和连接状态分开保存, 节点失联被移除之后分数仍然保留
+}
+
+// MisbehaviorError reports a protocol violation by a peer
+//
+// 对方节点违反了协议, handleConnection 根据 Score 给对方节点加上惩罚分数
+type MisbehaviorError struct {
+	Peer   string // 违规节点的连接对端地址, 为空时由 handleConnection 填上
+	Score  int    // 惩罚分数
+	Reason string // 违规原因
+	Err    error  // 底层错误, 可以为nil
+}
+
+func (e *MisbehaviorError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+	}
+	return e.Reason
+}
+
+func (e *MisbehaviorError) Unwrap() error {
+	return e.Err
+}
+
+// misbehavior builds a MisbehaviorError
+func misbehavior(peer string, score int, reason string, err error) *MisbehaviorError {
+	return &MisbehaviorError{Peer: peer, Score: score, Reason: reason, Err: err}
+}
+
+// NewPeerSet creates an empty peer set
+func NewPeerSet() *PeerSet {
+	return &PeerSet{peers: make(map[string]*Peer), announced: make(map[string][]string), scores: make(map[string]int)}
+}
+
+// get returns the peer with the given address, creating it if necessary; caller must hold ps.mu
+func (ps *PeerSet) get(addr string) *Peer {
+	peer, ok := ps.peers[addr]
+	if !ok {
+		peer = &Peer{Addr: addr}
+		ps.peers[addr] = peer
+	}
+	return peer
+}
+
+// Seen records that we have just received a message from addr
+//
+// 更新对端节点最后一次活跃的时间
+func (ps *PeerSet) Seen(addr string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.get(addr).LastSeen = time.Now()
+}
+
+// AddMisbehavior adds score to addr and returns the new total
+//
+// 给对端节点增加惩罚分数，返回累计之后的分数; addr 是 checkPeer 返回的地址, 知道对方监听地址时是 host:port, 否则是 host
+func (ps *PeerSet) AddMisbehavior(addr string, score int) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.scores[addr] += score
+	return ps.scores[addr]
+}
+
+// ResetMisbehavior clears the score of addr
+//
+// 节点被封禁之后清空分数, 封禁到期之后重新开始计算
+func (ps *PeerSet) ResetMisbehavior(addr string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.scores, addr)
+}
+
+// StartPing records an outgoing ping to addr
+//
+// 记录发送给addr的ping; 如果上一个ping还没有收到回复，就不再发送新的ping, 返回false
+func (ps *PeerSet) StartPing(addr string, nonce uint64) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	peer := ps.get(addr)
+	if peer.PingNonce != 0 {
+		return false
+	}
+	peer.PingNonce = nonce
+	peer.PingSent = time.Now()
+	return true
+}
+
+// FinishPing matches a pong against the outstanding ping and updates the latency statistics
+//
+// 收到pong之后计算往返时间, nonce 不匹配的pong会被忽略
+func (ps *PeerSet) FinishPing(addr string, nonce uint64) (time.Duration, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	peer := ps.get(addr)
+	if peer.PingNonce == 0 || peer.PingNonce != nonce {
+		return 0, false
+	}
+
+	rtt := time.Since(peer.PingSent)
+	peer.PingNonce = 0
+	peer.PingCount++
+	peer.LastRTT = rtt
+	if peer.MinRTT == 0 || rtt < peer.MinRTT {
+		peer.MinRTT = rtt
+	}
+	// 和TCP计算平滑往返时间的方法一样: avg = 7/8 * avg + 1/8 * rtt
+	if peer.AvgRTT == 0 {
+		peer.AvgRTT = rtt
+	} else {
+		peer.AvgRTT = (peer.AvgRTT*7 + rtt) / 8
+	}
+	return rtt, true
+}
+
+// Unresponsive reports whether a peer missed its pong or has been idle for too long
+//
+// 判断对方节点是否已经失联: ping超时没有回复，或者太久没有发送任何消息
+func (ps *PeerSet) Unresponsive(addr string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	peer, ok := ps.peers[addr]
+	if !ok {
+		return false
+	}
+	if peer.PingNonce != 0 && time.Since(peer.PingSent) > PINGTIMEOUT {
+		return true
+	}
+	return !peer.LastSeen.IsZero() && time.Since(peer.LastSeen) > IDLETIMEOUT
+}
+
+// Fastest returns the candidate with the lowest average round-trip time
+//
+// 从候选节点中选出平均往返时间最小的节点，还没有测量过往返时间的节点排在最后
+func (ps *PeerSet) Fastest(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	sorted := append([]string{}, candidates...)
+	rtt := func(addr string) time.Duration {
+		peer, ok := ps.peers[addr]
+		if !ok || peer.AvgRTT == 0 {
+			return time.Duration(1<<63 - 1)
+		}
+		return peer.AvgRTT
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return rtt(sorted[i]) < rtt(sorted[j]) })
+
+	return sorted[0]
+}
+
+// MarkAnnounced remembers that addr has the given blocks
+//
+// 记录addr通过inv消息通告过的区块, 下载区块的时候可以从这些节点中选择
+func (ps *PeerSet) MarkAnnounced(addr string, hashes [][]byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, hash := range hashes {
+		key := string(hash)
+		known := false
+		for _, peer := range ps.announced[key] {
+			if peer == addr {
+				known = true
+				break
+			}
+		}
+		if !known {
+			ps.announced[key] = append(ps.announced[key], addr)
+		}
+	}
+}
+
+// Announcers returns the peers that announced a block
+func (ps *PeerSet) Announcers(hash []byte) []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return append([]string{}, ps.announced[string(hash)]...)
+}
+
+// ForgetAnnounced drops the announcers of a block once we have it
+func (ps *PeerSet) ForgetAnnounced(hash []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.announced, string(hash))
+}
+
+// DownloadPeer picks the peer to fetch a block from
+//
+// 下载调度: 在所有通告过这个区块的节点中选择往返时间最短的节点, 没有通告者的时候使用fallback
+func (ps *PeerSet) DownloadPeer(hash []byte, fallback string) string {
+	peer := ps.Fastest(ps.Announcers(hash))
+	if peer == "" {
+		return fallback
+	}
+	return peer
+}
+
+// Snapshot returns a copy of every peer sorted by address
+func (ps *PeerSet) Snapshot() []Peer {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	peers := []Peer{}
+	for _, peer := range ps.peers {
+		snapshot := *peer
+		snapshot.Misbehavior = ps.scores[peer.Addr]
+		peers = append(peers, snapshot)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Addr < peers[j].Addr })
+	return peers
+}
+
+// Remove forgets the liveness state of a peer, its misbehavior score is kept
+func (ps *PeerSet) Remove(addr string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.peers, addr)
+	for hash, peers := range ps.announced {
+		remaining := []string{}
+		for _, peer := range peers {
+			if peer != addr {
+				remaining = append(remaining, peer)
+			}
+		}
+		ps.announced[hash] = remaining
+	}
+}
+
+// Misbehaving punishes a peer for a protocol violation
+//
+// 给违规的节点增加惩罚分数, 如果分数超过了 BanScore, 那么就断开并且封禁这个节点
+func Misbehaving(bc *Blockchain, addr string, score int, reason string) {
+	total := Peers.AddMisbehavior(addr, score)
+	fmt.Printf("peer %s misbehaving (+%d => %d): %s\n", addr, score, total, reason)
+
+	if total < BanScore {
+		return
+	}
+
+	err := bc.BanPeer(addr, BanTime, reason)
+	if err != nil {
+		fmt.Printf("ban peer %s failed: %v\n", addr, err)
+		return
+	}
+	fmt.Printf("peer %s banned for %v\n", addr, BanTime)
+
+	// 断开连接：把被封禁的节点从种子节点列表中移除, 之后也不会再接受它的连接
+	Peers.ResetMisbehavior(addr)
+	Peers.Remove(addr)
+	removeKnownNode(addr)
+}
+
+// removeKnownNode removes addr and every node on the same host if addr has no port
+//
+// 把被封禁的节点从种子节点列表中删除, host 相同的写法(比如 localhost 和 127.0.0.1)也会删除
+func removeKnownNode(addr string) {
+	dropKnownNodes(func(node string) bool { return banMatches(addr, node) })
+}
+
+// banMatches reports whether a ban on banned covers addr
+//
+// 封禁项既可以是 host:port 也可以是 host, 只有 host 的封禁项会封禁这个 host 上的所有节点;
+// host:port 的封禁项只封禁这个端口上的节点, 同一台机器上的其他节点不受影响
+func banMatches(banned, addr string) bool {
+	if banned == addr {
+		return true
+	}
+
+	bannedHost, bannedPort := splitAddr(banned)
+	host, port := splitAddr(addr)
+	if len(bannedPort) > 0 && bannedPort != port {
+		return false
+	}
+	return sameHost(bannedHost, host)
+}
+
+// peerKey returns the address misbehavior of a message is recorded on
+//
+// 对方声明的地址和连接在同一个 host 上时使用声明的 host:port, 否则只能使用连接的 host
+func peerKey(host, addrFrom string) string {
+	fromHost, fromPort := splitAddr(addrFrom)
+	if len(fromPort) > 0 && sameHost(host, fromHost) {
+		return addrFrom
+	}
+	return host
+}
+
+// splitAddr splits addr into host and port, the port is empty if addr has none
+func splitAddr(addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, ""
+	}
+	return host, port
+}
+
+// sameHost reports whether two hosts name the same machine
+//
+// 主机名先解析成IP再比较, localhost 和 127.0.0.1 是同一个 host
+func sameHost(a, b string) bool {
+	if a == b {
+		return true
+	}
+	for _, ipA := range resolveHost(a) {
+		for _, ipB := range resolveHost(b) {
+			if ipA.Equal(ipB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveHost returns the IP addresses of host, nil if it cannot be resolved
+func resolveHost(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return nil
+	}
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// remoteHost returns the host part of the remote address of a connection
+//
+// 获取连接对方的IP地址，用于在还没有解析出消息内容的时候识别对方节点
+func remoteHost(conn net.Conn) string {
+	return addrHost(conn.RemoteAddr().String())
+}
+
+// addrHost returns the host part of addr, or addr itself if it has no port
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPeerSetRemoveKeepsMisbehavior(t *testing.T) {
+	ps := NewPeerSet()
+	ps.Seen("10.0.0.1:3000")
+	ps.AddMisbehavior("10.0.0.1:3000", 20)
+
+	// 失联的节点被移除之后, 同一个节点重新连接时分数仍然在
+	ps.Remove("10.0.0.1:3000")
+	if got := ps.AddMisbehavior("10.0.0.1:3000", 20); got != 40 {
+		t.Errorf("AddMisbehavior after Remove = %d, want 40", got)
+	}
+
+	// 同一台机器上的其他节点分数单独计算
+	ps.Seen("10.0.0.1:3000")
+	ps.Seen("10.0.0.1:3001")
+	peers := ps.Snapshot()
+	if len(peers) != 2 || peers[0].Misbehavior != 40 || peers[1].Misbehavior != 0 {
+		t.Errorf("Snapshot() = %+v, want misbehavior 40 only on 10.0.0.1:3000", peers)
+	}
+
+	ps.ResetMisbehavior("10.0.0.1:3000")
+	if got := ps.AddMisbehavior("10.0.0.1:3000", 1); got != 1 {
+		t.Errorf("AddMisbehavior after ResetMisbehavior = %d, want 1", got)
+	}
+}
+
+func TestBanMatches(t *testing.T) {
+	tests := []struct {
+		banned string
+		addr   string
+		want   bool
+	}{
+		{"10.0.0.1:3000", "10.0.0.1:3000", true},
+		{"10.0.0.1", "10.0.0.1:3000", true},
+		{"10.0.0.1", "10.0.0.1", true},
+		// 封禁一个端口不影响同一台机器上的其他节点
+		{"10.0.0.1:3000", "10.0.0.1:3001", false},
+		{"10.0.0.1:3000", "10.0.0.1", false},
+		{"10.0.0.2", "10.0.0.1:3000", false},
+		{"127.0.0.1:3000", "localhost:3000", true},
+		{"localhost", "127.0.0.1:3000", true},
+	}
+	for _, tt := range tests {
+		if got := banMatches(tt.banned, tt.addr); got != tt.want {
+			t.Errorf("banMatches(%q, %q) = %v, want %v", tt.banned, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestPeerKey(t *testing.T) {
+	tests := []struct {
+		host     string
+		addrFrom string
+		want     string
+	}{
+		{"127.0.0.1", "localhost:3001", "localhost:3001"},
+		{"10.0.0.1", "10.0.0.1:3000", "10.0.0.1:3000"},
+		// 声明的地址不在连接的 host 上, 不能相信
+		{"10.0.0.1", "10.0.0.2:3000", "10.0.0.1"},
+		{"10.0.0.1", "10.0.0.1", "10.0.0.1"},
+		{"10.0.0.1", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := peerKey(tt.host, tt.addrFrom); got != tt.want {
+			t.Errorf("peerKey(%q, %q) = %q, want %q", tt.host, tt.addrFrom, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveKnownNode(t *testing.T) {
+	saved := KnownNodes
+	t.Cleanup(func() { KnownNodes = saved })
+
+	tests := []struct {
+		addr string
+		want []string
+	}{
+		{"localhost:3001", []string{"localhost:3000", "localhost:3002", "10.0.0.1:3000"}},
+		{"127.0.0.1:3001", []string{"localhost:3000", "localhost:3002", "10.0.0.1:3000"}},
+		{"127.0.0.1", []string{"10.0.0.1:3000"}},
+		{"10.0.0.1:3001", []string{"localhost:3000", "localhost:3001", "localhost:3002", "10.0.0.1:3000"}},
+	}
+	for _, tt := range tests {
+		KnownNodes = []string{"localhost:3000", "localhost:3001", "localhost:3002", "10.0.0.1:3000"}
+		removeKnownNode(tt.addr)
+		if !reflect.DeepEqual(KnownNodes, tt.want) {
+			t.Errorf("removeKnownNode(%q) left %v, want %v", tt.addr, KnownNodes, tt.want)
+		}
+	}
+}
+
+func TestAddrHost(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.0.0.1:3000", "10.0.0.1"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"[::1]:3000", "::1"},
+		{"localhost:3000", "localhost"},
+	}
+	for _, tt := range tests {
+		if got := addrHost(tt.addr); got != tt.want {
+			t.Errorf("addrHost(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
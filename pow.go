@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"math/big"
+	"time"
 )
 
 const (
-	targetBits       = 16        // 挖矿难度值，这里是16，表示hash值的前16位必须是0
-	maxNonce   int64 = 1<<63 - 1 // 2^63 - 1
+	maxNonce int64 = 1<<63 - 1 // 2^63 - 1
 )
 
 type POW struct {
@@ -20,8 +20,8 @@ type POW struct {
 func NewPOW(b *Block) *POW {
 
 	target := big.NewInt(1)
-	// left shift 256 - targetBits, which
-	target.Lsh(target, uint(256-targetBits))
+	// left shift 256 - TargetBits, 挖矿难度由网络参数决定, 主网是16, 表示hash值的前16位必须是0
+	target.Lsh(target, uint(256-Params.TargetBits))
 
 	pow := &POW{b, target}
 	return pow
@@ -55,6 +55,7 @@ func (pow *POW) Run() (int64, []byte) {
 	var nonce int64
 	var currentHash big.Int
 	var firstHash, secondHash [32]byte
+	start := time.Now()
 
 	for nonce < maxNonce {
 		// serialize the block
@@ -76,18 +77,39 @@ func (pow *POW) Run() (int64, []byte) {
 		}
 	}
 
+	// 记录计算过的哈希数和挖矿速度
+	hashes := uint64(nonce) + 1
+	Metrics.PowHashes.Add(hashes)
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		Metrics.PowHashrate.Set(float64(hashes) / elapsed)
+	}
+
 	// no nonce found
 	return nonce, secondHash[:]
 }
 
 // Validate validates if the nonce is valid
+//
+// 同时检查区块中记录的hash是否就是根据区块头计算出来的hash
 func (pow *POW) Validate() bool {
 	var hashInt big.Int
 
+	hash := pow.HeaderHash()
+	hashInt.SetBytes(hash)
+
+	if !bytes.Equal(pow.block.Hash, hash) {
+		return false
+	}
+
+	return hashInt.Cmp(pow.Target) == -1
+}
+
+// HeaderHash computes the hash of the block header with the nonce of the block
+//
+// 根据区块头计算hash, 不检查是否满足难度目标
+func (pow *POW) HeaderHash() []byte {
 	data := pow.ConvertData2Bytes(pow.block.Nonce)
 	firstHash := sha256.Sum256(data)
 	secondHash := sha256.Sum256(firstHash[:])
-	hashInt.SetBytes(secondHash[:])
-
-	return hashInt.Cmp(pow.Target) == -1
+	return secondHash[:]
 }
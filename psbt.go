@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const PSBTVERSION = 2 // 部分签名交易文件的格式版本; 版本2的每个输入带着完整的上一笔交易
+
+var (
+	ErrPSBTMismatch     = errors.New("partially signed transactions are not the same transaction")
+	ErrPrevTxMismatch   = errors.New("previous transaction does not match the txid the input spends")
+	ErrPubkeyMismatch   = errors.New("public key does not match the spent output")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// PSBT is a partially signed transaction passed between the owners of its inputs
+//
+// 多方签名的交易容器: 每个输入带着它花费的完整的上一笔交易, 这正是 sign 和 Verify 通过 FindTxByID 查找的数据,
+// 所以签名方不需要区块链. 上一笔交易的哈希必须等于输入引用的交易ID, 协调方无法伪造输出金额来隐藏手续费.
+// 输入的公钥是交易ID的一部分, 签名之前必须知道所有的公钥:
+//  1. createpsbt 创建交易, 持有其他人只读公钥的钱包可以用 signpsbt 一次填写所有公钥
+//  2. 否则每个签名方先用 signpsbt 填写自己的公钥, combinepsbt 合并之后交易ID确定
+//  3. 每个签名方用 signpsbt 签名, combinepsbt 合并签名, finalizepsbt 生成可以广播的交易
+type PSBT struct {
+	Tx     *Transaction // 未签名的交易, 签名保存在 Inputs 中
+	Inputs []PSBTInput
+}
+
+// PSBTInput is what a signer needs to know about an input besides the transaction
+type PSBTInput struct {
+	PrevTx    *Transaction // 输入引用的完整交易
+	PrevOut   TXoutput     // 输入花费的输出, 从 PrevTx 中取出, 不单独保存
+	Signature []byte
+}
+
+// psbtJSON is the file format of a PSBT
+type psbtJSON struct {
+	Version int             `json:"version"`
+	Tx      *Transaction    `json:"tx"`
+	Inputs  []psbtInputJSON `json:"inputs"`
+}
+
+type psbtInputJSON struct {
+	PrevTx    *Transaction `json:"prevtx"`
+	Signature string       `json:"signature,omitempty"`
+}
+
+// psbtPrevOut returns the output an input spends after checking that prevTx is the transaction it references
+//
+// 交易ID是交易内容的哈希, 重新计算哈希之后和输入引用的交易ID比较, 不能只相信 prevTx.ID
+func psbtPrevOut(input TXinput, prevTx *Transaction) (TXoutput, error) {
+	if prevTx == nil {
+		return TXoutput{}, errors.New("previous transaction is missing")
+	}
+	if !bytes.Equal(prevTx.ID, input.TXid) || !bytes.Equal(txIDOf(prevTx), input.TXid) {
+		return TXoutput{}, fmt.Errorf("%w %x", ErrPrevTxMismatch, input.TXid)
+	}
+	if input.Voutindex < 0 || input.Voutindex >= len(prevTx.Out) {
+		return TXoutput{}, fmt.Errorf("output %x:%d does not exist", input.TXid, input.Voutindex)
+	}
+	return prevTx.Out[input.Voutindex], nil
+}
+
+// checkPrevTxs checks the previous transaction of every input and derives the spent outputs again
+func (p *PSBT) checkPrevTxs() error {
+	if len(p.Tx.In) != len(p.Inputs) {
+		return errors.New("every input of the transaction needs its previous transaction")
+	}
+	for i, input := range p.Tx.In {
+		prevOut, err := psbtPrevOut(input, p.Inputs[i].PrevTx)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		p.Inputs[i].PrevOut = prevOut
+	}
+	return nil
+}
+
+// NewPSBT wraps a transaction, looking up the outputs its inputs spend with fetch
+//
+// 交易中已有的签名移到 Inputs 中, 签名对应的交易ID不变时合并的时候仍然有效
+func NewPSBT(tx *Transaction, fetch func(txID []byte) (*Transaction, error)) (*PSBT, error) {
+	if tx.IsCoinbase() {
+		return nil, ErrCoinbaseRelayed
+	}
+	if len(tx.In) == 0 {
+		return nil, ErrNoInputs
+	}
+
+	p := &PSBT{Tx: &Transaction{ID: tx.ID, Out: tx.Out}}
+	for i, input := range tx.In {
+		prevTx, err := fetch(input.TXid)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		prevOut, err := psbtPrevOut(input, prevTx)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		if len(input.Pubkey) > 0 && !bytes.Equal(PublickeyHash(input.Pubkey), prevOut.PublickeyHash) {
+			return nil, fmt.Errorf("input %d: %w", i, ErrPubkeyMismatch)
+		}
+
+		p.Tx.In = append(p.Tx.In, TXinput{input.TXid, input.Voutindex, nil, input.Pubkey})
+		p.Inputs = append(p.Inputs, PSBTInput{PrevTx: prevTx, PrevOut: prevOut, Signature: input.Signature})
+	}
+	return p, nil
+}
+
+// ReadPSBTFile reads a PSBT written by WritePSBTFile
+func ReadPSBTFile(file string) (*PSBT, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read psbt file failed, %w", err)
+	}
+	var p PSBT
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &p, nil
+}
+
+// WritePSBTFile saves a PSBT as indented JSON
+func WritePSBTFile(file string, p *PSBT) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, append(data, '\n'), 0600)
+}
+
+func (p PSBT) MarshalJSON() ([]byte, error) {
+	view := psbtJSON{Version: PSBTVERSION, Tx: p.Tx, Inputs: []psbtInputJSON{}}
+	for _, input := range p.Inputs {
+		view.Inputs = append(view.Inputs, psbtInputJSON{
+			PrevTx:    input.PrevTx,
+			Signature: hex.EncodeToString(input.Signature),
+		})
+	}
+	return json.Marshal(view)
+}
+
+// UnmarshalJSON decodes a PSBT and checks that every input has the previous transaction it references
+func (p *PSBT) UnmarshalJSON(data []byte) error {
+	var view psbtJSON
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+	if view.Version != PSBTVERSION {
+		return fmt.Errorf("unsupported psbt version %d", view.Version)
+	}
+	if view.Tx == nil || len(view.Tx.In) != len(view.Inputs) {
+		return errors.New("every input of the transaction needs its previous transaction")
+	}
+
+	decoded := PSBT{Tx: view.Tx}
+	for i, input := range view.Inputs {
+		prevOut, err := psbtPrevOut(view.Tx.In[i], input.PrevTx)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		pubkey := view.Tx.In[i].Pubkey
+		if len(pubkey) > 0 && !bytes.Equal(PublickeyHash(pubkey), prevOut.PublickeyHash) {
+			return fmt.Errorf("input %d: %w", i, ErrPubkeyMismatch)
+		}
+		signature, err := decodeHexField("signature", input.Signature)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		decoded.Tx.In[i].Signature = nil
+		decoded.Inputs = append(decoded.Inputs, PSBTInput{PrevTx: input.PrevTx, PrevOut: prevOut, Signature: signature})
+	}
+	*p = decoded
+	return nil
+}
+
+// missingPubkeys returns the inputs whose public key is still unknown
+func (p *PSBT) missingPubkeys() []int {
+	missing := []int{}
+	for i, input := range p.Tx.In {
+		if len(input.Pubkey) == 0 {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// missingSignatures returns the inputs that are not signed yet
+func (p *PSBT) missingSignatures() []int {
+	missing := []int{}
+	for i, input := range p.Inputs {
+		if len(input.Signature) == 0 {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// signatureHashes returns the hash each input signs, all public keys must be known
+func (p *PSBT) signatureHashes() [][]byte {
+	prevPubkeyHashes := make([][]byte, len(p.Inputs))
+	for i, input := range p.Inputs {
+		prevPubkeyHashes[i] = input.PrevOut.PublickeyHash
+	}
+	return p.Tx.signatureHashes(prevPubkeyHashes)
+}
+
+// setPubkeys fills in public keys, which changes the txid and invalidates every signature
+func (p *PSBT) setPubkeys(pubkeys map[int][]byte) {
+	if len(pubkeys) == 0 {
+		return
+	}
+	for i, pubkey := range pubkeys {
+		p.Tx.In[i].Pubkey = pubkey
+	}
+	for i := range p.Inputs {
+		p.Inputs[i].Signature = nil
+	}
+	p.Tx.ID = txIDOf(p.Tx)
+}
+
+// Sign fills in the public keys the wallet knows and, once every public key is known, signs its inputs
+//
+// 钱包中的私钥和只读地址的公钥都可以用来填写公钥; 返回这次签名的输入个数
+func (p *PSBT) Sign(wallets *Wallets) (int, error) {
+	pubkeys := make(map[int][]byte)
+	for i, input := range p.Tx.In {
+		if len(input.Pubkey) > 0 {
+			continue
+		}
+		address := PubkeyHashToAddress(p.Inputs[i].PrevOut.PublickeyHash, Params.AddressVersion)
+		if wallet := wallets.GetWallet(address); wallet != nil {
+			pubkeys[i] = wallet.PublicKey
+		} else if watch := wallets.GetWatchOnly(address); watch != nil && len(watch.PublicKey) > 0 {
+			pubkeys[i] = watch.PublicKey
+		}
+	}
+	p.setPubkeys(pubkeys)
+	if len(p.missingPubkeys()) > 0 {
+		return 0, nil
+	}
+
+	signed := 0
+	hashes := p.signatureHashes()
+	for i, input := range p.Inputs {
+		if len(input.Signature) > 0 {
+			continue
+		}
+		address := PubkeyHashToAddress(input.PrevOut.PublickeyHash, Params.AddressVersion)
+		if wallets.GetWallet(address) == nil {
+			continue
+		}
+		privateKey, err := wallets.PrivateKeyOf(address)
+		if err != nil {
+			return signed, err
+		}
+		p.Inputs[i].Signature = signHash(privateKey, hashes[i])
+		signed++
+	}
+	return signed, nil
+}
+
+// CombinePSBT merges the public keys and signatures of copies of the same transaction
+//
+// 签名只有在对应最终的交易ID时才有效, 合并之后验证每一个签名, 过期的和无效的签名被丢弃
+func CombinePSBT(psbts []*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, errors.New("nothing to combine")
+	}
+
+	// 每一份都重新检查上一笔交易, 不相信其他签名方给出的输出金额
+	for n, p := range psbts {
+		if err := p.checkPrevTxs(); err != nil {
+			return nil, fmt.Errorf("psbt %d: %w", n+1, err)
+		}
+	}
+
+	first := psbts[0]
+	combined := &PSBT{Tx: &Transaction{ID: first.Tx.ID, In: append([]TXinput{}, first.Tx.In...), Out: first.Tx.Out}}
+	for _, input := range first.Inputs {
+		combined.Inputs = append(combined.Inputs, PSBTInput{PrevTx: input.PrevTx, PrevOut: input.PrevOut})
+	}
+
+	pubkeys := make(map[int][]byte)
+	for n, p := range psbts[1:] {
+		if !sameUnsignedTx(first, p) {
+			return nil, fmt.Errorf("psbt %d: %w", n+2, ErrPSBTMismatch)
+		}
+		for i, input := range p.Tx.In {
+			if len(input.Pubkey) == 0 {
+				continue
+			}
+			known := combined.Tx.In[i].Pubkey
+			if len(known) == 0 {
+				known = pubkeys[i]
+			}
+			if len(known) > 0 && !bytes.Equal(known, input.Pubkey) {
+				return nil, fmt.Errorf("psbt %d input %d: conflicting public keys", n+2, i)
+			}
+			if len(combined.Tx.In[i].Pubkey) == 0 {
+				pubkeys[i] = input.Pubkey
+			}
+		}
+	}
+	combined.setPubkeys(pubkeys)
+	if len(combined.missingPubkeys()) > 0 {
+		return combined, nil
+	}
+
+	hashes := combined.signatureHashes()
+	for _, p := range psbts {
+		for i, input := range p.Inputs {
+			if len(combined.Inputs[i].Signature) > 0 || len(input.Signature) == 0 {
+				continue
+			}
+			if verifySignature(combined.Tx.In[i].Pubkey, input.Signature, hashes[i]) {
+				combined.Inputs[i].Signature = input.Signature
+			}
+		}
+	}
+	return combined, nil
+}
+
+// sameUnsignedTx reports whether two PSBTs spend the same outputs and pay the same outputs
+func sameUnsignedTx(a, b *PSBT) bool {
+	if len(a.Tx.In) != len(b.Tx.In) || len(a.Tx.Out) != len(b.Tx.Out) {
+		return false
+	}
+	for i := range a.Tx.In {
+		if !bytes.Equal(a.Tx.In[i].TXid, b.Tx.In[i].TXid) || a.Tx.In[i].Voutindex != b.Tx.In[i].Voutindex {
+			return false
+		}
+		if a.Inputs[i].PrevOut.Value != b.Inputs[i].PrevOut.Value || !bytes.Equal(a.Inputs[i].PrevOut.PublickeyHash, b.Inputs[i].PrevOut.PublickeyHash) {
+			return false
+		}
+	}
+	for i := range a.Tx.Out {
+		if a.Tx.Out[i].Value != b.Tx.Out[i].Value || !bytes.Equal(a.Tx.Out[i].PublickeyHash, b.Tx.Out[i].PublickeyHash) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fee returns the inputs minus the outputs, negative when the transaction spends more than it has
+func (p *PSBT) Fee() int {
+	fee := 0
+	for _, input := range p.Inputs {
+		fee += input.PrevOut.Value
+	}
+	for _, output := range p.Tx.Out {
+		fee -= output.Value
+	}
+	return fee
+}
+
+// Finalize checks every signature and returns the transaction ready for sendrawtx
+func (p *PSBT) Finalize() (*Transaction, error) {
+	if err := p.checkPrevTxs(); err != nil {
+		return nil, err
+	}
+	if missing := p.missingPubkeys(); len(missing) > 0 {
+		return nil, fmt.Errorf("%w, inputs %v have no public key", ErrTxNotSigned, missing)
+	}
+	if missing := p.missingSignatures(); len(missing) > 0 {
+		return nil, fmt.Errorf("%w, inputs %v have no signature", ErrTxNotSigned, missing)
+	}
+	if fee := p.Fee(); fee < 0 {
+		return nil, fmt.Errorf("outputs exceed inputs by %d", -fee)
+	}
+
+	tx := &Transaction{ID: p.Tx.ID, Out: p.Tx.Out}
+	prevOuts := []TXoutput{}
+	for i, input := range p.Tx.In {
+		tx.In = append(tx.In, TXinput{input.TXid, input.Voutindex, p.Inputs[i].Signature, input.Pubkey})
+		prevOuts = append(prevOuts, p.Inputs[i].PrevOut)
+	}
+	if !tx.verifySignatures(prevOuts) {
+		return nil, ErrInvalidSignature
+	}
+	return tx, nil
+}
+
+// String describes the inputs, the outputs and what is still missing
+func (p *PSBT) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("transaction %x", p.Tx.ID))
+	for i, input := range p.Tx.In {
+		status := "signed"
+		switch {
+		case len(input.Pubkey) == 0:
+			status = "needs public key"
+		case len(p.Inputs[i].Signature) == 0:
+			status = "needs signature"
+		}
+		prevOut := p.Inputs[i].PrevOut
+		lines = append(lines, fmt.Sprintf("input %d: %x:%d %d from %s, %s", i, input.TXid, input.Voutindex, prevOut.Value,
+			PubkeyHashToAddress(prevOut.PublickeyHash, Params.AddressVersion), status))
+	}
+	for i, output := range p.Tx.Out {
+		lines = append(lines, fmt.Sprintf("output %d: %d -> %s", i, output.Value, PubkeyHashToAddress(output.PublickeyHash, Params.AddressVersion)))
+	}
+	lines = append(lines, fmt.Sprintf("fee: %d", p.Fee()))
+
+	switch {
+	case len(p.missingPubkeys()) > 0:
+		lines = append(lines, fmt.Sprintf("next: the owners of inputs %v add their public keys with signpsbt", p.missingPubkeys()))
+	case len(p.missingSignatures()) > 0:
+		lines = append(lines, fmt.Sprintf("next: the owners of inputs %v sign with signpsbt", p.missingSignatures()))
+	default:
+		lines = append(lines, "next: finalizepsbt")
+	}
+	return strings.Join(lines, "\n")
+}
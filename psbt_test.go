@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// newTestPrevTx returns a transaction whose ID is the hash of its content, as in the chain
+func newTestPrevTx(values ...int) *Transaction {
+	prevTx := &Transaction{In: []TXinput{{TXid: []byte("funding"), Voutindex: 0}}}
+	for i, value := range values {
+		prevTx.Out = append(prevTx.Out, TXoutput{Value: value, PublickeyHash: bytes.Repeat([]byte{byte(i + 1)}, 20)})
+	}
+	prevTx.ID = txIDOf(prevTx)
+	return prevTx
+}
+
+func TestPSBTPrevTx(t *testing.T) {
+	prevTx := newTestPrevTx(60, 40)
+	tx := &Transaction{
+		In:  []TXinput{{TXid: prevTx.ID, Voutindex: 1}},
+		Out: []TXoutput{{Value: 30, PublickeyHash: bytes.Repeat([]byte{9}, 20)}},
+	}
+	tx.ID = txIDOf(tx)
+
+	// 修改金额但保留原来的ID, 或者重新计算ID, 都不再是输入引用的交易
+	inflated := newTestPrevTx(60, 400)
+	inflated.ID = prevTx.ID
+	rehashed := newTestPrevTx(60, 400)
+
+	tests := []struct {
+		name    string
+		prevTx  *Transaction
+		wantErr error
+	}{
+		{name: "matching", prevTx: prevTx},
+		{name: "same id, different content", prevTx: inflated, wantErr: ErrPrevTxMismatch},
+		{name: "different id", prevTx: rehashed, wantErr: ErrPrevTxMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetch := func(txID []byte) (*Transaction, error) { return test.prevTx, nil }
+			p, err := NewPSBT(tx, fetch)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("NewPSBT error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if p.Fee() != 10 {
+				t.Errorf("fee %d, want 10", p.Fee())
+			}
+		})
+	}
+
+	// 其他签名方交换上一笔交易之后, 解码和合并都要拒绝
+	p, err := NewPSBT(tx, func(txID []byte) (*Transaction, error) { return prevTx, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := &PSBT{Tx: p.Tx, Inputs: []PSBTInput{{PrevTx: rehashed, PrevOut: rehashed.Out[1]}}}
+	data, err := json.Marshal(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded PSBT
+	if err := json.Unmarshal(data, &decoded); !errors.Is(err, ErrPrevTxMismatch) {
+		t.Errorf("UnmarshalJSON error %v, want %v", err, ErrPrevTxMismatch)
+	}
+	if _, err := CombinePSBT([]*PSBT{p, forged}); !errors.Is(err, ErrPrevTxMismatch) {
+		t.Errorf("CombinePSBT error %v, want %v", err, ErrPrevTxMismatch)
+	}
+
+	data, err = json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Inputs[0].PrevOut.Value != 40 {
+		t.Errorf("decoded spent output %d, want 40", decoded.Inputs[0].PrevOut.Value)
+	}
+}
+
+// copyPSBT passes a PSBT through its file format, as between the signers
+func copyPSBT(t *testing.T, p *PSBT) *PSBT {
+	t.Helper()
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copied PSBT
+	if err := json.Unmarshal(data, &copied); err != nil {
+		t.Fatal(err)
+	}
+	return &copied
+}
+
+func TestCombinePSBT(t *testing.T) {
+	useTestWalletFile(t)
+
+	// 两个签名方各自持有一个输入的私钥
+	var signers []*Wallets
+	prevTx := &Transaction{In: []TXinput{{TXid: []byte("funding"), Voutindex: 0}}}
+	for i := 0; i < 2; i++ {
+		ws := CreateWallets()
+		ws.loaded = true
+		address, err := ws.CreateWalletRandomly()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubkeyHash, err := DecodeAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, ws)
+		prevTx.Out = append(prevTx.Out, TXoutput{Value: 50, PublickeyHash: pubkeyHash})
+	}
+	prevTx.ID = txIDOf(prevTx)
+
+	tx := &Transaction{
+		In:  []TXinput{{TXid: prevTx.ID, Voutindex: 0}, {TXid: prevTx.ID, Voutindex: 1}},
+		Out: []TXoutput{{Value: 90, PublickeyHash: bytes.Repeat([]byte{9}, 20)}},
+	}
+	tx.ID = txIDOf(tx)
+	p, err := NewPSBT(tx, func(txID []byte) (*Transaction, error) { return prevTx, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一轮: 公钥还不全, 只填写公钥不签名
+	var copies []*PSBT
+	for _, ws := range signers {
+		copied := copyPSBT(t, p)
+		if signed, err := copied.Sign(ws); err != nil || signed != 0 {
+			t.Fatalf("Sign without every public key = %d %v, want 0", signed, err)
+		}
+		copies = append(copies, copied)
+	}
+	withPubkeys, err := CombinePSBT(copies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := withPubkeys.missingPubkeys(); len(missing) != 0 {
+		t.Fatalf("inputs %v have no public key after combining", missing)
+	}
+	if _, err := withPubkeys.Finalize(); !errors.Is(err, ErrTxNotSigned) {
+		t.Errorf("Finalize without signatures error %v, want %v", err, ErrTxNotSigned)
+	}
+
+	// 第二轮: 每个签名方签自己的输入, 合并之后可以广播
+	copies = nil
+	for _, ws := range signers {
+		copied := copyPSBT(t, withPubkeys)
+		if signed, err := copied.Sign(ws); err != nil || signed != 1 {
+			t.Fatalf("Sign = %d %v, want 1", signed, err)
+		}
+		copies = append(copies, copied)
+	}
+	if _, err := copies[0].Finalize(); !errors.Is(err, ErrTxNotSigned) {
+		t.Errorf("Finalize with one signature error %v, want %v", err, ErrTxNotSigned)
+	}
+	combined, err := CombinePSBT(copies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	final, err := combined.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(final.ID, txIDOf(final)) || combined.Fee() != 10 {
+		t.Errorf("final txid %x, fee %d, want the hash of the transaction and fee 10", final.ID, combined.Fee())
+	}
+	if !final.Verify(map[string]*Transaction{string(prevTx.ID): prevTx}) {
+		t.Error("final transaction does not verify")
+	}
+
+	// 签名被篡改之后无法完成
+	tampered := copyPSBT(t, combined)
+	tampered.Inputs[0].Signature, tampered.Inputs[1].Signature = tampered.Inputs[1].Signature, tampered.Inputs[0].Signature
+	if _, err := tampered.Finalize(); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Finalize with swapped signatures error %v, want %v", err, ErrInvalidSignature)
+	}
+	// 合并时丢弃无效的签名
+	dropped, err := CombinePSBT([]*PSBT{withPubkeys, tampered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := dropped.missingSignatures(); len(missing) != 2 {
+		t.Errorf("combining swapped signatures kept %d of them, want none", 2-len(missing))
+	}
+
+	// 不同的交易不能合并
+	other := copyPSBT(t, withPubkeys)
+	other.Tx.Out[0].Value = 80
+	if _, err := CombinePSBT([]*PSBT{withPubkeys, other}); !errors.Is(err, ErrPSBTMismatch) {
+		t.Errorf("CombinePSBT of different transactions error %v, want %v", err, ErrPSBTMismatch)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoInputs        = errors.New("no inputs")
+	ErrUnknownSpender  = errors.New("public key of the spender is unknown")
+	ErrTxNotSigned     = errors.New("transaction is not fully signed")
+	ErrCoinbaseRelayed = errors.New("coinbase transactions are only valid in a block")
+)
+
+// SignedRawTx is the result of signing a raw transaction
+type SignedRawTx struct {
+	Hex      string `json:"hex"`
+	TxID     string `json:"txid"`
+	Complete bool   `json:"complete"` // 所有输入都已经签名, 可以广播
+}
+
+// NewRawTransaction builds an unsigned transaction spending exactly the given outpoints
+//
+// 按照给定的输入和输出构建未签名的交易: 不选币也不找零, 输入总额减去输出总额就是手续费;
+// 输入的公钥留空, 由签名方填写, 所以可以在没有私钥也没有钱包的机器上创建
+func NewRawTransaction(outpoints []Outpoint, recipients []Recipient) (*Transaction, error) {
+	if len(outpoints) == 0 {
+		return nil, ErrNoInputs
+	}
+
+	inputs := []TXinput{}
+	seen := make(map[string]bool)
+	for i, outpoint := range outpoints {
+		if len(outpoint.TxID) == 0 {
+			return nil, fmt.Errorf("input %d: empty txid", i)
+		}
+		if seen[outpoint.String()] {
+			return nil, fmt.Errorf("input %d: %s is spent twice", i, outpoint)
+		}
+		seen[outpoint.String()] = true
+		inputs = append(inputs, TXinput{outpoint.TxID, outpoint.Index, nil, nil})
+	}
+
+	outputs, _, err := paymentOutputs(recipients, 0, FEESPLITSENDER)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := Transaction{nil, inputs, outputs}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+// DecodeRawTransaction decodes a hex serialized transaction and checks its txid
+func DecodeRawTransaction(rawHex string) (*Transaction, error) {
+	data, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hex, %w", err)
+	}
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	if !tx.IsCoinbase() && !bytes.Equal(tx.ID, txIDOf(tx)) {
+		return nil, fmt.Errorf("%w %x", ErrTxIDMismatch, tx.ID)
+	}
+	return tx, nil
+}
+
+// SignRawTransaction signs the inputs of tx whose keys are in the wallet, returning whether every input is signed
+//
+// 输入的公钥是交易ID的一部分, 所以先填写所有缺少的公钥: 从区块链中找到引用的输出属于钱包中的哪个地址,
+// 离线的机器上找不到引用的交易时使用 from 的公钥; 填写公钥之后交易ID改变, 已有的签名都要重新签.
+// 属于其他人的输入保持不变, 由他们继续签名
+func SignRawTransaction(tx *Transaction, from string, wallets *Wallets, bc *Blockchain) (bool, error) {
+	if tx.IsCoinbase() {
+		return false, ErrCoinbaseRelayed
+	}
+
+	// 找到所有缺少的公钥之后再修改交易, 出错时交易保持不变
+	pubkeys := make(map[int][]byte)
+	for i, input := range tx.In {
+		if len(input.Pubkey) > 0 {
+			continue
+		}
+		wallet, err := spenderWallet(input, from, wallets, bc)
+		if err != nil {
+			return false, fmt.Errorf("input %d spends %x:%d, %w", i, input.TXid, input.Voutindex, err)
+		}
+		pubkeys[i] = wallet.PublicKey
+	}
+	if len(pubkeys) > 0 {
+		for i := range tx.In {
+			if pubkey, ok := pubkeys[i]; ok {
+				tx.In[i].Pubkey = pubkey
+			}
+			tx.In[i].Signature = nil
+		}
+		tx.ID = txIDOf(tx)
+	}
+
+	// 引用的输出的公钥哈希就是输入公钥的哈希, 不需要区块链也可以计算签名的哈希
+	prevPubkeyHashes := make([][]byte, len(tx.In))
+	for i, input := range tx.In {
+		prevPubkeyHashes[i] = PublickeyHash(input.Pubkey)
+	}
+	hashes := tx.signatureHashes(prevPubkeyHashes)
+	for i, input := range tx.In {
+		if len(input.Signature) > 0 {
+			continue
+		}
+		address := PubkeyHashToAddress(prevPubkeyHashes[i], Params.AddressVersion)
+		if wallets.GetWallet(address) == nil {
+			continue
+		}
+		privateKey, err := wallets.PrivateKeyOf(address)
+		if err != nil {
+			return false, err
+		}
+		tx.In[i].Signature = signHash(privateKey, hashes[i])
+	}
+
+	return isFullySigned(tx), nil
+}
+
+// spenderWallet finds the wallet key that can spend an input
+func spenderWallet(input TXinput, from string, wallets *Wallets, bc *Blockchain) (*Wallet, error) {
+	if bc != nil {
+		if prevTx, err := bc.FindTxByID(input.TXid); err == nil {
+			if input.Voutindex < 0 || input.Voutindex >= len(prevTx.Out) {
+				return nil, errors.New("output index is out of range")
+			}
+			pubkeyHash := prevTx.Out[input.Voutindex].PublickeyHash
+			if wallet := wallets.GetWallet(PubkeyHashToAddress(pubkeyHash, Params.AddressVersion)); wallet != nil {
+				return wallet, nil
+			}
+			return nil, fmt.Errorf("%w, the output belongs to %s", ErrUnknownSpender, PubkeyHashToAddress(pubkeyHash, Params.AddressVersion))
+		}
+	}
+
+	if len(from) > 0 {
+		if wallet := wallets.GetWallet(from); wallet != nil {
+			return wallet, nil
+		}
+		return nil, fmt.Errorf("address %s is not in the wallet", from)
+	}
+	return nil, fmt.Errorf("%w, the spent transaction is not in the local chain, sign with -from", ErrUnknownSpender)
+}
+
+// isFullySigned reports whether every input carries a public key and a signature
+func isFullySigned(tx *Transaction) bool {
+	for _, input := range tx.In {
+		if len(input.Pubkey) == 0 || len(input.Signature) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckRawTransaction verifies a signed transaction against the chain before it is broadcast
+//
+// 和 handleTx 相同的检查, 交易来自本地, 所以返回错误而不是给节点记录违规
+func CheckRawTransaction(tx *Transaction, bc *Blockchain) error {
+	if tx.IsCoinbase() {
+		return ErrCoinbaseRelayed
+	}
+	if !isFullySigned(tx) {
+		return ErrTxNotSigned
+	}
+	for i, input := range tx.In {
+		if _, err := bc.FindTxByID(input.TXid); err != nil {
+			return fmt.Errorf("input %d spends unknown transaction %x", i, input.TXid)
+		}
+	}
+	if !bc.VerifyTransaction(tx) {
+		return fmt.Errorf("invalid signature in transaction %x", tx.ID)
+	}
+	return nil
+}
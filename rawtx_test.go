@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// fundTestAddress mines a coinbase to address and returns the outpoint of its output
+func fundTestAddress(t *testing.T, bc *Blockchain, address string) Outpoint {
+	t.Helper()
+
+	coinbase, err := CoinBaseTx(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.AddBlock([]*Transaction{coinbase})
+	return Outpoint{TxID: coinbase.ID, Index: 0}
+}
+
+func TestNewRawTransaction(t *testing.T) {
+	to := PubkeyHashToAddress(make([]byte, PUBKEYHASHLENGTH), Params.AddressVersion)
+	first := Outpoint{TxID: []byte("first"), Index: 0}
+	recipients := []Recipient{{Address: to, Amount: 10}}
+
+	tests := []struct {
+		name      string
+		outpoints []Outpoint
+		wantErr   bool
+	}{
+		{name: "no inputs", wantErr: true},
+		{name: "empty txid", outpoints: []Outpoint{{Index: 1}}, wantErr: true},
+		{name: "spent twice", outpoints: []Outpoint{first, first}, wantErr: true},
+		{name: "two inputs", outpoints: []Outpoint{first, {TxID: []byte("first"), Index: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := NewRawTransaction(tt.outpoints, recipients)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRawTransaction error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, input := range tx.In {
+				if input.Pubkey != nil || input.Signature != nil {
+					t.Errorf("raw input %+v, want no public key and no signature", input)
+				}
+			}
+		})
+	}
+	if _, err := NewRawTransaction(nil, recipients); !errors.Is(err, ErrNoInputs) {
+		t.Errorf("NewRawTransaction without inputs error %v, want %v", err, ErrNoInputs)
+	}
+}
+
+func TestDecodeRawTransaction(t *testing.T) {
+	to := PubkeyHashToAddress(make([]byte, PUBKEYHASHLENGTH), Params.AddressVersion)
+	tx, err := NewRawTransaction([]Outpoint{{TxID: []byte("first")}}, []Recipient{{Address: to, Amount: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *tx
+	tampered.Out = []TXoutput{{Value: 11, PublickeyHash: tx.Out[0].PublickeyHash}}
+
+	tests := []struct {
+		name    string
+		hex     string
+		wantErr error
+	}{
+		{name: "valid", hex: hex.EncodeToString(tx.Serialize())},
+		{name: "tampered", hex: hex.EncodeToString(tampered.Serialize()), wantErr: ErrTxIDMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeRawTransaction(tt.hex)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeRawTransaction error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(decoded.ID, tx.ID) {
+				t.Errorf("decoded txid %x, want %x", decoded.ID, tx.ID)
+			}
+		})
+	}
+	if _, err := DecodeRawTransaction("zz"); err == nil {
+		t.Error("decoded a transaction that is not hex")
+	}
+}
+
+func TestSignRawTransaction(t *testing.T) {
+	useTestWalletFile(t)
+	bc := newTestBlockchain(t)
+	alice, aliceAddress, _ := newTestKey(t)
+	bob, bobAddress, bobPubkey := newTestKey(t)
+	aliceCoin := fundTestAddress(t, bc, aliceAddress)
+	bobCoin := fundTestAddress(t, bc, bobAddress)
+	to := PubkeyHashToAddress(make([]byte, PUBKEYHASHLENGTH), Params.AddressVersion)
+
+	tx, err := NewRawTransaction([]Outpoint{aliceCoin, bobCoin}, []Recipient{{Address: to, Amount: 2*COINBASEFEE - 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// alice 不知道 bob 的公钥, 无法补全 bob 的输入, 交易保持不变
+	if _, err := SignRawTransaction(tx, "", alice, bc); !errors.Is(err, ErrUnknownSpender) {
+		t.Fatalf("SignRawTransaction without the other public key error %v, want %v", err, ErrUnknownSpender)
+	}
+	if tx.In[0].Pubkey != nil || tx.In[0].Signature != nil {
+		t.Errorf("failed SignRawTransaction changed the inputs to %+v", tx.In)
+	}
+
+	// bob 先给出公钥和一个签名; alice 补全公钥之后交易ID改变, bob 的签名作废
+	tx.In[1].Pubkey = bobPubkey
+	tx.ID = txIDOf(tx)
+	tx.In[1].Signature = []byte("stale signature")
+	oldID := tx.ID
+	complete, err := SignRawTransaction(tx, "", alice, bc)
+	if err != nil || complete {
+		t.Fatalf("alice SignRawTransaction = %v %v, want incomplete", complete, err)
+	}
+	if bytes.Equal(tx.ID, oldID) || !bytes.Equal(tx.ID, txIDOf(tx)) {
+		t.Errorf("txid %x not recomputed after filling the public key", tx.ID)
+	}
+	if len(tx.In[0].Pubkey) == 0 || len(tx.In[0].Signature) == 0 || tx.In[1].Signature != nil {
+		t.Errorf("inputs after alice signed %+v, want alice signed and the stale signature dropped", tx.In)
+	}
+	if err := CheckRawTransaction(tx, bc); !errors.Is(err, ErrTxNotSigned) {
+		t.Errorf("CheckRawTransaction of a partly signed transaction error %v, want %v", err, ErrTxNotSigned)
+	}
+
+	// 所有公钥都已经填写, bob 签名不改变交易ID, alice 的签名仍然有效
+	signedID := tx.ID
+	complete, err = SignRawTransaction(tx, "", bob, bc)
+	if err != nil || !complete {
+		t.Fatalf("bob SignRawTransaction = %v %v, want complete", complete, err)
+	}
+	if !bytes.Equal(tx.ID, signedID) {
+		t.Errorf("txid changed from %x to %x when only signing", signedID, tx.ID)
+	}
+	if err := CheckRawTransaction(tx, bc); err != nil {
+		t.Errorf("CheckRawTransaction of the signed transaction, %v", err)
+	}
+
+	forged := *tx
+	forged.In = append([]TXinput{}, tx.In...)
+	forged.In[1].Signature = tx.In[0].Signature
+	if err := CheckRawTransaction(&forged, bc); err == nil {
+		t.Error("CheckRawTransaction accepted a signature copied from another input")
+	}
+}
+
+func TestSignRawTransactionOffline(t *testing.T) {
+	useTestWalletFile(t)
+	wallets, address, publickey := newTestKey(t)
+	to := PubkeyHashToAddress(make([]byte, PUBKEYHASHLENGTH), Params.AddressVersion)
+
+	// 离线的机器上没有区块链, 使用 from 的公钥
+	tests := []struct {
+		name    string
+		from    string
+		wantErr bool
+	}{
+		{name: "from", from: address},
+		{name: "no from", wantErr: true},
+		{name: "from not in the wallet", from: to, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := NewRawTransaction([]Outpoint{{TxID: []byte("offline")}}, []Recipient{{Address: to, Amount: 10}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			complete, err := SignRawTransaction(tx, tt.from, wallets, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SignRawTransaction error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !complete || !bytes.Equal(tx.In[0].Pubkey, publickey) {
+				t.Errorf("SignRawTransaction = %v with public key %x, want complete with %x", complete, tx.In[0].Pubkey, publickey)
+			}
+			if !tx.verifySignatures([]TXoutput{{Value: 10, PublickeyHash: PublickeyHash(publickey)}}) {
+				t.Error("the offline signature does not verify")
+			}
+		})
+	}
+
+	coinbase, err := CoinBaseTx(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignRawTransaction(coinbase, address, wallets, nil); !errors.Is(err, ErrCoinbaseRelayed) {
+		t.Errorf("SignRawTransaction of a coinbase error %v, want %v", err, ErrCoinbaseRelayed)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RESTDEFAULTPAGESIZE = 100                  // 分页结果默认每页的条数
+	RESTMAXPAGESIZE     = 1000                 // 每页最多的条数
+	RESTREADTIMEOUT     = 10 * time.Second     // 读取请求的超时时间
+	RESTIMMUTABLEMAXAGE = 365 * 24 * time.Hour // 按哈希查询的区块不会改变, 可以一直缓存
+	RESTCACHEREVALIDATE = "public, no-cache"   // 可能因为新区块或者分叉改变的响应, 使用之前需要用ETag确认
+	RESTCACHENONE       = "no-store"           // 交易池中的交易随时可能改变
+	RESTCONTENTTYPE     = "application/json"
+)
+
+// RESTServer serves a read-only JSON view of the chain without authentication
+//
+// 只读的REST接口, 给区块浏览器和监控面板使用; 不需要认证, 所以不提供任何钱包和节点管理的功能
+//
+//	GET /block/{hash}
+//	GET /block/height/{n}
+//	GET /tx/{txid}
+//	GET /address/{address}/utxos?offset=0&limit=100
+//	GET /chaininfo
+type RESTServer struct {
+	bc *Blockchain
+}
+
+// RESTPage is one page of a large result
+type RESTPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  interface{} `json:"items"`
+}
+
+// RESTUTXO is an unspent output of an address
+type RESTUTXO struct {
+	TxID  string `json:"txid"`
+	Vout  int    `json:"vout"`
+	Value int    `json:"value"`
+}
+
+// StartRESTServer listens on the configured REST address and serves requests in the background
+func StartRESTServer(bc *Blockchain) error {
+	if len(Config.RESTListen) == 0 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", Config.RESTListen)
+	if err != nil {
+		return fmt.Errorf("rest listen %s failed, %w", Config.RESTListen, err)
+	}
+
+	httpServer := &http.Server{Handler: &RESTServer{bc: bc}, ReadTimeout: RESTREADTIMEOUT}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil {
+			fmt.Printf("rest server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("rest server listening on %s\n", Config.RESTListen)
+	return nil
+}
+
+func (s *RESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		restError(w, http.StatusMethodNotAllowed, "the REST API is read-only")
+		return
+	}
+	// 浏览器中的监控面板可以直接跨域读取
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "block" && parts[1] == "height":
+		s.blockAtHeight(w, r, parts[2])
+	case len(parts) == 2 && parts[0] == "block":
+		s.block(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "tx":
+		s.transaction(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "address" && parts[2] == "utxos":
+		s.addressUTXOs(w, r, parts[1])
+	case len(parts) == 1 && parts[0] == "chaininfo":
+		s.chainInfo(w, r)
+	default:
+		restError(w, http.StatusNotFound, fmt.Sprintf("unknown endpoint %s", r.URL.Path))
+	}
+}
+
+// block serves a block by hash, which never changes and is cached for good
+func (s *RESTServer) block(w http.ResponseWriter, r *http.Request, hash string) {
+	blockHash, err := hex.DecodeString(hash)
+	if err != nil || len(blockHash) == 0 {
+		restError(w, http.StatusBadRequest, fmt.Sprintf("invalid block hash %q", hash))
+		return
+	}
+	block, err := s.bc.GetBlock(blockHash)
+	if err != nil {
+		restError(w, http.StatusNotFound, fmt.Sprintf("block %s is not found", hash))
+		return
+	}
+
+	cacheControl := fmt.Sprintf("public, max-age=%d, immutable", int64(RESTIMMUTABLEMAXAGE/time.Second))
+	restJSON(w, r, restBlockETag(&block), cacheControl, restBlock(&block))
+}
+
+// blockAtHeight serves the main chain block at a height, which changes after a reorg
+func (s *RESTServer) blockAtHeight(w http.ResponseWriter, r *http.Request, height string) {
+	n, err := strconv.ParseInt(height, 10, 64)
+	if err != nil || n < 0 {
+		restError(w, http.StatusBadRequest, fmt.Sprintf("invalid height %q", height))
+		return
+	}
+	block, err := s.bc.GetBlockByHeight(n)
+	if err != nil {
+		restError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// 内容由区块哈希决定, ETag 和按哈希查询时相同, 但是这个高度上的区块可能被替换, 每次都要确认
+	restJSON(w, r, restBlockETag(block), RESTCACHEREVALIDATE, restBlock(block))
+}
+
+// transaction serves a transaction of the mempool or of the main chain
+func (s *RESTServer) transaction(w http.ResponseWriter, r *http.Request, txid string) {
+	txID, err := hex.DecodeString(txid)
+	if err != nil || len(txID) == 0 {
+		restError(w, http.StatusBadRequest, fmt.Sprintf("invalid txid %q", txid))
+		return
+	}
+
+	if tx, ok := TxPool.Get(txID); ok {
+		restJSON(w, r, "", RESTCACHENONE, NewTransactionJSON(tx, nil, -1))
+		return
+	}
+	tx, block, err := s.bc.FindTxBlock(txID)
+	if err != nil {
+		restError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// 已经确认的交易只有在分叉切换到其他区块时才会改变, 所以 ETag 包含区块哈希
+	etag := fmt.Sprintf(`"%x-%x"`, tx.ID, block.Hash)
+	restJSON(w, r, etag, RESTCACHEREVALIDATE, NewTransactionJSON(tx, block, -1))
+}
+
+// addressUTXOs serves the unspent outputs of an address, one page at a time
+//
+// 从UTXO集合中查找, 不需要遍历区块链
+func (s *RESTServer) addressUTXOs(w http.ResponseWriter, r *http.Request, address string) {
+	pubkeyHash, err := DecodeAddress(address)
+	if err != nil {
+		restError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, limit, err := restPagination(r)
+	if err != nil {
+		restError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utxoSet := UTXOSet{s.bc}
+	coins, err := utxoSet.FindCoins(pubkeyHash)
+	if err != nil {
+		restError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page := RESTPage{Total: len(coins), Offset: offset, Limit: limit}
+	utxos := []RESTUTXO{}
+	for i := offset; i < len(coins) && i < offset+limit; i++ {
+		utxos = append(utxos, RESTUTXO{TxID: hex.EncodeToString(coins[i].TxID), Vout: coins[i].Index, Value: coins[i].Value})
+	}
+	page.Items = utxos
+
+	// 未花费的输出随着每个区块变化, 用最新区块的哈希作为 ETag
+	etag := fmt.Sprintf(`"%x"`, s.bc.GetTopHash())
+	restJSON(w, r, etag, RESTCACHEREVALIDATE, page)
+}
+
+func (s *RESTServer) chainInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := blockchainInfo(s.bc)
+	if err != nil {
+		restError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	restJSON(w, r, "", RESTCACHENONE, info)
+}
+
+// restBlock describes a block without the confirmations, which change with every new block
+func restBlock(block *Block) BlockJSON {
+	return NewBlockJSON(block, -1)
+}
+
+func restBlockETag(block *Block) string {
+	return fmt.Sprintf(`"%x"`, block.Hash)
+}
+
+// restPagination reads the offset and limit query params
+func restPagination(r *http.Request) (int, int, error) {
+	offset, limit := 0, RESTDEFAULTPAGESIZE
+	query := r.URL.Query()
+	if value := query.Get("offset"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", value)
+		}
+		offset = n
+	}
+	if value := query.Get("limit"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > RESTMAXPAGESIZE {
+			return 0, 0, fmt.Errorf("invalid limit %q, must be 1 to %d", value, RESTMAXPAGESIZE)
+		}
+		limit = n
+	}
+	return offset, limit, nil
+}
+
+// restJSON writes v as JSON, or 304 Not Modified when the client already has the same ETag
+func restJSON(w http.ResponseWriter, r *http.Request, etag, cacheControl string, v interface{}) {
+	w.Header().Set("Cache-Control", cacheControl)
+	if len(etag) > 0 {
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		restError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", RESTCONTENTTYPE)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)+1))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(append(body, '\n'))
+}
+
+// etagMatches checks an If-None-Match header, which may list several ETags or be *
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func restError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", RESTCONTENTTYPE)
+	w.Header().Set("Cache-Control", RESTCACHENONE)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,934 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RPCPORTOFFSET     = 1000                   // 默认的RPC端口 = 节点端口 + RPCPORTOFFSET
+	RPCCOOKIEUSER     = "__cookie__"           // cookie认证使用的用户名
+	RPCMAXBODYSIZE    = 1 << 20                // 单个请求的最大长度
+	RPCREADTIMEOUT    = 30 * time.Second       // 读取请求的超时时间
+	RPCAUTHFAILDELAY  = 250 * time.Millisecond // 认证失败之后延迟回复, 增加暴力破解密码的成本
+	RPCDEFAULTBANTIME = int64(DEFAULTBANTIME / time.Second)
+
+	// JSON-RPC 2.0 规定的错误码
+	RPCERRPARSE          = -32700
+	RPCERRINVALIDREQUEST = -32600
+	RPCERRMETHODNOTFOUND = -32601
+	RPCERRINVALIDPARAMS  = -32602
+	RPCERRINTERNAL       = -32603
+
+	// 和 bitcoind 一致的应用错误码
+	RPCERRMISC               = -1
+	RPCERRINVALIDADDRESS     = -5 // 地址无效, 或者找不到区块和交易
+	RPCERRINSUFFICIENTFUNDS  = -6
+	RPCERRWALLETUNLOCKNEEDED = -13
+	RPCERRWRONGPASSPHRASE    = -14
+	RPCERRWRONGENCSTATE      = -15
+	RPCERRDESERIALIZATION    = -22 // 交易无法解码
+	RPCERRVERIFY             = -25 // 交易没有通过验证
+)
+
+// RPCRequest is a JSON-RPC call with positional params
+//
+// JSON-RPC 请求, 参数按照位置传递, 例如 {"method":"getblockhash","params":[1],"id":1}
+type RPCRequest struct {
+	JSONRPC string            `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// RPCResponse carries the result or the error of a call
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error"`
+}
+
+// RPCError is the error object of a failed call
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func rpcErrorf(code int, format string, args ...interface{}) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// rpcErrorFrom maps an error of the node or the wallet to an error code
+func rpcErrorFrom(err error) *RPCError {
+	var rpcErr *RPCError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.Is(err, ErrWalletLocked):
+		return rpcErrorf(RPCERRWALLETUNLOCKNEEDED, "wallet is locked, unlock it with walletpassphrase first")
+	case errors.Is(err, ErrWrongPassphrase):
+		return rpcErrorf(RPCERRWRONGPASSPHRASE, "%v", err)
+	case errors.Is(err, ErrWalletNotEncrypted), errors.Is(err, ErrWalletEncrypted):
+		return rpcErrorf(RPCERRWRONGENCSTATE, "%v", err)
+	case errors.Is(err, ErrInsufficientFunds):
+		return rpcErrorf(RPCERRINSUFFICIENTFUNDS, "%v", err)
+	}
+	return rpcErrorf(RPCERRMISC, "%v", err)
+}
+
+// rpcHandler runs one RPC method
+type rpcHandler func(s *RPCServer, params []json.RawMessage) (interface{}, error)
+
+// RPCMethods are the methods served by the node
+var RPCMethods = map[string]rpcHandler{
+	"getblockcount":     rpcGetBlockCount,
+	"getbestblockhash":  rpcGetBestBlockHash,
+	"getblockhash":      rpcGetBlockHash,
+	"getblock":          rpcGetBlock,
+	"gettransaction":    rpcGetTransaction,
+	"getblockchaininfo": rpcGetBlockchainInfo,
+	"getmempoolinfo":    rpcGetMempoolInfo,
+	"getrawmempool":     rpcGetRawMempool,
+	"getpeerinfo":       rpcGetPeerInfo,
+	"listbanned":        rpcListBanned,
+	"setban":            rpcSetBan,
+	"getbalance":        rpcGetBalance,
+	"listbalances":      rpcListBalances,
+	"getnewaddress":     rpcGetNewAddress,
+	"sendtoaddress":     rpcSendToAddress,
+	"sendmany":          rpcSendMany,
+	"listtransactions":  rpcListTransactions,
+	"setlabel":          rpcSetLabel,
+	"listunspent":       rpcListUnspent,
+	"walletpassphrase":  rpcWalletPassphrase,
+	"walletlock":        rpcWalletLock,
+
+	"createrawtransaction": rpcCreateRawTransaction,
+	"decoderawtransaction": rpcDecodeRawTransaction,
+	"signrawtransaction":   rpcSignRawTransaction,
+	"sendrawtransaction":   rpcSendRawTransaction,
+}
+
+func init() {
+	// help 需要列出 RPCMethods, 不能直接写在 RPCMethods 的初始化中
+	RPCMethods["help"] = rpcHelp
+}
+
+// RPCServer serves JSON-RPC over HTTP on a running node
+//
+// 节点的 JSON-RPC 服务: 和P2P服务共用同一个区块链数据库, 钱包一直保存在内存中,
+// 所以 walletpassphrase 解锁之后在超时之前一直有效
+type RPCServer struct {
+	bc       *Blockchain
+	wallets  *Wallets
+	walletMu sync.Mutex // 钱包操作依次执行, 避免两个请求同时修改和保存钱包
+	user     string
+	password string
+}
+
+// StartRPCServer listens on the configured RPC address and serves requests in the background
+//
+// 没有配置用户名密码时生成一个随机的cookie写到数据目录中, 同一台机器上的命令行读取cookie进行认证
+func StartRPCServer(bc *Blockchain) error {
+	if len(Config.RPCListen) == 0 {
+		fmt.Println("rpc server disabled, set -rpclisten to enable it")
+		return nil
+	}
+
+	server := &RPCServer{bc: bc, wallets: CreateWallets(), user: Config.RPCUser, password: Config.RPCPassword}
+	server.wallets.ReadWalletsFromFile()
+
+	listener, err := net.Listen("tcp", Config.RPCListen)
+	if err != nil {
+		return fmt.Errorf("rpc listen %s failed, %w", Config.RPCListen, err)
+	}
+	if len(server.password) == 0 {
+		if err := server.writeCookie(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	httpServer := &http.Server{Handler: server, ReadTimeout: RPCREADTIMEOUT}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil {
+			fmt.Printf("rpc server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("rpc server listening on %s\n", Config.RPCListen)
+	return nil
+}
+
+// writeCookie creates fresh credentials and stores them as user:password in the cookie file
+func (s *RPCServer) writeCookie() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("generate rpc cookie failed, %w", err)
+	}
+	s.user, s.password = RPCCOOKIEUSER, hex.EncodeToString(secret)
+
+	// 先删除旧文件, 保证新文件的权限是0600
+	os.Remove(Config.RPCCookieFile())
+	err := os.WriteFile(Config.RPCCookieFile(), []byte(s.user+":"+s.password), 0600)
+	if err != nil {
+		return fmt.Errorf("write rpc cookie failed, %w", err)
+	}
+	return nil
+}
+
+// authorized checks the basic auth credentials of a request in constant time
+func (s *RPCServer) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+	return userOK && passwordOK
+}
+
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		time.Sleep(RPCAUTHFAILDELAY)
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// 事件订阅和 JSON-RPC 使用同一个端口和认证
+	if r.URL.Path == WEBSOCKETPATH {
+		s.serveEvents(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request RPCRequest
+	var response RPCResponse
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, RPCMAXBODYSIZE)).Decode(&request)
+	if err != nil {
+		response.Error = rpcErrorf(RPCERRPARSE, "parse request failed, %v", err)
+	} else {
+		response = s.call(request)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Printf("write rpc response failed: %v\n", err)
+	}
+}
+
+// call runs the method of a request
+func (s *RPCServer) call(request RPCRequest) RPCResponse {
+	response := RPCResponse{JSONRPC: request.JSONRPC, ID: request.ID}
+
+	handler, ok := RPCMethods[request.Method]
+	if !ok {
+		response.Error = rpcErrorf(RPCERRMETHODNOTFOUND, "method %q not found", request.Method)
+		return response
+	}
+
+	result, err := handler(s, request.Params)
+	if err != nil {
+		response.Error = rpcErrorFrom(err)
+		return response
+	}
+	response.Result, err = json.Marshal(result)
+	if err != nil {
+		response.Error = rpcErrorf(RPCERRINTERNAL, "encode result failed, %v", err)
+	}
+	return response
+}
+
+// parseParams decodes positional params into targets, the first required ones must be given
+//
+// 缺省的参数保持 targets 中原来的值, 所以调用前先把默认值写进去
+func parseParams(params []json.RawMessage, required int, targets ...interface{}) error {
+	if len(params) < required || len(params) > len(targets) {
+		return rpcErrorf(RPCERRINVALIDPARAMS, "want %d to %d params, got %d", required, len(targets), len(params))
+	}
+	for i, param := range params {
+		if err := json.Unmarshal(param, targets[i]); err != nil {
+			return rpcErrorf(RPCERRINVALIDPARAMS, "param %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// decodeHash parses a hex block hash or transaction id
+func decodeHash(name, value string) ([]byte, error) {
+	hash, err := hex.DecodeString(value)
+	if err != nil || len(hash) == 0 {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "invalid %s %q", name, value)
+	}
+	return hash, nil
+}
+
+// checkRPCAddress validates an address param
+func checkRPCAddress(address string) error {
+	if _, err := DecodeAddress(address); err != nil {
+		return rpcErrorf(RPCERRINVALIDADDRESS, "%v", err)
+	}
+	return nil
+}
+
+// ------------------------- 区块链 -------------------------
+
+func rpcGetBlockCount(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	return s.bc.GetLatestHeight()
+}
+
+func rpcGetBestBlockHash(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(s.bc.GetTopHash()), nil
+}
+
+func rpcGetBlockHash(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var height int64
+	if err := parseParams(params, 1, &height); err != nil {
+		return nil, err
+	}
+	block, err := s.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "%v", err)
+	}
+	return hex.EncodeToString(block.Hash), nil
+}
+
+// rpcGetBlock returns a block as JSON, or its serialized bytes in hex when verbose is false
+func rpcGetBlock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var hash string
+	verbose := true
+	if err := parseParams(params, 1, &hash, &verbose); err != nil {
+		return nil, err
+	}
+	blockHash, err := decodeHash("block hash", hash)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.bc.GetBlock(blockHash)
+	if err != nil {
+		return nil, rpcErrorf(RPCERRINVALIDADDRESS, "block %s is not found", hash)
+	}
+	if !verbose {
+		return hex.EncodeToString(block.Serialize()), nil
+	}
+	tipHeight, err := s.bc.GetLatestHeight()
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockJSON(&block, tipHeight), nil
+}
+
+// rpcGetTransaction looks a transaction up in the mempool and then in the main chain
+func rpcGetTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var txid string
+	if err := parseParams(params, 1, &txid); err != nil {
+		return nil, err
+	}
+	txID, err := decodeHash("txid", txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tipHeight, err := s.bc.GetLatestHeight()
+	if err != nil {
+		return nil, err
+	}
+	tx, ok := TxPool.Get(txID)
+	var block *Block
+	if !ok {
+		tx, block, err = s.bc.FindTxBlock(txID)
+		if err != nil {
+			return nil, rpcErrorf(RPCERRINVALIDADDRESS, "%v", err)
+		}
+	}
+	result := NewTransactionJSON(tx, block, tipHeight)
+	result.Hex = hex.EncodeToString(tx.Serialize())
+	return result, nil
+}
+
+func rpcGetBlockchainInfo(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	return blockchainInfo(s.bc)
+}
+
+// blockchainInfo summarizes the network and the tip of the chain, shared by RPC and REST
+func blockchainInfo(bc *Blockchain) (map[string]interface{}, error) {
+	height, err := bc.GetLatestHeight()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"chain":         Params.Name,
+		"blocks":        height,
+		"bestblockhash": hex.EncodeToString(bc.GetTopHash()),
+		"targetbits":    Params.TargetBits,
+	}, nil
+}
+
+func rpcGetMempoolInfo(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	return map[string]int{"size": TxPool.Count(), "bytes": TxPool.Bytes()}, nil
+}
+
+func rpcGetRawMempool(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	txids := []string{}
+	for _, tx := range TxPool.Transactions() {
+		txids = append(txids, hex.EncodeToString(tx.ID))
+	}
+	return txids, nil
+}
+
+// RPCPeer is the JSON form of a peer, round trip times are in milliseconds
+type RPCPeer struct {
+	Addr        string  `json:"addr"`
+	Misbehavior int     `json:"banscore"`
+	LastSeen    int64   `json:"lastseen"`
+	PingCount   int     `json:"pingcount"`
+	LastRTT     float64 `json:"lastrtt"`
+	MinRTT      float64 `json:"minrtt"`
+	AvgRTT      float64 `json:"avgrtt"`
+}
+
+func rpcGetPeerInfo(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	milliseconds := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+	peers := []RPCPeer{}
+	for _, peer := range Peers.Snapshot() {
+		peers = append(peers, RPCPeer{
+			Addr:        peer.Addr,
+			Misbehavior: peer.Misbehavior,
+			LastSeen:    peer.LastSeen.Unix(),
+			PingCount:   peer.PingCount,
+			LastRTT:     milliseconds(peer.LastRTT),
+			MinRTT:      milliseconds(peer.MinRTT),
+			AvgRTT:      milliseconds(peer.AvgRTT),
+		})
+	}
+	return peers, nil
+}
+
+func rpcListBanned(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	entries, err := s.bc.ListBanned()
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []BanEntry{}
+	}
+	return entries, nil
+}
+
+// rpcSetBan adds or removes a ban: [addr, "add"|"remove", bantime seconds]
+func rpcSetBan(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var addr string
+	command, banTime := "add", RPCDEFAULTBANTIME
+	if err := parseParams(params, 1, &addr, &command, &banTime); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case "add":
+		if banTime <= 0 {
+			return nil, rpcErrorf(RPCERRINVALIDPARAMS, "invalid bantime %d", banTime)
+		}
+		return nil, s.bc.BanPeer(addr, time.Duration(banTime)*time.Second, "manually banned")
+	case "remove":
+		return nil, s.bc.UnbanPeer(addr)
+	}
+	return nil, rpcErrorf(RPCERRINVALIDPARAMS, "invalid setban command %q, must be add or remove", command)
+}
+
+// ------------------------- 钱包 -------------------------
+
+// RPCAddressBalance is the balance of one address of the wallet
+type RPCAddressBalance struct {
+	Address   string `json:"address"`
+	Balance   int    `json:"balance"`
+	Change    bool   `json:"change,omitempty"`
+	WatchOnly bool   `json:"watchonly,omitempty"`
+}
+
+// walletBalances returns the balance of every address of the wallet, watch-only ones last
+func (s *RPCServer) walletBalances(includeWatchOnly bool) ([]RPCAddressBalance, error) {
+	utxoset := UTXOSet{s.bc}
+
+	addresses := s.wallets.getAllAddress()
+	sort.Strings(addresses)
+	balances := []RPCAddressBalance{}
+	for _, address := range addresses {
+		balance, err := addressBalance(&utxoset, address)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, RPCAddressBalance{Address: address, Balance: balance, Change: s.wallets.IsChangeAddress(address)})
+	}
+	if includeWatchOnly {
+		for _, address := range s.wallets.getWatchOnlyAddresses() {
+			balance, err := addressBalance(&utxoset, address)
+			if err != nil {
+				return nil, err
+			}
+			balances = append(balances, RPCAddressBalance{Address: address, Balance: balance, WatchOnly: true})
+		}
+	}
+	return balances, nil
+}
+
+// rpcGetBalance returns the balance of an address, or the total of the wallet: [address, includewatchonly]
+func rpcGetBalance(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var address string
+	includeWatchOnly := false
+	if err := parseParams(params, 0, &address, &includeWatchOnly); err != nil {
+		return nil, err
+	}
+	if len(address) > 0 {
+		if err := checkRPCAddress(address); err != nil {
+			return nil, err
+		}
+		utxoset := UTXOSet{s.bc}
+		return addressBalance(&utxoset, address)
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	balances, err := s.walletBalances(includeWatchOnly)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, balance := range balances {
+		total += balance.Balance
+	}
+	return total, nil
+}
+
+// rpcListBalances returns the balance of every address of the wallet: [includewatchonly]
+func rpcListBalances(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	includeWatchOnly := false
+	if err := parseParams(params, 0, &includeWatchOnly); err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	return s.walletBalances(includeWatchOnly)
+}
+
+// rpcGetNewAddress adds a receive or change address to the wallet: [format, change]
+func rpcGetNewAddress(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var format string
+	change := false
+	if err := parseParams(params, 0, &format, &change); err != nil {
+		return nil, err
+	}
+	if err := CheckAddressFormat(format); err != nil {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "%v", err)
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	address, err := s.wallets.NewAddress(change, format)
+	if err != nil {
+		return nil, err
+	}
+	if !s.wallets.SaveWalletsToFile() {
+		return nil, rpcErrorf(RPCERRMISC, "save wallet failed")
+	}
+	return address, nil
+}
+
+// RPCTxOptions are the optional settings of sendtoaddress and sendmany
+type RPCTxOptions struct {
+	ChangeAddress string   `json:"changeaddress"`
+	Dust          *int     `json:"dust"`
+	Selection     string   `json:"selection"`
+	Coins         []string `json:"coins"` // txid:index
+	Fee           int      `json:"fee"`
+	FeeSplit      string   `json:"feesplit"`
+}
+
+// txOptions checks the options and fills in the same defaults as the command line
+func (o RPCTxOptions) txOptions() (TxOptions, error) {
+	opts := TxOptions{
+		Change:    ChangePolicy{Address: o.ChangeAddress, DustThreshold: DEFAULTDUSTTHRESHOLD},
+		Selection: o.Selection,
+		Fee:       o.Fee,
+		FeeSplit:  o.FeeSplit,
+	}
+	if len(opts.Change.Address) == 0 {
+		opts.Change.Address = Config.ChangeAddress
+	}
+	if o.Dust != nil {
+		opts.Change.DustThreshold = *o.Dust
+	}
+	if len(opts.Selection) == 0 {
+		opts.Selection = DEFAULTSELECTION
+	}
+
+	if len(opts.Change.Address) > 0 {
+		if err := checkRPCAddress(opts.Change.Address); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Change.DustThreshold < 0 {
+		return opts, rpcErrorf(RPCERRINVALIDPARAMS, "invalid dust threshold %d", opts.Change.DustThreshold)
+	}
+	if _, ok := CoinSelections[opts.Selection]; !ok {
+		return opts, rpcErrorf(RPCERRINVALIDPARAMS, "invalid coin selection %q, must be largest, smallest, bnb or random", opts.Selection)
+	}
+	for _, coin := range o.Coins {
+		outpoint, err := ParseOutpoint(coin)
+		if err != nil {
+			return opts, rpcErrorf(RPCERRINVALIDPARAMS, "%v", err)
+		}
+		opts.Coins = append(opts.Coins, outpoint)
+	}
+	return opts, nil
+}
+
+// send signs a payment with the wallet of the node, puts it into the mempool and relays it
+//
+// 和命令行的 sendmany 相同, 只是交易直接进入当前节点的交易池, 矿工节点会立即挖矿
+func (s *RPCServer) send(from string, recipients []Recipient, options RPCTxOptions) (interface{}, error) {
+	if err := checkRPCAddress(from); err != nil {
+		return nil, err
+	}
+	opts, err := options.txOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	tx, _, err := CreateManyTransaction(from, recipients, opts, s.wallets, s.bc)
+	if err != nil {
+		return nil, err
+	}
+	// 钱包选币时不知道交易池中的交易已经花费了哪些输出, 先检查再记录到钱包中
+	if err := checkTxSpends(tx, s.bc); err != nil {
+		return nil, rpcErrorf(RPCERRVERIFY, "%v", err)
+	}
+	s.wallets.RecordTransaction(tx, s.bc)
+	if !s.wallets.SaveWalletsToFile() {
+		return nil, rpcErrorf(RPCERRMISC, "save wallet failed")
+	}
+
+	if err := relayTransaction(tx, "", s.bc); err != nil {
+		return nil, rpcErrorf(RPCERRVERIFY, "%v", err)
+	}
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// rpcSendToAddress pays one address: [from, to, amount, options]
+func rpcSendToAddress(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var from, to string
+	var amount int
+	var options RPCTxOptions
+	if err := parseParams(params, 3, &from, &to, &amount, &options); err != nil {
+		return nil, err
+	}
+	return s.send(from, []Recipient{{Address: to, Amount: amount}}, options)
+}
+
+// rpcSendMany pays several addresses with one transaction: [from, [{"address":...,"amount":...}], options]
+func rpcSendMany(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var from string
+	var recipients []Recipient
+	var options RPCTxOptions
+	if err := parseParams(params, 2, &from, &recipients, &options); err != nil {
+		return nil, err
+	}
+	return s.send(from, recipients, options)
+}
+
+// RPCJournalEntry is the JSON form of a wallet transaction
+type RPCJournalEntry struct {
+	TxID           string   `json:"txid"`
+	Direction      string   `json:"direction"`
+	Amount         int      `json:"amount"`
+	Fee            int      `json:"fee"`
+	Confirmations  int64    `json:"confirmations"`
+	BlockHash      string   `json:"blockhash,omitempty"`
+	Time           int64    `json:"time"`
+	Counterparties []string `json:"counterparties"`
+	Addresses      []string `json:"addresses"`
+	ChangeOutputs  []int    `json:"changeoutputs,omitempty"`
+	Label          string   `json:"label,omitempty"`
+	WatchOnly      bool     `json:"watchonly,omitempty"`
+}
+
+// syncJournal brings the wallet journal up to the tip, saving the wallet when it changed; caller must hold walletMu
+func (s *RPCServer) syncJournal() error {
+	connected, disconnected := s.wallets.SyncJournal(s.bc)
+	if (connected > 0 || disconnected > 0) && !s.wallets.SaveWalletsToFile() {
+		return rpcErrorf(RPCERRMISC, "save wallet failed")
+	}
+	return nil
+}
+
+// rpcListTransactions returns the most recent wallet transactions: [count, skip, includewatchonly]
+func rpcListTransactions(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	count, skip, includeWatchOnly := 10, 0, false
+	if err := parseParams(params, 0, &count, &skip, &includeWatchOnly); err != nil {
+		return nil, err
+	}
+	if count < 0 || skip < 0 {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "count and skip must not be negative")
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	if err := s.syncJournal(); err != nil {
+		return nil, err
+	}
+	tipHeight, err := s.bc.GetLatestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []RPCJournalEntry{}
+	for _, entry := range RecentTransactions(s.wallets.Transactions(), count, skip, includeWatchOnly) {
+		described := RPCJournalEntry{
+			TxID:           hex.EncodeToString(entry.TxID),
+			Direction:      entry.Direction,
+			Amount:         entry.Amount,
+			Fee:            entry.Fee,
+			Confirmations:  entry.Confirmations(tipHeight),
+			Time:           entry.Time,
+			Counterparties: append([]string{}, entry.Counterparties...),
+			Addresses:      append([]string{}, entry.Addresses...),
+			ChangeOutputs:  append([]int{}, entry.ChangeOutputs...),
+			Label:          s.wallets.LabelOf(entry),
+			WatchOnly:      entry.WatchOnly,
+		}
+		if entry.BlockHash != nil {
+			described.BlockHash = hex.EncodeToString(entry.BlockHash)
+		}
+		entries = append(entries, described)
+	}
+	return entries, nil
+}
+
+// rpcSetLabel labels a wallet transaction or an address: [target, label]
+func rpcSetLabel(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var target, label string
+	if err := parseParams(params, 2, &target, &label); err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	if err := s.syncJournal(); err != nil {
+		return nil, err
+	}
+	if err := s.wallets.SetLabel(target, label); err != nil {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "%v", err)
+	}
+	if !s.wallets.SaveWalletsToFile() {
+		return nil, rpcErrorf(RPCERRMISC, "save wallet failed")
+	}
+	return nil, nil
+}
+
+// RPCUnspent is an unspent output of the wallet
+type RPCUnspent struct {
+	TxID    string `json:"txid"`
+	Vout    int    `json:"vout"`
+	Value   int    `json:"value"`
+	Address string `json:"address"`
+}
+
+// rpcListUnspent returns the unspent outputs of the wallet, or of one address: [address]
+func rpcListUnspent(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var address string
+	if err := parseParams(params, 0, &address); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{address}
+	if len(address) > 0 {
+		if err := checkRPCAddress(address); err != nil {
+			return nil, err
+		}
+	} else {
+		s.walletMu.Lock()
+		addresses = append(s.wallets.getAllAddress(), s.wallets.getWatchOnlyAddresses()...)
+		s.walletMu.Unlock()
+		sort.Strings(addresses)
+	}
+
+	unspent := []RPCUnspent{}
+	for _, addr := range addresses {
+		pubkeyHash, err := DecodeAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		for _, coin := range s.bc.FindSpendableCoins(pubkeyHash) {
+			unspent = append(unspent, RPCUnspent{TxID: hex.EncodeToString(coin.TxID), Vout: coin.Index, Value: coin.Value, Address: addr})
+		}
+	}
+	return unspent, nil
+}
+
+// rpcWalletPassphrase unlocks the wallet of the node: [passphrase, timeout seconds]
+func rpcWalletPassphrase(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var passphrase string
+	var timeout int64
+	if err := parseParams(params, 2, &passphrase, &timeout); err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "timeout must be positive")
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	return nil, s.wallets.WalletPassphrase(passphrase, time.Duration(timeout)*time.Second)
+}
+
+func rpcWalletLock(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	if !s.wallets.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+	s.wallets.WalletLock()
+	return nil, nil
+}
+
+// ------------------------- 原始交易 -------------------------
+
+// rpcCreateRawTransaction builds an unsigned transaction: [["txid:vout", ...], [{"address":...,"amount":...}]]
+func rpcCreateRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var inputs []string
+	var recipients []Recipient
+	if err := parseParams(params, 2, &inputs, &recipients); err != nil {
+		return nil, err
+	}
+
+	outpoints := []Outpoint{}
+	for _, input := range inputs {
+		outpoint, err := ParseOutpoint(input)
+		if err != nil {
+			return nil, rpcErrorf(RPCERRINVALIDPARAMS, "%v", err)
+		}
+		outpoints = append(outpoints, outpoint)
+	}
+	tx, err := NewRawTransaction(outpoints, recipients)
+	if err != nil {
+		return nil, rpcErrorf(RPCERRINVALIDPARAMS, "%v", err)
+	}
+	return hex.EncodeToString(tx.Serialize()), nil
+}
+
+func rpcDecodeRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var rawHex string
+	if err := parseParams(params, 1, &rawHex); err != nil {
+		return nil, err
+	}
+	tx, err := DecodeRawTransaction(rawHex)
+	if err != nil {
+		return nil, rpcErrorf(RPCERRDESERIALIZATION, "%v", err)
+	}
+	return NewTransactionJSON(tx, nil, -1), nil
+}
+
+// rpcSignRawTransaction signs the inputs spendable by the wallet of the node: [hex, from]
+func rpcSignRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var rawHex, from string
+	if err := parseParams(params, 1, &rawHex, &from); err != nil {
+		return nil, err
+	}
+	tx, err := DecodeRawTransaction(rawHex)
+	if err != nil {
+		return nil, rpcErrorf(RPCERRDESERIALIZATION, "%v", err)
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	complete, err := SignRawTransaction(tx, from, s.wallets, s.bc)
+	if err != nil {
+		return nil, err
+	}
+	return SignedRawTx{Hex: hex.EncodeToString(tx.Serialize()), TxID: hex.EncodeToString(tx.ID), Complete: complete}, nil
+}
+
+// rpcSendRawTransaction puts a signed transaction into the mempool and relays it, it is fine to send it again
+func rpcSendRawTransaction(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	var rawHex string
+	if err := parseParams(params, 1, &rawHex); err != nil {
+		return nil, err
+	}
+	tx, err := DecodeRawTransaction(rawHex)
+	if err != nil {
+		return nil, rpcErrorf(RPCERRDESERIALIZATION, "%v", err)
+	}
+	if err := CheckRawTransaction(tx, s.bc); err != nil {
+		return nil, rpcErrorf(RPCERRVERIFY, "%v", err)
+	}
+
+	if err := relayTransaction(tx, "", s.bc); err != nil {
+		return nil, rpcErrorf(RPCERRVERIFY, "%v", err)
+	}
+	return hex.EncodeToString(tx.ID), nil
+}
+
+func rpcHelp(s *RPCServer, params []json.RawMessage) (interface{}, error) {
+	methods := []string{}
+	for method := range RPCMethods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestRPCServer returns an RPC server whose wallet owns one address funded by a coinbase
+func newTestRPCServer(t *testing.T) (*RPCServer, string) {
+	t.Helper()
+
+	useTestWalletFile(t)
+	savedPool, savedNodes := TxPool, KnownNodes
+	TxPool, KnownNodes = NewMempool(), []string{}
+	t.Cleanup(func() { TxPool, KnownNodes = savedPool, savedNodes })
+
+	bc := newTestBlockchain(t)
+	wallets := CreateWallets()
+	wallets.loaded = true
+	address, err := wallets.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase, err := CoinBaseTx(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.AddBlock([]*Transaction{coinbase})
+	utxoSet := UTXOSet{bc}
+	if err := utxoSet.StoreUTXO(); err != nil {
+		t.Fatal(err)
+	}
+	return &RPCServer{bc: bc, wallets: wallets, user: "user", password: "secret"}, address
+}
+
+// rawParams encodes positional params
+func rawParams(t *testing.T, params ...interface{}) []json.RawMessage {
+	t.Helper()
+
+	raw := []json.RawMessage{}
+	for _, param := range params {
+		data, err := json.Marshal(param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, data)
+	}
+	return raw
+}
+
+// rpcErrorCode returns the code of an RPC error, 0 for success
+func rpcErrorCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return rpcErrorFrom(err).Code
+}
+
+func TestRPCAuth(t *testing.T) {
+	server := &RPCServer{bc: newTestBlockchain(t), user: "user", password: "secret"}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		password string
+		body     string
+		status   int
+		code     int
+	}{
+		{name: "no credentials", method: http.MethodPost, body: `{"method":"getblockcount"}`, status: http.StatusUnauthorized},
+		{name: "wrong password", method: http.MethodPost, user: "user", password: "guess", body: `{"method":"getblockcount"}`, status: http.StatusUnauthorized},
+		{name: "wrong user", method: http.MethodPost, user: "admin", password: "secret", body: `{"method":"getblockcount"}`, status: http.StatusUnauthorized},
+		{name: "get", method: http.MethodGet, user: "user", password: "secret", status: http.StatusMethodNotAllowed},
+		{name: "authorized", method: http.MethodPost, user: "user", password: "secret", body: `{"method":"getblockcount","id":1}`, status: http.StatusOK},
+		{name: "parse error", method: http.MethodPost, user: "user", password: "secret", body: `{"method":`, status: http.StatusOK, code: RPCERRPARSE},
+		{name: "unknown method", method: http.MethodPost, user: "user", password: "secret", body: `{"method":"stop"}`, status: http.StatusOK, code: RPCERRMETHODNOTFOUND},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := http.NewRequest(tt.method, httpServer.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.user) > 0 {
+				request.SetBasicAuth(tt.user, tt.password)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, tt.status)
+			}
+			if response.StatusCode == http.StatusUnauthorized && response.Header.Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if response.StatusCode != http.StatusOK {
+				return
+			}
+
+			var decoded RPCResponse
+			if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+				t.Fatal(err)
+			}
+			code := 0
+			if decoded.Error != nil {
+				code = decoded.Error.Code
+			}
+			if code != tt.code {
+				t.Errorf("error code %d, want %d: %v", code, tt.code, decoded.Error)
+			}
+			if tt.code == 0 && string(decoded.Result) != "0" {
+				t.Errorf("getblockcount = %s, want 0", decoded.Result)
+			}
+		})
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		count   int
+		skip    int
+		watch   bool
+		wantErr bool
+	}{
+		// 缺省的参数保留默认值
+		{name: "defaults", params: `[]`, count: 10},
+		{name: "first only", params: `[3]`, count: 3},
+		{name: "all", params: `[3, 2, true]`, count: 3, skip: 2, watch: true},
+		{name: "too many", params: `[3, 2, true, 1]`, wantErr: true},
+		{name: "wrong type", params: `["3"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params []json.RawMessage
+			if err := json.Unmarshal([]byte(tt.params), &params); err != nil {
+				t.Fatal(err)
+			}
+			count, skip, watch := 10, 0, false
+			err := parseParams(params, 0, &count, &skip, &watch)
+			if tt.wantErr {
+				if rpcErrorCode(err) != RPCERRINVALIDPARAMS {
+					t.Errorf("parseParams error %v, want code %d", err, RPCERRINVALIDPARAMS)
+				}
+				return
+			}
+			if err != nil || count != tt.count || skip != tt.skip || watch != tt.watch {
+				t.Errorf("parseParams = %d %d %v %v, want %d %d %v", count, skip, watch, err, tt.count, tt.skip, tt.watch)
+			}
+		})
+	}
+
+	// 必需的参数不能缺省
+	var hash string
+	if err := parseParams(nil, 1, &hash); rpcErrorCode(err) != RPCERRINVALIDPARAMS {
+		t.Errorf("parseParams without a required param error %v, want code %d", err, RPCERRINVALIDPARAMS)
+	}
+}
+
+func TestRPCErrorFrom(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("sign failed, %w", ErrWalletLocked), RPCERRWALLETUNLOCKNEEDED},
+		{ErrWrongPassphrase, RPCERRWRONGPASSPHRASE},
+		{ErrWalletNotEncrypted, RPCERRWRONGENCSTATE},
+		{ErrWalletEncrypted, RPCERRWRONGENCSTATE},
+		{fmt.Errorf("%w, selected 1, want 2", ErrInsufficientFunds), RPCERRINSUFFICIENTFUNDS},
+		{rpcErrorf(RPCERRINVALIDADDRESS, "bad address"), RPCERRINVALIDADDRESS},
+		{errors.New("disk full"), RPCERRMISC},
+	}
+	for _, tt := range tests {
+		if code := rpcErrorCode(tt.err); code != tt.code {
+			t.Errorf("rpcErrorFrom(%v) code %d, want %d", tt.err, code, tt.code)
+		}
+	}
+}
+
+func TestRPCSend(t *testing.T) {
+	s, from := newTestRPCServer(t)
+	to := PubkeyHashToAddress(make([]byte, PUBKEYHASHLENGTH), Params.AddressVersion)
+
+	tests := []struct {
+		name   string
+		params []json.RawMessage
+		code   int
+	}{
+		{name: "missing amount", params: rawParams(t, from, to), code: RPCERRINVALIDPARAMS},
+		{name: "invalid from", params: rawParams(t, "nope", to, 10), code: RPCERRINVALIDADDRESS},
+		{name: "invalid selection", params: rawParams(t, from, to, 10, RPCTxOptions{Selection: "first"}), code: RPCERRINVALIDPARAMS},
+		{name: "insufficient funds", params: rawParams(t, from, to, COINBASEFEE+1), code: RPCERRINSUFFICIENTFUNDS},
+		{name: "paid", params: rawParams(t, from, to, 10)},
+		// 钱包不知道交易池已经花费了这个输出, 第二次付款和第一次双花
+		{name: "double spend", params: rawParams(t, from, to, 10), code: RPCERRVERIFY},
+	}
+	var txID string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := rpcSendToAddress(s, tt.params)
+			if code := rpcErrorCode(err); code != tt.code {
+				t.Fatalf("sendtoaddress error %v, want code %d", err, tt.code)
+			}
+			if err == nil {
+				txID = result.(string)
+			}
+		})
+	}
+
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, ok := TxPool.Get(id)
+	if !ok {
+		t.Fatalf("transaction %s is not in the mempool", txID)
+	}
+	if entries := s.wallets.Transactions(); len(entries) != 1 || entries[0].Direction != TXDIRECTIONSEND {
+		t.Errorf("wallet journal %+v, want the send", entries)
+	}
+
+	// 再次发送同一笔交易没有问题; 修改过的交易ID对不上, 重新计算ID之后签名对不上
+	rawHex := hex.EncodeToString(tx.Serialize())
+	tampered := *tx
+	tampered.Out = append([]TXoutput{{Value: tx.Out[0].Value + 1, PublickeyHash: tx.Out[0].PublickeyHash}}, tx.Out[1:]...)
+	rehashed := tampered
+	rehashed.ID = txIDOf(&rehashed)
+	rawTests := []struct {
+		name string
+		hex  string
+		code int
+	}{
+		{name: "resend", hex: rawHex},
+		{name: "not hex", hex: "zz", code: RPCERRDESERIALIZATION},
+		{name: "tampered", hex: hex.EncodeToString(tampered.Serialize()), code: RPCERRDESERIALIZATION},
+		{name: "tampered and rehashed", hex: hex.EncodeToString(rehashed.Serialize()), code: RPCERRVERIFY},
+	}
+	for _, tt := range rawTests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := rpcSendRawTransaction(s, rawParams(t, tt.hex))
+			if code := rpcErrorCode(err); code != tt.code {
+				t.Fatalf("sendrawtransaction error %v, want code %d", err, tt.code)
+			}
+			if err == nil && result != txID {
+				t.Errorf("sendrawtransaction = %v, want %s", result, txID)
+			}
+		})
+	}
+	if TxPool.Count() != 1 {
+		t.Errorf("mempool has %d transactions, want 1", TxPool.Count())
+	}
+}
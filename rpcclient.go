@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RPCDIALTIMEOUT   = time.Second     // 探测节点是否在运行的超时时间
+	RPCCLIENTTIMEOUT = 2 * time.Minute // 矿工节点收到交易之后会先挖矿再回复
+)
+
+// RemoteCommands are the commands the CLI forwards to a running node over RPC
+//
+// 节点运行时区块链数据库被节点锁定, 只有这些命令可以通过RPC执行, 其他命令需要先停止节点
+var RemoteCommands = map[string]bool{
+	"rpc":              true,
+	"getlatestheight":  true,
+	"gettx":            true,
+	"getbalance":       true,
+	"sendtx":           true,
+	"sendmany":         true,
+	"createwallet":     true,
+	"getnewaddress":    true,
+	"listaddress":      true,
+	"listtransactions": true,
+	"setlabel":         true,
+	"listunspent":      true,
+	"createrawtx":      true,
+	"decoderawtx":      true,
+	"signrawtx":        true,
+	"sendrawtx":        true,
+	"createpsbt":       true,
+	"signpsbt":         true,
+	"combinepsbt":      true,
+	"finalizepsbt":     true,
+	"inspectpsbt":      true,
+	"listbanned":       true,
+	"setban":           true,
+	"walletpassphrase": true,
+	"walletlock":       true,
+}
+
+// RPCClient calls the JSON-RPC server of a node
+type RPCClient struct {
+	URL      string
+	User     string
+	Password string
+
+	client *http.Client
+	nextID int64
+}
+
+// NewRPCClient creates a client of the RPC server at addr
+func NewRPCClient(addr, user, password string) *RPCClient {
+	return &RPCClient{
+		URL:      "http://" + addr,
+		User:     user,
+		Password: password,
+		client:   &http.Client{Timeout: RPCCLIENTTIMEOUT},
+	}
+}
+
+// ConnectRPC returns a client of the node running with the current configuration, nil if no node is running
+//
+// 没有配置密码时读取节点写入的cookie文件; 没有cookie文件或者连接不上RPC端口都说明节点没有运行
+func ConnectRPC() *RPCClient {
+	if len(Config.RPCListen) == 0 {
+		return nil
+	}
+
+	user, password := Config.RPCUser, Config.RPCPassword
+	if len(password) == 0 {
+		cookie, err := os.ReadFile(Config.RPCCookieFile())
+		if err != nil {
+			return nil
+		}
+		var ok bool
+		user, password, ok = strings.Cut(strings.TrimSpace(string(cookie)), ":")
+		if !ok {
+			return nil
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", Config.RPCListen, RPCDIALTIMEOUT)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+
+	return NewRPCClient(Config.RPCListen, user, password)
+}
+
+// Call runs a method on the node and decodes its result into result, which may be nil
+func (c *RPCClient) Call(method string, result interface{}, params ...interface{}) error {
+	request := RPCRequest{JSONRPC: "2.0", Method: method, Params: []json.RawMessage{}}
+	id, _ := json.Marshal(atomic.AddInt64(&c.nextID, 1))
+	request.ID = id
+	for _, param := range params {
+		encoded, err := json.Marshal(param)
+		if err != nil {
+			return fmt.Errorf("encode rpc param failed, %w", err)
+		}
+		request.Params = append(request.Params, encoded)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("encode rpc request failed, %w", err)
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create rpc request failed, %w", err)
+	}
+	httpRequest.SetBasicAuth(c.User, c.Password)
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := c.client.Do(httpRequest)
+	if err != nil {
+		return fmt.Errorf("rpc %s failed, %w", method, err)
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode == http.StatusUnauthorized {
+		return errors.New("rpc authentication failed, check -rpcuser and -rpcpassword or the cookie file")
+	}
+
+	var response RPCResponse
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return fmt.Errorf("decode rpc response failed, %s, %w", httpResponse.Status, err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("decode rpc result failed, %w", err)
+		}
+	}
+	return nil
+}
+
+// mustCall runs a method and exits on error
+func (c *RPCClient) mustCall(method string, result interface{}, params ...interface{}) {
+	if err := c.Call(method, result, params...); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// walletCall runs a wallet method with the wallet of the node unlocked by passphrase, and exits on error
+//
+// 和本地命令一样临时解锁钱包, 调用之后立即锁定; 钱包没有加密时忽略口令
+func (c *RPCClient) walletCall(passphrase, method string, result interface{}, params ...interface{}) {
+	unlocked := false
+	if len(passphrase) > 0 {
+		err := c.Call("walletpassphrase", nil, passphrase, int64(WALLETUNLOCKTIMEOUT/time.Second))
+		var rpcErr *RPCError
+		if err != nil && !(errors.As(err, &rpcErr) && rpcErr.Code == RPCERRWRONGENCSTATE) {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		unlocked = err == nil
+	}
+
+	err := c.Call(method, result, params...)
+	if unlocked {
+		c.Call("walletlock", nil)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// confirmedTransaction fetches a transaction of the main chain from the node
+func (c *RPCClient) confirmedTransaction(txID []byte) (*Transaction, error) {
+	var view TransactionJSON
+	if err := c.Call("gettransaction", &view, hex.EncodeToString(txID)); err != nil {
+		return nil, err
+	}
+	if view.Height == nil {
+		return nil, fmt.Errorf("transaction %x is not confirmed", txID)
+	}
+	return view.Transaction()
+}
+
+// CallRPC runs any RPC method with params given on the command line and prints the result
+//
+// 参数是合法的JSON时按照JSON传递, 否则作为字符串, 例如: rpc getblockhash 1, rpc getblock <hash> false
+func (cli *CLI) CallRPC(method string, args []string) {
+	params := []interface{}{}
+	for _, arg := range args {
+		if json.Valid([]byte(arg)) {
+			params = append(params, json.RawMessage(arg))
+		} else {
+			params = append(params, arg)
+		}
+	}
+
+	var result json.RawMessage
+	cli.RPC.mustCall(method, &result, params...)
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, result, "", "  "); err != nil {
+		fmt.Println(string(result))
+		return
+	}
+	fmt.Println(indented.String())
+}
+
+// ------------------------- 通过RPC执行的命令 -------------------------
+
+func (cli *CLI) remoteGetLatestHeight() {
+	var height int64
+	cli.RPC.mustCall("getblockcount", &height)
+	fmt.Printf("latest height: %d\n", height)
+}
+
+func (cli *CLI) remoteGetBalance(addr string) {
+	var balance int
+	cli.RPC.mustCall("getbalance", &balance, addr)
+	fmt.Printf("Balance of %s: %d\n", addr, balance)
+}
+
+func (cli *CLI) remoteGetWalletBalance(includeWatchOnly bool) {
+	var balances []RPCAddressBalance
+	cli.RPC.mustCall("listbalances", &balances, true)
+
+	spendable, watched := 0, 0
+	for _, balance := range balances {
+		if balance.WatchOnly {
+			watched += balance.Balance
+			fmt.Printf("Balance of %s: %d (watch-only)\n", balance.Address, balance.Balance)
+			continue
+		}
+		spendable += balance.Balance
+		fmt.Printf("Balance of %s: %d\n", balance.Address, balance.Balance)
+	}
+
+	fmt.Printf("spendable balance: %d\n", spendable)
+	fmt.Printf("watch-only balance: %d\n", watched)
+	if includeWatchOnly {
+		fmt.Printf("total balance: %d\n", spendable+watched)
+	}
+}
+
+func (cli *CLI) remoteListAddress() {
+	var balances []RPCAddressBalance
+	cli.RPC.mustCall("listbalances", &balances, true)
+
+	for _, balance := range balances {
+		switch {
+		case balance.Change:
+			fmt.Printf("address: %s (change)\n", balance.Address)
+		case balance.WatchOnly:
+			fmt.Printf("address: %s (watch-only)\n", balance.Address)
+		default:
+			fmt.Printf("address: %s\n", balance.Address)
+		}
+	}
+}
+
+func (cli *CLI) remoteNewAddress(change bool, format, passphrase string) string {
+	var address string
+	cli.RPC.walletCall(passphrase, "getnewaddress", &address, format, change)
+	return address
+}
+
+// remoteSendMany hands the payment to the node, which signs it with its wallet and relays it
+//
+// 节点运行时 -mine 不起作用: 交易进入节点的交易池, 由矿工节点打包
+func (cli *CLI) remoteSendMany(from string, recipients []Recipient, opts TxOptions, passphrase string) {
+	options := RPCTxOptions{
+		ChangeAddress: opts.Change.Address,
+		Dust:          &opts.Change.DustThreshold,
+		Selection:     opts.Selection,
+		Fee:           opts.Fee,
+		FeeSplit:      opts.FeeSplit,
+	}
+	for _, coin := range opts.Coins {
+		options.Coins = append(options.Coins, coin.String())
+	}
+
+	var txid string
+	cli.RPC.walletCall(passphrase, "sendmany", &txid, from, recipients, options)
+	fmt.Printf("transaction %s sent to %s\n", txid, Config.RPCListen)
+}
+
+func (cli *CLI) remoteListTransactions(count, skip int, includeWatchOnly bool) {
+	var entries []RPCJournalEntry
+	cli.RPC.mustCall("listtransactions", &entries, count, skip, includeWatchOnly)
+
+	for _, entry := range entries {
+		fmt.Printf("%s %-8s %+d fee=%d confirmations=%d txid=%s",
+			time.Unix(entry.Time, 0).UTC().Format(time.RFC3339), entry.Direction, entry.Amount, entry.Fee, entry.Confirmations, entry.TxID)
+		if len(entry.Counterparties) > 0 {
+			fmt.Printf(" counterparty=%s", strings.Join(entry.Counterparties, ","))
+		}
+		if len(entry.ChangeOutputs) > 0 {
+			fmt.Printf(" change=%s", formatIndexes(entry.ChangeOutputs))
+		}
+		if len(entry.Label) > 0 {
+			fmt.Printf(" label=%q", entry.Label)
+		}
+		if entry.WatchOnly {
+			fmt.Printf(" (watch-only)")
+		}
+		fmt.Println()
+	}
+}
+
+func (cli *CLI) remoteSetLabel(target, label string) {
+	cli.RPC.mustCall("setlabel", nil, target, label)
+	if len(label) == 0 {
+		fmt.Printf("label of %s removed\n", target)
+		return
+	}
+	fmt.Printf("%s labelled %q\n", target, label)
+}
+
+func (cli *CLI) remoteListUnspent(address string) {
+	var unspent []RPCUnspent
+	cli.RPC.mustCall("listunspent", &unspent, address)
+	for _, coin := range unspent {
+		fmt.Printf("%s:%d %d %s\n", coin.TxID, coin.Vout, coin.Value, coin.Address)
+	}
+}
+
+func (cli *CLI) remoteListBanned() {
+	var entries []BanEntry
+	cli.RPC.mustCall("listbanned", &entries)
+	if len(entries) == 0 {
+		fmt.Println("no banned peers")
+		return
+	}
+	for _, entry := range entries {
+		fmt.Println(entry.String())
+	}
+}
+
+func (cli *CLI) remoteSetBan(addr, command string, duration time.Duration) {
+	cli.RPC.mustCall("setban", nil, addr, command, int64(duration/time.Second))
+	fmt.Println("Success!")
+}
+
+func (cli *CLI) remoteWalletPassphrase(passphrase string, timeout time.Duration) {
+	cli.RPC.mustCall("walletpassphrase", nil, passphrase, int64(timeout/time.Second))
+	fmt.Printf("wallet unlocked for %v\n", timeout)
+}
+
+func (cli *CLI) remoteWalletLock() {
+	cli.RPC.mustCall("walletlock", nil)
+	fmt.Println("wallet locked")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	FEESPLITSENDER       = "sender"       // 付款人在付款金额之外另外支付手续费
+	FEESPLITEQUAL        = "equal"        // 从每个收款人的金额中平均扣除手续费
+	FEESPLITPROPORTIONAL = "proportional" // 按照收款金额的比例从收款人的金额中扣除手续费
+
+	DEFAULTFEESPLIT = FEESPLITSENDER
+)
+
+var (
+	ErrNoRecipients = errors.New("no recipients")
+)
+
+// Recipient is one payment of a transaction
+//
+// 收款人: 收款地址和金额
+type Recipient struct {
+	Address string
+	Amount  int
+}
+
+// FeeSplit returns how much of the fee each recipient pays
+//
+// 手续费分摊策略: 返回每个收款人需要承担的手续费
+type FeeSplit func(amounts []int, fee int) []int
+
+// FeeSplits are the policies available to sendmany -feesplit
+var FeeSplits = map[string]FeeSplit{
+	FEESPLITSENDER:       splitFeeSender,
+	FEESPLITEQUAL:        splitFeeEqual,
+	FEESPLITPROPORTIONAL: splitFeeProportional,
+}
+
+// splitFeeSender leaves the payments untouched, the sender adds the fee on top
+func splitFeeSender(amounts []int, _ int) []int {
+	return make([]int, len(amounts))
+}
+
+// splitFeeEqual charges every recipient the same share, the first ones pay the remainder
+func splitFeeEqual(amounts []int, fee int) []int {
+	shares := make([]int, len(amounts))
+	for i := range shares {
+		shares[i] = fee / len(amounts)
+		if i < fee%len(amounts) {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+// splitFeeProportional charges every recipient in proportion to its amount
+//
+// 按比例分摊之后向下取整, 剩下的零头由前面的收款人每人多付1
+func splitFeeProportional(amounts []int, fee int) []int {
+	total := 0
+	for _, amount := range amounts {
+		total += amount
+	}
+
+	shares := make([]int, len(amounts))
+	charged := 0
+	for i, amount := range amounts {
+		shares[i] = fee * amount / total
+		charged += shares[i]
+	}
+	for i := 0; charged < fee; i++ {
+		shares[i%len(shares)]++
+		charged++
+	}
+	return shares
+}
+
+// checkRecipient validates the address and amount of a payment
+func checkRecipient(recipient Recipient) error {
+	if _, err := DecodeAddress(recipient.Address); err != nil {
+		return err
+	}
+	if recipient.Amount <= 0 {
+		return fmt.Errorf("invalid amount %d for %s", recipient.Amount, recipient.Address)
+	}
+	return nil
+}
+
+// paymentOutputs creates an output for every recipient after taking its share of the fee,
+// returning the outputs and the amount the inputs must cover
+//
+// 为每个收款人创建交易输出; 返回的总额 = 所有收款输出 + 手续费, 也就是交易输入至少需要的金额
+func paymentOutputs(recipients []Recipient, fee int, split string) ([]TXoutput, int, error) {
+	if len(recipients) == 0 {
+		return nil, 0, ErrNoRecipients
+	}
+	if fee < 0 {
+		return nil, 0, fmt.Errorf("invalid fee %d", fee)
+	}
+	if len(split) == 0 {
+		split = DEFAULTFEESPLIT
+	}
+	splitFee, ok := FeeSplits[split]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown fee split %q", split)
+	}
+
+	// 同一个地址出现两次多半是付款名单写错了
+	seen := make(map[string]bool)
+	amounts := make([]int, 0, len(recipients))
+	for i, recipient := range recipients {
+		if err := checkRecipient(recipient); err != nil {
+			return nil, 0, fmt.Errorf("recipient %d: %w", i+1, err)
+		}
+		if seen[recipient.Address] {
+			return nil, 0, fmt.Errorf("recipient %d: duplicate address %s", i+1, recipient.Address)
+		}
+		seen[recipient.Address] = true
+		amounts = append(amounts, recipient.Amount)
+	}
+
+	shares := splitFee(amounts, fee)
+	outputs := make([]TXoutput, 0, len(recipients))
+	total := fee
+	for i, recipient := range recipients {
+		value := recipient.Amount - shares[i]
+		if value <= 0 {
+			return nil, 0, fmt.Errorf("recipient %d: amount %d of %s does not cover its fee share %d", i+1, recipient.Amount, recipient.Address, shares[i])
+		}
+
+		output := TXoutput{value, nil}
+		if err := output.LockAddress(recipient.Address); err != nil {
+			return nil, 0, fmt.Errorf("recipient %d: %w", i+1, err)
+		}
+		outputs = append(outputs, output)
+		total += value
+	}
+
+	return outputs, total, nil
+}
+
+// parseRecipient parses an address and an amount
+func parseRecipient(address, amount string) (Recipient, error) {
+	value, err := strconv.Atoi(strings.TrimSpace(amount))
+	if err != nil {
+		return Recipient{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	recipient := Recipient{Address: strings.TrimSpace(address), Amount: value}
+	return recipient, checkRecipient(recipient)
+}
+
+// ParseRecipients parses a comma separated list of address:amount pairs
+//
+// 解析收款人列表, 例如: 1abc...:10,1def...:20
+func ParseRecipients(list string) ([]Recipient, error) {
+	recipients := []Recipient{}
+	for i, pair := range strings.Split(list, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+		address, amount, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("recipient %d: want address:amount, got %q", i+1, pair)
+		}
+		recipient, err := parseRecipient(address, amount)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %d: %w", i+1, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	return recipients, nil
+}
+
+// ReadRecipientsCSV reads recipients from a CSV file with address,amount rows
+//
+// 从CSV文件读取收款人, 每行 地址,金额; # 开头的行是注释, 第一行可以是 address,amount 表头
+func ReadRecipientsCSV(file string) ([]Recipient, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open recipients file failed, %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	recipients := []Recipient{}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read recipients file failed, %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+			continue
+		}
+
+		recipient, err := parseRecipient(record[0], record[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", file, line, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	return recipients, nil
+}
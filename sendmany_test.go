@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFeeSplits(t *testing.T) {
+	tests := []struct {
+		split   string
+		amounts []int
+		fee     int
+		want    []int
+	}{
+		{FEESPLITSENDER, []int{100, 50}, 7, []int{0, 0}},
+		{FEESPLITEQUAL, []int{100, 50, 10}, 9, []int{3, 3, 3}},
+		// 零头由前面的收款人每人多付1
+		{FEESPLITEQUAL, []int{100, 50, 10}, 11, []int{4, 4, 3}},
+		{FEESPLITPROPORTIONAL, []int{300, 100}, 8, []int{6, 2}},
+		{FEESPLITPROPORTIONAL, []int{100, 100, 100}, 10, []int{4, 3, 3}},
+		{FEESPLITPROPORTIONAL, []int{1, 1000}, 3, []int{1, 2}},
+	}
+	for _, test := range tests {
+		shares := FeeSplits[test.split](test.amounts, test.fee)
+		if !reflect.DeepEqual(shares, test.want) {
+			t.Errorf("%s split of %d over %v = %v, want %v", test.split, test.fee, test.amounts, shares, test.want)
+		}
+	}
+}
+
+func TestPaymentOutputs(t *testing.T) {
+	first := PubkeyHashToAddress(bytes.Repeat([]byte{1}, PUBKEYHASHLENGTH), Params.AddressVersion)
+	second := PubkeyHashToAddress(bytes.Repeat([]byte{2}, PUBKEYHASHLENGTH), Params.AddressVersion)
+	recipients := []Recipient{{first, 30}, {second, 10}}
+
+	tests := []struct {
+		name       string
+		recipients []Recipient
+		fee        int
+		split      string
+		values     []int
+		total      int
+		wantErr    bool
+	}{
+		// 付款人另外支付手续费, 输入需要覆盖付款和手续费
+		{name: "sender", recipients: recipients, fee: 4, values: []int{30, 10}, total: 44},
+		// 收款人承担手续费, 输入只需要覆盖付款金额
+		{name: "equal", recipients: recipients, fee: 4, split: FEESPLITEQUAL, values: []int{28, 8}, total: 40},
+		{name: "proportional", recipients: recipients, fee: 4, split: FEESPLITPROPORTIONAL, values: []int{27, 9}, total: 40},
+		{name: "share exceeds amount", recipients: recipients, fee: 20, split: FEESPLITEQUAL, wantErr: true},
+		{name: "no recipients", fee: 1, wantErr: true},
+		{name: "negative fee", recipients: recipients, fee: -1, wantErr: true},
+		{name: "unknown split", recipients: recipients, fee: 1, split: "payee", wantErr: true},
+		{name: "duplicate address", recipients: []Recipient{{first, 1}, {first, 2}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputs, total, err := paymentOutputs(test.recipients, test.fee, test.split)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("paymentOutputs error %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var values []int
+			for _, output := range outputs {
+				values = append(values, output.Value)
+			}
+			if !reflect.DeepEqual(values, test.values) || total != test.total {
+				t.Errorf("paymentOutputs = %v total %d, want %v total %d", values, total, test.values, test.total)
+			}
+		})
+	}
+}
+
+func TestReadRecipients(t *testing.T) {
+	first := PubkeyHashToAddress(bytes.Repeat([]byte{1}, PUBKEYHASHLENGTH), Params.AddressVersion)
+	second := PubkeyHashToAddress(bytes.Repeat([]byte{2}, PUBKEYHASHLENGTH), Params.AddressVersion)
+	want := []Recipient{{first, 30}, {second, 10}}
+
+	recipients, err := ParseRecipients(first + ":30, " + second + ":10,")
+	if err != nil || !reflect.DeepEqual(recipients, want) {
+		t.Errorf("ParseRecipients = %v %v, want %v", recipients, err, want)
+	}
+	for _, list := range []string{"", first, first + ":x", first + ":0", "nope:1"} {
+		if _, err := ParseRecipients(list); err == nil {
+			t.Errorf("ParseRecipients(%q) succeeded, want an error", list)
+		}
+	}
+	if _, err := ParseRecipients(" , "); !errors.Is(err, ErrNoRecipients) {
+		t.Errorf("ParseRecipients of an empty list error %v, want %v", err, ErrNoRecipients)
+	}
+
+	file := filepath.Join(t.TempDir(), "recipients.csv")
+	content := "address,amount\n# payroll\n" + first + ", 30\n" + second + ",10\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	recipients, err = ReadRecipientsCSV(file)
+	if err != nil || !reflect.DeepEqual(recipients, want) {
+		t.Errorf("ReadRecipientsCSV = %v %v, want %v", recipients, err, want)
+	}
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -19,16 +24,34 @@ type Version struct {
 const (
 	// 常量只能是布尔型、数字型（整数型、浮点型和复数型）和字符串型
 	// 切片、函数、指针、接口、结构体等都不可以是常量
-	NODEVERSION   = 1
-	COMMANDLENGTH = 16 // 命令的长度
+	NODEVERSION    = 1
+	COMMANDLENGTH  = 16       // 命令的长度
+	MAXMESSAGESIZE = 32 << 20 // 单条消息的最大长度，超过这个长度的消息视为违规
+
+	DIALTIMEOUT = 10 * time.Second // 连接其他节点的超时时间
+	READTIMEOUT = 30 * time.Second // 读取一条消息的超时时间，防止对方建立连接之后不发送数据
 )
 
 var (
 	// 公链的种子节点会预先设置好一些种子节点的地址，也可以向外部获取种子节点的地址
 	// 通过udp广播的方式，让其他节点知道自己的存在``
-	KnownNodes     = []string{"localhost:3000"} // 种子节点列表
-	CurrentNode    = ""                         // 当前节点
-	BlockInTransit [][]byte                     // 传输中的区块
+	KnownNodes     = []string{} // 种子节点列表, 由节点配置设置; 节点启动之后通过 knownNodes 等函数访问
+	CurrentNode    = ""         // 当前节点
+	BlockInTransit [][]byte     // 传输中的区块, 通过 setBlocksInTransit 和 nextBlockInTransit 访问
+
+	// 多个连接同时读写种子节点列表和传输中的区块
+	knownNodesMu     sync.Mutex
+	blockInTransitMu sync.Mutex
+
+	// 已经向其他节点请求过、但是还没有收到的区块; map[区块hash]请求的节点地址
+	// 收到不在这个列表中的区块，说明对方发送了我们没有请求的区块
+	blocksRequested   = make(map[string]string)
+	blocksRequestedMu sync.Mutex
+
+	miningMu sync.Mutex // 保证同一时间只有一个goroutine在挖矿
+	blockMu  sync.Mutex // 保证同一时间只处理一个其他节点发送的区块, 孤儿区块按顺序连接
+
+	errPeerBanned = errors.New("peer is banned")
 )
 
 func (ver *Version) String() string {
@@ -40,26 +63,31 @@ func (ver *Version) String() string {
 
 // StartServer starts a node
 //
-// 给定一个端口号，持续监听这个端口号
+// 监听配置中的地址, 并且向其他节点通告配置中的对外地址
 func StartServer(nodeID, minderAddr string, blockchain *Blockchain) bool {
-	nodeAddr := fmt.Sprintf("localhost:%s", nodeID)
-	CurrentNode = nodeAddr
+	listenAddr := Config.ListenAddr
+	CurrentNode = Config.AdvertiseAddr // 其他节点通过这个地址连接当前节点
+	MinerAddress = minderAddr
 
 	// 一个程序监听一个地址（比如"localhost:3000"）只是指这个程序已经准备好接收和处理发往这个地址的网络请求
-	listener, err := net.Listen("tcp", nodeAddr) // 监听当前节点的地址
+	listener, err := net.Listen("tcp", listenAddr) // 监听当前节点的地址
 	if err != nil {
-		panic(err)
+		fmt.Printf("listen %s failed: %v\n", listenAddr, err)
+		return false
 	}
 	defer listener.Close()
-	fmt.Printf("start to listen this address: %s\n", nodeAddr)
+	fmt.Printf("node %s start to listen this address: %s, advertise as %s\n", nodeID, listenAddr, CurrentNode)
 
 	// 如果当前节点不是种子节点, 需要向种子节点发送版本信息, 让种子节点知道新节点的存在
-	// 这里 nodeAddr 就是localhost:3000
-	if nodeAddr != KnownNodes[0] {
-		fmt.Println("current node is not a seed node, need to send version to seed node")
-		sendVersion(KnownNodes[0], blockchain)
+	for _, seed := range knownNodes() {
+		if seed != CurrentNode {
+			fmt.Printf("send version to seed node %s\n", seed)
+			sendVersion(seed, blockchain)
+		}
 	}
 
+	go keepAlive() // 定期向其他节点发送ping, 断开失联的节点
+
 	for {
 		connect, err := listener.Accept() // 接收到一个连接
 		if err != nil {
@@ -139,93 +167,194 @@ func bytesToCommand(bytes []byte) string {
 //
 // 向一个种子节点发送数据, 如果节点不存在, 那么就把这个节点从种子节点列表中删除
 func sendData(toAddr string, data []byte) bool {
-	connect, err := net.Dial("tcp", toAddr) // 使用TCP协议连接到toAddr
+	connect, err := dialPeer(toAddr) // 使用TCP协议连接到toAddr, 开启加密时使用TLS
 
 	// 如果这个地址无法连接，那么就把这个地址从种子节点列表中删除
 	if err != nil {
 		fmt.Printf("address %s is not available\n", toAddr)
 
-		updateNodes := []string{}
-
-		// 遍历种子节点列表，把无法连接的节点剔除
-		// updateNodes列表中就包含了所有可以连接的节点，而无法连接的节点（即toAddr）被排除在外。
-		for _, node := range KnownNodes {
-			if node != toAddr {
-				updateNodes = append(updateNodes, node)
-			}
-		}
-
-		KnownNodes = updateNodes // 返回剔除之后的种子节点列表
+		// 把无法连接的节点从种子节点列表中剔除
+		dropKnownNodes(func(node string) bool { return node == toAddr })
 		return false
 	}
 	defer connect.Close()
 
-	// 如果连接建立成功，向对方发送数据
-	_, err = io.Copy(connect, bytes.NewReader(data)) // send data to connect
+	// 如果连接建立成功，向对方发送数据; 消息前面加上网络标识, 其他网络的节点会丢弃这条消息
+	message := append(Params.Magic[:], data...)
+	_, err = io.Copy(connect, bytes.NewReader(message)) // send data to connect
 	if err != nil {
-		panic(err)
+		fmt.Printf("send data to %s failed: %v\n", toAddr, err)
+		return false
 	}
+	Metrics.MessagesSent.Add(messageLabel(bytesToCommand(data[:COMMANDLENGTH])), 1)
+	Metrics.BytesSent.Add(uint64(len(message)))
 
 	return true
 }
 
+// decodePayload decodes the gob payload following the command of a request
+//
+// 解码请求中命令之后的数据，无法解码的数据视为对方节点违规
+func decodePayload(request []byte, payload interface{}) error {
+	decoder := gob.NewDecoder(bytes.NewReader(request[COMMANDLENGTH:]))
+	err := decoder.Decode(payload)
+	if err != nil {
+		return misbehavior("", MISBEHAVIOR_MALFORMED, "malformed gob", err)
+	}
+
+	return nil
+}
+
+// checkPeer rejects messages from banned peers and returns the address punished for the message
+//
+// peer 是连接对端的 host, addrFrom 是消息中对方自己声明的地址; 任何一个被封禁了都直接丢弃这个消息;
+// addrFrom 和连接在同一个 host 上时惩罚分数和封禁记在 addrFrom 上, 同一台机器上的其他节点不受影响,
+// 否则只记在 peer 上, 对方无法通过伪造 addrFrom 逃避
+func checkPeer(peer, addrFrom string, bc *Blockchain) (string, error) {
+	peer = peerKey(peer, addrFrom)
+	if bc.IsBanned(peer) || bc.IsBanned(addrFrom) {
+		return peer, errPeerBanned
+	}
+	Peers.Seen(addrFrom)
+	return peer, nil
+}
+
 // handleConnection handles connection
 //
 // 从连接中读取数据，然后根据命令执行对应的函数
 func handleConnection(conn net.Conn, bc *Blockchain) {
+	defer conn.Close()
+
+	peer := remoteHost(conn)
+
+	// 0. 拒绝被封禁节点的连接
+	if bc.IsBanned(peer) {
+		fmt.Printf("reject connection from banned peer %s\n", peer)
+		return
+	}
 
-	// 1. 从连接中读取数据
-	request, err := io.ReadAll(conn)
+	// 超过 READTIMEOUT 还没有完成握手并读完消息的连接直接断开
+	conn.SetDeadline(time.Now().Add(READTIMEOUT))
+
+	// 1. 协商传输方式: 明文或者TLS加密
+	conn, err := acceptPeer(conn)
 	if err != nil {
-		fmt.Println("cannot connect to addr, %w", err)
-		panic(err)
+		fmt.Printf("reject connection from %s: %v\n", peer, err)
+		return
 	}
 
-	// 2. 从request中解析出命令
+	// 2. 从连接中读取数据, 最多读取 MAXMESSAGESIZE+1 个字节, 用于判断消息是否超长
+	request, err := io.ReadAll(io.LimitReader(conn, MAXMESSAGESIZE+1))
+	if err != nil {
+		fmt.Printf("read from %s failed: %v\n", peer, err)
+		return
+	}
+
+	Metrics.BytesReceived.Add(uint64(len(request)))
+
+	if len(request) > MAXMESSAGESIZE {
+		Misbehaving(bc, peer, MISBEHAVIOR_OVERSIZED, "oversized message")
+		return
+	}
+	// 其他网络的节点不算违规, 只是丢弃它的消息
+	if len(request) < MAGICLENGTH || !bytes.Equal(request[:MAGICLENGTH], Params.Magic[:]) {
+		fmt.Printf("drop message from %s: not from a %s node\n", peer, Params.Name)
+		return
+	}
+	request = request[MAGICLENGTH:]
+
+	if len(request) < COMMANDLENGTH {
+		Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "message shorter than command")
+		return
+	}
+
+	// 3. 从request中解析出命令
 	command := bytesToCommand(request[:COMMANDLENGTH])
+	Metrics.MessagesReceived.Add(messageLabel(command), 1)
 
-	// 3. 接收到来自其他节点的命令，根据命令执行对应的函数
+	// 4. 接收到来自其他节点的命令，根据命令执行对应的函数
 	switch command {
 	case "version":
 		// 其他节点向当前节点发送version信息，用于比较当前节点和其他节点的区块链高度
 		fmt.Println("receive version message")
-		handleVersion(request, bc)
+		err = handleVersion(request, peer, bc)
 	case "getblocks":
 		// 其他节点发送的getblocks信息，想要获取当前节点的区块
 		fmt.Println("receive getblocks message")
-		handleGetBlocks(request, bc)
+		err = handleGetBlocks(request, peer, bc)
 	case "inv":
 		// 其他节点发送的inv信息，包含了对方节点的区块链中的所有区块的hash值
 		fmt.Println("receive inv message")
-		handleInv(request, bc)
+		err = handleInv(request, peer, bc)
 	case "getdata":
 		// 其他节点发送的getdata请求，想要获取当前节点的区块链中的某个区块
 		fmt.Println("receive getdata message")
-		handleGetData(request, bc)
+		err = handleGetData(request, peer, bc)
 	case "block":
 		// 其他节点发送的block信息，包含了对方节点的区块链中的某个区块，当前节点需要把这个区块添加到自己的区块链中
 		fmt.Println("receive block message")
-		handleBlock(request, bc)
+		err = handleBlock(request, peer, bc)
+	case "ping":
+		// 其他节点发送的ping信息，需要回复pong
+		err = handlePing(request, peer, bc)
+	case "pong":
+		// 其他节点对我们发送的ping的回复，用于计算往返时间
+		err = handlePong(request, peer, bc)
+	case "tx":
+		// 其他节点发送的交易, 放入交易池
+		fmt.Println("receive tx message")
+		err = handleTx(request, peer, bc)
+	case "cmpctblock":
+		// 其他节点挖出的新区块, 只包含区块头和短交易ID
+		fmt.Println("receive cmpctblock message")
+		err = handleCmpctBlock(request, peer, bc)
+	case "getblocktxn":
+		// 其他节点重建紧凑区块时缺少的交易
+		fmt.Println("receive getblocktxn message")
+		err = handleGetBlockTxn(request, peer, bc)
+	case "blocktxn":
+		// 我们请求的紧凑区块中缺少的交易
+		fmt.Println("receive blocktxn message")
+		err = handleBlockTxn(request, peer, bc)
+	default:
+		fmt.Printf("unknown command %q from %s\n", command, peer)
+	}
+
+	// 5. 处理错误, 对方违规则增加惩罚分数
+	if err == nil || errors.Is(err, errPeerBanned) {
+		return
+	}
+
+	var mErr *MisbehaviorError
+	if errors.As(err, &mErr) {
+		if mErr.Peer == "" {
+			mErr.Peer = peer
+		}
+		Misbehaving(bc, mErr.Peer, mErr.Score, mErr.Error())
+		return
 	}
+
+	fmt.Printf("handle %s message from %s failed: %v\n", command, peer, err)
 }
 
 // handleVersion handles version message
 //
 // 处理version信息
-func handleVersion(request []byte, bc *Blockchain) {
+func handleVersion(request []byte, peer string, bc *Blockchain) error {
 	// 1. 解码version信息
-	var buff bytes.Buffer
 	var payload Version // payload 指代在一个数据包或消息中，实际携带的、对于最终用户有意义的数据
 
-	decoder := gob.NewDecoder(&buff)
-	buff.Write(request[COMMANDLENGTH:])
 	// 因为Decode()方法需要在已有的内存空间（也就是你传入的那个变量）上直接进行修改，
 	// 而不是创建一个新的变量。如果你传入一个变量（而不是指针），
 	// Decode()方法会在一个新的内存空间上进行操作，这个新的内存空间只在Decode()方法内部存在，
 	// 当方法返回后，这个新的内存空间就会被释放，你在方法外部是无法访问到这个新的内存空间的
-	err := decoder.Decode(&payload) // 解压version信息到payload中
+	err := decodePayload(request, &payload) // 解压version信息到payload中
 	if err != nil {
-		panic(err)
+		return err
+	}
+	peer, err = checkPeer(peer, payload.Addrfrom, bc)
+	if err != nil {
+		return err
 	}
 
 	localHeight, _ := bc.GetLatestHeight() // 获取当前节点的区块高度，也就是种子节点的区块高度
@@ -246,25 +375,70 @@ func handleVersion(request []byte, bc *Blockchain) {
 	}
 
 	// 如果发送方的地址不在种子节点列表中，那么就把发送方的地址添加到种子节点列表中
-	if !isKnownNode(payload.Addrfrom) {
-		KnownNodes = append(KnownNodes, payload.Addrfrom)
-	}
+	addKnownNode(payload.Addrfrom)
 
-	fmt.Println("Current Known Nodes: ", KnownNodes)
+	fmt.Println("Current Known Nodes: ", knownNodes())
 	fmt.Println("handleVersion func complete")
+	return nil
 }
 
-// isKnownNode checks if a node is known
+// knownNodes returns a copy of the known nodes
 //
-// 判断一个节点是否在种子节点列表中
-func isKnownNode(addr string) bool {
+// 返回种子节点列表的副本, 遍历的时候其他连接可以继续修改列表
+func knownNodes() []string {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	return append([]string{}, KnownNodes...)
+}
+
+// addKnownNode adds addr to the known nodes, returning false if it is already known
+func addKnownNode(addr string) bool {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
 	for _, node := range KnownNodes {
 		if node == addr {
-			return true
+			return false
 		}
 	}
+	KnownNodes = append(KnownNodes, addr)
+	return true
+}
+
+// dropKnownNodes removes the known nodes matched by drop
+func dropKnownNodes(drop func(node string) bool) {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
 
-	return false
+	updateNodes := []string{}
+	for _, node := range KnownNodes {
+		if !drop(node) {
+			updateNodes = append(updateNodes, node)
+		}
+	}
+	KnownNodes = updateNodes
+}
+
+// setBlocksInTransit replaces the blocks left to download
+func setBlocksInTransit(hashes [][]byte) {
+	blockInTransitMu.Lock()
+	defer blockInTransitMu.Unlock()
+
+	BlockInTransit = hashes
+}
+
+// nextBlockInTransit removes and returns the next block to download
+func nextBlockInTransit() ([]byte, bool) {
+	blockInTransitMu.Lock()
+	defer blockInTransitMu.Unlock()
+
+	if len(BlockInTransit) == 0 {
+		return nil, false
+	}
+	hash := BlockInTransit[0]
+	BlockInTransit = BlockInTransit[1:]
+	return hash, true
 }
 
 type GetBlocks struct {
@@ -288,16 +462,17 @@ func getBlocksFrom(toAddr string) {
 // handleGetBlocks handles getblocks message from other nodes
 //
 // 处理其他节点发送过来的getblocks命令, 把当前节点的所有区块hash发送给请求方
-func handleGetBlocks(request []byte, blockchain *Blockchain) {
+func handleGetBlocks(request []byte, peer string, blockchain *Blockchain) error {
 	// 1. 解码getblocks命令
-	var buff bytes.Buffer
 	var payload GetBlocks // 包含了请求方的地址
 
-	decoder := gob.NewDecoder(&buff)    // 构建解码器
-	buff.Write(request[COMMANDLENGTH:]) // 将request中的数据写入到buff中
-	err := decoder.Decode(&payload)     // 解码buff中的数据到payload中
+	err := decodePayload(request, &payload) // 解码request中的数据到payload中
 	if err != nil {
-		panic(err)
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, blockchain)
+	if err != nil {
+		return err
 	}
 
 	// 2. 获取当前节点的所有区块hash？？？
@@ -308,6 +483,7 @@ func handleGetBlocks(request []byte, blockchain *Blockchain) {
 	sendInv(payload.AddrFrom, "block", blockHashes) // 把当前节点的所有区块hash发送给请求方
 
 	fmt.Println("handleGetBlocks func complete")
+	return nil
 }
 
 // GetBlockHashes returns all block hashes
@@ -349,42 +525,51 @@ func sendInv(toAddr, kind string, items [][]byte) {
 // handleInv handles inv message from other nodes
 //
 // 接收到对方节点的所有区块hash, 当前节点接收到inv命令后，把缺少的区块hash发送给对方节点
-func handleInv(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleInv(request []byte, peer string, bc *Blockchain) error {
 	var paypload INV
 
-	decoder := gob.NewDecoder(&buff)
-	buff.Write(request[COMMANDLENGTH:])
-	err := decoder.Decode(&paypload)
+	err := decodePayload(request, &paypload)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	peer, err = checkPeer(peer, paypload.AddrFrom, bc)
+	if err != nil {
+		return err
 	}
 
 	// 打印接收到的inv信息
 	fmt.Println("Received inventory with ", len(paypload.Items), " from ", paypload.AddrFrom)
 
 	// 处理所有区块的hash
-	if paypload.Type == "block" {
-		BlockInTransit = paypload.Items      // 将所有区块的hash存储到BlockInTransit中
-		latestBlockHash := paypload.Items[0] // 获取最新的区块hash
-
-		// XXX: 向对方节点请求最新的区块数据
-		getBlockData(paypload.AddrFrom, "block", latestBlockHash) // 向种子节点发送getdata命令，请求最新的区块数据
-
-		newInTransit := [][]byte{}
-
-		// XXX:??? 不理解
-		// 逐个请求区块数据，每请求一个数据，就把对应的区块哈希从列表中移除。
-		// 这样，BlockInTransit 变量就始终保持了需要请求的区块哈希列表，防止重复请求已经得到的数据。
-		for _, b := range BlockInTransit {
-			// 剔除掉已经请求过的区块hash
-			if !bytes.Equal(b, latestBlockHash) {
-				newInTransit = append(newInTransit, b)
+	if paypload.Type == "block" && len(paypload.Items) > 0 {
+		Peers.MarkAnnounced(paypload.AddrFrom, paypload.Items) // 记录对方拥有这些区块
+
+		// inv中的区块hash从新到旧排列; 只下载本地没有的区块, 并且从旧到新逐个下载,
+		// 这样每个区块到达的时候父区块已经在本地了, 可以马上校验
+		missing := [][]byte{}
+		for i := len(paypload.Items) - 1; i >= 0; i-- {
+			if _, err := bc.GetBlock(paypload.Items[i]); err != nil {
+				missing = append(missing, paypload.Items[i])
 			}
 		}
 
-		BlockInTransit = newInTransit // 更新BlockInTransit变量，移除已经请求过的区块hash
+		if len(missing) > 0 {
+			// 如果有多个节点通告了这个区块，从往返时间最短的节点下载
+			getBlockData(Peers.DownloadPeer(missing[0], paypload.AddrFrom), "block", missing[0])
+			setBlocksInTransit(missing[1:]) // 剩下的区块在收到上一个区块之后再请求
+		}
+	}
+
+	// 处理交易的hash, 交易池中没有的交易才需要请求
+	if paypload.Type == "tx" {
+		for _, txID := range paypload.Items {
+			if !TxPool.Has(txID) {
+				getBlockData(paypload.AddrFrom, "tx", txID)
+			}
+		}
 	}
+
+	return nil
 }
 
 type GetData struct {
@@ -397,6 +582,13 @@ type GetData struct {
 //
 // 向toAddr发送getdata命令，请求对应区块的数据
 func getBlockData(toAddr, kind string, blockHash []byte) {
+	// 0. 记录请求过的区块，对方返回区块的时候用于判断是否是我们请求的
+	if kind == "block" {
+		blocksRequestedMu.Lock()
+		blocksRequested[string(blockHash)] = toAddr
+		blocksRequestedMu.Unlock()
+	}
+
 	// 1. 构建getdata命令, 并且转化成字节数组
 	payload := EncodeEverything(GetData{AddrFrom: CurrentNode, Type: kind, ID: blockHash})
 	request := append(commandToBytes("getdata"), payload...)
@@ -408,29 +600,41 @@ func getBlockData(toAddr, kind string, blockHash []byte) {
 // handleGetData handles getdata message from other nodes
 //
 // 处理其他节点发送过来的getdata命令，根据区块hash，获取对应的区块数据，然后发送给请求方
-func handleGetData(request []byte, bc *Blockchain) {
+func handleGetData(request []byte, peer string, bc *Blockchain) error {
 	// 1. 把request 字节切片转化成Getdata结构体
-	var buff bytes.Buffer
 	var payload GetData
 
-	decoder := gob.NewDecoder(&buff)
-	buff.Write(request[COMMANDLENGTH:])
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
 	}
 
 	// 2. 根据区块的hash，获取对应的区块数据
 	if payload.Type == "block" {
 		block, err := bc.GetBlock(payload.ID) // 根据区块hash，获取对应的区块数据
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("get block %x failed, %w", payload.ID, err)
 		}
 
 		// 找到了区块数据，就把区块数据发送给请求方
 		sendBlock(payload.AddrFrom, &block)
 	}
 
+	// 3. 根据交易ID, 从交易池中获取交易发送给请求方
+	if payload.Type == "tx" {
+		tx, ok := TxPool.Get(payload.ID)
+		if !ok {
+			return fmt.Errorf("transaction %x is not in mempool", payload.ID)
+		}
+
+		sendTx(payload.AddrFrom, tx)
+	}
+
+	return nil
 }
 
 // GetBlock returns a block by its hash
@@ -482,40 +686,417 @@ func sendBlock(toAddr string, block *Block) {
 // handleBlock handles block message from other nodes
 //
 // 处理其他节点发送过来的block命令，把区块添加到本地区块链中，并且更新UTXO集合
-func handleBlock(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleBlock(request []byte, peer string, bc *Blockchain) error {
 	var payload SendBlock
 
 	// 1. 把request 字节切片转化成SendBlock结构体
-	decoder := gob.NewDecoder(&buff)
-	buff.Write(request[COMMANDLENGTH:])
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
 	}
 
 	// 2. 反序列化区块数据
-	block := Deserialize(payload.Block)
+	block, err := DeserializeBlock(payload.Block)
+	if err != nil {
+		return misbehavior(peer, MISBEHAVIOR_MALFORMED, "malformed block", err)
+	}
+
+	// 3. 只接收我们请求过的区块
+	blocksRequestedMu.Lock()
+	_, requested := blocksRequested[string(block.Hash)]
+	delete(blocksRequested, string(block.Hash))
+	blocksRequestedMu.Unlock()
+	if !requested {
+		return misbehavior(peer, MISBEHAVIOR_UNSOLICITED, fmt.Sprintf("unsolicited block %x", block.Hash), nil)
+	}
+
+	// 4. 校验并且添加区块, 父区块还没有收到的区块先放进孤儿池
+	if err := processBlock(block, peer, payload.AddrFrom, bc); err != nil {
+		return err
+	}
+
+	// 5. 继续下载下一个区块
+	if blockHash, ok := nextBlockInTransit(); ok {
+		getBlockData(Peers.DownloadPeer(blockHash, payload.AddrFrom), "block", blockHash) // 根据区块hash，从最快的节点获取对应的区块数据
+	}
+
+	return nil
+}
+
+// processBlock validates a block from a peer and adds it to the chain
+//
+// 父区块不在本地的区块无法校验交易, 先放进孤儿池并且向对方请求父区块;
+// 区块添加到区块链之后, 继续处理等待这个区块的孤儿区块
+func processBlock(block *Block, peer, from string, bc *Blockchain) error {
+	blockMu.Lock()
+	defer blockMu.Unlock()
+
+	if _, err := bc.GetBlock(block.Hash); err == nil {
+		return nil
+	}
 
-	// 3. 把区块添加到区块链中
+	// 孤儿区块也要先通过不依赖父区块的检查, 防止对方用无效的区块占满孤儿池
+	if err := checkBlock(block); err != nil {
+		return misbehavior(peer, err.Score, err.Reason, err.Err)
+	}
+
+	parent, err := bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		if Orphans.Add(block, peer, from) {
+			fmt.Printf("hold orphan block %x until its parent %x arrives\n", block.Hash, block.PrevBlockHash)
+			if !Orphans.Has(block.PrevBlockHash) && !isBlockRequested(block.PrevBlockHash) {
+				getBlockData(from, "block", block.PrevBlockHash)
+			}
+		}
+		return nil
+	}
+
+	if err := validateBlock(block, &parent, bc); err != nil {
+		return misbehavior(peer, err.Score, err.Reason, err.Err)
+	}
+	connectBlock(block, bc)
+
+	// 孤儿区块可能来自其他节点, 校验失败时惩罚发送它的节点
+	queue := Orphans.TakeChildren(block.Hash)
+	for len(queue) > 0 {
+		orphan := queue[0]
+		queue = queue[1:]
+
+		parent, err := bc.GetBlock(orphan.block.PrevBlockHash)
+		if err != nil {
+			continue
+		}
+		if err := validateBlock(orphan.block, &parent, bc); err != nil {
+			Misbehaving(bc, orphan.peer, err.Score, err.Error())
+			continue
+		}
+		connectBlock(orphan.block, bc)
+		queue = append(queue, Orphans.TakeChildren(orphan.block.Hash)...)
+	}
+
+	return nil
+}
+
+// connectBlock stores a validated block and removes its transactions from the mempool
+func connectBlock(block *Block, bc *Blockchain) {
+	prevTop := bc.GetTopHash()
 	bc.AddBlockBy(block) // 把区块添加到区块链中
-	fmt.Printf("Received a new block and add it to blockchain! %v", block)
+	Peers.ForgetAnnounced(block.Hash)
+	TxPool.RemoveBlockTxs(block)
+	prunePendingCompact(block.Height)
+
+	// update UTXO set
+	utxoSet := UTXOSet{bc}
+	if err := utxoSet.ConnectBlock(block, prevTop); err != nil { // 使用新区块更新UTXO集合
+		fmt.Printf("update utxo set failed: %v\n", err)
+	}
+	fmt.Printf("add block %x at height %d to blockchain\n", block.Hash, block.Height)
+}
 
-	if len(BlockInTransit) > 0 {
-		blockHash := BlockInTransit[0]                     // 获取第一个区块hash
-		getBlockData(payload.AddrFrom, "block", blockHash) // 根据区块hash，获取对应的区块数据
-		BlockInTransit = BlockInTransit[1:]                // 移除第一个区块hash
-	} else {
-		// update UTXO set
+// isBlockRequested reports whether a block has been requested and not received yet
+func isBlockRequested(hash []byte) bool {
+	blocksRequestedMu.Lock()
+	defer blocksRequestedMu.Unlock()
+
+	_, ok := blocksRequested[string(hash)]
+	return ok
+}
+
+// checkBlock checks what does not depend on the parent: the proof of work and the merkle root
+//
+// 默克尔根是区块头的一部分, 受工作量证明保护; 交易和默克尔根对不上说明交易被替换过
+func checkBlock(block *Block) *MisbehaviorError {
+	if !NewPOW(block).Validate() {
+		return misbehavior("", MISBEHAVIOR_BADPOW, fmt.Sprintf("bad proof of work in block %x", block.Hash), nil)
+	}
+	if !bytes.Equal(block.CreateMerkleRoot(), block.MerkleRoot) {
+		return misbehavior("", MISBEHAVIOR_BADBLOCK, fmt.Sprintf("merkle root of block %x does not match its transactions", block.Hash), nil)
+	}
+	return nil
+}
+
+// validateBlock checks a block received from a peer against its parent
+//
+// 校验其他节点发送过来的区块: 工作量证明和默克尔根必须有效, 高度必须接在父区块后面, 区块中的交易必须有效
+func validateBlock(block, parent *Block, bc *Blockchain) *MisbehaviorError {
+	defer Metrics.BlockValidation["peer"].ObserveSince(time.Now())
+
+	if err := checkBlock(block); err != nil {
+		return err
+	}
+	if block.Height != parent.Height+1 {
+		return misbehavior("", MISBEHAVIOR_BADBLOCK, fmt.Sprintf("block %x at height %d does not follow its parent at height %d", block.Hash, block.Height, parent.Height), nil)
+	}
+
+	for _, tx := range block.Transactions {
+		if !bc.VerifyTransaction(tx) {
+			return misbehavior("", MISBEHAVIOR_INVALIDTX, fmt.Sprintf("invalid transaction %x in block %x", tx.ID, block.Hash), nil)
+		}
+	}
+
+	// UTXO集合对应最新区块, 只有接在最新区块后面的区块才能检查双花
+	if bytes.Equal(parent.Hash, bc.GetTopHash()) {
 		utxoSet := UTXOSet{bc}
-		utxoSet.UpdateUTXO(block) // 使用新区块更新UTXO集合
+		if err := utxoSet.CheckSpends(block.Transactions); err != nil {
+			return misbehavior("", MISBEHAVIOR_INVALIDTX, fmt.Sprintf("block %x double spends", block.Hash), err)
+		}
+	}
+
+	return nil
+}
+
+type SendTransaction struct {
+	AddrFrom    string
+	Transaction []byte // 序列化之后的交易
+}
+
+// sendTx sends a transaction to a node
+//
+// 把一笔交易发送给其他节点
+func sendTx(toAddr string, tx *Transaction) bool {
+	payload := EncodeEverything(SendTransaction{AddrFrom: CurrentNode, Transaction: tx.Serialize()})
+	request := append(commandToBytes("tx"), payload...)
+
+	return sendData(toAddr, request)
+}
+
+// handleTx verifies a transaction from a peer, adds it to the mempool and relays it
+//
+// 收到其他节点发送的交易之后, 验证交易并且放入交易池, 然后通知其他节点; 矿工节点会尝试挖矿
+func handleTx(request []byte, peer string, bc *Blockchain) error {
+	var payload SendTransaction
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DeserializeTransaction(payload.Transaction)
+	if err != nil {
+		return misbehavior(peer, MISBEHAVIOR_MALFORMED, "malformed transaction", err)
+	}
+
+	// coinbase交易只能出现在区块中
+	if tx.IsCoinbase() {
+		return misbehavior(peer, MISBEHAVIOR_INVALIDTX, "coinbase transaction relayed outside a block", nil)
+	}
+
+	// 引用的交易还没有同步过来的时候无法验证, 直接丢弃, 不算违规
+	for _, input := range tx.In {
+		if _, err := bc.FindTxByID(input.TXid); err != nil {
+			fmt.Printf("transaction %x spends unknown transaction %x, drop it\n", tx.ID, input.TXid)
+			return nil
+		}
+	}
+	if !bc.VerifyTransaction(tx) {
+		return misbehavior(peer, MISBEHAVIOR_INVALIDTX, fmt.Sprintf("invalid transaction %x", tx.ID), nil)
+	}
+
+	// 已经被打包或者花费的输出已经被花费的交易, 对方可能只是还没有同步到最新区块, 直接丢弃, 不算违规
+	if err := relayTransaction(tx, payload.AddrFrom, bc); err != nil {
+		fmt.Printf("drop transaction %x: %v\n", tx.ID, err)
+	}
+	return nil
+}
+
+// checkTxSpends rejects a transaction that is already in the chain or spends an output that is spent or does not exist
+//
+// 交易引用的输出必须在UTXO集合中, 并且不能和交易池中的交易花费同一个输出
+func checkTxSpends(tx *Transaction, bc *Blockchain) error {
+	if _, err := bc.FindTxByID(tx.ID); err == nil {
+		return fmt.Errorf("transaction %x is already in the blockchain", tx.ID)
+	}
+
+	utxoSet := UTXOSet{bc}
+	if err := utxoSet.CheckSpends([]*Transaction{tx}); err != nil {
+		return err
+	}
+
+	if conflict := TxPool.Conflict(tx); conflict != nil {
+		return fmt.Errorf("transaction %x spends the same output as mempool transaction %x, %w", tx.ID, conflict, ErrDoubleSpend)
+	}
+	return nil
+}
+
+// relayTransaction adds a verified transaction to the mempool, announces it to every node but from and mines it on a miner node
+//
+// 交易池中已经有这笔交易时什么都不做; 交易和区块链或者交易池中的交易双花时返回错误
+func relayTransaction(tx *Transaction, from string, bc *Blockchain) error {
+	if TxPool.Has(tx.ID) {
+		return nil
+	}
+	if err := checkTxSpends(tx, bc); err != nil {
+		return err
+	}
+	if !TxPool.Add(tx) {
+		return nil
+	}
+	fmt.Printf("add transaction %x to mempool, mempool size %d\n", tx.ID, TxPool.Count())
+
+	// 把交易通知给其他节点
+	for _, node := range knownNodes() {
+		if node != CurrentNode && node != from {
+			sendInv(node, "tx", [][]byte{tx.ID})
+		}
+	}
+
+	if len(MinerAddress) > 0 && TxPool.Count() >= MINETHRESHOLD {
+		mineBlock(bc)
+	}
+	return nil
+}
+
+// mineBlock mines the transactions in the mempool into a new block and announces it
+//
+// 把交易池中的交易打包成新区块, 并且以紧凑区块的形式广播给其他节点
+func mineBlock(bc *Blockchain) {
+	// 多个交易可能同时到达, 同一时间只挖一个区块
+	miningMu.Lock()
+	defer miningMu.Unlock()
+
+	txs := TxPool.SelectForBlock(bc)
+	if len(txs) == 0 {
+		fmt.Println("no valid transaction to mine")
+		return
+	}
+
+	coinbase, err := CoinBaseTx(MinerAddress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// coinbase交易放在区块的第一个位置
+	txs = append([]*Transaction{coinbase}, txs...)
+
+	prevTop := bc.GetTopHash()
+	_, newBlock := bc.AddBlock(txs)
+	utxoSet := UTXOSet{bc}
+	if err := utxoSet.ConnectBlock(newBlock, prevTop); err != nil {
+		fmt.Printf("update utxo set failed: %v\n", err)
+	}
+	TxPool.RemoveBlockTxs(newBlock)
+	prunePendingCompact(newBlock.Height)
+
+	fmt.Printf("mined a new block %x with %d transactions\n", newBlock.Hash, len(newBlock.Transactions))
+	announceBlock(newBlock, "")
+}
+
+type Ping struct {
+	AddrFrom string
+	Nonce    uint64 // 随机数, 对方回复的pong必须带上相同的随机数
+}
+
+type Pong struct {
+	AddrFrom string
+	Nonce    uint64
+}
+
+// keepAlive pings every known node periodically and disconnects unresponsive ones
+//
+// 定期向所有已知节点发送ping，ping超时或者长时间没有消息的节点会被断开
+func keepAlive() {
+	ticker := time.NewTicker(PINGINTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		nodes := knownNodes()
+
+		for _, node := range nodes {
+			if node == CurrentNode {
+				continue
+			}
+
+			if Peers.Unresponsive(node) {
+				fmt.Printf("peer %s is unresponsive, disconnect it\n", node)
+				Peers.Remove(node)
+				removeKnownNode(node)
+				continue
+			}
+
+			sendPing(node)
+		}
+	}
+}
+
+// sendPing sends a ping message with a fresh nonce to a node
+//
+// 向一个节点发送ping, 上一个ping还没有回复的时候不会重复发送
+func sendPing(toAddr string) {
+	var nonceBytes [8]byte
+	_, err := rand.Read(nonceBytes[:])
+	if err != nil {
+		fmt.Printf("generate ping nonce failed: %v\n", err)
+		return
 	}
+	nonce := binary.BigEndian.Uint64(nonceBytes[:]) | 1 // nonce 不能为0, 0表示没有等待中的ping
+
+	if !Peers.StartPing(toAddr, nonce) {
+		return
+	}
+
+	payload := EncodeEverything(Ping{AddrFrom: CurrentNode, Nonce: nonce})
+	request := append(commandToBytes("ping"), payload...)
+	sendData(toAddr, request)
+}
+
+// handlePing answers a ping with a pong carrying the same nonce
+//
+// 收到ping之后，把相同的nonce放在pong中发送回去
+func handlePing(request []byte, peer string, bc *Blockchain) error {
+	var payload Ping
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
+	}
+
+	response := EncodeEverything(Pong{AddrFrom: CurrentNode, Nonce: payload.Nonce})
+	sendData(payload.AddrFrom, append(commandToBytes("pong"), response...))
+	return nil
+}
+
+// handlePong records the round-trip time of a ping
+//
+// 收到pong之后更新对方节点的往返时间
+func handlePong(request []byte, peer string, bc *Blockchain) error {
+	var payload Pong
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	peer, err = checkPeer(peer, payload.AddrFrom, bc)
+	if err != nil {
+		return err
+	}
+
+	rtt, ok := Peers.FinishPing(payload.AddrFrom, payload.Nonce)
+	if !ok {
+		fmt.Printf("unexpected pong from %s\n", payload.AddrFrom)
+		return nil
+	}
+	fmt.Printf("pong from %s, rtt %v\n", payload.AddrFrom, rtt)
+	return nil
 }
 
 // AddBlockBy adds a block to the blockchain
 //
 // 把区块添加到区块链中
 func (bc *Blockchain) AddBlockBy(block *Block) {
+	var disconnected, connected []*Block
+
 	// 1. 把区块添加到区块链数据库中
 	err := bc.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(BLOCKBUCKET))
@@ -536,7 +1117,10 @@ func (bc *Blockchain) AddBlockBy(block *Block) {
 			if err != nil {
 				panic(err)
 			}
-			bc.topHash = block.Hash // 更新区块链最新区块的hash
+			bc.setTopHash(block.Hash) // 更新区块链最新区块的hash
+
+			// 新区块不一定接在原来的最新区块后面, 找出因为分叉切换离开主链的区块
+			disconnected, connected = tipChange(bucket, latestBlock, block)
 		}
 
 		return nil
@@ -545,4 +1129,7 @@ func (bc *Blockchain) AddBlockBy(block *Block) {
 	if err != nil {
 		panic(err)
 	}
+
+	// 2. 事务提交之后再发布事件, 订阅者看到事件时可以读到新区块
+	Events.publishTipChange(disconnected, connected)
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
 	"fmt"
 
 	"github.com/boltdb/bolt"
@@ -12,11 +15,61 @@ const (
 	UTXOBUCKET = "chainstate"
 )
 
+var (
+	ErrMissingOutpoint = errors.New("output is spent or does not exist")
+	ErrDoubleSpend     = errors.New("output is spent twice")
+)
+
 // 存储UTXO
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
+// UnspentOutput is an unspent output together with its index in the transaction
+//
+// 交易中的部分输出被花费之后, 剩下的输出在切片中的位置和在交易中的位置不一样, 所以要保存输出索引
+type UnspentOutput struct {
+	Index  int
+	Output TXoutput
+}
+
+// UnspentOutputs are the unspent outputs of one transaction, stored under its ID
+type UnspentOutputs []UnspentOutput
+
+// Serialize encodes the unspent outputs for the chainstate bucket
+func (outs UnspentOutputs) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	err := gob.NewEncoder(&buffer).Encode(outs)
+	if err != nil {
+		panic(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// DeserializeUnspentOutputs decodes the unspent outputs of a transaction
+func DeserializeUnspentOutputs(data []byte) (UnspentOutputs, error) {
+	var outs UnspentOutputs
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&outs)
+	if err != nil {
+		return nil, fmt.Errorf("decode unspent outputs failed, %w", err)
+	}
+
+	return outs, nil
+}
+
+// Find returns the output with the given index
+func (outs UnspentOutputs) Find(index int) (TXoutput, bool) {
+	for _, out := range outs {
+		if out.Index == index {
+			return out.Output, true
+		}
+	}
+	return TXoutput{}, false
+}
+
 // StoreUTXO deletes the old UTXO set and stores the current one into chainstate bucket
 //
 // 删除并且创建UTXO bucket，然后将UTXO存储到bucket中
@@ -72,6 +125,13 @@ func (u *UTXOSet) StoreUTXO() error {
 		return fmt.Errorf("update utxo bucket failed, %w", err)
 	}
 
+	outputs := 0
+	for _, utxos := range UTXO {
+		outputs += len(utxos)
+	}
+	Metrics.UTXOTransactions.Set(float64(len(UTXO)))
+	Metrics.UTXOOutputs.Set(float64(outputs))
+
 	return nil
 }
 
@@ -93,12 +153,15 @@ func (uset *UTXOSet) FindUTXOByPubkeyHash(pubkeyHash []byte) TXoutputSlice {
 		// k: 交易ID, v: 交易输出切片
 		for k, v := dbCursor.First(); k != nil; k, v = dbCursor.Next() {
 
-			outputslice := DeserializeOutputSlice(v)
+			outputslice, err := DeserializeUnspentOutputs(v)
+			if err != nil {
+				return err
+			}
 
 			// 遍历交易输出切片, 查找公钥哈希可以解锁的UTXO
-			for _, output := range outputslice {
-				if output.CanBeUnlockedWith(pubkeyHash) {
-					utxos = append(utxos, output)
+			for _, unspent := range outputslice {
+				if unspent.Output.CanBeUnlockedWith(pubkeyHash) {
+					utxos = append(utxos, unspent.Output)
 				}
 			}
 		}
@@ -112,11 +175,95 @@ func (uset *UTXOSet) FindUTXOByPubkeyHash(pubkeyHash []byte) TXoutputSlice {
 	return utxos
 }
 
-// UpdateUTX sets the UTXO set with the UTXO in the new block
+// FindCoins returns the unspent outputs of a public key hash with their outpoints
+//
+// 按照交易ID和输出索引排序, 分页的时候每一页的内容是稳定的
+func (u *UTXOSet) FindCoins(pubkeyHash []byte) ([]Coin, error) {
+	coins := []Coin{}
+
+	err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(UTXOBUCKET)).ForEach(func(k, v []byte) error {
+			outs, err := DeserializeUnspentOutputs(v)
+			if err != nil {
+				return err
+			}
+			for _, out := range outs {
+				if out.Output.CanBeUnlockedWith(pubkeyHash) {
+					outpoint := Outpoint{TxID: append([]byte{}, k...), Index: out.Index}
+					coins = append(coins, Coin{Outpoint: outpoint, Value: out.Output.Value})
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find coins failed, %w", err)
+	}
+
+	return coins, nil
+}
+
+// FindOutput returns an unspent output, or ErrMissingOutpoint if it is spent or does not exist
+func (u *UTXOSet) FindOutput(outpoint Outpoint) (TXoutput, error) {
+	var output TXoutput
+
+	err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(UTXOBUCKET)).Get(outpoint.TxID)
+		if data == nil {
+			return ErrMissingOutpoint
+		}
+		outs, err := DeserializeUnspentOutputs(data)
+		if err != nil {
+			return err
+		}
+
+		out, ok := outs.Find(outpoint.Index)
+		if !ok {
+			return ErrMissingOutpoint
+		}
+		output = out
+		return nil
+	})
+	if err != nil {
+		return TXoutput{}, fmt.Errorf("find output %s failed, %w", outpoint, err)
+	}
+
+	return output, nil
+}
+
+// CheckSpends checks that every input of txs spends an output of the UTXO set, and no output is spent twice
+//
+// 交易引用的输出必须还没有被花费; 同一批交易中两个输入花费同一个输出也是双花
+func (u *UTXOSet) CheckSpends(txs []*Transaction) error {
+	spent := make(map[string]bool) // key 是 Outpoint.String()
+
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range tx.In {
+			outpoint := Outpoint{TxID: input.TXid, Index: input.Voutindex}
+			if spent[outpoint.String()] {
+				return fmt.Errorf("transaction %x spends %s, %w", tx.ID, outpoint, ErrDoubleSpend)
+			}
+			spent[outpoint.String()] = true
+
+			if _, err := u.FindOutput(outpoint); err != nil {
+				return fmt.Errorf("transaction %x spends %s, %w", tx.ID, outpoint, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateUTXO spends the inputs and adds the outputs of a block that extends the tip of the UTXO set
 //
-// 把新添加的区块中的UTXO添加到UTXO集合中, 并且更新当前的区块
-func (u *UTXOSet) UpdateUTXO(block *Block) {
+// 把新添加的区块中的UTXO添加到UTXO集合中, 并且删除区块中的交易花费的输出;
+// 区块引用的输出不在UTXO集合中时返回错误, 整个事务回滚, UTXO集合保持不变
+func (u *UTXOSet) UpdateUTXO(block *Block) error {
 	db := u.Blockchain.db
+	txDelta, outputDelta := 0, 0 // UTXO集合大小的变化, 事务提交之后更新指标
 
 	// 更新数据库中的UTXO
 	err := db.Update(func(tx *bolt.Tx) error {
@@ -124,58 +271,96 @@ func (u *UTXOSet) UpdateUTXO(block *Block) {
 
 		// 遍历新增区块中的所有交易
 		for _, tx := range block.Transactions {
-			// 非coinbase交易, 遍历交易输入
+			// 非coinbase交易, 删除交易输入引用的输出
 			if !tx.IsCoinbase() {
-				// 遍历新增区块中的当前交易的输入
 				for _, input := range tx.In {
-					tempOutputSlice := TXoutputSlice{} // 存储当前交易输入引用的上一笔交易中，没有使用的输出
+					outpoint := Outpoint{TxID: input.TXid, Index: input.Voutindex}
 
-					txID := input.TXid           // 当前交易输入引用的交易ID
-					outputTxBytes := b.Get(txID) // 根据txID获取当前交易的交易输出切片的字节数组
-
-					outputSlice := DeserializeOutputSlice(outputTxBytes)
+					outputTxBytes := b.Get(input.TXid) // 根据txID获取当前交易的交易输出切片的字节数组
+					if outputTxBytes == nil {
+						return fmt.Errorf("transaction %x spends %s, %w", tx.ID, outpoint, ErrMissingOutpoint)
+					}
+					outputSlice, err := DeserializeUnspentOutputs(outputTxBytes)
+					if err != nil {
+						return err
+					}
 
-					// 遍历上一笔交易的每一个输出，如果当前交易的输入引用了上一笔交易的输出，那么就删除这个输出
-					for outputIdx, output := range outputSlice {
-						// 如果当前交易没有使用这个输出，把这个输出暂存
-						if outputIdx != input.Voutindex {
+					// 按照输出索引删除被花费的输出, 没有找到说明这个输出已经被花费了
+					tempOutputSlice := UnspentOutputs{}
+					for _, output := range outputSlice {
+						if output.Index != input.Voutindex {
 							tempOutputSlice = append(tempOutputSlice, output)
 						}
 					}
+					if len(tempOutputSlice) == len(outputSlice) {
+						return fmt.Errorf("transaction %x spends %s, %w", tx.ID, outpoint, ErrMissingOutpoint)
+					}
+					outputDelta--
 
 					// 如果上一笔交易的所有输出都被使用了，那么就删除这笔交易
 					if len(tempOutputSlice) == 0 {
-						err := b.Delete(input.TXid)
-						if err != nil {
-							panic(fmt.Errorf("delete transaction fail, %w", err))
-						}
+						txDelta--
+						err = b.Delete(input.TXid)
 					} else {
-						// 如果上一笔交易的所有输出没有被使用完，那么就更新这笔交易
-						err := b.Put(input.TXid, tempOutputSlice.Serialize())
-						if err != nil {
-							panic(fmt.Errorf("update transaction fail, %w", err))
-						}
+						err = b.Put(input.TXid, tempOutputSlice.Serialize())
+					}
+					if err != nil {
+						return fmt.Errorf("update transaction %x failed, %w", input.TXid, err)
 					}
 				}
+			}
 
-				// 遍历交易输出
-				newOutputSlice := TXoutputSlice{}
-
-				// 把新区块当前交易的所有输出添加到newOutputSlice中
-				for _, output := range tx.Out {
-					newOutputSlice = append(newOutputSlice, output)
-				}
+			// 把交易的所有输出添加到UTXO集合中, coinbase交易的输出也一样
+			newOutputSlice := UnspentOutputs{}
+			for index, output := range tx.Out {
+				newOutputSlice = append(newOutputSlice, UnspentOutput{Index: index, Output: output})
+			}
 
-				err := b.Put(tx.ID, newOutputSlice.Serialize())
+			// 交易ID相同的交易(比如两个给同一个地址的coinbase交易)会覆盖之前还没有花费的输出
+			if old := b.Get(tx.ID); old != nil {
+				oldSlice, err := DeserializeUnspentOutputs(old)
 				if err != nil {
-					panic(fmt.Errorf("update transaction fail, %w", err))
+					return err
 				}
+				txDelta--
+				outputDelta -= len(oldSlice)
+			}
+
+			err := b.Put(tx.ID, newOutputSlice.Serialize())
+			if err != nil {
+				return fmt.Errorf("update transaction %x failed, %w", tx.ID, err)
 			}
+			txDelta++
+			outputDelta += len(newOutputSlice)
 		}
 		return nil
 	})
 
 	if err != nil {
-		panic(fmt.Errorf("update utxo fail, %w", err))
+		return fmt.Errorf("update utxo with block %x failed, %w", block.Hash, err)
 	}
+	Metrics.UTXOTransactions.Add(float64(txDelta))
+	Metrics.UTXOOutputs.Add(float64(outputDelta))
+	return nil
+}
+
+// ConnectBlock brings the UTXO set up to date after block has been added to the chain
+//
+// 新区块接在原来的最新区块后面时增量更新; 分叉切换时重新从区块链生成UTXO集合; 侧链上的区块不影响UTXO集合.
+// 增量更新失败说明UTXO集合和区块链不一致, 也重新生成
+func (u *UTXOSet) ConnectBlock(block *Block, prevTop []byte) error {
+	top := u.Blockchain.GetTopHash()
+	if bytes.Equal(top, prevTop) {
+		return nil
+	}
+
+	if bytes.Equal(top, block.Hash) && bytes.Equal(block.PrevBlockHash, prevTop) {
+		err := u.UpdateUTXO(block)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("%v, rebuild utxo set\n", err)
+	}
+
+	return u.StoreUTXO()
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestBlockchain opens a fresh blockchain in a temporary directory
+func newTestBlockchain(t *testing.T) *Blockchain {
+	t.Helper()
+
+	bc := CreateBlockchain(filepath.Join(t.TempDir(), "blockchain.db"))
+	t.Cleanup(func() { bc.db.Close() })
+	return bc
+}
+
+func TestUpdateUTXO(t *testing.T) {
+	bc := newTestBlockchain(t)
+	utxoSet := UTXOSet{bc}
+	genesis, err := bc.GetBlock(bc.GetTopHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisTx := genesis.Transactions[0]
+
+	// 花费创世区块的输出, 产生两个输出
+	pay := &Transaction{
+		ID:  []byte("pay"),
+		In:  []TXinput{{TXid: genesisTx.ID, Voutindex: 0, Pubkey: []byte{1}}},
+		Out: []TXoutput{{Value: 60, PublickeyHash: []byte{1}}, {Value: 40, PublickeyHash: []byte{2}}},
+	}
+	// 只花费第二个输出, 剩下的输出在切片中的位置变了, 但是索引不变
+	spendSecond := &Transaction{
+		ID:  []byte("spend second"),
+		In:  []TXinput{{TXid: pay.ID, Voutindex: 1, Pubkey: []byte{2}}},
+		Out: []TXoutput{{Value: 40, PublickeyHash: []byte{3}}},
+	}
+	coinbase, err := CoinBaseTx(Params.GenesisAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase.ID = []byte("coinbase")
+
+	tests := []struct {
+		name    string
+		txs     []*Transaction
+		wantErr error
+		present []Outpoint
+		missing []Outpoint
+	}{
+		{
+			name:    "spend genesis",
+			txs:     []*Transaction{coinbase, pay},
+			present: []Outpoint{{pay.ID, 0}, {pay.ID, 1}, {coinbase.ID, 0}},
+			missing: []Outpoint{{genesisTx.ID, 0}},
+		},
+		{
+			name:    "partial spend keeps the index",
+			txs:     []*Transaction{spendSecond},
+			present: []Outpoint{{pay.ID, 0}, {spendSecond.ID, 0}},
+			missing: []Outpoint{{pay.ID, 1}},
+		},
+		{
+			name:    "spent output",
+			txs:     []*Transaction{{ID: []byte("again"), In: []TXinput{{TXid: pay.ID, Voutindex: 1}}, Out: []TXoutput{{Value: 40}}}},
+			wantErr: ErrMissingOutpoint,
+			present: []Outpoint{{pay.ID, 0}},
+			missing: []Outpoint{{[]byte("again"), 0}},
+		},
+		{
+			// 第一个输入已经删除了输出, 第二个输入失败之后整个区块回滚
+			name: "rollback",
+			txs: []*Transaction{{ID: []byte("rollback"), In: []TXinput{
+				{TXid: pay.ID, Voutindex: 0},
+				{TXid: []byte("unknown"), Voutindex: 0},
+			}, Out: []TXoutput{{Value: 60}}}},
+			wantErr: ErrMissingOutpoint,
+			present: []Outpoint{{pay.ID, 0}},
+			missing: []Outpoint{{[]byte("rollback"), 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := utxoSet.UpdateUTXO(&Block{Transactions: tt.txs})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateUTXO() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, outpoint := range tt.present {
+				if _, err := utxoSet.FindOutput(outpoint); err != nil {
+					t.Errorf("FindOutput(%s) = %v, want the output", outpoint, err)
+				}
+			}
+			for _, outpoint := range tt.missing {
+				if _, err := utxoSet.FindOutput(outpoint); !errors.Is(err, ErrMissingOutpoint) {
+					t.Errorf("FindOutput(%s) = %v, want ErrMissingOutpoint", outpoint, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSpends(t *testing.T) {
+	bc := newTestBlockchain(t)
+	utxoSet := UTXOSet{bc}
+	genesis, err := bc.GetBlock(bc.GetTopHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisID := genesis.Transactions[0].ID
+	coinbase, err := CoinBaseTx(Params.GenesisAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spend := func(id string, txID []byte, index int) *Transaction {
+		return &Transaction{ID: []byte(id), In: []TXinput{{TXid: txID, Voutindex: index}}, Out: []TXoutput{{Value: 1}}}
+	}
+
+	tests := []struct {
+		name    string
+		txs     []*Transaction
+		wantErr error
+	}{
+		{"unspent", []*Transaction{spend("a", genesisID, 0)}, nil},
+		{"coinbase", []*Transaction{coinbase}, nil},
+		{"unknown index", []*Transaction{spend("a", genesisID, 1)}, ErrMissingOutpoint},
+		{"unknown transaction", []*Transaction{spend("a", []byte("unknown"), 0)}, ErrMissingOutpoint},
+		{"double spend", []*Transaction{spend("a", genesisID, 0), spend("b", genesisID, 0)}, ErrDoubleSpend},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := utxoSet.CheckSpends(tt.txs); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckSpends() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFindCoins(t *testing.T) {
+	bc := newTestBlockchain(t)
+	utxoSet := UTXOSet{bc}
+	genesis, err := bc.GetBlock(bc.GetTopHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisID := genesis.Transactions[0].ID
+	owner, err := DecodeAddress(Params.GenesisAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 花掉第一个输出之后, 剩下的输出仍然返回原来的索引
+	split := &Transaction{
+		ID:  []byte("split"),
+		In:  []TXinput{{TXid: genesisID, Voutindex: 0}},
+		Out: []TXoutput{{Value: 30, PublickeyHash: owner}, {Value: 70, PublickeyHash: owner}},
+	}
+	spend := &Transaction{
+		ID:  []byte("spend"),
+		In:  []TXinput{{TXid: split.ID, Voutindex: 0}},
+		Out: []TXoutput{{Value: 30, PublickeyHash: []byte("other")}},
+	}
+	for _, tx := range []*Transaction{split, spend} {
+		if err := utxoSet.UpdateUTXO(&Block{Transactions: []*Transaction{tx}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	coins, err := utxoSet.FindCoins(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Coin{{Outpoint: Outpoint{TxID: split.ID, Index: 1}, Value: 70}}
+	if !reflect.DeepEqual(coins, want) {
+		t.Errorf("FindCoins() = %v, want %v", coins, want)
+	}
+}
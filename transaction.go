@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"fmt"
@@ -14,8 +15,29 @@ import (
 
 const (
 	COINBASEFEE = 100 // coinbase交易给矿工的奖励
+
+	DEFAULTDUSTTHRESHOLD = 1 // 找零不超过这个金额时不创建找零输出, 直接作为手续费
 )
 
+// TxOptions controls how the wallet builds a transaction
+//
+// 构建交易的选项: 找零策略, 选币策略, 以及手动指定要花费的输出
+type TxOptions struct {
+	Change    ChangePolicy
+	Selection string     // 选币策略, 见 CoinSelections
+	Coins     []Outpoint // coin control: 只花费这些输出
+	Fee       int        // 手续费, 不包括放弃的找零
+	FeeSplit  string     // 手续费由谁支付, 见 FeeSplits
+}
+
+// ChangePolicy decides where the change of a transaction goes
+//
+// 找零策略: 默认每笔交易使用一个新的找零地址, 避免所有付款都关联到付款人的同一个地址
+type ChangePolicy struct {
+	Address       string // 固定的找零地址, 为空时生成新的找零地址
+	DustThreshold int    // 找零不超过这个金额时不创建找零输出, 直接加到手续费中
+}
+
 type Transaction struct {
 	ID  []byte     // 交易的哈希值
 	In  []TXinput  // 交易的所有输入。每一个 TXinput 都包含一个引用到过去交易的未花费输出UTXO，这表示你想要花费这些比特币。
@@ -36,51 +58,26 @@ type TXoutput struct {
 
 type TXoutputSlice []TXoutput
 
-// Serialize returns a serialized TXinput
-//
-// 序列化交易输入
-func (outs *TXoutputSlice) Serialize() []byte {
-	var buffer bytes.Buffer // 编码后的字节流
-
-	encoder := gob.NewEncoder(&buffer)
-
-	err := encoder.Encode(outs)
-	if err != nil {
-		panic(err)
-	}
-
-	return buffer.Bytes()
-}
-
-// DeserializeOutputSlice deserializes a TXoutputSlice from a byte slice
-//
-// 根据字节流反序列化交易输出切片
-func DeserializeOutputSlice(data []byte) TXoutputSlice {
-	var outputs TXoutputSlice
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-
-	err := decoder.Decode(&outputs)
-	if err != nil {
-		panic(err)
-	}
-
-	return outputs
-}
-
 // Lock signs the output
 //
 // 交易输出锁定, 根据收款人的地址计算出公钥哈希并且赋值给交易输出的公钥哈希字段
 // 只有拥有相应私钥的用户（即接收者）才能解锁（也就是花费）这个交易输出。
-func (out *TXoutput) LockAddress(address string) {
-	decodedAddr, err := Base58Decode([]byte(address))
+func (out *TXoutput) LockAddress(address string) error {
+	pubkeyHash, err := DecodeAddress(address)
 	if err != nil {
-		fmt.Println("decode address error", err)
-		panic(err)
+		return err
 	}
 
-	pubkeyHash := decodedAddr[1 : len(decodedAddr)-4]
-
 	out.PublickeyHash = pubkeyHash
+	return nil
+}
+
+// gob numbers the types in the order a process first uses them and writes the numbers into
+// the encoding, so encode a transaction first to keep the txid the same in every process
+//
+// 交易ID和签名都是对gob编码的哈希, 先编码一次交易, 保证交易相关的类型编号不受其他编码的影响
+func init() {
+	Transaction{}.Serialize()
 }
 
 // Serialize returns a serialized Transaction
@@ -95,6 +92,18 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+// DeserializeTransaction returns a deserialized Transaction
+//
+// 根据序列化的数据反序列化交易, 数据可能来自其他节点, 所以返回错误而不是panic
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
 // Hash returns the hash of the Transaction
 func (tx Transaction) Hash() []byte {
 
@@ -107,20 +116,24 @@ func (tx Transaction) Hash() []byte {
 // CoinbaseTx creates a coinbase transaction
 //
 // Coinbase 交易是一种特殊的交易，它没有任何输入，只有一个输出，toaddr是收款地址
-func CoinBaseTx(toAddr string) *Transaction {
+func CoinBaseTx(toAddr string) (*Transaction, error) {
 	// coinbase transaction has no input, so we use an empty byte slice
 	// also, the index of the output is -1 which means it create output without input
 	// the signature is nil
 	// pubkey is also nil
 	txin := TXinput{[]byte{}, -1, nil, []byte{}}
+	pubkeyHash, err := DecodeAddress(toAddr)
+	if err != nil {
+		return nil, fmt.Errorf("coinbase address failed, %w", err)
+	}
 	// value of coinbase transaction is 100
-	txout := TXoutput{COINBASEFEE, AddressToPubkeyHash(toAddr)}
+	txout := TXoutput{COINBASEFEE, pubkeyHash}
 	// create a transaction
 	tx := Transaction{nil, []TXinput{txin}, []TXoutput{txout}}
 	// get the hash of the transaction and set it as the ID
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
 // String returns a human-readable representation of a transaction
@@ -167,59 +180,142 @@ func (txin *TXinput) CanUnlockOutputWith(pubkeyHash []byte) bool {
 
 // CreateTransaction creates a new transaction
 //
-// 创建一个新的交易
-func CreateTransaction(fromAddr, toAddr string, amount int, blockchain *Blockchain) *Transaction {
-	inputs := []TXinput{}
-	outputs := []TXoutput{}
+// 创建一个新的交易, 加密钱包必须先解锁才能签名; 返回找零输出的位置, 没有找零输出时为-1
+// 生成了新的找零地址时, 调用者需要保存钱包
+func CreateTransaction(fromAddr, toAddr string, amount int, opts TxOptions, wallets *Wallets, blockchain *Blockchain) (*Transaction, int, error) {
+	return CreateManyTransaction(fromAddr, []Recipient{{Address: toAddr, Amount: amount}}, opts, wallets, blockchain)
+}
 
-	// create a wallet to get pubkey
-	wallets := CreateWallets()
-	senderKeyPair := wallets.GetWallet(fromAddr) // 根据地址获取公私钥对
+// CreateManyTransaction creates one signed transaction paying every recipient
+//
+// 创建一笔同时支付多个收款人的交易, 手续费按照 opts.FeeSplit 分摊
+func CreateManyTransaction(fromAddr string, recipients []Recipient, opts TxOptions, wallets *Wallets, blockchain *Blockchain) (*Transaction, int, error) {
+	senderKeyPair := wallets.GetWallet(fromAddr) // 根据地址获取公钥
+	if senderKeyPair == nil {
+		if wallets.IsWatchOnly(fromAddr) {
+			return nil, -1, ErrWatchOnly
+		}
+		return nil, -1, fmt.Errorf("address %s is not in the wallet", fromAddr)
+	}
+	privateKey, err := wallets.PrivateKeyOf(fromAddr) // 获取签名用的私钥
+	if err != nil {
+		return nil, -1, err
+	}
 
-	// 获取fromAddr的所有未花费输出的总额和索引
-	actualBalance, tx_index := blockchain.FindSpendableOutputs(PublickeyHash(senderKeyPair.PublicKey), amount)
+	payments, total, err := paymentOutputs(recipients, opts.Fee, opts.FeeSplit)
+	if err != nil {
+		return nil, -1, err
+	}
+	inputs, actualBalance, err := collectInputs(fromAddr, total, senderKeyPair.PublicKey, opts, blockchain)
+	if err != nil {
+		return nil, -1, err
+	}
 
-	// 如果余额不足，返回 nil
-	if actualBalance < amount {
-		fmt.Println("ERROR: Not enough funds")
-		return nil
+	// 没有配置找零地址的时候, 只有真的需要找零才生成新的找零地址, 格式和付款地址相同
+	change := opts.Change
+	if len(change.Address) == 0 && actualBalance-total > change.DustThreshold {
+		change.Address, err = wallets.NewAddress(true, AddressFormatOf(fromAddr))
+		if err != nil {
+			return nil, -1, fmt.Errorf("create change address failed, %w", err)
+		}
 	}
 
-	// iterate over the tx_index mapping, which contains the unspent output index
-	for txid, outputList := range tx_index {
-		txidStr := string(txid)
+	tx, changeIndex, err := assembleTransaction(inputs, payments, actualBalance-total, change)
+	if err != nil {
+		return nil, -1, err
+	}
 
-		// iterate over the outputList, which contains the unspent output index
-		for _, outputIndex := range outputList {
-			// create a new input
-			input := TXinput{[]byte(txidStr), outputIndex, nil, senderKeyPair.PublicKey}
-			// append the input to the inputs
-			inputs = append(inputs, input)
-		}
+	blockchain.SignTransaction(tx, privateKey)
+	return tx, changeIndex, nil
+}
+
+// NewUnsignedTransaction builds a transaction from the outputs of fromAddr without signing it
+//
+// 构建未签名的交易, publickey 会填写到每个交易输入中, 不知道公钥的时候可以为nil, 由签名方填写;
+// opts.Change.Address 为空时找零回到 fromAddr
+func NewUnsignedTransaction(fromAddr, toAddr string, amount int, publickey []byte, opts TxOptions, blockchain *Blockchain) (*Transaction, int, error) {
+	payments, total, err := paymentOutputs([]Recipient{{Address: toAddr, Amount: amount}}, opts.Fee, opts.FeeSplit)
+	if err != nil {
+		return nil, -1, err
+	}
+	inputs, actualBalance, err := collectInputs(fromAddr, total, publickey, opts, blockchain)
+	if err != nil {
+		return nil, -1, err
 	}
 
-	// create a new output for the receiver
-	output := TXoutput{amount, nil}
-	output.LockAddress(toAddr) // 使用收款人的地址锁定交易输出
-	// append the output to the outputs
-	outputs = append(outputs, output)
-
-	// if the actualBalance is greater than the amount,
-	// we need to send the change back to the sender
-	if actualBalance > amount {
-		// create a new output for the sender
-		output := TXoutput{actualBalance - amount, nil}
-		output.LockAddress(fromAddr) // 使用付款人的地址锁定交易输出
-		// append the output to the outputs
-		outputs = append(outputs, output)
+	change := opts.Change
+	if len(change.Address) == 0 {
+		change.Address = fromAddr
+	}
+	return assembleTransaction(inputs, payments, actualBalance-total, change)
+}
+
+// collectInputs spends enough outputs of fromAddr to cover amount
+//
+// 按照选币策略找到fromAddr足够支付amount的未花费输出, 返回交易输入和这些输出的总额
+func collectInputs(fromAddr string, amount int, publickey []byte, opts TxOptions, blockchain *Blockchain) ([]TXinput, int, error) {
+	pubkeyHash, err := DecodeAddress(fromAddr)
+	if err != nil {
+		return nil, 0, err
+	}
+	coins := blockchain.FindSpendableCoins(pubkeyHash)
+
+	selection := opts.Selection
+	if len(selection) == 0 {
+		selection = DEFAULTSELECTION
+	}
+	selected, actualBalance, err := SelectCoins(coins, amount, opts.Change.DustThreshold, selection, opts.Coins)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	inputs := []TXinput{}
+	for _, coin := range selected {
+		inputs = append(inputs, TXinput{coin.TxID, coin.Index, nil, publickey})
+	}
+
+	return inputs, actualBalance, nil
+}
+
+// assembleTransaction adds the change to the payment outputs, returning the change position or -1
+//
+// 在收款输出之外创建找零输出; 找零不超过 change.DustThreshold 的时候不创建找零输出, 这部分金额成为手续费
+func assembleTransaction(inputs []TXinput, payments []TXoutput, changeAmount int, change ChangePolicy) (*Transaction, int, error) {
+	outputs := append([]TXoutput{}, payments...)
+
+	// if the inputs are worth more than the payments and the fee,
+	// we need to send the change back to the change address
+	changeIndex := -1
+	if changeAmount > change.DustThreshold {
+		output := TXoutput{changeAmount, nil}
+		// 使用找零地址锁定交易输出
+		if err := output.LockAddress(change.Address); err != nil {
+			return nil, -1, fmt.Errorf("invalid change address, %w", err)
+		}
+
+		// 找零输出放在随机的位置, 不能根据位置判断哪个输出是找零
+		outputs, changeIndex = insertOutputRandomly(outputs, output)
 	}
 
 	// create a new transaction
 	tx := Transaction{nil, inputs, outputs}
 	tx.ID = tx.Hash()
 
-	blockchain.SignTransaction(&tx, senderKeyPair.PrivateKey)
-	return &tx
+	return &tx, changeIndex, nil
+}
+
+// insertOutputRandomly inserts an output at a random position and returns that position
+func insertOutputRandomly(outputs []TXoutput, output TXoutput) ([]TXoutput, int) {
+	position := len(outputs)
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(outputs)+1)))
+	if err == nil {
+		position = int(n.Int64())
+	}
+
+	outputs = append(outputs, TXoutput{})
+	copy(outputs[position+1:], outputs[position:])
+	outputs[position] = output
+	return outputs, position
 }
 
 // Verify verifies the transaction input
@@ -232,48 +328,61 @@ func (tx *Transaction) Verify(inputTxs map[string]*Transaction) bool {
 	// 这里不是很理解为什么还要判断一次是否在mapping
 	for _, input := range tx.In {
 		// 如果交易输入的TXid不在mapping中，说明交易输入的TXid是无效的
-		if inputTxs[string(input.TXid)] == nil {
+		prevtx := inputTxs[string(input.TXid)]
+		if prevtx == nil {
 			fmt.Println("ERROR: Previous transaction is not correct")
-			panic("ERROR: Previous transaction is not correct")
+			return false
+		}
+		// 交易可能来自其他节点，引用的输出索引必须存在
+		if input.Voutindex < 0 || input.Voutindex >= len(prevtx.Out) {
+			fmt.Println("ERROR: Previous output index is out of range")
+			return false
 		}
 	}
 
-	txcopy := tx.trimmedCopy()
-
-	curve := secp256k1.S256() // secp256k1 椭圆曲线
-
-	// 参考构建交易的时候的签名过程，重新构建交易输入的签名来验证两者是否一致
-	// 参考这个方法的实现 (tx *Transaction) sign
+	prevOuts := make([]TXoutput, len(tx.In))
 	for inputIdx, input := range tx.In {
-		prevtx := inputTxs[string(input.TXid)] // 获取交易输入引用的上一笔交易的结构体
-		// txcopy.In[inputIdx].Signature = nil		// 将交易输入的签名置空, 但是并不需要，因为在trimmedCopy中已经置空了
-		txcopy.In[inputIdx].Pubkey = prevtx.Out[input.Voutindex].PublickeyHash // 将交易输入的公钥置为上一笔交易输出的公钥哈希
-		txcopy.ID = txcopy.Hash()                                              // 重新计算交易的哈希值
-
-		r, s := big.Int{}, big.Int{} // 用来存储交易输入的签名
-		signatureLength := len(input.Signature)
-		r.SetBytes(input.Signature[:(signatureLength / 2)])
-		s.SetBytes(input.Signature[(signatureLength / 2):])
-
-		// get the public key from the signature
-		ecPubkeyX := big.Int{}
-		ecPubkeyY := big.Int{}
+		prevOuts[inputIdx] = inputTxs[string(input.TXid)].Out[input.Voutindex] // 交易输入引用的上一笔交易的输出
+	}
 
-		// 从签名中获取公钥
-		// XXX: 这里会有问题，因为input中的pubkey实际上是pubkeyhash，而不是pubkey, 可能会导致验证失败
-		pubkeyLength := len(input.Pubkey)
-		ecPubkeyX.SetBytes(input.Pubkey[:(pubkeyLength / 2)])
-		ecPubkeyY.SetBytes(input.Pubkey[(pubkeyLength / 2):])
+	return tx.verifySignatures(prevOuts)
+}
 
-		rawPubkey := ecdsa.PublicKey{Curve: curve, X: &ecPubkeyX, Y: &ecPubkeyY}
+// verifySignatures verifies the signature of every input against the output it spends
+//
+// 参考构建交易的时候的签名过程，重新构建交易输入的签名来验证两者是否一致
+// 参考这个方法的实现 (tx *Transaction) sign; 只需要引用的输出, 不需要区块链
+func (tx *Transaction) verifySignatures(prevOuts []TXoutput) bool {
+	prevPubkeyHashes := make([][]byte, len(prevOuts))
+	for inputIdx, prevOut := range prevOuts {
+		prevPubkeyHashes[inputIdx] = prevOut.PublickeyHash
+	}
 
+	for inputIdx, hash := range tx.signatureHashes(prevPubkeyHashes) {
 		// 只要有一个交易输入的签名验证失败，就返回false
-		if !ecdsa.Verify(&rawPubkey, txcopy.ID, &r, &s) {
+		if !verifySignature(tx.In[inputIdx].Pubkey, tx.In[inputIdx].Signature, hash) {
 			return false
 		}
-
-		txcopy.In[inputIdx].Pubkey = nil // 将交易输入的公钥置空 用于下一次循环
 	}
 
 	return true
 }
+
+// verifySignature verifies an r || s signature of hash with an X || Y public key
+func verifySignature(pubkey, signature, hash []byte) bool {
+	curve := secp256k1.S256() // secp256k1 椭圆曲线
+
+	r, s := big.Int{}, big.Int{} // 用来存储交易输入的签名
+	signatureLength := len(signature)
+	r.SetBytes(signature[:(signatureLength / 2)])
+	s.SetBytes(signature[(signatureLength / 2):])
+
+	ecPubkeyX := big.Int{}
+	ecPubkeyY := big.Int{}
+	pubkeyLength := len(pubkey)
+	ecPubkeyX.SetBytes(pubkey[:(pubkeyLength / 2)])
+	ecPubkeyY.SetBytes(pubkey[(pubkeyLength / 2):])
+
+	rawPubkey := ecdsa.PublicKey{Curve: curve, X: &ecPubkeyX, Y: &ecPubkeyY}
+	return ecdsa.Verify(&rawPubkey, hash, &r, &s)
+}
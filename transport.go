@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	TLSRECORDHANDSHAKE = 0x16 // TLS握手记录的第一个字节, 明文命令不会以这个字节开头
+)
+
+var (
+	EncryptTransport  = false                 // 连接其他节点时使用TLS加密
+	RequireEncryption = false                 // 拒绝所有明文连接
+	AllowedPeerKeys   = make(map[string]bool) // 允许连接的节点公钥指纹, 为空表示不限制
+
+	transportTLS *tls.Config // 加密传输的配置, InitTransport 之前为nil
+
+	errPlaintextRejected = errors.New("plaintext connection rejected, encryption is required")
+)
+
+// InitTransport loads or creates the node key and prepares the TLS configuration
+//
+// 加载或者生成节点私钥, 根据私钥生成自签名证书, 节点之间通过证书中的公钥识别对方
+func InitTransport(keyFile string) error {
+	key, err := loadOrCreateNodeKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := selfSignedCert(key)
+	if err != nil {
+		return err
+	}
+
+	transportTLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		// 节点证书都是自签名的, 不使用CA校验证书链, 而是在 verifyPeerKey 中校验对方的公钥
+		InsecureSkipVerify:    true,
+		ClientAuth:            tls.RequestClientCert,
+		VerifyPeerCertificate: verifyPeerKey,
+	}
+	// 配置了白名单之后, 双方都必须出示证书
+	if len(AllowedPeerKeys) > 0 {
+		transportTLS.ClientAuth = tls.RequireAnyClientCert
+	}
+
+	fmt.Printf("node key fingerprint: %s\n", KeyFingerprint(key.Public().(ed25519.PublicKey)))
+	return nil
+}
+
+// LoadAllowedPeerKeys reads one hex key fingerprint per line; blank lines and # comments are ignored
+//
+// 读取节点公钥白名单文件, 每行一个公钥指纹
+func LoadAllowedPeerKeys(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read allowed peers file failed, %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(line); err != nil || len(line) != sha256.Size*2 {
+			return fmt.Errorf("invalid peer key fingerprint %q", line)
+		}
+		AllowedPeerKeys[strings.ToLower(line)] = true
+	}
+
+	return nil
+}
+
+// KeyFingerprint returns the hex SHA-256 of the DER encoded public key
+//
+// 公钥指纹 = SHA256(DER编码的公钥), 用于白名单和日志
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:])
+}
+
+// loadOrCreateNodeKey reads the node key, generating a new one on first start
+func loadOrCreateNodeKey(keyFile string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(keyFile)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("node key file %s is not PEM encoded", keyFile)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse node key failed, %w", err)
+		}
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("node key in %s is not an ed25519 key", keyFile)
+		}
+		return edKey, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read node key failed, %w", err)
+	}
+
+	// 第一次启动, 生成新的节点私钥, 只有文件拥有者可以读写
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate node key failed, %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode node key failed, %w", err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("write node key failed, %w", err)
+	}
+
+	return key, nil
+}
+
+// selfSignedCert wraps the node key in a self-signed certificate
+func selfSignedCert(key ed25519.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate certificate serial failed, %w", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "buildblockchain node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("create node certificate failed, %w", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// verifyPeerKey checks the key of the peer certificate against the allow-list
+//
+// 校验对方证书中的公钥, 配置了白名单的时候只允许白名单中的节点
+func verifyPeerKey(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		if len(AllowedPeerKeys) > 0 {
+			return errors.New("peer did not present a certificate")
+		}
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("parse peer certificate failed, %w", err)
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("peer certificate does not carry an ed25519 key")
+	}
+	// 证书必须是用这个公钥自己签名的, 证明对方确实持有私钥
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return fmt.Errorf("peer certificate is not self-signed, %w", err)
+	}
+
+	if len(AllowedPeerKeys) > 0 && !AllowedPeerKeys[KeyFingerprint(pub)] {
+		return fmt.Errorf("peer key %s is not allowed", KeyFingerprint(pub))
+	}
+	return nil
+}
+
+// dialPeer opens a connection to a node, using TLS when encryption is enabled
+//
+// 连接其他节点, 开启加密传输时使用TLS
+func dialPeer(toAddr string) (net.Conn, error) {
+	if transportTLS == nil || !(EncryptTransport || RequireEncryption || len(AllowedPeerKeys) > 0) {
+		return net.DialTimeout("tcp", toAddr, DIALTIMEOUT)
+	}
+
+	dialer := &net.Dialer{Timeout: DIALTIMEOUT}
+	return tls.DialWithDialer(dialer, "tcp", toAddr, transportTLS)
+}
+
+// peekedConn is a net.Conn whose first bytes have been buffered
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// acceptPeer negotiates the transport of an incoming connection
+//
+// 根据对方发送的第一个字节判断连接是否加密: TLS握手以0x16开头, 明文连接以命令开头
+func acceptPeer(conn net.Conn) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("peek first byte failed, %w", err)
+	}
+	peeked := &peekedConn{conn, reader}
+
+	if first[0] != TLSRECORDHANDSHAKE {
+		if RequireEncryption || len(AllowedPeerKeys) > 0 {
+			return nil, errPlaintextRejected
+		}
+		return peeked, nil
+	}
+
+	if transportTLS == nil {
+		return nil, errors.New("peer wants an encrypted connection but transport encryption is not initialized")
+	}
+
+	tlsConn := tls.Server(peeked, transportTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("tls handshake failed, %w", err)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestTransport initializes the transport with a new node key, restoring the settings after the test
+func useTestTransport(t *testing.T) string {
+	t.Helper()
+
+	savedTLS, savedEncrypt, savedRequire, savedAllowed := transportTLS, EncryptTransport, RequireEncryption, AllowedPeerKeys
+	t.Cleanup(func() {
+		transportTLS, EncryptTransport, RequireEncryption, AllowedPeerKeys = savedTLS, savedEncrypt, savedRequire, savedAllowed
+	})
+
+	AllowedPeerKeys = make(map[string]bool)
+	keyFile := filepath.Join(t.TempDir(), "node.key")
+	if err := InitTransport(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+// nodeKey returns the node key in keyFile and its fingerprint
+func nodeKey(t *testing.T, keyFile string) (ed25519.PrivateKey, string) {
+	t.Helper()
+
+	key, err := loadOrCreateNodeKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, KeyFingerprint(key.Public().(ed25519.PublicKey))
+}
+
+// plaintextDial connects without TLS, like a node that does not encrypt its transport
+func plaintextDial(toAddr string) (net.Conn, error) {
+	return net.Dial("tcp", toAddr)
+}
+
+// exchange sends a command over a dialled connection and returns what acceptPeer lets through
+func exchange(t *testing.T, dial func(string) (net.Conn, error)) (string, error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := dial(listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("version"))
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	accepted, err := acceptPeer(conn)
+	if err != nil {
+		return "", err
+	}
+	received, err := io.ReadAll(accepted)
+	return string(received), err
+}
+
+func TestAcceptPeer(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+		require bool
+		dial    func(string) (net.Conn, error)
+		wantErr error
+	}{
+		{name: "plaintext", dial: dialPeer},
+		{name: "encrypted", encrypt: true, dial: dialPeer},
+		// 自己不主动加密的节点也接受加密连接
+		{name: "encrypted peer", dial: func(toAddr string) (net.Conn, error) {
+			return tls.Dial("tcp", toAddr, transportTLS)
+		}},
+		{name: "encryption required", require: true, dial: dialPeer},
+		{name: "plaintext rejected", require: true, dial: plaintextDial, wantErr: errPlaintextRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestTransport(t)
+			EncryptTransport, RequireEncryption = tt.encrypt, tt.require
+
+			received, err := exchange(t, tt.dial)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("acceptPeer error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && received != "version" {
+				t.Errorf("received %q, want %q", received, "version")
+			}
+		})
+	}
+}
+
+func TestAllowedPeerKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowOwn bool
+		dial     func(string) (net.Conn, error)
+		wantErr  bool
+	}{
+		// 测试中两端使用同一个节点私钥
+		{name: "allowed", allowOwn: true, dial: dialPeer},
+		{name: "not allowed", dial: dialPeer, wantErr: true},
+		{name: "plaintext", allowOwn: true, dial: plaintextDial, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFile := useTestTransport(t)
+			_, own := nodeKey(t, keyFile)
+			AllowedPeerKeys[strings.Repeat("ab", 32)] = true
+			if tt.allowOwn {
+				AllowedPeerKeys[own] = true
+			}
+			if err := InitTransport(keyFile); err != nil {
+				t.Fatal(err)
+			}
+
+			received, err := exchange(t, tt.dial)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("acceptPeer error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && received != "version" {
+				t.Errorf("received %q, want %q", received, "version")
+			}
+		})
+	}
+}
+
+func TestVerifyPeerKey(t *testing.T) {
+	useTestTransport(t)
+	key, fingerprint := nodeKey(t, filepath.Join(t.TempDir(), "peer.key"))
+	cert, err := selfSignedCert(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		rawCerts [][]byte
+		wantErr  bool
+	}{
+		{name: "no allow-list", rawCerts: cert.Certificate},
+		{name: "no allow-list, no certificate"},
+		{name: "allowed", allowed: []string{fingerprint}, rawCerts: cert.Certificate},
+		{name: "not allowed", allowed: []string{strings.Repeat("ab", 32)}, rawCerts: cert.Certificate, wantErr: true},
+		{name: "no certificate", allowed: []string{fingerprint}, wantErr: true},
+		{name: "not a certificate", rawCerts: [][]byte{[]byte("garbage")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AllowedPeerKeys = make(map[string]bool)
+			for _, key := range tt.allowed {
+				AllowedPeerKeys[key] = true
+			}
+			if err := verifyPeerKey(tt.rawCerts, nil); (err != nil) != tt.wantErr {
+				t.Errorf("verifyPeerKey error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadAllowedPeerKeys(t *testing.T) {
+	saved := AllowedPeerKeys
+	t.Cleanup(func() { AllowedPeerKeys = saved })
+
+	key := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "keys", content: "# peers\n\n" + strings.ToUpper(key) + "\n  " + strings.Repeat("cd", 32) + "  \n", want: []string{key, strings.Repeat("cd", 32)}},
+		{name: "short", content: "abcd\n", wantErr: true},
+		{name: "not hex", content: strings.Repeat("zz", 32) + "\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AllowedPeerKeys = make(map[string]bool)
+			file := filepath.Join(t.TempDir(), "peers")
+			if err := os.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := LoadAllowedPeerKeys(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadAllowedPeerKeys error %v, want error %v", err, tt.wantErr)
+			}
+			if len(AllowedPeerKeys) != len(tt.want) {
+				t.Errorf("loaded %d keys, want %d", len(AllowedPeerKeys), len(tt.want))
+			}
+			for _, want := range tt.want {
+				if !AllowedPeerKeys[want] {
+					t.Errorf("key %s not loaded", want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadOrCreateNodeKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "node.key")
+	created, err := loadOrCreateNodeKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("node key file mode %v %v, want 0600", info, err)
+	}
+	// 重启之后使用同一个私钥, 指纹不变
+	loaded, err := loadOrCreateNodeKey(keyFile)
+	if err != nil || !created.Equal(loaded) {
+		t.Errorf("loadOrCreateNodeKey reloaded another key, %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateNodeKey(keyFile); err == nil {
+		t.Error("loadOrCreateNodeKey accepted a file that is not PEM")
+	}
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
 	"golang.org/x/crypto/ripemd160"
@@ -14,16 +15,33 @@ const (
 	// 用于生成地址的版本号
 	MAINNET_VERSION byte = 0x00 // 主网版本号
 	TESTNET_VERSION byte = 0x6f // 测试网版本号
+
+	PUBKEYHASHLENGTH = 20 // RIPEMD160 的长度
+
+	ADDRESSFORMATBASE58 = "base58" // Base58Check 地址, 例如 1FBae9...
+	ADDRESSFORMATBECH32 = "bech32" // Bech32 地址, 例如 bb1q..., 不区分大小写, 校验和可以指出写错的字符
+
+	DEFAULTADDRESSFORMAT = ADDRESSFORMATBASE58
 )
 
+// AddressFormats encode a public key hash as an address of the current network
+var AddressFormats = map[string]func(pubkeyHash []byte) string{
+	ADDRESSFORMATBASE58: func(pubkeyHash []byte) string { return Base58CheckEncode(Params.AddressVersion, pubkeyHash) },
+	ADDRESSFORMATBECH32: EncodeBech32Address,
+}
+
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey // 用于签署交易，保证交易的非伪造性
 	PublicKey  []byte           // 用于验证交易，保证交易的真实性
+
+	encryptedKey []byte // 加密钱包中私钥的密文, 钱包锁定时PrivateKey为空
+	path         string // HD钱包中的派生路径, 随机生成的私钥为空
+	change       bool   // 是否是找零地址
 }
 
 func CreateWallet() *Wallet {
 	privatekey, publickey := GenerateKeyPair()
-	return &Wallet{privatekey, publickey}
+	return &Wallet{PrivateKey: privatekey, PublicKey: publickey}
 }
 
 // GenerateKeyPair generates a new key pair
@@ -41,7 +59,11 @@ func GenerateKeyPair() (ecdsa.PrivateKey, []byte) {
 		panic(err)
 	}
 
-	publickey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// X 和 Y 都补齐到32字节, 否则有前导0的坐标会得到更短的公钥, 验证签名时按长度的一半拆分就会出错,
+	// 导入同一个私钥时计算出的地址也会不同
+	publickey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(publickey[:32])
+	private.PublicKey.Y.FillBytes(publickey[32:])
 
 	return *private, publickey
 }
@@ -54,21 +76,10 @@ func (w *Wallet) GetAddressWithPublickey(version byte) []byte {
 	// 1. calculate the public key hash
 	publickeyHash := PublickeyHash(w.PublicKey)
 
-	// 2～4 concat [version, public key hash, checksum] into one
-	//
-	// 2. put the blockchain version and public key hash together
-	versionedPayload := append([]byte{version}, publickeyHash...)
-
-	// 3. calculate the checksum
-	checkSum := GenerateChecksum(versionedPayload)
-
-	// 4. append the checksum to the versioned payload
-	fullPayload := append(versionedPayload, checkSum...)
-
-	// 5. encode the full payload with base58
-	address := Base58Encode(fullPayload)
+	// 2. put the blockchain version, the public key hash and the checksum together and encode them with base58
+	address := Base58CheckEncode(version, publickeyHash)
 
-	return address
+	return []byte(address)
 }
 
 // PublickeyHash returns the public key hash
@@ -95,25 +106,74 @@ func PublickeyHash(publickey []byte) []byte {
 	return publickeyHash
 }
 
-// ValidateAddress checks if the public key hash is valid
+// DecodeAddress checks an address of the current network in either format and returns its public key hash
 //
-// 验证公钥地址是否有效
-func ValidateAddress(address string) bool {
-	// decode the address to byte slice
-	publickeyHash, _ := Base58Decode([]byte(address))
+// 解析当前网络的地址, Base58Check 和 Bech32 两种格式都可以; 非法字符, 长度错误, 校验和错误, 未知版本号和其他网络的地址分别返回不同的错误
+func DecodeAddress(address string) ([]byte, error) {
+	if isBech32Address(address) {
+		publickeyHash, err := decodeBech32Address(address)
+		if errors.Is(err, ErrWrongNetwork) {
+			return nil, fmt.Errorf("address %s %w", address, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q, %w", address, err)
+		}
+		return publickeyHash, nil
+	}
+
+	version, publickeyHash, err := Base58CheckDecode(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q, %w", address, err)
+	}
+	if len(publickeyHash) != PUBKEYHASHLENGTH {
+		return nil, fmt.Errorf("invalid address %q, %w: public key hash is %d bytes, want %d", address, ErrInvalidLength, len(publickeyHash), PUBKEYHASHLENGTH)
+	}
+
+	if version != Params.AddressVersion {
+		for _, params := range Networks {
+			if params.AddressVersion == version {
+				return nil, fmt.Errorf("address %s %w (version 0x%02x)", address, ErrWrongNetwork, version)
+			}
+		}
+		return nil, fmt.Errorf("invalid address %q, %w 0x%02x", address, ErrUnknownVersion, version)
+	}
 
-	// the last 4 bytes is the checksum
-	actualCheckSum := publickeyHash[len(publickeyHash)-4:]
+	return publickeyHash, nil
+}
 
-	// publickeyHash[0] is the version
-	actualPublickeyHash := publickeyHash[1 : len(publickeyHash)-4]
+// AddressFormatOf returns the format an address is written in
+func AddressFormatOf(address string) string {
+	if isBech32Address(address) {
+		return ADDRESSFORMATBECH32
+	}
+	return ADDRESSFORMATBASE58
+}
 
-	actualVersion := publickeyHash[0]
+// CheckAddressFormat rejects an unknown address format, empty means the default
+func CheckAddressFormat(format string) error {
+	if _, ok := AddressFormats[format]; !ok && len(format) > 0 {
+		return fmt.Errorf("unknown address format %q, must be base58 or bech32", format)
+	}
+	return nil
+}
 
-	// recalculate the checksum according to the version and public key hash
-	targetCheckSum := GenerateChecksum(append([]byte{actualVersion}, actualPublickeyHash...))
+// EncodeAddress encodes a public key hash as an address of the current network
+func EncodeAddress(pubkeyHash []byte, format string) (string, error) {
+	if err := CheckAddressFormat(format); err != nil {
+		return "", err
+	}
+	if len(format) == 0 {
+		format = DEFAULTADDRESSFORMAT
+	}
+	return AddressFormats[format](pubkeyHash), nil
+}
 
-	return bytes.Compare(actualCheckSum, targetCheckSum) == 0
+// ValidateAddress checks if the address is a valid address of the current network
+//
+// 验证公钥地址是否有效
+func ValidateAddress(address string) bool {
+	_, err := DecodeAddress(address)
+	return err == nil
 }
 
 // GenerateChecksum generates a 4-byte checksum for a byte slice
@@ -125,16 +185,3 @@ func GenerateChecksum(data []byte) []byte {
 
 	return secondSHA[:4]
 }
-
-// AddressToPubkeyHash returns the public key hash from an address
-//
-// 根据输入的地址，返回公钥哈希
-func AddressToPubkeyHash(addr string) []byte {
-	decodedAddr, err := Base58Decode([]byte(addr))
-	if err != nil {
-		panic(err)
-	}
-
-	pubkeyHash := decodedAddr[1 : len(decodedAddr)-4]
-	return pubkeyHash
-}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// scrypt 参数: N=2^15, r=8, p=1, 每次派生大约需要32MB内存, 增加暴力破解口令的成本
+	SCRYPT_N      = 1 << 15
+	SCRYPT_R      = 8
+	SCRYPT_P      = 1
+	SCRYPT_KEYLEN = 32 // AES-256
+	SALTLENGTH    = 16
+
+	WALLETUNLOCKTIMEOUT = 60 * time.Second // 签名命令临时解锁钱包的时长
+)
+
+var (
+	ErrWalletLocked       = errors.New("wallet is locked, unlock it with the passphrase first")
+	ErrWalletNotEncrypted = errors.New("wallet is not encrypted")
+	ErrWalletEncrypted    = errors.New("wallet is already encrypted")
+	ErrWrongPassphrase    = errors.New("wrong wallet passphrase")
+
+	// 用来校验口令是否正确的已知明文, 即使钱包中还没有私钥也可以校验口令
+	walletCheckPlaintext = []byte("buildblockchain wallet")
+)
+
+// walletCrypter keeps the key derivation parameters of an encrypted wallet
+//
+// 加密钱包的参数: 口令经过scrypt派生出AES密钥, 每个私钥使用AES-GCM单独加密
+type walletCrypter struct {
+	Salt  []byte // scrypt 的盐
+	N     int
+	R     int
+	P     int
+	Check []byte // 使用派生密钥加密的 walletCheckPlaintext
+}
+
+// newWalletCrypter creates fresh parameters and returns them with the derived key
+func newWalletCrypter(passphrase string) (*walletCrypter, []byte, error) {
+	salt := make([]byte, SALTLENGTH)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, fmt.Errorf("generate wallet salt failed, %w", err)
+	}
+
+	crypter := &walletCrypter{Salt: salt, N: SCRYPT_N, R: SCRYPT_R, P: SCRYPT_P}
+	key, err := crypter.deriveKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crypter.Check, err = sealWithKey(key, walletCheckPlaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return crypter, key, nil
+}
+
+// deriveKey runs scrypt over the passphrase
+func (c *walletCrypter) deriveKey(passphrase string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), c.Salt, c.N, c.R, c.P, SCRYPT_KEYLEN)
+	if err != nil {
+		return nil, fmt.Errorf("derive wallet key failed, %w", err)
+	}
+	return key, nil
+}
+
+// verifyPassphrase derives the key and checks it against the check value
+//
+// 派生密钥并且解密校验值, 解密失败说明口令错误
+func (c *walletCrypter) verifyPassphrase(passphrase string) ([]byte, error) {
+	key, err := c.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openWithKey(key, c.Check)
+	if err != nil || !bytes.Equal(plaintext, walletCheckPlaintext) {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// sealWithKey encrypts data with AES-GCM, the random nonce is put in front of the ciphertext
+//
+// 使用AES-GCM加密, 返回 nonce || 密文, GCM同时保证了数据没有被篡改
+func sealWithKey(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openWithKey decrypts data produced by sealWithKey
+func openWithKey(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// privateKeyBytes returns the 32-byte scalar of a private key
+func privateKeyBytes(key *ecdsa.PrivateKey) []byte {
+	d := make([]byte, 32)
+	key.D.FillBytes(d)
+	return d
+}
+
+// privateKeyFromBytes rebuilds a secp256k1 private key from its scalar
+//
+// 根据私钥的标量重新计算公钥, 得到完整的ecdsa私钥
+func privateKeyFromBytes(d []byte) (ecdsa.PrivateKey, error) {
+	curve := secp256k1.S256()
+
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		return ecdsa.PrivateKey{}, errors.New("invalid private key")
+	}
+
+	var private ecdsa.PrivateKey
+	private.PublicKey.Curve = curve
+	private.D = k
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(d)
+	return private, nil
+}
+
+// IsEncrypted reports whether the private keys are stored encrypted
+func (ws *Wallets) IsEncrypted() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.crypter != nil
+}
+
+// IsLocked reports whether the private keys are currently unavailable
+//
+// 加密的钱包在解锁之前无法签名
+func (ws *Wallets) IsLocked() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.crypter != nil && ws.unlockKey == nil
+}
+
+// EncryptWallet encrypts every private key with a passphrase and locks the wallet
+//
+// 第一次设置口令, 加密所有私钥并且保存到文件中, 之后钱包处于锁定状态
+func (ws *Wallets) EncryptWallet(passphrase string) error {
+	if len(passphrase) == 0 {
+		return errors.New("passphrase is empty")
+	}
+
+	ws.mu.Lock()
+	if ws.crypter != nil {
+		ws.mu.Unlock()
+		return ErrWalletEncrypted
+	}
+
+	crypter, key, err := newWalletCrypter(passphrase)
+	if err != nil {
+		ws.mu.Unlock()
+		return err
+	}
+	for address, wallet := range ws.Wallets {
+		wallet.encryptedKey, err = sealWithKey(key, privateKeyBytes(&wallet.PrivateKey))
+		if err != nil {
+			ws.mu.Unlock()
+			return fmt.Errorf("encrypt key of %s failed, %w", address, err)
+		}
+	}
+	if ws.hd != nil {
+		if err := ws.hd.seal(key); err != nil {
+			ws.mu.Unlock()
+			return fmt.Errorf("encrypt hd seed failed, %w", err)
+		}
+	}
+	ws.crypter = crypter
+	ws.lockLocked()
+	ws.mu.Unlock()
+
+	if !ws.SaveWalletsToFile() {
+		return errors.New("save encrypted wallet failed")
+	}
+	return nil
+}
+
+// WalletPassphrase unlocks the wallet for timeout, after which it locks itself again
+//
+// 使用口令解锁钱包, 超过timeout之后自动重新锁定
+func (ws *Wallets) WalletPassphrase(passphrase string, timeout time.Duration) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.crypter == nil {
+		return ErrWalletNotEncrypted
+	}
+
+	key, err := ws.crypter.verifyPassphrase(passphrase)
+	if err != nil {
+		return err
+	}
+
+	for address, wallet := range ws.Wallets {
+		d, err := openWithKey(key, wallet.encryptedKey)
+		if err != nil {
+			return fmt.Errorf("decrypt key of %s failed, %w", address, err)
+		}
+		wallet.PrivateKey, err = privateKeyFromBytes(d)
+		if err != nil {
+			return fmt.Errorf("decrypt key of %s failed, %w", address, err)
+		}
+	}
+	if ws.hd != nil {
+		if err := ws.hd.open(key); err != nil {
+			return fmt.Errorf("decrypt hd seed failed, %w", err)
+		}
+	}
+	ws.unlockKey = key
+
+	// 重新设置自动锁定的定时器
+	if ws.lockTimer != nil {
+		ws.lockTimer.Stop()
+	}
+	ws.lockTimer = time.AfterFunc(timeout, ws.WalletLock)
+
+	return nil
+}
+
+// WalletLock forgets the decrypted private keys
+//
+// 锁定钱包, 清除内存中解密之后的私钥
+func (ws *Wallets) WalletLock() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.lockLocked()
+}
+
+// lockLocked drops the decrypted keys; caller must hold ws.mu
+func (ws *Wallets) lockLocked() {
+	if ws.crypter == nil {
+		return
+	}
+
+	for _, wallet := range ws.Wallets {
+		if wallet.PrivateKey.D != nil {
+			wallet.PrivateKey.D.SetInt64(0)
+		}
+		wallet.PrivateKey = ecdsa.PrivateKey{}
+	}
+	if ws.hd != nil {
+		ws.hd.wipe()
+	}
+	for i := range ws.unlockKey {
+		ws.unlockKey[i] = 0
+	}
+	ws.unlockKey = nil
+
+	if ws.lockTimer != nil {
+		ws.lockTimer.Stop()
+		ws.lockTimer = nil
+	}
+}
+
+// ChangePassphrase re-encrypts every private key under a new passphrase
+//
+// 修改口令: 使用旧口令解密所有私钥, 使用新的盐和新口令重新加密, 然后重新写入文件
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if len(newPassphrase) == 0 {
+		return errors.New("new passphrase is empty")
+	}
+
+	ws.mu.Lock()
+	if ws.crypter == nil {
+		ws.mu.Unlock()
+		return ErrWalletNotEncrypted
+	}
+
+	oldKey, err := ws.crypter.verifyPassphrase(oldPassphrase)
+	if err != nil {
+		ws.mu.Unlock()
+		return err
+	}
+
+	crypter, newKey, err := newWalletCrypter(newPassphrase)
+	if err != nil {
+		ws.mu.Unlock()
+		return err
+	}
+
+	// 先全部重新加密成功之后再替换, 避免中途失败导致部分私钥使用新口令
+	reencrypted := make(map[string][]byte)
+	for address, wallet := range ws.Wallets {
+		d, err := openWithKey(oldKey, wallet.encryptedKey)
+		if err != nil {
+			ws.mu.Unlock()
+			return fmt.Errorf("decrypt key of %s failed, %w", address, err)
+		}
+		reencrypted[address], err = sealWithKey(newKey, d)
+		if err != nil {
+			ws.mu.Unlock()
+			return fmt.Errorf("encrypt key of %s failed, %w", address, err)
+		}
+	}
+	var hd *hdChain
+	if ws.hd != nil {
+		hd = &hdChain{
+			EncryptedSeed:     ws.hd.EncryptedSeed,
+			EncryptedMnemonic: ws.hd.EncryptedMnemonic,
+			NextReceive:       ws.hd.NextReceive,
+			NextChange:        ws.hd.NextChange,
+		}
+		if err := hd.open(oldKey); err != nil {
+			ws.mu.Unlock()
+			return fmt.Errorf("decrypt hd seed failed, %w", err)
+		}
+		if err := hd.seal(newKey); err != nil {
+			ws.mu.Unlock()
+			return fmt.Errorf("encrypt hd seed failed, %w", err)
+		}
+	}
+	for address, encryptedKey := range reencrypted {
+		ws.Wallets[address].encryptedKey = encryptedKey
+	}
+	if hd != nil {
+		ws.hd = hd
+	}
+	ws.crypter = crypter
+	ws.lockLocked()
+	ws.mu.Unlock()
+
+	if !ws.SaveWalletsToFile() {
+		return errors.New("save re-encrypted wallet failed")
+	}
+	return nil
+}
+
+// PrivateKeyOf returns the signing key of an address
+//
+// 获取地址对应的私钥, 加密钱包必须先解锁
+func (ws *Wallets) PrivateKeyOf(address string) (ecdsa.PrivateKey, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	_, wallet := ws.findWalletLocked(address)
+	if wallet == nil {
+		return ecdsa.PrivateKey{}, fmt.Errorf("address %s is not in the wallet", address)
+	}
+	if ws.crypter != nil && ws.unlockKey == nil {
+		return ecdsa.PrivateKey{}, ErrWalletLocked
+	}
+
+	// 返回私钥的副本, 避免钱包自动锁定时清零正在签名使用的私钥
+	key := wallet.PrivateKey
+	key.D = new(big.Int).Set(wallet.PrivateKey.D)
+	return key, nil
+}
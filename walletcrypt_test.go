@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestWalletFile points the wallet file at a temporary directory for the duration of the test
+func useTestWalletFile(t *testing.T) string {
+	t.Helper()
+
+	saved := Config.WalletFile
+	Config.WalletFile = filepath.Join(t.TempDir(), "wallets.dat")
+	t.Cleanup(func() { Config.WalletFile = saved })
+	return Config.WalletFile
+}
+
+func TestWalletCrypterDeriveKey(t *testing.T) {
+	// RFC 7914 的 scrypt 测试向量, 钱包只使用输出的前32字节
+	crypter := &walletCrypter{Salt: []byte("NaCl"), N: 1024, R: 8, P: 16}
+	key, err := crypter.deriveKey("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162"
+	if hex.EncodeToString(key) != want {
+		t.Errorf("deriveKey = %x, want %s", key, want)
+	}
+}
+
+func TestSealWithKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, SCRYPT_KEYLEN)
+	plaintext := []byte("private key")
+	sealed, err := sealWithKey(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		key     []byte
+		sealed  []byte
+		wantErr bool
+	}{
+		{name: "round trip", key: key, sealed: sealed},
+		{name: "wrong key", key: bytes.Repeat([]byte{2}, SCRYPT_KEYLEN), sealed: sealed, wantErr: true},
+		{name: "tampered", key: key, sealed: tampered, wantErr: true},
+		{name: "too short", key: key, sealed: sealed[:4], wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opened, err := openWithKey(test.key, test.sealed)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("openWithKey error %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && !bytes.Equal(opened, plaintext) {
+				t.Errorf("openWithKey = %q, want %q", opened, plaintext)
+			}
+		})
+	}
+}
+
+func TestEncryptWallet(t *testing.T) {
+	file := useTestWalletFile(t)
+
+	ws := CreateWallets()
+	ws.loaded = true
+	address, err := ws.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := privateKeyBytes(&ws.GetWallet(address).PrivateKey)
+
+	if err := ws.WalletPassphrase("secret", time.Minute); !errors.Is(err, ErrWalletNotEncrypted) {
+		t.Fatalf("WalletPassphrase before encryption error %v, want %v", err, ErrWalletNotEncrypted)
+	}
+	if err := ws.EncryptWallet("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.EncryptWallet("again"); !errors.Is(err, ErrWalletEncrypted) {
+		t.Errorf("EncryptWallet twice error %v, want %v", err, ErrWalletEncrypted)
+	}
+
+	// 文件中只有私钥的密文
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, original) {
+		t.Error("wallet file contains the plaintext private key")
+	}
+
+	if !ws.IsLocked() {
+		t.Error("wallet is not locked after encryption")
+	}
+	if _, err := ws.PrivateKeyOf(address); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("PrivateKeyOf locked wallet error %v, want %v", err, ErrWalletLocked)
+	}
+	if err := ws.WalletPassphrase("wrong", time.Minute); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("WalletPassphrase with a wrong passphrase error %v, want %v", err, ErrWrongPassphrase)
+	}
+
+	// 修改口令之后重新读取文件, 只有新口令可以解锁
+	if err := ws.ChangePassphrase("secret", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := CreateWallets()
+	if !reloaded.ReadWalletsFromFile() {
+		t.Fatal("read encrypted wallet failed")
+	}
+	if err := reloaded.WalletPassphrase("secret", time.Minute); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("WalletPassphrase with the old passphrase error %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err := reloaded.WalletPassphrase("new secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	key, err := reloaded.PrivateKeyOf(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(privateKeyBytes(&key), original) {
+		t.Error("unlocked private key differs from the original")
+	}
+
+	reloaded.WalletLock()
+	if _, err := reloaded.PrivateKeyOf(address); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("PrivateKeyOf after WalletLock error %v, want %v", err, ErrWalletLocked)
+	}
+
+	// 超时之后自动锁定
+	if err := reloaded.WalletPassphrase("new secret", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !reloaded.IsLocked() {
+		t.Error("wallet is still unlocked after the timeout")
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	TXDIRECTIONSEND     = "send"     // 花费了钱包的输出, 付款给其他地址
+	TXDIRECTIONRECEIVE  = "receive"  // 其他地址付款给钱包
+	TXDIRECTIONSELF     = "self"     // 钱包付款给自己, 例如合并零钱
+	TXDIRECTIONGENERATE = "generate" // 挖矿得到的coinbase
+
+	UNCONFIRMEDHEIGHT = -1 // 交易还没有被打包进主链的区块
+)
+
+var (
+	ErrUnknownLabelTarget = errors.New("label target is neither a wallet transaction nor a valid address")
+)
+
+// JournalEntry is a wallet transaction as recorded in the journal
+//
+// 钱包的一条交易记录; Amount 是钱包余额的变化, 付款时为负数并且包含手续费
+type JournalEntry struct {
+	TxID           []byte
+	Direction      string
+	Amount         int
+	Fee            int      // 只有钱包支付了全部输入时才知道手续费
+	Counterparties []string // 付款时是收款地址, 收款时是付款地址
+	Addresses      []string // 收到付款的钱包地址
+	ChangeOutputs  []int    // 付给钱包找零地址的输出索引
+	BlockHash      []byte   // 未确认时为nil
+	Height         int64    // 未确认时为 UNCONFIRMEDHEIGHT
+	Time           int64    // 区块的时间, 未确认时是记录的时间
+	WatchOnly      bool     // 只涉及只读地址
+}
+
+// Confirmations returns how many blocks confirm the entry when the chain tip is at tipHeight
+func (e JournalEntry) Confirmations(tipHeight int64) int64 {
+	if e.Height == UNCONFIRMEDHEIGHT {
+		return 0
+	}
+	return tipHeight - e.Height + 1
+}
+
+// RecentTransactions returns the count most recent entries after skipping the skip most recent ones, oldest first
+//
+// count 为0时返回全部; 只读地址的交易只有 includeWatchOnly 为true时才返回
+func RecentTransactions(entries []JournalEntry, count, skip int, includeWatchOnly bool) []JournalEntry {
+	selected := []JournalEntry{}
+	for _, entry := range entries {
+		if entry.WatchOnly && !includeWatchOnly {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	end := len(selected) - skip
+	if end < 0 {
+		end = 0
+	}
+	start := 0
+	if count > 0 && end-count > 0 {
+		start = end - count
+	}
+	return selected[start:end]
+}
+
+// txJournal is the transaction history of a wallet
+//
+// 钱包的交易记录: 区块连接时记录钱包相关的交易, 区块断开时把交易改回未确认
+type txJournal struct {
+	Entries    []*JournalEntry
+	Labels     map[string]string // 交易ID(hex)或者地址 -> 标签
+	SyncedHash []byte            // 已经处理过的最新区块, nil 表示需要从创世区块开始扫描
+}
+
+func newTxJournal() *txJournal {
+	return &txJournal{Labels: make(map[string]string)}
+}
+
+// snapshot copies the journal so it can be encoded without holding the wallet lock
+func (j *txJournal) snapshot() *txJournal {
+	copied := &txJournal{Labels: make(map[string]string), SyncedHash: j.SyncedHash}
+	for _, entry := range j.Entries {
+		e := *entry
+		copied.Entries = append(copied.Entries, &e)
+	}
+	for key, label := range j.Labels {
+		copied.Labels[key] = label
+	}
+	return copied
+}
+
+// find returns the entry of a transaction
+func (j *txJournal) find(txID []byte) *JournalEntry {
+	for _, entry := range j.Entries {
+		if bytes.Equal(entry.TxID, txID) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// PubkeyHashToAddress encodes a public key hash as an address
+//
+// 交易输出只保存公钥哈希, 不知道付款时使用的地址格式; 交易记录和标签统一使用 Base58 地址
+func PubkeyHashToAddress(pubkeyHash []byte, version byte) string {
+	return Base58CheckEncode(version, pubkeyHash)
+}
+
+// ownedPubkeyHashesLocked maps the public key hash of every address of the wallet to whether it is watch-only
+func (ws *Wallets) ownedPubkeyHashesLocked() map[string]bool {
+	owned := make(map[string]bool)
+	for _, watch := range ws.watchOnly {
+		owned[string(watch.PubkeyHash)] = true
+	}
+	for _, wallet := range ws.Wallets {
+		owned[string(PublickeyHash(wallet.PublicKey))] = false
+	}
+	return owned
+}
+
+// changePubkeyHashesLocked returns the public key hashes of the change addresses of the wallet
+func (ws *Wallets) changePubkeyHashesLocked() map[string]bool {
+	change := make(map[string]bool)
+	for _, wallet := range ws.Wallets {
+		if wallet.change {
+			change[string(PublickeyHash(wallet.PublicKey))] = true
+		}
+	}
+	return change
+}
+
+// changeOutputs returns the indexes of the outputs of tx paid to a change address
+func changeOutputs(tx *Transaction, change map[string]bool) []int {
+	var indexes []int
+	for index, output := range tx.Out {
+		if change[string(output.PublickeyHash)] {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// describeTransaction works out what a transaction means to the wallet, nil if it does not touch the wallet
+//
+// prevouts 缓存钱包的输出金额, 找不到时在区块链中查找输入引用的交易
+func describeTransaction(tx *Transaction, owned map[string]bool, prevouts map[string]int, bc *Blockchain) *JournalEntry {
+	entry := &JournalEntry{TxID: tx.ID, WatchOnly: true}
+	touched := false
+
+	spent, allInputsMine := 0, true
+	if !tx.IsCoinbase() {
+		for _, input := range tx.In {
+			watchOnly, mine := owned[string(PublickeyHash(input.Pubkey))]
+			if !mine {
+				allInputsMine = false
+				continue
+			}
+			touched = true
+			entry.WatchOnly = entry.WatchOnly && watchOnly
+
+			outpoint := Outpoint{TxID: input.TXid, Index: input.Voutindex}.String()
+			value, ok := prevouts[outpoint]
+			if !ok {
+				prevtx, err := bc.FindTxByID(input.TXid)
+				if err == nil && input.Voutindex >= 0 && input.Voutindex < len(prevtx.Out) {
+					value = prevtx.Out[input.Voutindex].Value
+				}
+			}
+			spent += value
+		}
+	}
+
+	received, external, total := 0, 0, 0
+	var payees []string
+	for index, output := range tx.Out {
+		total += output.Value
+		watchOnly, mine := owned[string(output.PublickeyHash)]
+		if !mine {
+			external += output.Value
+			payees = append(payees, PubkeyHashToAddress(output.PublickeyHash, Params.AddressVersion))
+			continue
+		}
+		touched = true
+		entry.WatchOnly = entry.WatchOnly && watchOnly
+		entry.Addresses = append(entry.Addresses, PubkeyHashToAddress(output.PublickeyHash, Params.AddressVersion))
+		received += output.Value
+		prevouts[Outpoint{TxID: tx.ID, Index: index}.String()] = output.Value
+	}
+	if !touched {
+		return nil
+	}
+
+	switch {
+	case tx.IsCoinbase():
+		entry.Direction = TXDIRECTIONGENERATE
+		entry.Amount = received
+	case spent > 0:
+		if allInputsMine {
+			entry.Fee = spent - total
+		}
+		entry.Direction = TXDIRECTIONSEND
+		if external == 0 {
+			entry.Direction = TXDIRECTIONSELF
+		}
+		entry.Amount = received - spent
+		entry.Counterparties = payees
+	default:
+		entry.Direction = TXDIRECTIONRECEIVE
+		entry.Amount = received
+		for _, input := range tx.In {
+			payer := PubkeyHashToAddress(PublickeyHash(input.Pubkey), Params.AddressVersion)
+			if len(entry.Counterparties) == 0 || entry.Counterparties[len(entry.Counterparties)-1] != payer {
+				entry.Counterparties = append(entry.Counterparties, payer)
+			}
+		}
+	}
+	return entry
+}
+
+// connectBlockLocked records the wallet transactions of a block that joined the main chain
+func (ws *Wallets) connectBlockLocked(block *Block, owned map[string]bool, prevouts map[string]int, bc *Blockchain) int {
+	change := ws.changePubkeyHashesLocked()
+	connected := 0
+	for _, tx := range block.Transactions {
+		described := describeTransaction(tx, owned, prevouts, bc)
+		if described == nil {
+			continue
+		}
+		described.ChangeOutputs = changeOutputs(tx, change)
+
+		// 区块中可能有重复的交易(默克尔树补齐), 已经记录过的交易只更新区块信息
+		entry := ws.journal.find(tx.ID)
+		if entry == nil {
+			entry = described
+			ws.journal.Entries = append(ws.journal.Entries, entry)
+		} else if entry.Height == UNCONFIRMEDHEIGHT {
+			*entry = *described
+		}
+		if !bytes.Equal(entry.BlockHash, block.Hash) {
+			connected++
+		}
+		entry.BlockHash = block.Hash
+		entry.Height = block.Height
+		entry.Time = block.Time
+	}
+
+	ws.journal.SyncedHash = block.Hash
+	return connected
+}
+
+// disconnectBlockLocked marks the wallet transactions of a block that left the main chain as unconfirmed
+func (ws *Wallets) disconnectBlockLocked(block *Block) int {
+	disconnected := 0
+	for _, entry := range ws.journal.Entries {
+		if bytes.Equal(entry.BlockHash, block.Hash) {
+			entry.BlockHash = nil
+			entry.Height = UNCONFIRMEDHEIGHT
+			disconnected++
+		}
+	}
+
+	ws.journal.SyncedHash = block.PrevBlockHash
+	return disconnected
+}
+
+// SyncJournal brings the journal up to the tip of the chain, returning how many transactions were
+// confirmed and unconfirmed
+//
+// 同步交易记录: 从最新区块往回找到上次处理过的区块; 找不到说明发生了分叉,
+// 先断开旧分支上的区块, 再从分叉点开始连接主链上的区块
+func (ws *Wallets) SyncJournal(bc *Blockchain) (int, int) {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	synced := ws.journal.SyncedHash
+
+	// 新区块在前, 遇到上次处理过的区块就停下
+	var blocks []*Block
+	onMainChain := make(map[string]bool)
+	found := false
+	iterator := bc.Iterator()
+	for {
+		block := iterator.Next()
+		if len(synced) > 0 && bytes.Equal(block.Hash, synced) {
+			found = true
+			break
+		}
+		blocks = append(blocks, block)
+		onMainChain[string(block.Hash)] = true
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	disconnected := 0
+	if !found && len(synced) > 0 {
+		// 沿着旧分支往回走到主链上, 这些区块已经不在主链上了
+		hash := synced
+		for len(hash) > 0 && !onMainChain[string(hash)] {
+			block, err := bc.GetBlock(hash)
+			if err != nil {
+				break
+			}
+			disconnected += ws.disconnectBlockLocked(&block)
+			hash = block.PrevBlockHash
+		}
+
+		// 只需要连接分叉点之后的区块
+		for i, block := range blocks {
+			if bytes.Equal(block.Hash, hash) {
+				blocks = blocks[:i]
+				break
+			}
+		}
+	}
+
+	owned := ws.ownedPubkeyHashesLocked()
+	prevouts := make(map[string]int)
+	connected := 0
+	for i := len(blocks) - 1; i >= 0; i-- {
+		connected += ws.connectBlockLocked(blocks[i], owned, prevouts, bc)
+	}
+	return connected, disconnected
+}
+
+// RecordTransaction adds a transaction created by the wallet as unconfirmed
+//
+// 记录钱包刚刚创建的交易, 打包进区块之后同步交易记录时更新为已确认
+func (ws *Wallets) RecordTransaction(tx *Transaction, bc *Blockchain) {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.journal.find(tx.ID) != nil {
+		return
+	}
+	entry := describeTransaction(tx, ws.ownedPubkeyHashesLocked(), make(map[string]int), bc)
+	if entry == nil {
+		return
+	}
+	entry.ChangeOutputs = changeOutputs(tx, ws.changePubkeyHashesLocked())
+	entry.Height = UNCONFIRMEDHEIGHT
+	entry.Time = time.Now().Unix()
+	ws.journal.Entries = append(ws.journal.Entries, entry)
+}
+
+// Transactions returns the journal from the oldest to the newest, unconfirmed transactions last
+func (ws *Wallets) Transactions() []JournalEntry {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	entries := []JournalEntry{}
+	for _, entry := range ws.journal.Entries {
+		entries = append(entries, *entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		hi, hj := entries[i].Height, entries[j].Height
+		if (hi == UNCONFIRMEDHEIGHT) != (hj == UNCONFIRMEDHEIGHT) {
+			return hj == UNCONFIRMEDHEIGHT
+		}
+		return hi < hj
+	})
+	return entries
+}
+
+// SetLabel labels a wallet transaction (hex txid) or an address; an empty label removes it
+//
+// 给交易或者地址设置标签, 交易没有标签时显示对方地址的标签
+func (ws *Wallets) SetLabel(target, label string) error {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	txID, err := hex.DecodeString(target)
+	isTx := err == nil && ws.journal.find(txID) != nil
+	if isTx {
+		target = hex.EncodeToString(txID)
+	} else {
+		pubkeyHash, err := DecodeAddress(target)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUnknownLabelTarget, target)
+		}
+		// Bech32 地址的标签也保存在 Base58 地址下, 和交易记录中的地址一致
+		target = PubkeyHashToAddress(pubkeyHash, Params.AddressVersion)
+	}
+
+	if len(label) == 0 {
+		delete(ws.journal.Labels, target)
+	} else {
+		ws.journal.Labels[target] = label
+	}
+	return nil
+}
+
+// LabelOf returns the label of a journal entry, falling back to the first labelled address it involves
+func (ws *Wallets) LabelOf(entry JournalEntry) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if label, ok := ws.journal.Labels[hex.EncodeToString(entry.TxID)]; ok {
+		return label
+	}
+	for _, address := range append(entry.Counterparties, entry.Addresses...) {
+		if label, ok := ws.journal.Labels[address]; ok {
+			return label
+		}
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangeOutputs(t *testing.T) {
+	change := map[string]bool{"change": true}
+	output := func(pkh string) TXoutput { return TXoutput{Value: 1, PublickeyHash: []byte(pkh)} }
+
+	tests := []struct {
+		name string
+		outs []TXoutput
+		want []int
+	}{
+		{"no change", []TXoutput{output("payee")}, nil},
+		{"change last", []TXoutput{output("payee"), output("change")}, []int{1}},
+		{"change first", []TXoutput{output("change"), output("payee")}, []int{0}},
+		{"consolidation", []TXoutput{output("change"), output("change")}, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changeOutputs(&Transaction{Out: tt.outs}, change); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changeOutputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := formatIndexes([]int{0, 2}); got != "0,2" {
+		t.Errorf("formatIndexes() = %q, want \"0,2\"", got)
+	}
+}
+
+func TestDescribeTransaction(t *testing.T) {
+	mine, other, watch := []byte("mine"), []byte("other"), []byte("watch")
+	owned := map[string]bool{string(PublickeyHash(mine)): false, string(PublickeyHash(watch)): true}
+	output := func(pubkey []byte, value int) TXoutput {
+		return TXoutput{Value: value, PublickeyHash: PublickeyHash(pubkey)}
+	}
+	input := func(pubkey []byte, index int) TXinput {
+		return TXinput{TXid: []byte("funding"), Voutindex: index, Pubkey: pubkey}
+	}
+
+	tests := []struct {
+		name      string
+		tx        *Transaction
+		direction string
+		amount    int
+		fee       int
+		watchOnly bool
+	}{
+		{
+			name:      "coinbase",
+			tx:        &Transaction{In: []TXinput{{TXid: []byte{}, Voutindex: -1}}, Out: []TXoutput{output(mine, 100)}},
+			direction: TXDIRECTIONGENERATE,
+			amount:    100,
+		},
+		{
+			name:      "receive",
+			tx:        &Transaction{In: []TXinput{input(other, 1)}, Out: []TXoutput{output(mine, 30), output(other, 60)}},
+			direction: TXDIRECTIONRECEIVE,
+			amount:    30,
+		},
+		{
+			// 钱包支付了全部输入, 余额的变化包含手续费
+			name:      "send",
+			tx:        &Transaction{In: []TXinput{input(mine, 0)}, Out: []TXoutput{output(other, 60), output(mine, 35)}},
+			direction: TXDIRECTIONSEND,
+			amount:    -65,
+			fee:       5,
+		},
+		{
+			name:      "self",
+			tx:        &Transaction{In: []TXinput{input(mine, 0)}, Out: []TXoutput{output(mine, 98)}},
+			direction: TXDIRECTIONSELF,
+			amount:    -2,
+			fee:       2,
+		},
+		{
+			// 其他人也支付了输入, 不知道手续费
+			name:      "shared inputs",
+			tx:        &Transaction{In: []TXinput{input(mine, 0), input(other, 1)}, Out: []TXoutput{output(other, 150)}},
+			direction: TXDIRECTIONSEND,
+			amount:    -100,
+		},
+		{
+			name:      "watch-only",
+			tx:        &Transaction{In: []TXinput{input(other, 1)}, Out: []TXoutput{output(watch, 20)}},
+			direction: TXDIRECTIONRECEIVE,
+			amount:    20,
+			watchOnly: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tx.ID = []byte(tt.name)
+			prevouts := map[string]int{Outpoint{TxID: []byte("funding"), Index: 0}.String(): 100}
+			entry := describeTransaction(tt.tx, owned, prevouts, nil)
+			if entry == nil {
+				t.Fatal("describeTransaction = nil for a wallet transaction")
+			}
+			if entry.Direction != tt.direction || entry.Amount != tt.amount || entry.Fee != tt.fee || entry.WatchOnly != tt.watchOnly {
+				t.Errorf("describeTransaction = %s %d fee %d watch-only %v, want %s %d fee %d watch-only %v",
+					entry.Direction, entry.Amount, entry.Fee, entry.WatchOnly, tt.direction, tt.amount, tt.fee, tt.watchOnly)
+			}
+		})
+	}
+
+	unrelated := &Transaction{ID: []byte("unrelated"), In: []TXinput{input(other, 1)}, Out: []TXoutput{output(other, 10)}}
+	if entry := describeTransaction(unrelated, owned, map[string]int{}, nil); entry != nil {
+		t.Errorf("describeTransaction = %+v for a transaction outside the wallet, want nil", entry)
+	}
+}
+
+func TestSyncJournalReorg(t *testing.T) {
+	useTestWalletFile(t)
+	bc := newTestBlockchain(t)
+	genesis, err := bc.GetBlock(bc.GetTopHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := CreateWallets()
+	ws.loaded = true
+	address, err := ws.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkeyHash, err := DecodeAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pay := &Transaction{
+		ID:  []byte("pay"),
+		In:  []TXinput{{TXid: []byte("funding"), Voutindex: 0, Pubkey: []byte("other")}},
+		Out: []TXoutput{{Value: 30, PublickeyHash: pubkeyHash}},
+	}
+	block := func(hash string, parent *Block, txs ...*Transaction) *Block {
+		b := &Block{Hash: []byte(hash), PrevBlockHash: parent.Hash, Height: parent.Height + 1, Transactions: txs}
+		bc.AddBlockBy(b)
+		return b
+	}
+	check := func(step string, wantConnected, wantDisconnected int, wantBlock *Block) {
+		t.Helper()
+
+		connected, disconnected := ws.SyncJournal(bc)
+		if connected != wantConnected || disconnected != wantDisconnected {
+			t.Errorf("%s: SyncJournal = %d connected %d disconnected, want %d %d", step, connected, disconnected, wantConnected, wantDisconnected)
+		}
+		entries := ws.Transactions()
+		if len(entries) != 1 {
+			t.Fatalf("%s: journal has %d entries, want 1", step, len(entries))
+		}
+		wantHeight, wantHash := int64(UNCONFIRMEDHEIGHT), []byte(nil)
+		if wantBlock != nil {
+			wantHeight, wantHash = wantBlock.Height, wantBlock.Hash
+		}
+		if entries[0].Height != wantHeight || !reflect.DeepEqual(entries[0].BlockHash, wantHash) {
+			t.Errorf("%s: entry in block %q at height %d, want %q at %d", step, entries[0].BlockHash, entries[0].Height, wantHash, wantHeight)
+		}
+	}
+
+	a1 := block("a1", &genesis, pay)
+	check("confirm", 1, 0, a1)
+
+	// 更长的分支重新打包了这笔交易: 先断开 a1, 再在 b2 中确认
+	b1 := block("b1", &genesis)
+	b2 := block("b2", b1, pay)
+	check("reorg", 1, 1, b2)
+
+	// 再换到没有这笔交易的分支, 交易变回未确认
+	c1 := block("c1", &genesis)
+	c2 := block("c2", c1)
+	block("c3", c2)
+	check("reorg away", 0, 1, nil)
+}
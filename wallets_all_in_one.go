@@ -3,19 +3,54 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
 )
 
 const (
-	WALLETSFILE = "wallets.dat"
+	WALLETMAGIC         = "BBWALLET" // 新格式钱包文件的文件头, 没有这个文件头的是旧格式(直接gob编码的Wallets)
+	WALLETFORMATVERSION = 2          // 版本2在文件头之后增加了校验和
 )
 
 type Wallets struct {
 	Wallets map[string]*Wallet // map[address]*Wallet
+
+	mu        sync.Mutex
+	crypter   *walletCrypter        // 钱包口令的参数, nil 表示钱包没有加密
+	hd        *hdChain              // HD种子, nil 表示钱包中的私钥都是随机生成的
+	watchOnly map[string]*WatchOnly // 只读地址, 没有私钥, 只用来查询余额和构建未签名的交易
+	journal   *txJournal            // 钱包的交易记录和标签
+	loaded    bool                  // 是否已经读取过钱包文件
+	unlockKey []byte                // 钱包解锁期间由口令派生出的密钥, nil 表示钱包已锁定
+	lockTimer *time.Timer           // 到期之后自动锁定钱包
+}
+
+// walletFile is the on-disk layout of the wallet
+//
+// 钱包文件的内容: 地址和公钥是明文, 加密钱包的私钥只保存密文
+type walletFile struct {
+	Version int
+	Network string         // 钱包所属的网络, 为空表示主网(旧版本的钱包文件)
+	Crypter *walletCrypter // nil 表示私钥没有加密
+	HD      *hdChain       // nil 表示没有HD种子
+	Keys    []walletKey
+	Watch   []WatchOnly // 只读地址
+	Journal *txJournal  // 交易记录, 旧版本的钱包文件中为nil
+}
+
+type walletKey struct {
+	Address      string
+	PublicKey    []byte
+	PrivateKey   []byte // 没有加密时的私钥
+	EncryptedKey []byte // 加密之后的私钥, nonce || 密文
+	Path         string // HD派生路径
+	Change       bool   // 是否是找零地址
 }
 
 // CreateWallets creates a new wallets to store a number of wallets
@@ -24,28 +59,54 @@ type Wallets struct {
 func CreateWallets() *Wallets {
 	ws := &Wallets{}
 	ws.Wallets = make(map[string]*Wallet) // 初始化map, 任何对nil map的操作都会引发panic
+	ws.watchOnly = make(map[string]*WatchOnly)
+	ws.journal = newTxJournal()
 	return ws
 }
 
 // CreateWalletRandomly creates a wallet randomly
 //
-// 随机创建一个钱包并且保存到mapping中, 返回钱包的公钥地址
-func (ws *Wallets) CreateWalletRandomly() string {
-	wallet := CreateWallet()
-	address := wallet.GetAddressWithPublickey(MAINNET_VERSION)
-	ws.Wallets[string(address)] = wallet
+// 随机创建一个钱包并且保存到mapping中, 返回钱包的公钥地址; 加密的钱包必须先解锁才能加密新的私钥
+func (ws *Wallets) CreateWalletRandomly() (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
 
-	return string(address)
+	return ws.addKeyLocked(CreateWallet(), DEFAULTADDRESSFORMAT)
 }
 
+// GetWallet returns the key pair of an address in either format, reading the wallet file on first use
 func (ws *Wallets) GetWallet(address string) *Wallet {
-	ws.ReadWalletsFromFile()
-	return ws.Wallets[address]
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	_, wallet := ws.findWalletLocked(address)
+	return wallet
+}
+
+// findWalletLocked returns the key of an address and the address it is stored under; caller must hold ws.mu
+//
+// 同一个私钥的 Base58 地址和 Bech32 地址锁定的是同一个公钥哈希, 钱包中只保存生成时的那个格式
+func (ws *Wallets) findWalletLocked(address string) (string, *Wallet) {
+	if wallet, ok := ws.Wallets[address]; ok {
+		return address, wallet
+	}
+	pubkeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return "", nil
+	}
+	for stored, wallet := range ws.Wallets {
+		if bytes.Equal(PublickeyHash(wallet.PublicKey), pubkeyHash) {
+			return stored, wallet
+		}
+	}
+	return "", nil
 }
 
 func (ws *Wallets) getAllAddress() []string {
 	var addresses []string
-	ws.ReadWalletsFromFile()
+	ws.ensureLoaded()
 	// iterate over all keys in the map
 	for address := range ws.Wallets {
 		addresses = append(addresses, address)
@@ -54,36 +115,65 @@ func (ws *Wallets) getAllAddress() []string {
 	return addresses
 }
 
+// IsChangeAddress reports whether an address of the wallet was created to receive change
+func (ws *Wallets) IsChangeAddress(address string) bool {
+	wallet := ws.GetWallet(address)
+	return wallet != nil && wallet.change
+}
+
+// ensureLoaded reads the wallet file unless it has been read already
+func (ws *Wallets) ensureLoaded() {
+	if !ws.loaded {
+		ws.ReadWalletsFromFile()
+	}
+}
+
 // SaveWalletsToFile saves wallets to file
 //
-// 将钱包保存到文件中
+// 将钱包保存到文件中, 加密钱包只写入私钥的密文
 func (ws *Wallets) SaveWalletsToFile() bool {
+	ws.mu.Lock()
+	content := walletFile{Version: WALLETFORMATVERSION, Network: Params.Name, Crypter: ws.crypter}
+	if ws.hd != nil {
+		hd := *ws.hd
+		// 加密钱包即使处于解锁状态也只写入种子的密文
+		if ws.crypter != nil {
+			hd.Seed, hd.Mnemonic = nil, ""
+		} else {
+			hd.EncryptedSeed, hd.EncryptedMnemonic = nil, nil
+		}
+		content.HD = &hd
+	}
+	for address, wallet := range ws.Wallets {
+		key := walletKey{Address: address, PublicKey: wallet.PublicKey, Path: wallet.path, Change: wallet.change}
+		if ws.crypter != nil {
+			key.EncryptedKey = wallet.encryptedKey
+		} else {
+			key.PrivateKey = privateKeyBytes(&wallet.PrivateKey)
+		}
+		content.Keys = append(content.Keys, key)
+	}
+	for _, watch := range ws.watchOnly {
+		content.Watch = append(content.Watch, *watch)
+	}
+	content.Journal = ws.journal.snapshot()
+	ws.mu.Unlock()
+
 	// Go 语言标准库中的一个类型，它是一个可以读写的字节缓冲区。你可以向这个缓冲区写入字节，也可以从这个缓冲区读取字节
 	var buffer bytes.Buffer
-	gob.Register(secp256k1.S256()) // 注册椭圆曲线
 	// 将数据编码为 gob 格式，也就是binary格式, 编码后的数据会被写入到 buffer 中
 	encoder := gob.NewEncoder(&buffer)
 	// 执行编码操作, 传递结构体指针避免传递大结构体
-	err := encoder.Encode(ws)
+	err := encoder.Encode(&content)
 	if err != nil {
 		fmt.Printf("create encoder failed while saving wallet to file : %v\n", err)
 		return false
 	}
 
-	// 将 buffer 中的数据以追加的形式写入到文件中，0666 表示文件所有用户可读可写
-	// os.O_CREATE模式表示如果文件不存在，那么会创建一个新的文件。
-	// os.O_WRONLY模式表示文件被打开以供写入数据，不能用于读取数据。
-	file, err := os.OpenFile(WALLETSFILE, os.O_CREATE|os.O_WRONLY, 0666)
+	// 写入临时文件之后原子替换, 并且保留上一个版本作为备份; 0600 表示只有文件拥有者可读可写
+	err = writeWalletFile(Config.WalletFile, encodeWalletEnvelope(buffer.Bytes()))
 	if err != nil {
-		fmt.Printf("open wallets file failed: %v\n", err)
-		return false
-	}
-	defer file.Close()
-
-	// 将 buffer 中的数据写入到文件中
-	_, err = file.Write(buffer.Bytes())
-	if err != nil {
-		fmt.Printf("write wallets file failed: %v\n", err)
+		fmt.Printf("save wallets file failed: %v\n", err)
 		return false
 	}
 
@@ -92,16 +182,18 @@ func (ws *Wallets) SaveWalletsToFile() bool {
 
 // ReadWalletsFromFile reads wallets from file
 //
-// 从文件中读取钱包
+// 从文件中读取钱包, 加密钱包读取之后处于锁定状态
 func (ws *Wallets) ReadWalletsFromFile() bool {
+	ws.loaded = true
+
 	// 判断文件是否存在
-	if _, err := os.Stat(WALLETSFILE); os.IsNotExist(err) {
+	if _, err := os.Stat(Config.WalletFile); os.IsNotExist(err) {
 		fmt.Printf("wallets file doesn't exist! we will create a new one!\n")
 		return false
 	}
 
 	// 打开文件
-	file, err := os.Open(WALLETSFILE)
+	file, err := os.Open(Config.WalletFile)
 	if err != nil {
 		fmt.Printf("open wallets file failed: %v\n", err)
 		return false
@@ -115,19 +207,106 @@ func (ws *Wallets) ReadWalletsFromFile() bool {
 		return false
 	}
 
-	// 反序列化
-	var wallets Wallets                              // 创建一个临时的wallets
-	gob.Register(secp256k1.S256())                   // 注册椭圆曲线
-	decoder := gob.NewDecoder(bytes.NewReader(data)) // 创建一个解码器
-	err = decoder.Decode(&wallets)                   // 把解码后的数据放到wallets中
+	// 反序列化, 文件损坏的时候提示使用备份恢复
+	decoded, err := decodeWalletFile(data)
 	if err != nil {
 		fmt.Printf("decode wallets file failed: %v\n", err)
+		if !errors.Is(err, ErrWalletCorrupt) {
+			return false
+		}
+		if _, statErr := os.Stat(Config.WalletFile + WALLETBACKUPSUFFIX); statErr == nil {
+			fmt.Printf("a backup of the wallet exists, run `recoverwallet` to restore it\n")
+		}
 		return false
 	}
 
 	// 所以当你把一个map赋值给另一个map，你其实是创建了一个新的引用（或者指针），
 	// 它指向的是原来的map。因此，如果你改变其中一个map，另一个也会发生改变，因为它们都指向同一块内存空间
-	ws.Wallets = wallets.Wallets // 把解码后的数据放到当前的wallets中, 这里的ws是指针，所以可以直接赋值
+	ws.mu.Lock()
+	ws.lockLocked()
+	ws.Wallets = decoded.Wallets // 把解码后的数据放到当前的wallets中, 这里的ws是指针，所以可以直接赋值
+	ws.crypter = decoded.crypter
+	ws.hd = decoded.hd
+	ws.watchOnly = decoded.watchOnly
+	ws.journal = decoded.journal
+	ws.mu.Unlock()
 
 	return true
 }
+
+// decodeWalletFile parses both the current format and the legacy plain gob format
+//
+// 解析钱包文件, 兼容没有文件头的旧格式
+func decodeWalletFile(data []byte) (*Wallets, error) {
+	// 旧格式: 直接gob编码的Wallets, 私钥是明文
+	if !bytes.HasPrefix(data, []byte(WALLETMAGIC)) {
+		var legacy struct {
+			Wallets map[string]*Wallet
+		}
+		gob.Register(secp256k1.S256()) // 注册椭圆曲线
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrWalletCorrupt, err)
+		}
+		if legacy.Wallets == nil {
+			legacy.Wallets = make(map[string]*Wallet)
+		}
+		if Params.Name != NETWORKMAINNET {
+			return nil, fmt.Errorf("wallet file %w: %s", ErrWrongNetwork, NETWORKMAINNET)
+		}
+		return &Wallets{Wallets: legacy.Wallets, watchOnly: make(map[string]*WatchOnly), journal: newTxJournal()}, nil
+	}
+
+	var content walletFile
+	payload, err := openWalletEnvelope(data)
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrWalletCorrupt, err)
+		}
+	} else {
+		// 版本1的文件在文件头之后直接是gob编码的内容, 没有校验和
+		legacyErr := gob.NewDecoder(bytes.NewReader(data[len(WALLETMAGIC):])).Decode(&content)
+		if legacyErr != nil || content.Version != 1 {
+			return nil, err
+		}
+	}
+	if content.Version > WALLETFORMATVERSION {
+		return nil, fmt.Errorf("unsupported wallet format version %d", content.Version)
+	}
+	// 地址的版本号和网络有关, 不能在其他网络中使用这个钱包
+	network := content.Network
+	if len(network) == 0 {
+		network = NETWORKMAINNET
+	}
+	if network != Params.Name {
+		return nil, fmt.Errorf("wallet file %w: %s", ErrWrongNetwork, network)
+	}
+
+	wallets := make(map[string]*Wallet)
+	for _, key := range content.Keys {
+		wallet := &Wallet{PublicKey: key.PublicKey, encryptedKey: key.EncryptedKey, path: key.Path, change: key.Change}
+		if content.Crypter == nil {
+			wallet.PrivateKey, err = privateKeyFromBytes(key.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid private key of %s, %w", key.Address, err)
+			}
+		}
+		wallets[key.Address] = wallet
+	}
+
+	watchOnly := make(map[string]*WatchOnly)
+	for i := range content.Watch {
+		watchOnly[content.Watch[i].Address] = &content.Watch[i]
+	}
+
+	journal := content.Journal
+	if journal == nil {
+		journal = newTxJournal()
+	}
+	if journal.Labels == nil {
+		journal.Labels = make(map[string]string)
+	}
+
+	return &Wallets{Wallets: wallets, crypter: content.Crypter, hd: content.HD, watchOnly: watchOnly, journal: journal}, nil
+}
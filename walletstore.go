@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	WALLETBACKUPSUFFIX = ".bak" // 上一个版本的钱包文件, 每次保存之前滚动更新
+)
+
+var (
+	ErrWalletCorrupt = errors.New("wallet file is corrupt")
+)
+
+// encodeWalletEnvelope wraps the encoded wallet with the magic and a checksum
+//
+// 钱包文件 = 文件头 || SHA256(内容) || 内容, 读取的时候通过校验和发现文件损坏
+func encodeWalletEnvelope(payload []byte) []byte {
+	checksum := sha256.Sum256(payload)
+	return bytes.Join([][]byte{[]byte(WALLETMAGIC), checksum[:], payload}, []byte{})
+}
+
+// openWalletEnvelope checks the magic and checksum and returns the encoded wallet
+func openWalletEnvelope(data []byte) ([]byte, error) {
+	header := len(WALLETMAGIC) + sha256.Size
+	if len(data) < header || !bytes.HasPrefix(data, []byte(WALLETMAGIC)) {
+		return nil, fmt.Errorf("%w: missing header", ErrWalletCorrupt)
+	}
+
+	payload := data[header:]
+	checksum := sha256.Sum256(payload)
+	if !bytes.Equal(checksum[:], data[len(WALLETMAGIC):header]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrWalletCorrupt)
+	}
+	return payload, nil
+}
+
+// writeFileAtomic replaces file with data so that a crash leaves either the old or the new content
+//
+// 先写入同一目录下的临时文件并且fsync, 然后rename覆盖原文件, rename是原子操作
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file failed, %w", err)
+	}
+	tmpName := tmp.Name()
+	// 出错的时候删除临时文件, rename成功之后删除会失败, 忽略错误即可
+	defer os.Remove(tmpName)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("chmod temp file failed, %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file failed, %w", err)
+	}
+	// 数据落盘之后才能rename, 否则断电之后可能得到一个空文件
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp file failed, %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file failed, %w", err)
+	}
+
+	if err := os.Rename(tmpName, file); err != nil {
+		return fmt.Errorf("rename temp file failed, %w", err)
+	}
+
+	// fsync目录, 保证rename本身也已经落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// writeWalletFile saves the wallet atomically, keeping the previous valid version as a backup
+//
+// 保存钱包之前, 把当前的钱包文件滚动保存为备份; 加密状态或者口令改变的时候, 备份也换成新的内容,
+// 否则旧的明文私钥或者旧口令加密的私钥会留在备份中
+func writeWalletFile(file string, data []byte) error {
+	old, err := os.ReadFile(file)
+	if err == nil {
+		// 损坏的文件中可能还有没有备份的私钥, 不能直接覆盖, 需要先恢复或者手动处理
+		previous, err := decodeWalletFile(old)
+		if errors.Is(err, ErrWrongNetwork) {
+			return fmt.Errorf("refuse to overwrite %s, %w", file, err)
+		} else if err != nil {
+			return fmt.Errorf("refuse to overwrite %s, %w; run `recoverwallet` first", file, err)
+		}
+		current, err := decodeWalletFile(data)
+		if err != nil {
+			return fmt.Errorf("check new wallet content failed, %w", err)
+		}
+		backup := old
+		if !sameWalletCrypter(previous.crypter, current.crypter) {
+			backup = data
+		}
+		if err := writeFileAtomic(file+WALLETBACKUPSUFFIX, backup, 0600); err != nil {
+			return fmt.Errorf("backup wallet file failed, %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("read wallet file failed, %w", err)
+	}
+
+	return writeFileAtomic(file, data, 0600)
+}
+
+// sameWalletCrypter reports whether two versions of the wallet protect their keys with the same passphrase
+func sameWalletCrypter(a, b *walletCrypter) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(a.Salt, b.Salt) && bytes.Equal(a.Check, b.Check)
+}
+
+// RecoverWalletFromBackup replaces a corrupt wallet file with its backup
+//
+// 使用备份恢复损坏的钱包文件, 损坏的文件重命名保留下来, 以便手动检查
+func RecoverWalletFromBackup(file string) error {
+	backup, err := os.ReadFile(file + WALLETBACKUPSUFFIX)
+	if err != nil {
+		return fmt.Errorf("read wallet backup failed, %w", err)
+	}
+	if _, err := decodeWalletFile(backup); err != nil {
+		return fmt.Errorf("wallet backup is not usable, %w", err)
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		corrupt := fmt.Sprintf("%s.corrupt-%d", file, time.Now().Unix())
+		if err := os.Rename(file, corrupt); err != nil {
+			return fmt.Errorf("keep corrupt wallet file failed, %w", err)
+		}
+		fmt.Printf("corrupt wallet file moved to %s\n", corrupt)
+	}
+
+	return writeFileAtomic(file, backup, 0600)
+}
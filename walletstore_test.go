@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "wallets.dat")
+
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil || string(data) != content {
+			t.Fatalf("read %q %v, want %q", data, err, content)
+		}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode %v, want 0600", info.Mode().Perm())
+	}
+	// 临时文件在 rename 之后不再存在
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the wallet file", len(entries))
+	}
+}
+
+func TestWalletEnvelope(t *testing.T) {
+	payload := []byte("encoded wallet")
+	envelope := encodeWalletEnvelope(payload)
+
+	flipped := append([]byte{}, envelope...)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "valid", data: envelope},
+		{name: "flipped bit", data: flipped, wantErr: ErrWalletCorrupt},
+		{name: "truncated", data: envelope[:len(WALLETMAGIC)+8], wantErr: ErrWalletCorrupt},
+		{name: "no magic", data: payload, wantErr: ErrWalletCorrupt},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opened, err := openWalletEnvelope(test.data)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("openWalletEnvelope error %v, want %v", err, test.wantErr)
+			}
+			if err == nil && !bytes.Equal(opened, payload) {
+				t.Errorf("openWalletEnvelope = %q, want %q", opened, payload)
+			}
+		})
+	}
+}
+
+func TestWalletBackup(t *testing.T) {
+	file := useTestWalletFile(t)
+
+	ws := CreateWallets()
+	ws.loaded = true
+	first, err := ws.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ws.SaveWalletsToFile() {
+		t.Fatal("save wallet failed")
+	}
+	second, err := ws.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ws.SaveWalletsToFile() {
+		t.Fatal("save wallet failed")
+	}
+
+	// 备份是上一个版本, 只有第一个地址
+	backup, err := decodeWalletFile(mustReadFile(t, file+WALLETBACKUPSUFFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Wallets[first] == nil || backup.Wallets[second] != nil {
+		t.Errorf("backup has %d keys, want only the first one", len(backup.Wallets))
+	}
+
+	// 损坏的钱包文件不能被覆盖, 只能从备份恢复
+	corrupt := mustReadFile(t, file)
+	corrupt[len(corrupt)-1] ^= 1
+	if err := os.WriteFile(file, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ws.SaveWalletsToFile() {
+		t.Error("saved over a corrupt wallet file")
+	}
+	if !bytes.Equal(mustReadFile(t, file), corrupt) {
+		t.Error("corrupt wallet file was overwritten")
+	}
+
+	if err := RecoverWalletFromBackup(file); err != nil {
+		t.Fatal(err)
+	}
+	recovered := CreateWallets()
+	if !recovered.ReadWalletsFromFile() {
+		t.Fatal("read recovered wallet failed")
+	}
+	if recovered.Wallets[first] == nil {
+		t.Error("recovered wallet lost the first key")
+	}
+	matches, _ := filepath.Glob(file + ".corrupt-*")
+	if len(matches) != 1 {
+		t.Errorf("found %d kept corrupt files, want 1", len(matches))
+	}
+}
+
+func mustReadFile(t *testing.T, file string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWalletBackupAfterEncryption(t *testing.T) {
+	file := useTestWalletFile(t)
+
+	ws := CreateWallets()
+	ws.loaded = true
+	var keys [][]byte
+	for i := 0; i < 2; i++ {
+		address, err := ws.CreateWalletRandomly()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, privateKeyBytes(&ws.GetWallet(address).PrivateKey))
+		// 每次保存都滚动备份, 加密之前备份中是明文私钥
+		if !ws.SaveWalletsToFile() {
+			t.Fatal("save wallet failed")
+		}
+	}
+
+	if err := ws.EncryptWallet("secret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{file, file + WALLETBACKUPSUFFIX} {
+		data := mustReadFile(t, name)
+		for _, key := range keys {
+			if bytes.Contains(data, key) {
+				t.Errorf("%s contains a plaintext private key after encryptwallet", filepath.Base(name))
+			}
+		}
+	}
+
+	// 修改口令之后, 备份不能再用旧口令解锁
+	if err := ws.ChangePassphrase("secret", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := decodeWalletFile(mustReadFile(t, file+WALLETBACKUPSUFFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.crypter.verifyPassphrase("secret"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("backup unlocks with the old passphrase, error %v", err)
+	}
+	if _, err := backup.crypter.verifyPassphrase("new secret"); err != nil {
+		t.Errorf("backup does not unlock with the new passphrase, %v", err)
+	}
+}
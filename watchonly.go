@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrAddressIsMine = errors.New("address is already spendable by this wallet")
+	ErrWatchOnly     = errors.New("address is watch-only, build an unsigned transaction and sign it where the key is")
+)
+
+// WatchOnly is an address tracked by the wallet without its private key
+//
+// 只读地址: 只保存地址(公钥哈希), 知道公钥的时候也保存公钥, 构建的交易需要在其他机器上签名
+type WatchOnly struct {
+	Address    string
+	PublicKey  []byte // 可选, 未签名交易的输入需要填写公钥
+	PubkeyHash []byte
+}
+
+// ImportWatchOnlyAddress starts tracking an address, returning false if it was already watched
+func (ws *Wallets) ImportWatchOnlyAddress(address string) (bool, error) {
+	pubkeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return false, err
+	}
+
+	return ws.addWatchOnly(&WatchOnly{Address: address, PubkeyHash: pubkeyHash})
+}
+
+// ImportWatchOnlyPubkey starts tracking the address of a public key
+//
+// 导入公钥, 公钥格式是 X || Y, 也接受带 0x04 前缀的未压缩公钥
+func (ws *Wallets) ImportWatchOnlyPubkey(publickey []byte) (string, bool, error) {
+	if len(publickey) == 65 && publickey[0] == 0x04 {
+		publickey = publickey[1:]
+	}
+	if len(publickey) != 64 {
+		return "", false, fmt.Errorf("invalid public key length %d, want 64 bytes X || Y", len(publickey))
+	}
+
+	wallet := &Wallet{PublicKey: publickey}
+	address := string(wallet.GetAddressWithPublickey(Params.AddressVersion))
+	isNew, err := ws.addWatchOnly(&WatchOnly{Address: address, PublicKey: publickey, PubkeyHash: PublickeyHash(publickey)})
+	return address, isNew, err
+}
+
+// addWatchOnly stores a watch-only entry, filling in the public key of an existing entry
+func (ws *Wallets) addWatchOnly(watch *WatchOnly) (bool, error) {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, wallet := ws.findWalletLocked(watch.Address); wallet != nil {
+		return false, ErrAddressIsMine
+	}
+	if old := ws.findWatchOnlyLocked(watch.Address); old != nil {
+		if len(old.PublicKey) == 0 && len(watch.PublicKey) > 0 {
+			old.PublicKey = watch.PublicKey
+			return true, nil
+		}
+		return false, nil
+	}
+
+	ws.watchOnly[watch.Address] = watch
+	ws.journal.SyncedHash = nil // 重新扫描交易记录
+	return true, nil
+}
+
+// GetWatchOnly returns the watch-only entry of an address
+func (ws *Wallets) GetWatchOnly(address string) *WatchOnly {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.findWatchOnlyLocked(address)
+}
+
+// findWatchOnlyLocked returns the watch-only entry of an address in either format; caller must hold ws.mu
+func (ws *Wallets) findWatchOnlyLocked(address string) *WatchOnly {
+	if watch, ok := ws.watchOnly[address]; ok {
+		return watch
+	}
+	pubkeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return nil
+	}
+	for _, watch := range ws.watchOnly {
+		if bytes.Equal(watch.PubkeyHash, pubkeyHash) {
+			return watch
+		}
+	}
+	return nil
+}
+
+// getWatchOnlyAddresses returns every watch-only address in order
+func (ws *Wallets) getWatchOnlyAddresses() []string {
+	ws.ensureLoaded()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	addresses := []string{}
+	for address := range ws.watchOnly {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// IsWatchOnly reports whether the wallet tracks an address without its key
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	return ws.GetWatchOnly(address) != nil
+}
+
+// CreateUnsignedTransaction builds a transaction spending a watch-only address
+//
+// 使用只读地址的UTXO构建未签名的交易, 交易需要在持有私钥的机器上签名;
+// 只读钱包无法生成新的找零地址, 没有配置找零地址时找零回到 fromAddr
+func CreateUnsignedTransaction(fromAddr, toAddr string, amount int, opts TxOptions, wallets *Wallets, blockchain *Blockchain) (*Transaction, int, error) {
+	var publickey []byte
+	if watch := wallets.GetWatchOnly(fromAddr); watch != nil {
+		publickey = watch.PublicKey
+	} else if wallet := wallets.GetWallet(fromAddr); wallet != nil {
+		publickey = wallet.PublicKey
+	} else {
+		return nil, -1, fmt.Errorf("address %s is not in the wallet", fromAddr)
+	}
+
+	return NewUnsignedTransaction(fromAddr, toAddr, amount, publickey, opts, blockchain)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newTestKey returns an address and public key held by another wallet
+func newTestKey(t *testing.T) (*Wallets, string, []byte) {
+	t.Helper()
+
+	other := CreateWallets()
+	other.loaded = true
+	address, err := other.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return other, address, other.GetWallet(address).PublicKey
+}
+
+func TestImportWatchOnly(t *testing.T) {
+	useTestWalletFile(t)
+	ws := CreateWallets()
+	ws.loaded = true
+	own, err := ws.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, address, publickey := newTestKey(t)
+
+	// 依次执行, 后面的导入依赖前面的结果
+	tests := []struct {
+		name      string
+		address   string
+		publickey []byte
+		isNew     bool
+		wantErr   error
+	}{
+		{name: "own address", address: own, wantErr: ErrAddressIsMine},
+		{name: "address", address: address, isNew: true},
+		{name: "address again", address: address},
+		// 先导入地址再导入公钥, 补全已有的只读地址的公钥
+		{name: "uncompressed pubkey", publickey: append([]byte{0x04}, publickey...), isNew: true},
+		{name: "pubkey again", publickey: publickey},
+		{name: "own pubkey", publickey: ws.GetWallet(own).PublicKey, wantErr: ErrAddressIsMine},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var isNew bool
+			var err error
+			if len(tt.publickey) > 0 {
+				var imported string
+				imported, isNew, err = ws.ImportWatchOnlyPubkey(tt.publickey)
+				if err == nil && imported != address {
+					t.Errorf("imported address %s, want %s", imported, address)
+				}
+			} else {
+				isNew, err = ws.ImportWatchOnlyAddress(tt.address)
+			}
+			if !errors.Is(err, tt.wantErr) || isNew != tt.isNew {
+				t.Errorf("import = %v %v, want %v %v", isNew, err, tt.isNew, tt.wantErr)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"nope", ""} {
+		if _, err := ws.ImportWatchOnlyAddress(invalid); err == nil {
+			t.Errorf("imported the invalid address %q", invalid)
+		}
+	}
+	if _, _, err := ws.ImportWatchOnlyPubkey(publickey[1:]); err == nil {
+		t.Error("imported a 63 byte public key")
+	}
+
+	// 两种地址格式都能找到同一个只读地址
+	watch := ws.GetWatchOnly(address)
+	if watch == nil || !bytes.Equal(watch.PublicKey, publickey) {
+		t.Fatalf("watch-only entry %+v, want the imported public key", watch)
+	}
+	if !ws.IsWatchOnly(EncodeBech32Address(watch.PubkeyHash)) {
+		t.Error("the bech32 address of a watched key is not watch-only")
+	}
+	if ws.IsWatchOnly(own) {
+		t.Error("a spendable address is watch-only")
+	}
+	if got := ws.getWatchOnlyAddresses(); !reflect.DeepEqual(got, []string{address}) {
+		t.Errorf("watch-only addresses %v, want [%s]", got, address)
+	}
+
+	if !ws.SaveWalletsToFile() {
+		t.Fatal("save wallet failed")
+	}
+	loaded := CreateWallets()
+	if !loaded.ReadWalletsFromFile() {
+		t.Fatal("read wallet failed")
+	}
+	if watch := loaded.GetWatchOnly(address); watch == nil || !bytes.Equal(watch.PublicKey, publickey) {
+		t.Errorf("reloaded watch-only entry %+v, want the imported public key", watch)
+	}
+}
+
+func TestCreateUnsignedTransaction(t *testing.T) {
+	useTestWalletFile(t)
+	bc := newTestBlockchain(t)
+	signer, address, publickey := newTestKey(t)
+	coinbase, err := CoinBaseTx(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.AddBlock([]*Transaction{coinbase})
+
+	ws := CreateWallets()
+	ws.loaded = true
+	to, err := ws.CreateWalletRandomly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.ImportWatchOnlyAddress(address); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只读地址不能直接付款
+	if _, _, err := CreateTransaction(address, to, 10, TxOptions{}, ws, bc); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("CreateTransaction from a watch-only address error %v, want %v", err, ErrWatchOnly)
+	}
+	if _, _, err := CreateUnsignedTransaction(to[:len(to)-1], to, 10, TxOptions{}, ws, bc); err == nil {
+		t.Error("built a transaction from an address that is not in the wallet")
+	}
+
+	// 不知道公钥的时候输入中的公钥为空, 由签名方填写
+	tx, _, err := CreateUnsignedTransaction(address, to, 10, TxOptions{}, ws, bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.In) != 1 || tx.In[0].Pubkey != nil || tx.In[0].Signature != nil {
+		t.Errorf("unsigned inputs %+v, want no public key and no signature", tx.In)
+	}
+
+	if _, _, err := ws.ImportWatchOnlyPubkey(publickey); err != nil {
+		t.Fatal(err)
+	}
+	tx, changeIndex, err := CreateUnsignedTransaction(address, to, 10, TxOptions{}, ws, bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.In) != 1 || !bytes.Equal(tx.In[0].Pubkey, publickey) || tx.In[0].Signature != nil {
+		t.Errorf("unsigned inputs %+v, want the watched public key and no signature", tx.In)
+	}
+	// 只读钱包没有新的找零地址, 找零回到付款地址
+	if changeIndex < 0 || !bytes.Equal(tx.Out[changeIndex].PublickeyHash, ws.GetWatchOnly(address).PubkeyHash) || tx.Out[changeIndex].Value != COINBASEFEE-10 {
+		t.Errorf("change output %d of %+v, want %d back to %s", changeIndex, tx.Out, COINBASEFEE-10, address)
+	}
+	if bc.VerifyTransaction(tx) {
+		t.Error("an unsigned transaction verifies")
+	}
+
+	// 在持有私钥的钱包中签名之后可以通过验证
+	privateKey, err := signer.PrivateKeyOf(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SignTransaction(tx, privateKey)
+	if !bc.VerifyTransaction(tx) {
+		t.Error("the transaction signed by the key holder does not verify")
+	}
+}
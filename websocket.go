@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WEBSOCKETGUID    = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" // RFC 6455 规定的握手常量
+	WEBSOCKETVERSION = "13"
+	WEBSOCKETPATH    = "/ws" // 事件订阅的地址, 和 JSON-RPC 共用端口和认证
+
+	WSOPCONTINUATION = 0x0
+	WSOPTEXT         = 0x1
+	WSOPBINARY       = 0x2
+	WSOPCLOSE        = 0x8
+	WSOPPING         = 0x9
+	WSOPPONG         = 0xA
+
+	WSCLOSENORMAL        = 1000
+	WSCLOSEPROTOCOLERROR = 1002
+	WSCLOSETOOBIG        = 1009
+	WSCLOSETRYAGAIN      = 1013 // 订阅者跟不上事件, 重新连接之后需要重新同步
+
+	WSMAXMESSAGESIZE     = 64 << 10 // 客户端消息的最大长度, 客户端只发送订阅请求
+	WSMAXCONTROLSIZE     = 125
+	WSPINGINTERVAL       = 30 * time.Second
+	WSPONGWAIT           = 2 * WSPINGINTERVAL // 这么长时间没有收到任何数据就断开
+	WSWRITETIMEOUT       = 10 * time.Second
+	WSPAYMENTUNCONFIRMED = "unconfirmed" // 付款交易进入交易池
+	WSPAYMENTCONFIRMED   = "confirmed"   // 付款交易所在的区块加入主链
+	WSPAYMENTREVERSED    = "reversed"    // 付款交易所在的区块因为分叉离开了主链
+)
+
+var (
+	ErrWSProtocol    = errors.New("websocket protocol error")
+	ErrWSMessageSize = errors.New("websocket message too big")
+)
+
+// ------------------------- WebSocket 协议 -------------------------
+
+// WSConn is the server side of a WebSocket connection
+//
+// 只实现了服务端需要的部分: 客户端的帧必须带掩码, 服务端的帧不带掩码, 不支持扩展
+type WSConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex // 事件推送, ping 和 pong 可能同时写
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WSConn, error) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, ErrWSProtocol
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != WEBSOCKETVERSION {
+		w.Header().Set("Sec-WebSocket-Version", WEBSOCKETVERSION)
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrWSProtocol
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrWSProtocol
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, ErrWSProtocol
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack connection failed, %w", err)
+	}
+	// http.Server 设置的超时对接管之后的连接仍然有效, 改为按帧设置
+	conn.SetDeadline(time.Time{})
+
+	accept := sha1.Sum([]byte(key + WEBSOCKETGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write websocket handshake failed, %w", err)
+	}
+	return &WSConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma separated header contains a token, ignoring case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readFrame reads one frame and unmasks its payload
+func (c *WSConn) readFrame() (bool, int, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(WSPONGWAIT))
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits are set", ErrWSProtocol)
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("%w: client frame is not masked", ErrWSProtocol)
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if opcode >= WSOPCLOSE && (!fin || length > WSMAXCONTROLSIZE) {
+		return false, 0, nil, fmt.Errorf("%w: invalid control frame", ErrWSProtocol)
+	}
+	if length > WSMAXMESSAGESIZE {
+		return false, 0, nil, ErrWSMessageSize
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings on the way
+//
+// 控制帧可以夹在分片的消息中间; 收到 close 帧时回复 close 并返回 io.EOF
+func (c *WSConn) ReadMessage() (int, []byte, error) {
+	messageOpcode := -1
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case WSOPPING:
+			if err := c.WriteMessage(WSOPPONG, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WSOPPONG:
+			continue // readFrame 已经延长了读取的超时时间
+		case WSOPCLOSE:
+			c.WriteMessage(WSOPCLOSE, payload)
+			return 0, nil, io.EOF
+		case WSOPCONTINUATION:
+			if messageOpcode < 0 {
+				return 0, nil, fmt.Errorf("%w: continuation without a message", ErrWSProtocol)
+			}
+		case WSOPTEXT, WSOPBINARY:
+			if messageOpcode >= 0 {
+				return 0, nil, fmt.Errorf("%w: new message inside a fragmented one", ErrWSProtocol)
+			}
+			messageOpcode = opcode
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown opcode %d", ErrWSProtocol, opcode)
+		}
+
+		if len(message)+len(payload) > WSMAXMESSAGESIZE {
+			return 0, nil, ErrWSMessageSize
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageOpcode, message, nil
+		}
+	}
+}
+
+// WriteMessage sends a message in a single unmasked frame
+func (c *WSConn) WriteMessage(opcode int, payload []byte) error {
+	frame := []byte{0x80 | byte(opcode)}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(WSWRITETIMEOUT))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// WriteJSON sends v as a text message
+func (c *WSConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode websocket message failed, %w", err)
+	}
+	return c.WriteMessage(WSOPTEXT, data)
+}
+
+// Close sends a close frame with a status code and closes the connection
+func (c *WSConn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > WSMAXCONTROLSIZE-2 {
+		reason = reason[:WSMAXCONTROLSIZE-2]
+	}
+	c.WriteMessage(WSOPCLOSE, append(payload, reason...))
+	return c.conn.Close()
+}
+
+// ------------------------- 事件订阅 -------------------------
+
+// WSRequest changes the subscriptions of a connection
+//
+// 例如 {"action":"subscribe","topics":["tip","mempool"],"addresses":["bbrt1..."]};
+// 订阅了地址之后, 付款到这些地址的交易会以 payment 事件推送
+type WSRequest struct {
+	Action    string   `json:"action"` // subscribe 或者 unsubscribe
+	Topics    []string `json:"topics"`
+	Addresses []string `json:"addresses"`
+}
+
+// WSEvent is a message pushed to the client
+type WSEvent struct {
+	Type      string           `json:"type"`
+	Hash      string           `json:"hash,omitempty"`
+	Height    int64            `json:"height,omitempty"`
+	Block     *BlockJSON       `json:"block,omitempty"`
+	Tx        *TransactionJSON `json:"tx,omitempty"`
+	Payment   *WSPayment       `json:"payment,omitempty"`
+	Topics    []string         `json:"topics,omitempty"`
+	Addresses []string         `json:"addresses,omitempty"`
+	Message   string           `json:"message,omitempty"`
+}
+
+// WSPayment is an output paying a subscribed address
+type WSPayment struct {
+	Address   string `json:"address"`
+	TxID      string `json:"txid"`
+	Vout      int    `json:"vout"`
+	Value     int    `json:"value"`
+	Status    string `json:"status"` // unconfirmed, confirmed 或者 reversed
+	BlockHash string `json:"blockhash,omitempty"`
+	Height    int64  `json:"height,omitempty"`
+}
+
+// WSTopics are the event types a client can subscribe to
+var WSTopics = map[string]bool{
+	EVENTTIP:               true,
+	EVENTBLOCKCONNECTED:    true,
+	EVENTBLOCKDISCONNECTED: true,
+	EVENTMEMPOOL:           true,
+}
+
+// wsSession is the subscription state of one connection
+type wsSession struct {
+	conn      *WSConn
+	mu        sync.Mutex
+	topics    map[string]bool
+	addresses map[string]string // hex公钥哈希 -> 客户端订阅时使用的地址
+}
+
+// serveEvents upgrades the request and pushes the subscribed events until the client goes away
+//
+// 连接建立之后才开始订阅事件总线, 客户端重新连接之后应该用 RPC 或者 REST 重新同步状态
+func (s *RPCServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	session := &wsSession{conn: conn, topics: make(map[string]bool), addresses: make(map[string]string)}
+
+	sub := Events.Subscribe()
+	defer sub.Unsubscribe()
+
+	done := make(chan error, 1)
+	go func() { done <- session.readRequests() }()
+
+	ticker := time.NewTicker(WSPINGINTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				conn.Close(WSCLOSETRYAGAIN, "too many pending events, reconnect and resync")
+				return
+			}
+			if err := session.deliver(event); err != nil {
+				conn.Close(WSCLOSENORMAL, "")
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteMessage(WSOPPING, nil); err != nil {
+				conn.Close(WSCLOSENORMAL, "")
+				return
+			}
+		case err := <-done:
+			switch {
+			case errors.Is(err, ErrWSMessageSize):
+				conn.Close(WSCLOSETOOBIG, err.Error())
+			case errors.Is(err, ErrWSProtocol):
+				conn.Close(WSCLOSEPROTOCOLERROR, err.Error())
+			default:
+				conn.Close(WSCLOSENORMAL, "")
+			}
+			return
+		}
+	}
+}
+
+// readRequests applies the subscription requests of the client until the connection fails
+func (ss *wsSession) readRequests() error {
+	for {
+		opcode, message, err := ss.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if opcode != WSOPTEXT {
+			ss.conn.WriteJSON(WSEvent{Type: "error", Message: "requests must be JSON text messages"})
+			continue
+		}
+
+		var request WSRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			ss.conn.WriteJSON(WSEvent{Type: "error", Message: fmt.Sprintf("parse request failed, %v", err)})
+			continue
+		}
+		reply, err := ss.apply(request)
+		if err != nil {
+			ss.conn.WriteJSON(WSEvent{Type: "error", Message: err.Error()})
+			continue
+		}
+		if err := ss.conn.WriteJSON(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// apply changes the subscriptions, nothing changes when any topic or address is invalid
+func (ss *wsSession) apply(request WSRequest) (WSEvent, error) {
+	if request.Action != "subscribe" && request.Action != "unsubscribe" {
+		return WSEvent{}, fmt.Errorf("invalid action %q, must be subscribe or unsubscribe", request.Action)
+	}
+	for _, topic := range request.Topics {
+		if !WSTopics[topic] {
+			return WSEvent{}, fmt.Errorf("invalid topic %q, must be tip, blockconnected, blockdisconnected or mempool", topic)
+		}
+	}
+	pubkeyHashes := make([]string, 0, len(request.Addresses))
+	for _, address := range request.Addresses {
+		pubkeyHash, err := DecodeAddress(address)
+		if err != nil {
+			return WSEvent{}, err
+		}
+		pubkeyHashes = append(pubkeyHashes, hex.EncodeToString(pubkeyHash))
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	subscribe := request.Action == "subscribe"
+	for _, topic := range request.Topics {
+		if subscribe {
+			ss.topics[topic] = true
+		} else {
+			delete(ss.topics, topic)
+		}
+	}
+	for i, pubkeyHash := range pubkeyHashes {
+		if subscribe {
+			ss.addresses[pubkeyHash] = request.Addresses[i]
+		} else {
+			delete(ss.addresses, pubkeyHash)
+		}
+	}
+
+	reply := WSEvent{Type: "subscriptions", Topics: []string{}, Addresses: []string{}}
+	for topic := range ss.topics {
+		reply.Topics = append(reply.Topics, topic)
+	}
+	for _, address := range ss.addresses {
+		reply.Addresses = append(reply.Addresses, address)
+	}
+	return reply, nil
+}
+
+// deliver pushes an event and the payments it contains, if the client subscribed to them
+func (ss *wsSession) deliver(event Event) error {
+	ss.mu.Lock()
+	subscribed := ss.topics[event.Type]
+	ss.mu.Unlock()
+
+	var message WSEvent
+	var payments []WSEvent
+	switch event.Type {
+	case EVENTTIP:
+		message = WSEvent{Type: event.Type, Hash: hex.EncodeToString(event.Block.Hash), Height: event.Block.Height}
+	case EVENTBLOCKCONNECTED, EVENTBLOCKDISCONNECTED:
+		block := restBlock(event.Block)
+		message = WSEvent{Type: event.Type, Block: &block}
+		status := WSPAYMENTCONFIRMED
+		if event.Type == EVENTBLOCKDISCONNECTED {
+			status = WSPAYMENTREVERSED
+		}
+		for _, tx := range event.Block.Transactions {
+			payments = append(payments, ss.payments(tx, event.Block, status)...)
+		}
+	case EVENTMEMPOOL:
+		tx := NewTransactionJSON(event.Tx, nil, -1)
+		message = WSEvent{Type: event.Type, Tx: &tx}
+		payments = ss.payments(event.Tx, nil, WSPAYMENTUNCONFIRMED)
+	}
+
+	if subscribed {
+		if err := ss.conn.WriteJSON(message); err != nil {
+			return err
+		}
+	}
+	for _, payment := range payments {
+		if err := ss.conn.WriteJSON(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payments returns a payment event for every output of tx paying a subscribed address
+func (ss *wsSession) payments(tx *Transaction, block *Block, status string) []WSEvent {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var payments []WSEvent
+	for i, output := range tx.Out {
+		address, ok := ss.addresses[hex.EncodeToString(output.PublickeyHash)]
+		if !ok {
+			continue
+		}
+		payment := &WSPayment{Address: address, TxID: hex.EncodeToString(tx.ID), Vout: i, Value: output.Value, Status: status}
+		if block != nil {
+			payment.BlockHash = hex.EncodeToString(block.Hash)
+			payment.Height = block.Height
+		}
+		payments = append(payments, WSEvent{Type: "payment", Payment: payment})
+	}
+	return payments
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsMask is the masking key of the examples in RFC 6455 section 5.7
+var wsMask = []byte{0x37, 0xfa, 0x21, 0x3d}
+
+// maskedFrame builds a client frame with the given first byte
+func maskedFrame(first byte, payload []byte) []byte {
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, wsMask...)
+	for i, b := range payload {
+		frame = append(frame, b^wsMask[i%4])
+	}
+	return frame
+}
+
+// newTestWSConn returns the server side of a connection and the client end of the pipe
+func newTestWSConn(t *testing.T) (*WSConn, net.Conn) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return &WSConn{conn: server, reader: bufio.NewReader(server)}, client
+}
+
+func TestWSReadMessage(t *testing.T) {
+	large := bytes.Repeat([]byte{0x5a}, 256)
+	tooBig := []byte{0x82, 0xff, 0, 0, 0, 0, 0, 1, 0, 1}
+
+	tests := []struct {
+		name    string
+		input   [][]byte
+		opcode  int
+		message []byte
+		replies []byte
+		wantErr error
+	}{
+		{
+			// RFC 6455 5.7: 一个带掩码的文本帧 "Hello"
+			name:    "masked text",
+			input:   [][]byte{{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}},
+			opcode:  WSOPTEXT,
+			message: []byte("Hello"),
+		},
+		{
+			name:    "fragmented",
+			input:   [][]byte{maskedFrame(0x01, []byte("Hel")), maskedFrame(0x80, []byte("lo"))},
+			opcode:  WSOPTEXT,
+			message: []byte("Hello"),
+		},
+		{
+			// 分片中间的 ping 立即回复 pong, RFC 6455 5.7 中不带掩码的 pong
+			name:    "ping between fragments",
+			input:   [][]byte{maskedFrame(0x01, []byte("Hel")), maskedFrame(0x89, []byte("Hello")), maskedFrame(0x80, []byte("lo"))},
+			opcode:  WSOPTEXT,
+			message: []byte("Hello"),
+			replies: []byte{0x8a, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f},
+		},
+		{
+			name:    "16-bit length",
+			input:   [][]byte{maskedFrame(0x82, large)},
+			opcode:  WSOPBINARY,
+			message: large,
+		},
+		{
+			name:    "close",
+			input:   [][]byte{maskedFrame(0x88, []byte{0x03, 0xe8})},
+			replies: []byte{0x88, 0x02, 0x03, 0xe8},
+			wantErr: io.EOF,
+		},
+		{
+			// RFC 6455 5.7 中服务端发送的不带掩码的帧, 客户端不能这样发送
+			name:    "unmasked",
+			input:   [][]byte{{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}},
+			wantErr: ErrWSProtocol,
+		},
+		{name: "reserved bits", input: [][]byte{maskedFrame(0xc1, []byte("Hello"))}, wantErr: ErrWSProtocol},
+		{name: "continuation first", input: [][]byte{maskedFrame(0x80, []byte("lo"))}, wantErr: ErrWSProtocol},
+		{name: "unknown opcode", input: [][]byte{maskedFrame(0x83, []byte("Hello"))}, wantErr: ErrWSProtocol},
+		{name: "fragmented ping", input: [][]byte{maskedFrame(0x09, []byte("Hello"))}, wantErr: ErrWSProtocol},
+		{name: "long ping", input: [][]byte{maskedFrame(0x89, large)}, wantErr: ErrWSProtocol},
+		{
+			name:    "new message inside a fragmented one",
+			input:   [][]byte{maskedFrame(0x01, []byte("Hel")), maskedFrame(0x81, []byte("lo"))},
+			wantErr: ErrWSProtocol,
+		},
+		{name: "64-bit length too big", input: [][]byte{tooBig}, wantErr: ErrWSMessageSize},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, client := newTestWSConn(t)
+			go func() {
+				for _, frame := range test.input {
+					if _, err := client.Write(frame); err != nil {
+						return
+					}
+				}
+			}()
+			replies := make(chan []byte)
+			go func() {
+				var received []byte
+				buffer := make([]byte, 512)
+				for {
+					n, err := client.Read(buffer)
+					received = append(received, buffer[:n]...)
+					if err != nil {
+						replies <- received
+						return
+					}
+				}
+			}()
+
+			opcode, message, err := conn.ReadMessage()
+			conn.conn.Close()
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("ReadMessage error %v, want %v", err, test.wantErr)
+			}
+			if opcode != test.opcode || !bytes.Equal(message, test.message) {
+				t.Errorf("ReadMessage = %d %q, want %d %q", opcode, message, test.opcode, test.message)
+			}
+			if received := <-replies; !bytes.Equal(received, test.replies) {
+				t.Errorf("replies % x, want % x", received, test.replies)
+			}
+		})
+	}
+}
+
+func TestWSWriteMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		opcode  int
+		payload []byte
+		header  []byte
+	}{
+		// RFC 6455 5.7 的例子
+		{name: "text", opcode: WSOPTEXT, payload: []byte("Hello"), header: []byte{0x81, 0x05}},
+		{name: "256 bytes", opcode: WSOPBINARY, payload: make([]byte, 256), header: []byte{0x82, 0x7e, 0x01, 0x00}},
+		{name: "64KiB", opcode: WSOPBINARY, payload: make([]byte, 65536), header: []byte{0x82, 0x7f, 0, 0, 0, 0, 0, 0x01, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, client := newTestWSConn(t)
+			errs := make(chan error, 1)
+			go func() { errs <- conn.WriteMessage(test.opcode, test.payload) }()
+
+			frame := make([]byte, len(test.header)+len(test.payload))
+			if _, err := io.ReadFull(client, frame); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(frame[:len(test.header)], test.header) || !bytes.Equal(frame[len(test.header):], test.payload) {
+				t.Errorf("frame header % x, want % x", frame[:len(test.header)], test.header)
+			}
+		})
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err == nil {
+			conn.Close(WSCLOSENORMAL, "")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		headers string
+		status  string
+		accept  string
+	}{
+		{
+			// RFC 6455 1.3 的握手例子
+			name:    "handshake",
+			headers: "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n",
+			status:  "101",
+			accept:  "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+		},
+		{
+			name:    "unsupported version",
+			headers: "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n",
+			status:  "426",
+		},
+		{
+			name:    "invalid key",
+			headers: "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: c2hvcnQ=\r\nSec-WebSocket-Version: 13\r\n",
+			status:  "400",
+		},
+		{name: "not an upgrade", headers: "Sec-WebSocket-Version: 13\r\n", status: "400"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			request := "GET " + WEBSOCKETPATH + " HTTP/1.1\r\nHost: localhost\r\n" + test.headers + "\r\n"
+			if _, err := conn.Write([]byte(request)); err != nil {
+				t.Fatal(err)
+			}
+			response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if !strings.HasPrefix(response.Status, test.status) {
+				t.Errorf("status %s, want %s", response.Status, test.status)
+			}
+			if len(test.accept) > 0 {
+				name, value, _ := strings.Cut(test.accept, ": ")
+				if response.Header.Get(name) != value {
+					t.Errorf("%s: %q, want %q", name, response.Header.Get(name), value)
+				}
+			}
+		})
+	}
+}
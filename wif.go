@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// WIF 的版本号, 和地址的版本号不同
+	MAINNET_WIF_VERSION byte = 0x80 // 主网私钥, 编码之后以 5 开头
+	TESTNET_WIF_VERSION byte = 0xef // 测试网私钥, 编码之后以 9 开头
+
+	WIFCOMPRESSEDFLAG byte = 0x01 // 私钥后面跟着 0x01 表示对应压缩公钥
+)
+
+var (
+	ErrInvalidWIF = errors.New("invalid WIF private key")
+)
+
+// EncodeWIF encodes a private key in Wallet Import Format
+//
+// WIF = Base58(版本号 || 32字节私钥 || 校验和), 和地址使用同样的 Base58 和校验和
+func EncodeWIF(key *ecdsa.PrivateKey, version byte) string {
+	return Base58CheckEncode(version, privateKeyBytes(key))
+}
+
+// DecodeWIF decodes a WIF private key and returns it with its version byte
+//
+// 解析WIF私钥, 校验版本号和校验和; 带压缩标志的私钥也可以导入, 但是地址仍然使用未压缩的公钥计算
+func DecodeWIF(wif string) (ecdsa.PrivateKey, byte, error) {
+	version, payload, err := Base58CheckDecode(strings.TrimSpace(wif))
+	if err != nil {
+		return ecdsa.PrivateKey{}, 0, fmt.Errorf("%w: %w", ErrInvalidWIF, err)
+	}
+	// 私钥(32) + [压缩标志(1)]
+	if len(payload) != 32 && len(payload) != 33 {
+		return ecdsa.PrivateKey{}, 0, fmt.Errorf("%w: %w %d", ErrInvalidWIF, ErrInvalidLength, len(payload))
+	}
+	if version != MAINNET_WIF_VERSION && version != TESTNET_WIF_VERSION {
+		return ecdsa.PrivateKey{}, 0, fmt.Errorf("%w: %w 0x%02x", ErrInvalidWIF, ErrUnknownVersion, version)
+	}
+	if len(payload) == 33 && payload[32] != WIFCOMPRESSEDFLAG {
+		return ecdsa.PrivateKey{}, 0, fmt.Errorf("%w: invalid compression flag", ErrInvalidWIF)
+	}
+
+	key, err := privateKeyFromBytes(payload[:32])
+	if err != nil {
+		return ecdsa.PrivateKey{}, 0, fmt.Errorf("%w: %v", ErrInvalidWIF, err)
+	}
+	return key, version, nil
+}
+
+// checkWIFNetwork rejects a WIF key of another network
+func checkWIFNetwork(version byte) error {
+	if version != Params.WIFVersion {
+		return fmt.Errorf("private key %w", ErrWrongNetwork)
+	}
+	return nil
+}
+
+// ImportPrivateKey adds a private key to the wallet, returning its address and whether it was new
+//
+// 导入私钥, 钱包中已经有这个私钥的时候不重复添加
+func (ws *Wallets) ImportPrivateKey(key ecdsa.PrivateKey) (string, bool, error) {
+	wallet := walletFromPrivateKey(key)
+	address := string(wallet.GetAddressWithPublickey(Params.AddressVersion))
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if stored, existing := ws.findWalletLocked(address); existing != nil {
+		return stored, false, nil
+	}
+	if _, err := ws.addKeyLocked(wallet, DEFAULTADDRESSFORMAT); err != nil {
+		return "", false, err
+	}
+	// 导入的私钥可能在之前的区块中有交易, 交易记录需要重新扫描
+	ws.journal.SyncedHash = nil
+	return address, true, nil
+}
+
+// DumpWallet writes every private key of the wallet to a file in WIF
+//
+// 导出钱包中的所有私钥, 每行一个: <WIF> # addr=<地址> [hdkeypath=<路径>]
+func (ws *Wallets) DumpWallet(file string, version byte) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "# wallet dump created at %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&buffer, "# keep this file secret, anyone who reads it can spend the coins\n")
+
+	mnemonic, err := ws.Mnemonic()
+	if err == nil {
+		fmt.Fprintf(&buffer, "# mnemonic: %s\n", mnemonic)
+	} else if !errors.Is(err, ErrNoHDSeed) {
+		return err
+	}
+
+	addresses := ws.getAllAddress()
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		key, err := ws.PrivateKeyOf(address)
+		if err != nil {
+			return err
+		}
+		line := fmt.Sprintf("%s # addr=%s", EncodeWIF(&key, version), address)
+		if path := ws.Wallets[address].path; len(path) > 0 {
+			line += " hdkeypath=" + path
+		}
+		fmt.Fprintln(&buffer, line)
+	}
+
+	// 导出文件里是明文私钥, 只有文件拥有者可以读写
+	return writeFileAtomic(file, buffer.Bytes(), 0600)
+}
+
+// ImportWallet imports every WIF key of a file written by DumpWallet, returning the new addresses
+//
+// 导入 DumpWallet 导出的文件, 空行和 # 开头的注释行会被忽略
+func (ws *Wallets) ImportWallet(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open wallet dump failed, %w", err)
+	}
+	defer f.Close()
+
+	imported := []string{}
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, version, err := DecodeWIF(strings.Fields(line)[0])
+		if err == nil {
+			err = checkWIFNetwork(version)
+		}
+		if err != nil {
+			return imported, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		address, isNew, err := ws.ImportPrivateKey(key)
+		if err != nil {
+			return imported, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if isNew {
+			imported = append(imported, address)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, fmt.Errorf("read wallet dump failed, %w", err)
+	}
+
+	return imported, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDecodeWIF(t *testing.T) {
+	// 比特币维基中 WIF 的例子, 同一个私钥的三种编码
+	key, _ := hex.DecodeString("0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d")
+
+	tests := []struct {
+		name    string
+		wif     string
+		version byte
+		wantErr error
+	}{
+		{name: "mainnet", wif: "5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ", version: MAINNET_WIF_VERSION},
+		{name: "mainnet compressed", wif: "KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617", version: MAINNET_WIF_VERSION},
+		{name: "testnet", wif: "91gGn1HgSap6CbU12F6z3pJri26xzp7Ay1VW6NHCoEayNXwRpu2", version: TESTNET_WIF_VERSION},
+		{name: "surrounding spaces", wif: " 5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ\n", version: MAINNET_WIF_VERSION},
+		{name: "checksum", wif: "5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTK", wantErr: ErrChecksumMismatch},
+		{name: "address", wif: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", wantErr: ErrInvalidLength},
+		{name: "unknown version", wif: Base58CheckEncode(0x00, key), wantErr: ErrUnknownVersion},
+		{name: "compression flag", wif: Base58CheckEncode(MAINNET_WIF_VERSION, append(append([]byte{}, key...), 0x02)), wantErr: ErrInvalidWIF},
+		{name: "zero key", wif: Base58CheckEncode(MAINNET_WIF_VERSION, make([]byte, 32)), wantErr: ErrInvalidWIF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			private, version, err := DecodeWIF(test.wif)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("DecodeWIF error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidWIF) {
+					t.Errorf("DecodeWIF error %v does not wrap %v", err, ErrInvalidWIF)
+				}
+				return
+			}
+			if version != test.version || !bytes.Equal(privateKeyBytes(&private), key) {
+				t.Errorf("DecodeWIF = %x version %#x, want %x version %#x", privateKeyBytes(&private), version, key, test.version)
+			}
+		})
+	}
+
+	private, err := privateKeyFromBytes(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wif := EncodeWIF(&private, MAINNET_WIF_VERSION); wif != tests[0].wif {
+		t.Errorf("EncodeWIF = %s, want %s", wif, tests[0].wif)
+	}
+}
+
+func TestDumpWallet(t *testing.T) {
+	useTestWalletFile(t)
+
+	ws := CreateWallets()
+	ws.loaded = true
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		address, err := ws.CreateWalletRandomly()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	dump := filepath.Join(t.TempDir(), "dump.txt")
+	if err := ws.DumpWallet(dump, Params.WIFVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := CreateWallets()
+	imported.loaded = true
+	got, err := imported.ImportWallet(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, addresses) {
+		t.Errorf("ImportWallet = %v, want %v", got, addresses)
+	}
+
+	// 再次导入不会重复添加
+	if got, err := imported.ImportWallet(dump); err != nil || len(got) != 0 {
+		t.Errorf("ImportWallet again = %v %v, want nothing new", got, err)
+	}
+}